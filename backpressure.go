@@ -0,0 +1,235 @@
+// Package sockethub provides slow-consumer handling for client send channels.
+// When a client's send channel is full, the BackpressureConfig of the hub (or of the room a
+// frame is broadcast to) decides whether to drop, wait, coalesce or disconnect. Every such
+// decision is counted and reported to the OnSlowConsumer callback.
+package sockethub
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// ErrSlowConsumer is returned when a client is disconnected by the SlowConsumerDisconnect policy.
+var ErrSlowConsumer = errors.New("sockethub: client disconnected as a slow consumer")
+
+// =============================================================================
+// Events and Statistics
+// =============================================================================
+
+// SlowConsumerOutcome is what a policy did with a frame.
+type SlowConsumerOutcome uint8
+
+const (
+	OutcomeDroppedNewest SlowConsumerOutcome = iota // The new frame was discarded
+	OutcomeDroppedOldest                            // A queued frame was discarded to make room
+	OutcomeCoalesced                                // The new frame replaced a queued frame with the same key
+	OutcomeDisconnected                             // The client was closed
+)
+
+// String returns the outcome name.
+func (o SlowConsumerOutcome) String() string {
+	switch o {
+	case OutcomeDroppedNewest:
+		return "dropped-newest"
+	case OutcomeDroppedOldest:
+		return "dropped-oldest"
+	case OutcomeCoalesced:
+		return "coalesced"
+	case OutcomeDisconnected:
+		return "disconnected"
+	default:
+		return fmt.Sprintf("SlowConsumerOutcome(%d)", uint8(o))
+	}
+}
+
+// SlowConsumerEvent describes one backpressure decision.
+type SlowConsumerEvent struct {
+	Policy  sockethub_config.SlowConsumerPolicy
+	Outcome SlowConsumerOutcome
+	Header  protocol.SocketHeader // The frame that was discarded or replaced, or the one that could not be queued
+}
+
+// SlowConsumerFunc is called for every backpressure decision. It runs on the sending
+// goroutine, so it must not block or send to the same client.
+type SlowConsumerFunc func(c *Client, ev SlowConsumerEvent)
+
+// SendStats counts backpressure decisions.
+type SendStats struct {
+	Dropped      uint64 // Frames discarded (newest or oldest)
+	Coalesced    uint64 // Frames merged into a queued frame with the same key
+	Disconnected uint64 // Clients closed by SlowConsumerDisconnect
+}
+
+// sendCounters backs SendStats for a client or the whole hub.
+type sendCounters struct {
+	dropped      atomic.Uint64
+	coalesced    atomic.Uint64
+	disconnected atomic.Uint64
+}
+
+func (s *sendCounters) add(outcome SlowConsumerOutcome) {
+	switch outcome {
+	case OutcomeDroppedNewest, OutcomeDroppedOldest:
+		s.dropped.Add(1)
+	case OutcomeCoalesced:
+		s.coalesced.Add(1)
+	case OutcomeDisconnected:
+		s.disconnected.Add(1)
+	}
+}
+
+func (s *sendCounters) stats() SendStats {
+	return SendStats{
+		Dropped:      s.dropped.Load(),
+		Coalesced:    s.coalesced.Load(),
+		Disconnected: s.disconnected.Load(),
+	}
+}
+
+// =============================================================================
+// Hub Settings
+// =============================================================================
+
+// OnSlowConsumer sets the callback for backpressure decisions (nil to remove it).
+func (h *SocketHub) OnSlowConsumer(fn SlowConsumerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onSlowConsumer = fn
+}
+
+// SetRoomBackpressure overrides the hub policy for broadcasts to the named room.
+// A nil config restores the hub policy. The setting outlives the room becoming empty.
+func (h *SocketHub) SetRoomBackpressure(name string, bp *sockethub_config.BackpressureConfig) error {
+	if bp != nil {
+		if err := bp.Validate(); err != nil {
+			return fmt.Errorf("sockethub: room %s: %w", name, err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if bp == nil {
+		delete(h.roomBackpressure, RoomID(name))
+	} else {
+		h.roomBackpressure[RoomID(name)] = *bp
+	}
+	return nil
+}
+
+// SendStats returns the backpressure counters of the whole hub.
+func (h *SocketHub) SendStats() SendStats {
+	return h.sendStats.stats()
+}
+
+// SendStats returns the backpressure counters of this client.
+func (c *Client) SendStats() SendStats {
+	return c.sendStats.stats()
+}
+
+// =============================================================================
+// Queueing
+// =============================================================================
+
+// enqueue queues f for the write loop, applying bp when the send channel of its priority
+// class is full. SlowConsumerDropOldest only makes room within that class.
+func (c *Client) enqueue(f *outboundFrame, bp *sockethub_config.BackpressureConfig) error {
+	q := c.send[f.class]
+	if bp.Policy == sockethub_config.SlowConsumerCoalesce {
+		if f.key = bp.CoalesceKey(&f.header); f.key != "" {
+			c.queueMu.Lock()
+			if queued, ok := c.pending[f.key]; ok {
+				replaced := queued.header
+				queued.header, queued.payload = f.header, f.payload
+				c.queueMu.Unlock()
+				c.slowConsumer(bp, OutcomeCoalesced, replaced)
+				return nil
+			}
+			c.pending[f.key] = f // Visible before it is queued, so concurrent senders coalesce into it
+			c.queueMu.Unlock()
+		}
+	}
+
+	select {
+	case q <- f:
+		return nil
+	case <-c.done:
+		c.take(f)
+		return ErrClientClosed
+	default:
+	}
+
+	switch bp.Policy {
+	case sockethub_config.SlowConsumerDropOldest:
+		for {
+			select {
+			case oldest := <-q:
+				header, _ := c.take(oldest)
+				c.slowConsumer(bp, OutcomeDroppedOldest, header)
+			default:
+			}
+			select {
+			case q <- f:
+				return nil
+			case <-c.done:
+				c.take(f)
+				return ErrClientClosed
+			default:
+			}
+		}
+
+	case sockethub_config.SlowConsumerBlock:
+		t := time.NewTimer(bp.BlockTimeout)
+		defer t.Stop()
+		select {
+		case q <- f:
+			return nil
+		case <-c.done:
+			c.take(f)
+			return ErrClientClosed
+		case <-t.C:
+		}
+
+	case sockethub_config.SlowConsumerDisconnect:
+		header, _ := c.take(f)
+		c.slowConsumer(bp, OutcomeDisconnected, header)
+		c.hub.log(socketlog.WARNING, "Disconnecting slow consumer %s: send queue full", c.ID)
+		c.Close()
+		return ErrSlowConsumer
+	}
+
+	header, _ := c.take(f)
+	c.slowConsumer(bp, OutcomeDroppedNewest, header)
+	return ErrSendQueueFull
+}
+
+// take removes f from the coalescing index and returns its current contents.
+func (c *Client) take(f *outboundFrame) (protocol.SocketHeader, []byte) {
+	if f.key == "" {
+		return f.header, f.payload
+	}
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if c.pending[f.key] == f {
+		delete(c.pending, f.key)
+	}
+	return f.header, f.payload
+}
+
+// slowConsumer records a backpressure decision and reports it to the hub callback.
+func (c *Client) slowConsumer(bp *sockethub_config.BackpressureConfig, outcome SlowConsumerOutcome, header protocol.SocketHeader) {
+	c.sendStats.add(outcome)
+	c.hub.sendStats.add(outcome)
+
+	c.hub.mu.RLock()
+	fn := c.hub.onSlowConsumer
+	c.hub.mu.RUnlock()
+	if fn != nil {
+		fn(c, SlowConsumerEvent{Policy: bp.Policy, Outcome: outcome, Header: header})
+	}
+}
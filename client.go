@@ -0,0 +1,450 @@
+// Package sockethub provides the per-connection Client of a SocketHub.
+// Each client runs a read goroutine that dispatches incoming frames and a write goroutine
+// that drains its send channels by priority, so a slow client never blocks the others.
+package sockethub
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/codec"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// outboundFrame is a frame waiting in a client's send channel. The header is the client's own copy.
+// Frames with a coalescing key may be replaced while queued, so they are read under queueMu.
+type outboundFrame struct {
+	header  protocol.SocketHeader
+	payload []byte
+	class   protocol.Priority // Send channel the frame is queued in
+	key     string            // Coalescing key (empty if the frame is never coalesced)
+}
+
+// Client is a connection accepted by the hub, on any transport.
+type Client struct {
+	ID        uuid.UUID // Assigned by the hub; stamped as Sender on every frame the client sends
+	Listener  string    // Name of the listener that accepted the connection
+	conn      protocol.Conn
+	hub       *SocketHub
+	listener  *hubListener
+	send      [protocol.NumPriorities]chan *outboundFrame // One send channel per priority class
+	wake      chan struct{}                               // Signaled when a frame is queued
+	held      [protocol.NumPriorities]*outboundFrame      // Head of each class, taken but waiting for flow credit (write loop only)
+	credit    <-chan struct{}                             // Closed when flow credit grows after a frame was held (write loop only)
+	flow      protocol.FlowControlConn                    // conn, if the listener uses flow control
+	queueMu   sync.Mutex                                  // Guards pending and the frames it indexes
+	pending   map[string]*outboundFrame                   // Queued frames by coalescing key
+	sendStats sendCounters
+	done      chan struct{}
+	closeOnce sync.Once
+	rooms     map[uuid.UUID]struct{} // Rooms joined (guarded by hub.mu)
+	readMu    sync.Mutex             // Orders read deadline updates against Shutdown interrupting the read
+	goingAway chan struct{}          // Closed by Shutdown: send the going-away frame
+	flushing  chan struct{}          // Closed by Shutdown: write what is queued, then close
+	drainOnce sync.Once
+	codec     atomic.Pointer[codec.Codec] // Negotiated from the client's Accept extension (nil until announced)
+	addr      atomic.Pointer[uuid.UUID]   // Address bound by SocketHub.Bind (nil until bound)
+}
+
+func newClient(h *SocketHub, conn protocol.Conn, hl *hubListener) *Client {
+	var flow protocol.FlowControlConn
+	if fc := hl.config.FlowControl; fc != nil {
+		flow = protocol.NewFlowControlConn(conn, *fc)
+		conn = flow
+	}
+	conn.SetSender(h.id)
+	c := &Client{
+		ID:        uuid.New(),
+		Listener:  hl.config.Name,
+		conn:      conn,
+		flow:      flow,
+		hub:       h,
+		listener:  hl,
+		wake:      make(chan struct{}, 1),
+		pending:   make(map[string]*outboundFrame),
+		done:      make(chan struct{}),
+		rooms:     make(map[uuid.UUID]struct{}),
+		goingAway: make(chan struct{}),
+		flushing:  make(chan struct{}),
+	}
+	for i := range c.send {
+		c.send[i] = make(chan *outboundFrame, hl.config.SendChanSize)
+	}
+	return c
+}
+
+// Conn returns the underlying connection.
+func (c *Client) Conn() protocol.Conn {
+	return c.conn
+}
+
+// RemoteAddr returns the address of the remote end.
+func (c *Client) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// FlowStats returns the connection credit of the client, if its listener uses flow control.
+func (c *Client) FlowStats() (protocol.FlowStats, bool) {
+	if fc, ok := c.conn.(protocol.FlowControlConn); ok {
+		return fc.FlowStats(), true
+	}
+	return protocol.FlowStats{}, false
+}
+
+// Done is closed once the client is disconnected.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Send queues a frame for the client. The header is copied, extensions included; the payload
+// is not, so it must
+// not be modified afterwards. A nil Sender is sent as the hub ID. When the send channel is
+// full, the hub's slow-consumer policy applies: only SlowConsumerBlock waits, and a discarded
+// frame returns ErrSendQueueFull.
+func (c *Client) Send(header *protocol.SocketHeader, payload []byte) error {
+	return c.sendWith(&c.hub.config.Backpressure, header, payload)
+}
+
+// sendWith queues a frame under the given slow-consumer policy.
+func (c *Client) sendWith(bp *sockethub_config.BackpressureConfig, header *protocol.SocketHeader, payload []byte) error {
+	return c.sendIn(bp, header.Priority(), header, payload)
+}
+
+// sendIn queues a frame in the given priority class rather than the header's own, so frames
+// that must keep their order (such as a room's history and its ControlReplayEnd) share a class.
+func (c *Client) sendIn(bp *sockethub_config.BackpressureConfig, class protocol.Priority, header *protocol.SocketHeader, payload []byte) error {
+	select {
+	case <-c.done:
+		return ErrClientClosed
+	default:
+	}
+
+	f := &outboundFrame{header: *header.Clone(), payload: payload, class: class} // Callers such as Broadcast reuse the header
+	if f.header.Sender == uuid.Nil {
+		f.header.Sender = c.hub.id
+	}
+	err := c.enqueue(f, bp)
+	if err == nil {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return err
+}
+
+// dequeue returns the oldest frame of the highest non-empty priority class, or nil.
+func (c *Client) dequeue() *outboundFrame {
+	for class, q := range c.send {
+		if f := c.held[class]; f != nil {
+			c.held[class] = nil
+			return f
+		}
+		select {
+		case f := <-q:
+			return f
+		default:
+		}
+	}
+	return nil
+}
+
+// next returns the oldest frame of the highest priority class that can be written without
+// waiting for flow credit, or nil. A frame without credit is held at the head of its class,
+// so lower classes, and control frames that need no credit, keep going out meanwhile.
+func (c *Client) next() *outboundFrame {
+	if c.flow == nil {
+		return c.dequeue()
+	}
+	stalled := false // Held frames are checked again only once credit has grown
+	if c.credit != nil {
+		select {
+		case <-c.credit:
+			c.credit = nil
+		default:
+			stalled = true
+		}
+	}
+	for class, q := range c.send {
+		f := c.held[class]
+		if f == nil {
+			select {
+			case f = <-q:
+			default:
+				continue
+			}
+		} else if stalled {
+			continue
+		}
+		if ok, credit := c.ready(f); !ok {
+			c.held[class], c.credit = f, credit
+			continue
+		}
+		c.held[class] = nil
+		return f
+	}
+	return nil
+}
+
+// ready reports whether f can be written without waiting for flow credit.
+func (c *Client) ready(f *outboundFrame) (bool, <-chan struct{}) {
+	if f.key != "" {
+		c.queueMu.Lock()
+		defer c.queueMu.Unlock()
+	}
+	return c.flow.Ready(&f.header, f.payload)
+}
+
+// SendError queues an error frame for the client on the given router.
+func (c *Client) SendError(err error, router uint8) error {
+	header, payload, ferr := ErrorFrame(err, c.hub.id, c.ID, router)
+	if ferr != nil {
+		return ferr
+	}
+	return c.Send(header, payload)
+}
+
+// goAway asks the write loop to send the going-away frame and interrupts the pending read,
+// so the read loop exits as soon as its current handler returns. Called once, by Shutdown.
+func (c *Client) goAway() {
+	close(c.goingAway)
+	c.readMu.Lock()
+	c.conn.SetReadDeadline(time.Unix(1, 0))
+	c.readMu.Unlock()
+}
+
+// drain asks the write loop to deliver the queued frames and then close the client.
+func (c *Client) drain() {
+	c.drainOnce.Do(func() { close(c.flushing) })
+}
+
+// Close disconnects the client and removes it from the hub. Queued frames are discarded.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.conn.Close()
+		c.hub.unregister(c)
+	})
+	return err
+}
+
+// =============================================================================
+// Client Goroutines
+// =============================================================================
+
+// readLoop reads and dispatches frames until the connection fails or the client is closed.
+// During Shutdown it returns without closing, leaving the write loop to flush the client.
+func (c *Client) readLoop() {
+	defer c.hub.wg.Done()
+	defer c.hub.readers.Done()
+
+	if c.read() {
+		c.Close()
+	}
+}
+
+// read runs the read loop and reports whether the client should be closed on exit.
+func (c *Client) read() bool {
+	idle := c.listener.config.IdleTimeout
+	for {
+		c.readMu.Lock()
+		if c.hub.draining.Load() {
+			c.readMu.Unlock()
+			return false
+		}
+		if idle != nil && *idle > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(*idle))
+		}
+		c.readMu.Unlock()
+
+		header, payload, err := c.conn.ReadFrame()
+		if err != nil {
+			select {
+			case <-c.done:
+				return false // Closed locally
+			default:
+			}
+			if c.hub.draining.Load() {
+				return false
+			}
+			if protocol.IsDroppable(err) {
+				c.hub.log(socketlog.WARNING, "Dropped frame from %s: %v", c.ID, err)
+				continue
+			}
+			if protocol.IsTimeout(err) && idle != nil {
+				c.hub.log(socketlog.INFO, "Client %s idle for %v", c.ID, *idle)
+			} else if !protocol.IsFatal(err) {
+				c.hub.log(socketlog.WARNING, "Read error from %s: %v", c.ID, err)
+			}
+			return true
+		}
+
+		// The hub vouches for the sender, so clients cannot impersonate each other
+		header.Sender = c.Address()
+		if accept, ok := header.Accept(); ok {
+			c.negotiate(accept)
+		}
+		switch header.MessageType {
+		case protocol.MessageTypeHeartbeat:
+			continue
+		case protocol.MessageTypeControl:
+			c.handleControl(header, payload)
+			continue
+		}
+		if c.hub.delivery != nil && c.hub.receive(c, header, payload) {
+			continue
+		}
+		c.hub.dispatch(c, header, payload)
+	}
+}
+
+// handleControl processes a control frame from the client; control frames are never routed.
+func (c *Client) handleControl(header *protocol.SocketHeader, payload []byte) {
+	code, body, err := protocol.ParseControl(header, payload)
+	if err != nil {
+		c.hub.log(socketlog.WARNING, "Dropped control frame from %s: %v", c.ID, err)
+		return
+	}
+	switch code {
+	case protocol.ControlGoingAway:
+		c.hub.log(socketlog.INFO, "Client %s going away: %s", c.ID, body)
+	}
+}
+
+// writeLoop drains the send channels in priority order and keeps the connection alive with
+// heartbeats. Priorities are strict: lower classes wait while higher ones have frames queued.
+func (c *Client) writeLoop() {
+	defer c.hub.wg.Done()
+
+	var heartbeat <-chan time.Time
+	if hb := c.hub.config.HeartbeatInterval; hb != nil && *hb > 0 {
+		ticker := time.NewTicker(*hb)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	var redeliver <-chan time.Time
+	if d := c.hub.delivery; d != nil {
+		ticker := time.NewTicker(d.cfg.AckTimeout / 2)
+		defer ticker.Stop()
+		redeliver = ticker.C
+	}
+	flusher, _ := c.conn.(protocol.Flusher)
+	wrote := false           // Whether anything was written since the last heartbeat tick
+	goingAway := c.goingAway // Set to nil once the going-away frame is sent
+	flushed := true          // Whether everything written has been flushed
+
+	for {
+		// Shutdown signals are checked before each frame, so a busy queue cannot delay them
+		select {
+		case <-goingAway:
+			goingAway = nil
+			if !c.writeGoingAway(flusher) {
+				return
+			}
+		case <-c.flushing:
+			if goingAway != nil && !c.writeGoingAway(flusher) {
+				return
+			}
+			c.flush(flusher)
+			return
+		case <-redeliver:
+			if !c.redeliver() {
+				return
+			}
+			wrote, flushed = true, false
+		case <-c.done:
+			return
+		default:
+		}
+
+		if f := c.next(); f != nil {
+			header, payload := c.take(f)
+			if !c.write(&header, payload) {
+				return
+			}
+			c.written(&header, payload)
+			wrote, flushed = true, false
+			continue
+		}
+		if flusher != nil && !flushed {
+			flusher.Flush()
+			flushed = true
+		}
+
+		select {
+		case <-c.wake:
+		case <-c.credit:
+		case <-redeliver:
+			if !c.redeliver() {
+				return
+			}
+			wrote, flushed = true, false
+		case <-heartbeat:
+			if wrote {
+				wrote = false
+				continue
+			}
+			hb := protocol.SocketHeader{Sender: c.hub.id, MessageType: protocol.MessageTypeHeartbeat}
+			if !c.write(&hb, nil) {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-goingAway:
+		case <-c.flushing:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// writeGoingAway tells the client the hub is shutting down.
+func (c *Client) writeGoingAway(flusher protocol.Flusher) bool {
+	header, payload := protocol.GoingAwayFrame(ShutdownReason)
+	header.Sender = c.hub.id
+	if !c.write(header, payload) {
+		return false
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return true
+}
+
+// flush writes every queued frame in priority order and closes the client.
+func (c *Client) flush(flusher protocol.Flusher) {
+	for f := c.dequeue(); f != nil; f = c.dequeue() {
+		header, payload := c.take(f)
+		if !c.write(&header, payload) {
+			return
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	c.Close()
+}
+
+// write sends one frame with the write timeout applied, closing the client on failure.
+func (c *Client) write(header *protocol.SocketHeader, payload []byte) bool {
+	if wt := c.listener.config.WriteTimeout; wt != nil && *wt > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(*wt))
+	}
+	if err := c.conn.WriteFrame(header, payload); err != nil {
+		select {
+		case <-c.done:
+		default:
+			c.hub.log(socketlog.WARNING, "Write error to %s: %v", c.ID, err)
+			c.Close()
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+// Package main provides the gen subcommand, which turns a schema file into Go code.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Jdcabreradev/sockethub/schema"
+)
+
+// generate parses a schema file and writes the generated Go code next to it, or to -out.
+func generate(args []string) error {
+	fs := flag.NewFlagSet("sockethub gen", flag.ExitOnError)
+	out := fs.String("out", "", "output file (default: the schema path with a _gen.go suffix)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: sockethub gen [-out file] <schema file>")
+	}
+
+	in := fs.Arg(0)
+	src, err := os.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	f, err := schema.Parse(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+	code, err := schema.Generate(f, src, filepath.Base(in))
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(in, filepath.Ext(in)) + "_gen.go"
+	}
+	return os.WriteFile(*out, code, 0o644)
+}
@@ -1 +1,101 @@
-package main
\ No newline at end of file
+// Package main provides the sockethub command, which runs a SocketHub server.
+//
+// Usage:
+//
+//	sockethub [-tcp addr] [-udp addr] [-unix path] [-ws addr] [-log-dir dir] [-shutdown-timeout d]
+//	sockethub gen [-out file] <schema file>
+//
+// The first SIGINT or SIGTERM shuts the hub down gracefully; a second one exits immediately.
+// SIGHUP restarts without downtime: a new process inherits the listening sockets and starts
+// accepting while this one drains its clients within the shutdown timeout.
+//
+// The gen subcommand generates Go message types and typed handlers from a schema file
+// (see package schema).
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+)
+
+func main() {
+	run, args := serve, os.Args[1:]
+	if len(args) > 0 && args[0] == "gen" {
+		run, args = generate, args[1:]
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "sockethub:", err)
+		os.Exit(1)
+	}
+}
+
+// serve parses the flags, runs the hub and shuts it down on SIGINT or SIGTERM.
+func serve(args []string) error {
+	fs := flag.NewFlagSet("sockethub", flag.ExitOnError)
+	tcpAddr := fs.String("tcp", "127.0.0.1:8080", "TCP listen address (empty to disable)")
+	udpAddr := fs.String("udp", "", "UDP listen address")
+	unixPath := fs.String("unix", "", "Unix domain socket path")
+	wsAddr := fs.String("ws", "", "WebSocket listen address")
+	logDir := fs.String("log-dir", "./logs", "directory for log files")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 30*time.Second, "time allowed for a graceful shutdown")
+	fs.Parse(args)
+
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Listeners = nil
+	for _, l := range []sockethub_config.ListenerConfig{
+		{Network: sockethub_config.NetworkTCP, Address: *tcpAddr},
+		{Network: sockethub_config.NetworkUDP, Address: *udpAddr},
+		{Network: sockethub_config.NetworkUnix, Address: *unixPath},
+		{Network: sockethub_config.NetworkWebSocket, Address: *wsAddr},
+	} {
+		if l.Address != "" {
+			cfg.Listeners = append(cfg.Listeners, l)
+		}
+	}
+	if len(cfg.Listeners) == 0 {
+		return fmt.Errorf("no listeners configured")
+	}
+
+	logger, err := socketlog.NewLogger(*logDir, cfg.LogMode)
+	if err != nil {
+		return err
+	}
+	defer logger.Close()
+
+	hub, err := sockethub.New(cfg, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := notifyShutdown(context.Background())
+	defer stop()
+	restartSignals, stopRestart := notifyRestart()
+	defer stopRestart()
+	if err := hub.Start(context.Background()); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			stop() // Restore default signal handling: a second signal terminates the process
+			return shutdown(hub, *shutdownTimeout)
+		case <-restartSignals:
+			if err := startChild(hub, *shutdownTimeout); err != nil {
+				logger.Log("Command", socketlog.ERROR, "Restart failed, still serving: "+err.Error())
+				continue
+			}
+			stop()
+			return shutdown(hub, *shutdownTimeout)
+		case <-hub.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package main provides the signal handling used to stop the hub.
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+)
+
+// shutdownSignals are the signals that trigger a graceful shutdown.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// notifyShutdown returns a context canceled by the first SIGINT or SIGTERM. Calling stop
+// restores the default behavior, so a later signal terminates the process.
+func notifyShutdown(parent context.Context) (ctx context.Context, stop context.CancelFunc) {
+	return signal.NotifyContext(parent, shutdownSignals...)
+}
+
+// notifyRestart returns a channel that receives SIGHUP, which asks for a zero-downtime restart.
+func notifyRestart() (<-chan os.Signal, func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	return ch, func() { signal.Stop(ch) }
+}
+
+// startChild starts a new instance of this executable with the same arguments and hands it
+// the listening sockets, returning once the new instance is accepting.
+func startChild(hub *sockethub.SocketHub, timeout time.Duration) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return hub.StartChild(ctx, cmd)
+}
+
+// shutdown drains the hub, force-closing connections still open after timeout.
+func shutdown(hub *sockethub.SocketHub, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return hub.Shutdown(ctx)
+}
@@ -0,0 +1,232 @@
+// Package codec provides payload codecs selected by the ContentType header extension.
+// Built-in codecs cover JSON, gob, raw bytes and MessagePack; applications may register their
+// own. Peers announce the content types they can read with the Accept extension, and Negotiate
+// picks the first one both sides support.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// Codec encodes and decodes payloads of one content type.
+type Codec interface {
+	ContentType() string                // Media type stored in the ContentType extension
+	Marshal(v any) ([]byte, error)      // Encodes v
+	Unmarshal(data []byte, v any) error // Decodes data into the value v points to
+}
+
+// Built-in codecs
+var (
+	JSON        Codec = jsonCodec{}    // application/json
+	Gob         Codec = gobCodec{}     // application/x-gob
+	Raw         Codec = rawCodec{}     // application/octet-stream: []byte and string as is
+	MessagePack Codec = msgpackCodec{} // application/msgpack
+)
+
+// Default is the codec used for payloads without a ContentType extension.
+var Default = JSON
+
+// ErrUnknownContentType is returned for content types without a registered codec.
+var ErrUnknownContentType = errors.New("codec: unknown content type")
+
+// registry maps media types (without parameters, lower case) to codecs.
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Codec{
+		"application/json":         JSON,
+		"application/x-gob":        Gob,
+		"application/octet-stream": Raw,
+		"application/msgpack":      MessagePack,
+		"application/x-msgpack":    MessagePack,
+	}
+)
+
+// Register adds a codec under its content type and any aliases.
+// Returns an error if one of the names is already registered.
+func Register(c Codec, aliases ...string) error {
+	names := append([]string{c.ContentType()}, aliases...)
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for i, name := range names {
+		key, err := mediaType(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := registry[key]; ok {
+			return fmt.Errorf("codec: content type %q already registered", key)
+		}
+		names[i] = key
+	}
+	for _, key := range names {
+		registry[key] = c
+	}
+	return nil
+}
+
+// Lookup returns the codec for a content type. Parameters such as charset are ignored.
+func Lookup(contentType string) (Codec, error) {
+	key, err := mediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	registryMu.RLock()
+	c, ok := registry[key]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownContentType, key)
+	}
+	return c, nil
+}
+
+// ForHeader returns the codec named by the header's ContentType extension, or Default.
+func ForHeader(h *protocol.SocketHeader) (Codec, error) {
+	if contentType, ok := h.ContentType(); ok {
+		return Lookup(contentType)
+	}
+	return Default, nil
+}
+
+// Negotiate picks a codec for an Accept list such as "application/msgpack, application/json;q=0.5".
+// Types are tried by descending quality, in list order on ties; "*/*" accepts Default.
+// It returns false if no listed type has a registered codec.
+func Negotiate(accept string) (Codec, bool) {
+	type candidate struct {
+		name    string
+		quality float64
+	}
+	var candidates []candidate
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality > 0 {
+			candidates = append(candidates, candidate{name, quality})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		}
+		return 0
+	})
+
+	for _, cand := range candidates {
+		if cand.name == "*/*" {
+			return Default, true
+		}
+		if c, err := Lookup(cand.name); err == nil {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// Encode marshals v with c and records c's content type on the header.
+func Encode(h *protocol.SocketHeader, c Codec, v any) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.SetContentType(c.ContentType()); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Decode unmarshals a payload into a T with the codec named by the header.
+func Decode[T any](h *protocol.SocketHeader, payload []byte) (T, error) {
+	var v T
+	c, err := ForHeader(h)
+	if err != nil {
+		return v, err
+	}
+	if err := c.Unmarshal(payload, &v); err != nil {
+		return v, fmt.Errorf("codec: decode %s: %w", c.ContentType(), err)
+	}
+	return v, nil
+}
+
+// mediaType normalizes a content type to its lower-case media type.
+func mediaType(contentType string) (string, error) {
+	key, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("codec: invalid content type %q: %w", contentType, err)
+	}
+	return key, nil
+}
+
+// =============================================================================
+// Built-in Codecs
+// =============================================================================
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string { return "application/octet-stream" }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("codec: raw codec cannot encode %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = bytes.Clone(data)
+	case *string:
+		*v = string(data)
+	default:
+		return fmt.Errorf("codec: raw codec cannot decode into %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,645 @@
+// Package codec provides an in-tree MessagePack codec.
+// Values are encoded with reflection: structs become maps keyed by field name (or by the name in
+// a `msgpack:"name,omitempty"` tag), map keys are sorted so equal values encode identically, and
+// decoding into an interface yields nil, bool, int64, uint64, float64, string, []byte, []any or
+// map[string]any (map[any]any when a key is not a string). Types implementing
+// encoding.BinaryMarshaler, such as time.Time, are encoded as binary.
+package codec
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// maxMsgpackDepth bounds nesting so hostile input cannot exhaust the stack.
+const maxMsgpackDepth = 100
+
+var (
+	binaryMarshaler   = reflect.TypeFor[encoding.BinaryMarshaler]()
+	binaryUnmarshaler = reflect.TypeFor[encoding.BinaryUnmarshaler]()
+)
+
+// errMsgpackTruncated is returned when the input ends inside a value.
+var errMsgpackTruncated = errors.New("codec: msgpack: unexpected end of data")
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return "application/msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf []byte
+	return msgpackEncode(buf, reflect.ValueOf(v), 0)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("codec: msgpack: Unmarshal needs a non-nil pointer, got %T", v)
+	}
+	d := msgpackDecoder{data: data}
+	value, err := d.decode(0)
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("codec: msgpack: %d trailing bytes", len(data)-d.pos)
+	}
+	return msgpackAssign(rv.Elem(), value)
+}
+
+// =============================================================================
+// Encoding
+// =============================================================================
+
+func msgpackEncode(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("codec: msgpack: value nested too deeply")
+	}
+	if !v.IsValid() {
+		return append(buf, 0xc0), nil
+	}
+	if v.Kind() != reflect.Pointer && v.Kind() != reflect.Interface && v.Type().Implements(binaryMarshaler) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("codec: msgpack: %w", err)
+		}
+		return msgpackBinary(buf, b), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		return msgpackEncode(buf, v.Elem(), depth+1)
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return msgpackInt(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return msgpackUint(buf, v.Uint()), nil
+	case reflect.Float32:
+		buf = append(buf, 0xca)
+		return binary.BigEndian.AppendUint32(buf, math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		buf = append(buf, 0xcb)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return msgpackString(buf, v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if v.Kind() == reflect.Slice && v.IsNil() {
+				return append(buf, 0xc0), nil
+			}
+			return msgpackBinary(buf, msgpackBytes(v)), nil
+		}
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		buf = msgpackHeader(buf, v.Len(), 0x90, 0xdc, 0xdd)
+		var err error
+		for i := range v.Len() {
+			if buf, err = msgpackEncode(buf, v.Index(i), depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		return msgpackMap(buf, v, depth)
+	case reflect.Struct:
+		return msgpackStruct(buf, v, depth)
+	default:
+		return nil, fmt.Errorf("codec: msgpack: unsupported type %s", v.Type())
+	}
+}
+
+func msgpackInt(buf []byte, n int64) []byte {
+	switch {
+	case n >= 0:
+		return msgpackUint(buf, uint64(n))
+	case n >= -32:
+		return append(buf, byte(n))
+	case n >= math.MinInt8:
+		return append(buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(n))
+	}
+}
+
+func msgpackUint(buf []byte, n uint64) []byte {
+	switch {
+	case n <= 0x7f:
+		return append(buf, byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), n)
+	}
+}
+
+func msgpackString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func msgpackBinary(buf []byte, b []byte) []byte {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xc5), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xc6), uint32(n))
+	}
+	return append(buf, b...)
+}
+
+// msgpackBytes returns the contents of a byte slice or array.
+func msgpackBytes(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b
+}
+
+// msgpackHeader appends an array or map header for n elements.
+func msgpackHeader(buf []byte, n int, fix, code16, code32 byte) []byte {
+	switch {
+	case n < 16:
+		return append(buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, code16), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint32(append(buf, code32), uint32(n))
+	}
+}
+
+func msgpackMap(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	type entry struct{ key, value []byte }
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := msgpackEncode(nil, iter.Key(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := msgpackEncode(nil, iter.Value(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key, value})
+	}
+	slices.SortFunc(entries, func(a, b entry) int { return bytes.Compare(a.key, b.key) })
+
+	buf = msgpackHeader(buf, len(entries), 0x80, 0xde, 0xdf)
+	for _, e := range entries {
+		buf = append(append(buf, e.key...), e.value...)
+	}
+	return buf, nil
+}
+
+// msgpackField is an exported struct field and its encoded name.
+type msgpackField struct {
+	index     int
+	name      string
+	omitEmpty bool
+}
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	var fields []msgpackField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("msgpack"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, msgpackField{index: i, name: name, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+func msgpackStruct(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	fields := msgpackFields(v.Type())
+	n := 0
+	for _, f := range fields {
+		if !f.omitEmpty || !v.Field(f.index).IsZero() {
+			n++
+		}
+	}
+
+	buf = msgpackHeader(buf, n, 0x80, 0xde, 0xdf)
+	var err error
+	for _, f := range fields {
+		field := v.Field(f.index)
+		if f.omitEmpty && field.IsZero() {
+			continue
+		}
+		buf = msgpackString(buf, f.name)
+		if buf, err = msgpackEncode(buf, field, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// =============================================================================
+// Decoding
+// =============================================================================
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+// decode reads one value into its generic form.
+func (d *msgpackDecoder) decode(depth int) (any, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("codec: msgpack: value nested too deeply")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0xa0 && c <= 0xbf:
+		return d.str(int(c & 0x1f))
+	case c >= 0x90 && c <= 0x9f:
+		return d.array(int(c&0x0f), depth)
+	case c >= 0x80 && c <= 0x8f:
+		return d.mapping(int(c&0x0f), depth)
+	}
+
+	switch c := b[0]; c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.next(n)
+		return bytes.Clone(raw), err
+	case 0xca:
+		raw, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), nil
+	case 0xcb:
+		raw, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		raw, err := d.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		n := msgpackUnsigned(raw)
+		if n <= math.MaxInt64 {
+			return int64(n), nil
+		}
+		return n, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		raw, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(msgpackUnsigned(raw)<<shift) >> shift, nil // Sign-extend
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapping(n, depth)
+	default:
+		return nil, fmt.Errorf("codec: msgpack: unsupported type byte 0x%02x", c)
+	}
+}
+
+func msgpackUnsigned(raw []byte) uint64 {
+	var n uint64
+	for _, b := range raw {
+		n = n<<8 | uint64(b)
+	}
+	return n
+}
+
+func (d *msgpackDecoder) str(n int) (any, error) {
+	raw, err := d.next(n)
+	return string(raw), err
+}
+
+func (d *msgpackDecoder) array(n int, depth int) (any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated // Every element takes at least a byte
+	}
+	values := make([]any, n)
+	for i := range values {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+func (d *msgpackDecoder) mapping(n int, depth int) (any, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMsgpackTruncated
+	}
+	keys, values := make([]any, n), make([]any, n)
+	allStrings := true
+	for i := range n {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if !msgpackHashable(key) {
+			return nil, fmt.Errorf("codec: msgpack: unsupported map key %T", key)
+		}
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		_, isString := key.(string)
+		allStrings = allStrings && isString
+		keys[i], values[i] = key, value
+	}
+
+	if allStrings {
+		m := make(map[string]any, n)
+		for i, key := range keys {
+			m[key.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[any]any, n)
+	for i, key := range keys {
+		m[key] = values[i]
+	}
+	return m, nil
+}
+
+func msgpackHashable(key any) bool {
+	switch key.(type) {
+	case []byte, []any, map[string]any, map[any]any:
+		return false
+	}
+	return true
+}
+
+// msgpackAssign stores a decoded value in dst, converting it to dst's type.
+func msgpackAssign(dst reflect.Value, value any) error {
+	if value == nil {
+		dst.SetZero()
+		return nil
+	}
+	mismatch := func() error {
+		return fmt.Errorf("codec: msgpack: cannot decode %T into %s", value, dst.Type())
+	}
+	if b, ok := value.([]byte); ok && dst.Kind() != reflect.Pointer && reflect.PointerTo(dst.Type()).Implements(binaryUnmarshaler) {
+		return dst.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if dst.NumMethod() != 0 {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(value))
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return msgpackAssign(dst.Elem(), value)
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := value.(int64)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch()
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var n uint64
+		switch v := value.(type) {
+		case int64:
+			if v < 0 {
+				return mismatch()
+			}
+			n = uint64(v)
+		case uint64:
+			n = v
+		default:
+			return mismatch()
+		}
+		if dst.OverflowUint(n) {
+			return mismatch()
+		}
+		dst.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		switch v := value.(type) {
+		case float64:
+			dst.SetFloat(v)
+		case int64:
+			dst.SetFloat(float64(v))
+		case uint64:
+			dst.SetFloat(float64(v))
+		default:
+			return mismatch()
+		}
+	case reflect.String:
+		switch v := value.(type) {
+		case string:
+			dst.SetString(v)
+		case []byte:
+			dst.SetString(string(v))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := value.(type) {
+			case []byte:
+				dst.SetBytes(v)
+			case string:
+				dst.SetBytes([]byte(v))
+			default:
+				return mismatch()
+			}
+			return nil
+		}
+		values, ok := value.([]any)
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(dst.Type(), len(values), len(values))
+		for i, v := range values {
+			if err := msgpackAssign(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		var values []any
+		switch v := value.(type) {
+		case []any:
+			values = v
+		case []byte:
+			for _, b := range v {
+				values = append(values, int64(b))
+			}
+		default:
+			return mismatch()
+		}
+		if len(values) != dst.Len() {
+			return fmt.Errorf("codec: msgpack: cannot decode %d elements into %s", len(values), dst.Type())
+		}
+		for i, v := range values {
+			if err := msgpackAssign(dst.Index(i), v); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m := reflect.MakeMap(dst.Type())
+		assign := func(k, v any) error {
+			key := reflect.New(dst.Type().Key()).Elem()
+			if err := msgpackAssign(key, k); err != nil {
+				return err
+			}
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := msgpackAssign(elem, v); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, elem)
+			return nil
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			for k, e := range v {
+				if err := assign(k, e); err != nil {
+					return err
+				}
+			}
+		case map[any]any:
+			for k, e := range v {
+				if err := assign(k, e); err != nil {
+					return err
+				}
+			}
+		default:
+			return mismatch()
+		}
+		dst.Set(m)
+	case reflect.Struct:
+		m, ok := value.(map[string]any)
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range msgpackFields(dst.Type()) {
+			v, ok := m[f.name]
+			if !ok {
+				continue
+			}
+			if err := msgpackAssign(dst.Field(f.index), v); err != nil {
+				return fmt.Errorf("%w (field %s)", err, f.name)
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
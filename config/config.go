@@ -1,72 +1,259 @@
 package sockethub_config
 
-// import (
-// 	"crypto/tls"
-// 	"fmt"
-// 	"time"
-
-// 	"github.com/Jdcabreradev/sockethub/logger"
-// 	"github.com/Jdcabreradev/sockethub/protocol"
-// )
-
-// // SocketConfig holds configuration for the server
-// type SocketConfig struct {
-// 	IP                string            // IP to bind
-// 	Port              uint16            // Port to listen on
-// 	TLSConfig         *tls.Config       // TLS settings (nil for no TLS)
-// 	LogMode           socketlog.LogMode // Logging verbosity
-// 	Protocol          protocol.ProtocolType      // TCP or UDP
-// 	MaxClients        uint32            // Maximum simultaneous clients (zero for no limit)
-// 	BufferSize        int               // Buffer size for reads
-// 	ReadTimeout       *time.Duration    // Read timeout per-client
-// 	WriteTimeout      *time.Duration    // Write timeout per-client
-// 	IdleTimeout       *time.Duration    // Idle timeout per-client
-// 	SendChanSize      int               // Size of client send channels
-// 	HeartbeatInterval *time.Duration    // Heartbeat interval for connection health (zero for disabled)
-// 	EnableCompression bool              // Enable message compression
-// 	MaxMessageSize    int               // Maximum message size in bytes (zero for no limits)
-// }
-
-// // DefaultConfig returns a reasonable default configuration
-// func DefaultConfig() *SocketConfig {
-// 	defaultReadTimeout := 30 * time.Second
-// 	defaultWriteTimeout := 10 * time.Second
-// 	defaultIdleTimeout := 5 * time.Minute
-// 	defaultHeartbeat := 30 * time.Second
-
-// 	return &SocketConfig{
-// 		IP:                "127.0.0.1",
-// 		Port:              8080,
-// 		LogMode:           socketlog.DEV,
-// 		Protocol:          protocol.ProtocolTCP,
-// 		MaxClients:        10000,
-// 		BufferSize:        8192,
-// 		ReadTimeout:       &defaultReadTimeout,
-// 		WriteTimeout:      &defaultWriteTimeout,
-// 		IdleTimeout:       &defaultIdleTimeout,
-// 		SendChanSize:      256,
-// 		HeartbeatInterval: &defaultHeartbeat,
-// 		EnableCompression: true,
-// 		MaxMessageSize:    4 * 1024 * 1024, // 4MB
-// 	}
-// }
-
-// // Validate checks if the configuration is valid
-// func (c *SocketConfig) Validate() error {
-// 	if c.Port == 0 {
-// 		return fmt.Errorf("invalid port: %d", c.Port)
-// 	}
-// 	if c.MaxClients <= 0 {
-// 		return fmt.Errorf("maxClients must be greater than 0")
-// 	}
-// 	if c.BufferSize <= 0 {
-// 		return fmt.Errorf("bufferSize must be greater than 0")
-// 	}
-// 	if c.SendChanSize <= 0 {
-// 		return fmt.Errorf("sendChanSize must be greater than 0")
-// 	}
-// 	if c.MaxMessageSize <= 0 {
-// 		return fmt.Errorf("maxMessageSize must be greater than 0")
-// 	}
-// 	return nil
-// }
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/offline"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/roomlog"
+)
+
+// Listener networks supported by the hub
+const (
+	NetworkTCP       = "tcp"       // TCP, or TLS when TLSConfig is set
+	NetworkUDP       = "udp"       // UDP datagrams, one client per source address
+	NetworkUnix      = "unix"      // Unix domain stream socket
+	NetworkUnixgram  = "unixgram"  // Unix domain datagram socket
+	NetworkWebSocket = "websocket" // WebSocket over HTTP, or HTTPS when TLSConfig is set
+)
+
+// SlowConsumerPolicy selects what happens to a frame sent to a client whose send channel is full
+type SlowConsumerPolicy uint8
+
+const (
+	SlowConsumerDropNewest SlowConsumerPolicy = iota // Discard the new frame (default)
+	SlowConsumerDropOldest                           // Discard the oldest queued frame to make room
+	SlowConsumerBlock                                // Wait up to BlockTimeout for room, then discard the new frame
+	SlowConsumerCoalesce                             // Replace the queued frame with the same key; discard the new frame if none
+	SlowConsumerDisconnect                           // Close the client
+)
+
+// String returns the policy name
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case SlowConsumerDropNewest:
+		return "drop-newest"
+	case SlowConsumerDropOldest:
+		return "drop-oldest"
+	case SlowConsumerBlock:
+		return "block"
+	case SlowConsumerCoalesce:
+		return "coalesce"
+	case SlowConsumerDisconnect:
+		return "disconnect"
+	default:
+		return fmt.Sprintf("SlowConsumerPolicy(%d)", uint8(p))
+	}
+}
+
+// BackpressureConfig describes how frames are queued for clients that cannot keep up
+type BackpressureConfig struct {
+	Policy       SlowConsumerPolicy                         // What to do when a send channel is full
+	BlockTimeout time.Duration                              // How long SlowConsumerBlock waits for room
+	CoalesceKey  func(header *protocol.SocketHeader) string // Key for SlowConsumerCoalesce; frames with an empty key are never coalesced
+}
+
+// Validate checks if the backpressure configuration is valid
+func (b *BackpressureConfig) Validate() error {
+	switch b.Policy {
+	case SlowConsumerDropNewest, SlowConsumerDropOldest, SlowConsumerDisconnect:
+	case SlowConsumerBlock:
+		if b.BlockTimeout <= 0 {
+			return fmt.Errorf("blockTimeout must be greater than 0 for the block policy")
+		}
+	case SlowConsumerCoalesce:
+		if b.CoalesceKey == nil {
+			return fmt.Errorf("coalesceKey is required for the coalesce policy")
+		}
+	default:
+		return fmt.Errorf("invalid slow consumer policy: %v", b.Policy)
+	}
+	return nil
+}
+
+// OfflineQueueConfig describes the store-and-forward queue for direct messages to receivers
+// that are not connected
+type OfflineQueueConfig struct {
+	Store         offline.Store // Where queued frames are kept (nil for an in-memory store)
+	TTL           time.Duration // How long a frame stays queued (zero for no expiry)
+	MaxMessages   int           // Maximum frames queued per receiver (zero for no limit)
+	MaxBytes      int64         // Maximum payload bytes queued per receiver (zero for no limit)
+	MaxReceivers  int           // Maximum receivers with frames queued (zero for no limit)
+	MaxTotalBytes int64         // Maximum payload bytes queued across all receivers (zero for no limit)
+}
+
+// Validate checks if the offline queue configuration is valid
+func (o *OfflineQueueConfig) Validate() error {
+	if o.TTL < 0 || o.MaxMessages < 0 || o.MaxBytes < 0 || o.MaxReceivers < 0 || o.MaxTotalBytes < 0 {
+		return fmt.Errorf("ttl, maxMessages, maxBytes, maxReceivers and maxTotalBytes must not be negative")
+	}
+	return nil
+}
+
+// DeliveryConfig describes at-least-once delivery of frames flagged protocol.FlagReliable
+type DeliveryConfig struct {
+	AckTimeout  time.Duration // Wait for a client's ACK before redelivering (zero for reliable.DefaultAckTimeout)
+	MaxAttempts int           // Deliveries of a frame before it is dropped (zero for reliable.DefaultMaxAttempts)
+	MaxUnacked  int           // Unacknowledged frames kept per client address, dropping the oldest beyond it (zero for reliable.DefaultMaxUnacked)
+	DedupWindow int           // Frame IDs remembered per client address to drop redeliveries (zero for reliable.DefaultDedupWindow)
+	Retention   time.Duration // How long the unacknowledged frames of a disconnected address are kept (zero for no limit)
+}
+
+// Validate checks if the delivery configuration is valid
+func (d *DeliveryConfig) Validate() error {
+	if d.AckTimeout < 0 || d.MaxAttempts < 0 || d.MaxUnacked < 0 || d.DedupWindow < 0 || d.Retention < 0 {
+		return fmt.Errorf("ackTimeout, maxAttempts, maxUnacked, dedupWindow and retention must not be negative")
+	}
+	return nil
+}
+
+// ListenerConfig describes one listener of the hub. Zero-valued limits and timeouts
+// fall back to the hub-wide values in SocketConfig.
+type ListenerConfig struct {
+	Name           string                     // Identifies the listener in logs and on each Client (defaults to Network://Address)
+	Network        string                     // One of the Network* constants
+	Address        string                     // host:port, or the socket path for Unix networks
+	Path           string                     // HTTP path for WebSocket listeners (default "/")
+	TLSConfig      *tls.Config                // TLS settings for TCP and WebSocket listeners (nil for no TLS)
+	UnixSocket     protocol.UnixSocketOptions // Socket file permissions for Unix listeners
+	MaxClients     uint32                     // Maximum simultaneous clients on this listener (zero for hub limit only)
+	MaxMessageSize int                        // Maximum message size in bytes (zero for hub value)
+	WriteTimeout   *time.Duration             // Write timeout per-client (nil for hub value)
+	IdleTimeout    *time.Duration             // Idle timeout per-client (nil for hub value)
+	SendChanSize   int                        // Size of client send channels (zero for hub value)
+	FlowControl    *protocol.FlowControl      // Credit windows for stream listeners (nil for hub value)
+}
+
+// SocketConfig holds configuration for the server
+type SocketConfig struct {
+	Listeners         []ListenerConfig      // Listeners opened by SocketHub.Start
+	LogMode           socketlog.LogMode     // Logging verbosity
+	MaxClients        uint32                // Maximum simultaneous clients across all listeners (zero for no limit)
+	WriteTimeout      *time.Duration        // Write timeout per-client
+	IdleTimeout       *time.Duration        // Idle timeout per-client (nil for none)
+	SendChanSize      int                   // Size of client send channels (one per priority class)
+	HeartbeatInterval *time.Duration        // Heartbeat interval for connection health (nil for disabled)
+	MaxMessageSize    int                   // Maximum message size in bytes
+	Backpressure      BackpressureConfig    // Slow-consumer policy for client send channels (rooms may override it)
+	FlowControl       *protocol.FlowControl // Credit-based flow control on stream listeners (nil for none)
+	OfflineQueue      *OfflineQueueConfig   // Queue direct messages for receivers that are not connected (nil to drop them)
+	RoomLog           *roomlog.Log          // Durable history of room broadcasts for replay on join (nil for none)
+	Delivery          *DeliveryConfig       // Acknowledge and redeliver reliable frames (nil to treat them like any other)
+}
+
+// DefaultConfig returns a reasonable default configuration
+func DefaultConfig() *SocketConfig {
+	defaultWriteTimeout := 10 * time.Second
+	defaultIdleTimeout := 5 * time.Minute
+	defaultHeartbeat := 30 * time.Second
+
+	return &SocketConfig{
+		Listeners: []ListenerConfig{
+			{Network: NetworkTCP, Address: "127.0.0.1:8080"},
+		},
+		LogMode:           socketlog.DEV,
+		MaxClients:        10000,
+		WriteTimeout:      &defaultWriteTimeout,
+		IdleTimeout:       &defaultIdleTimeout,
+		SendChanSize:      256,
+		HeartbeatInterval: &defaultHeartbeat,
+		MaxMessageSize:    4 * 1024 * 1024, // 4MB
+	}
+}
+
+// Validate checks if the configuration is valid
+func (c *SocketConfig) Validate() error {
+	if c.SendChanSize <= 0 {
+		return fmt.Errorf("sendChanSize must be greater than 0")
+	}
+	if c.MaxMessageSize <= 0 {
+		return fmt.Errorf("maxMessageSize must be greater than 0")
+	}
+	if err := c.Backpressure.Validate(); err != nil {
+		return fmt.Errorf("backpressure: %w", err)
+	}
+	if c.OfflineQueue != nil {
+		if err := c.OfflineQueue.Validate(); err != nil {
+			return fmt.Errorf("offlineQueue: %w", err)
+		}
+	}
+	if c.Delivery != nil {
+		if err := c.Delivery.Validate(); err != nil {
+			return fmt.Errorf("delivery: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(c.Listeners))
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := l.Validate(); err != nil {
+			return fmt.Errorf("listener %d: %w", i, err)
+		}
+		name := l.ListenerName()
+		if names[name] {
+			return fmt.Errorf("duplicate listener name %q", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// Validate checks if the listener configuration is valid
+func (l *ListenerConfig) Validate() error {
+	switch l.Network {
+	case NetworkTCP, NetworkUDP, NetworkUnix, NetworkUnixgram, NetworkWebSocket:
+	default:
+		return fmt.Errorf("invalid network: %q", l.Network)
+	}
+	if l.Address == "" {
+		return fmt.Errorf("address is required")
+	}
+	if l.TLSConfig != nil && l.Network != NetworkTCP && l.Network != NetworkWebSocket {
+		return fmt.Errorf("TLS is not supported on %s listeners", l.Network)
+	}
+	if l.MaxMessageSize < 0 || l.SendChanSize < 0 {
+		return fmt.Errorf("maxMessageSize and sendChanSize must not be negative")
+	}
+	if l.FlowControl != nil && l.datagram() {
+		return fmt.Errorf("flow control is not supported on %s listeners", l.Network)
+	}
+	return nil
+}
+
+// ListenerName returns Name, or Network://Address when Name is empty.
+func (l *ListenerConfig) ListenerName() string {
+	if l.Name != "" {
+		return l.Name
+	}
+	return l.Network + "://" + l.Address
+}
+
+// Resolved returns a copy of l with every unset limit and timeout taken from c.
+func (c *SocketConfig) Resolved(l ListenerConfig) ListenerConfig {
+	l.Name = l.ListenerName()
+	if l.MaxMessageSize == 0 {
+		l.MaxMessageSize = c.MaxMessageSize
+	}
+	if l.WriteTimeout == nil {
+		l.WriteTimeout = c.WriteTimeout
+	}
+	if l.IdleTimeout == nil {
+		l.IdleTimeout = c.IdleTimeout
+	}
+	if l.SendChanSize == 0 {
+		l.SendChanSize = c.SendChanSize
+	}
+	if l.FlowControl == nil && !l.datagram() {
+		l.FlowControl = c.FlowControl
+	}
+	return l
+}
+
+// datagram reports whether the listener uses a datagram network, where a lost window
+// update would stall the peer for good.
+func (l *ListenerConfig) datagram() bool {
+	return l.Network == NetworkUDP || l.Network == NetworkUnixgram
+}
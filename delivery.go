@@ -0,0 +1,281 @@
+// Package sockethub provides at-least-once delivery. With SocketConfig.Delivery set, frames a
+// client sends with protocol.FlagReliable are dispatched once per ID and acknowledged once their
+// handler succeeds (see HandleReliable) or they are routed; rejected frames are answered with an
+// error frame instead. Frames the hub writes with the flag are kept per client address
+// and written again until the client acknowledges them: after the ACK timeout, and at once
+// when a client binds the address again after a reconnect. See package reliable for the
+// client side.
+package sockethub
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/reliable"
+	"github.com/google/uuid"
+)
+
+// delivery is the hub's at-least-once delivery state.
+type delivery struct {
+	cfg   sockethub_config.DeliveryConfig
+	mu    sync.Mutex
+	peers map[uuid.UUID]*peerDelivery // By client address
+	swept time.Time                   // Last removal of expired peers
+}
+
+// peerDelivery is the delivery state of one client address.
+type peerDelivery struct {
+	unacked []*unackedFrame  // Frames written to the client, in the order first written
+	seen    *reliable.Window // IDs of frames from the client that were dispatched
+	holder  uuid.UUID        // ID of the client last seen at the address
+	left    time.Time        // When the holder disconnected (zero while connected)
+}
+
+// unackedFrame is a frame waiting for the client's ACK.
+type unackedFrame struct {
+	header   protocol.SocketHeader
+	payload  []byte
+	written  time.Time
+	attempts int
+}
+
+// newDelivery returns the delivery state for cfg, or nil if delivery is disabled.
+func newDelivery(cfg *sockethub_config.DeliveryConfig) *delivery {
+	if cfg == nil {
+		return nil
+	}
+	d := &delivery{cfg: *cfg, peers: make(map[uuid.UUID]*peerDelivery), swept: time.Now()}
+	if d.cfg.AckTimeout == 0 {
+		d.cfg.AckTimeout = reliable.DefaultAckTimeout
+	}
+	if d.cfg.MaxAttempts == 0 {
+		d.cfg.MaxAttempts = reliable.DefaultMaxAttempts
+	}
+	if d.cfg.MaxUnacked == 0 {
+		d.cfg.MaxUnacked = reliable.DefaultMaxUnacked
+	}
+	if d.cfg.DedupWindow == 0 {
+		d.cfg.DedupWindow = reliable.DefaultDedupWindow
+	}
+	return d
+}
+
+// peer returns the state of an address, creating it if needed. d.mu must be held.
+func (d *delivery) peer(addr uuid.UUID) *peerDelivery {
+	p, ok := d.peers[addr]
+	if !ok {
+		p = &peerDelivery{seen: reliable.NewWindow(d.cfg.DedupWindow)}
+		d.peers[addr] = p
+	}
+	return p
+}
+
+// written records that a reliable frame was written to the client at addr. Beyond MaxUnacked
+// frames the oldest are dropped, and returned as evicted.
+func (d *delivery) written(addr, clientID uuid.UUID, header *protocol.SocketHeader, payload []byte, now time.Time) (evicted int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p := d.peer(addr)
+	p.holder = clientID
+	for _, f := range p.unacked {
+		if f.header.ID == header.ID {
+			f.written = now
+			f.attempts++
+			return 0
+		}
+	}
+	p.unacked = append(p.unacked, &unackedFrame{header: *header, payload: payload, written: now, attempts: 1})
+	evicted = d.trim(p)
+
+	if d.cfg.Retention > 0 && now.Sub(d.swept) >= d.cfg.Retention {
+		d.swept = now
+		for addr, p := range d.peers {
+			if !p.left.IsZero() && now.Sub(p.left) > d.cfg.Retention {
+				delete(d.peers, addr)
+			}
+		}
+	}
+	return evicted
+}
+
+// trim drops the oldest unacknowledged frames of p beyond MaxUnacked and returns how many it
+// dropped. d.mu must be held.
+func (d *delivery) trim(p *peerDelivery) int {
+	n := len(p.unacked) - d.cfg.MaxUnacked
+	if n <= 0 {
+		return 0
+	}
+	clear(p.unacked[:n])
+	p.unacked = p.unacked[n:]
+	return n
+}
+
+// acked removes a frame the client at addr acknowledged.
+func (d *delivery) acked(addr, id uuid.UUID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if p, ok := d.peers[addr]; ok {
+		p.unacked = slices.DeleteFunc(p.unacked, func(f *unackedFrame) bool { return f.header.ID == id })
+	}
+}
+
+// due returns copies of the frames for addr whose ACK is overdue at now. Frames that were
+// written MaxAttempts times are dropped instead, and returned as dropped.
+func (d *delivery) due(addr uuid.UUID, now time.Time) (due []unackedFrame, dropped int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.peers[addr]
+	if !ok {
+		return nil, 0
+	}
+	p.unacked = slices.DeleteFunc(p.unacked, func(f *unackedFrame) bool {
+		if now.Sub(f.written) < d.cfg.AckTimeout {
+			return false
+		}
+		if f.attempts >= d.cfg.MaxAttempts {
+			dropped++
+			return true
+		}
+		due = append(due, *f)
+		return false
+	})
+	return due, dropped
+}
+
+// resume moves the state of from (the client's previous address) to addr, now held by the
+// client with ID clientID, and returns copies of every frame still unacknowledged at addr.
+func (d *delivery) resume(from, addr, clientID uuid.UUID) []unackedFrame {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p := d.peer(addr)
+	if old, ok := d.peers[from]; ok && from != addr {
+		p.unacked = append(p.unacked, old.unacked...)
+		delete(d.peers, from)
+		d.trim(p)
+	}
+	p.holder, p.left = clientID, time.Time{}
+	frames := make([]unackedFrame, len(p.unacked))
+	for i, f := range p.unacked {
+		frames[i] = *f
+	}
+	return frames
+}
+
+// leave records that the client with ID clientID disconnected from addr, unless another
+// client has taken the address over. Frames for an address that was only a connection ID can
+// never be redelivered, so its state is dropped.
+func (d *delivery) leave(addr, clientID uuid.UUID, bound bool, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.peers[addr]
+	switch {
+	case !ok || (p.holder != clientID && p.holder != uuid.Nil):
+	case !bound:
+		delete(d.peers, addr)
+	default:
+		p.left = now
+	}
+}
+
+// seen reports whether a frame from addr was already dispatched.
+func (d *delivery) seen(addr, id uuid.UUID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p, ok := d.peers[addr]
+	return ok && p.seen.Contains(id)
+}
+
+// processed records that a frame from addr was dispatched.
+func (d *delivery) processed(addr, id uuid.UUID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.peer(addr).seen.Add(id)
+}
+
+// =============================================================================
+// Hub and Client Hooks
+// =============================================================================
+
+// receive handles the delivery side of a frame from c: ACKs settle frames the hub wrote, and
+// reliable frames are dispatched unless they were before, then acknowledged if dispatch
+// accepted them. It reports whether the frame was consumed.
+func (h *SocketHub) receive(c *Client, header *protocol.SocketHeader, payload []byte) bool {
+	d := h.delivery
+	switch {
+	case reliable.IsAck(header):
+		d.acked(c.Address(), header.ID)
+		return true
+	case reliable.IsReliable(header):
+		addr := c.Address()
+		if !d.seen(addr, header.ID) {
+			if !h.dispatch(c, header, payload) {
+				return true // Answered with an error frame; a redelivery is dispatched again
+			}
+			d.processed(addr, header.ID)
+		} else {
+			h.log(socketlog.DEBUG, "Dropped redelivered frame %s from %s", header.ID, c.ID)
+		}
+		c.Send(reliable.AckFrame(header.ID, addr), nil)
+		return true
+	}
+	return false
+}
+
+// resumeDelivery writes the frames still unacknowledged at the client's previous address and
+// at address to c, which is being bound to address. The lock of address in h.addressMu must be
+// held.
+func (h *SocketHub) resumeDelivery(c *Client, from, address uuid.UUID) {
+	if h.delivery == nil {
+		return
+	}
+	bp := h.catchUpPolicy()
+	frames := h.delivery.resume(from, address, c.ID)
+	for i := range frames {
+		if err := c.sendWith(&bp, &frames[i].header, frames[i].payload); err != nil {
+			h.log(socketlog.WARNING, "Redelivered %d of %d unacknowledged frames to %s: %v", i, len(frames), c.ID, err)
+			return
+		}
+	}
+	if len(frames) > 0 {
+		h.log(socketlog.INFO, "Redelivering %d unacknowledged frames to %s", len(frames), c.ID)
+	}
+}
+
+// written records a frame the write loop has written, if it must be acknowledged.
+func (c *Client) written(header *protocol.SocketHeader, payload []byte) {
+	if d := c.hub.delivery; d != nil && reliable.IsReliable(header) {
+		if evicted := d.written(c.Address(), c.ID, header, payload, time.Now()); evicted > 0 {
+			c.hub.log(socketlog.WARNING, "Dropped %d unacknowledged frames for %s beyond the limit of %d", evicted, c.ID, d.cfg.MaxUnacked)
+		}
+	}
+}
+
+// redeliver writes the frames whose ACK is overdue again. It reports false if a write failed.
+func (c *Client) redeliver() bool {
+	due, dropped := c.hub.delivery.due(c.Address(), time.Now())
+	if dropped > 0 {
+		c.hub.log(socketlog.WARNING, "Dropped %d frames for %s after %d unacknowledged deliveries", dropped, c.ID, c.hub.delivery.cfg.MaxAttempts)
+	}
+	for i := range due {
+		header := due[i].header
+		if c.flow != nil {
+			if ok, _ := c.flow.Ready(&header, due[i].payload); !ok {
+				continue // Still due at the next tick; the write loop must not wait for credit
+			}
+		}
+		if !c.write(&header, due[i].payload) {
+			return false
+		}
+		c.written(&header, due[i].payload)
+	}
+	return true
+}
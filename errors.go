@@ -0,0 +1,282 @@
+// Package sockethub provides the standard error frame format exchanged between SocketHub peers.
+// An Error travels as the payload of a frame flagged with FlagError and round-trips back into a Go error.
+package sockethub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Error Codes
+// =============================================================================
+
+// ErrorCode identifies the kind of failure carried by an error frame.
+type ErrorCode uint16
+
+const (
+	ErrorCodeUnknown         ErrorCode = iota // Uninitialized/unspecified error
+	ErrorCodeUnauthorized                     // Sender is not allowed to perform the operation
+	ErrorCodeUnknownReceiver                  // Receiver UUID is not connected or does not exist
+	ErrorCodeRateLimited                      // Sender exceeded its allowed message rate
+	ErrorCodeTooLarge                         // Frame or payload exceeds the configured maximum size
+	ErrorCodeInternal                         // Unexpected failure inside the hub or handler
+	ErrorCodeInvalidPayload                   // Payload does not match the schema of its router
+	// Extend with more well-known codes as needed (must stay below ErrorCodeUserBase).
+)
+
+// ErrorCodeUserBase is the first code available to applications.
+// Codes below it are reserved for SocketHub itself.
+const ErrorCodeUserBase ErrorCode = 0x0100
+
+// errorCodeRegistry maps every known code to its display name.
+var (
+	errorCodeMu       sync.RWMutex
+	errorCodeRegistry = map[ErrorCode]string{
+		ErrorCodeUnknown:         "Unknown",
+		ErrorCodeUnauthorized:    "Unauthorized",
+		ErrorCodeUnknownReceiver: "UnknownReceiver",
+		ErrorCodeRateLimited:     "RateLimited",
+		ErrorCodeTooLarge:        "TooLarge",
+		ErrorCodeInternal:        "Internal",
+		ErrorCodeInvalidPayload:  "InvalidPayload",
+	}
+)
+
+// RegisterErrorCode adds an application-defined code to the registry.
+// Returns an error if the code falls in the reserved range or is already registered.
+func RegisterErrorCode(code ErrorCode, name string) error {
+	if code < ErrorCodeUserBase {
+		return fmt.Errorf("sockethub: error code %d is reserved (user codes start at %d)", code, ErrorCodeUserBase)
+	}
+	if name == "" {
+		return errors.New("sockethub: error code name cannot be empty")
+	}
+
+	errorCodeMu.Lock()
+	defer errorCodeMu.Unlock()
+
+	if existing, ok := errorCodeRegistry[code]; ok {
+		return fmt.Errorf("sockethub: error code %d already registered as %q", code, existing)
+	}
+	errorCodeRegistry[code] = name
+	return nil
+}
+
+// String returns the registered name of the ErrorCode.
+func (c ErrorCode) String() string {
+	errorCodeMu.RLock()
+	name, ok := errorCodeRegistry[c]
+	errorCodeMu.RUnlock()
+
+	if !ok {
+		return fmt.Sprintf("ErrorCode(%d)", uint16(c))
+	}
+	return name
+}
+
+// IsRegistered reports true if the ErrorCode is well-known or was registered by the application.
+func (c ErrorCode) IsRegistered() bool {
+	errorCodeMu.RLock()
+	defer errorCodeMu.RUnlock()
+
+	_, ok := errorCodeRegistry[c]
+	return ok
+}
+
+// =============================================================================
+// Error Type
+// =============================================================================
+
+// Error is a structured error that can be sent to a peer as an error frame.
+type Error struct {
+	Code      ErrorCode // Machine-readable error code
+	Message   string    // Human-readable description
+	Details   []byte    // Optional opaque details (e.g., JSON)
+	RequestID uuid.UUID // ID of the frame that caused the error (uuid.Nil if none)
+}
+
+// Well-known errors, usable as errors.Is targets (matching is done by Code).
+var (
+	ErrUnauthorized    = &Error{Code: ErrorCodeUnauthorized, Message: "unauthorized"}
+	ErrUnknownReceiver = &Error{Code: ErrorCodeUnknownReceiver, Message: "unknown receiver"}
+	ErrRateLimited     = &Error{Code: ErrorCodeRateLimited, Message: "rate limited"}
+	ErrTooLarge        = &Error{Code: ErrorCodeTooLarge, Message: "message too large"}
+	ErrInternal        = &Error{Code: ErrorCodeInternal, Message: "internal error"}
+	ErrInvalidPayload  = &Error{Code: ErrorCodeInvalidPayload, Message: "invalid payload"}
+)
+
+// NewError creates an Error with the given code and message.
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("sockethub: %s", e.Code)
+	}
+	return fmt.Sprintf("sockethub: %s: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is an *Error with the same Code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return e.Code == t.Code
+}
+
+// WithRequest returns a copy of the Error correlated to the given request ID.
+func (e *Error) WithRequest(id uuid.UUID) *Error {
+	c := *e
+	c.RequestID = id
+	return &c
+}
+
+// WithDetails returns a copy of the Error carrying the given details.
+func (e *Error) WithDetails(details []byte) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
+
+// =============================================================================
+// Wire Format
+// =============================================================================
+
+// errorPayloadMinSize is the size of an encoded Error with empty Message and Details:
+//
+//	Code(2) + RequestID(16) + MessageLen(2) + DetailsLen(4)
+const errorPayloadMinSize = 2 + 16 + 2 + 4
+
+// MarshalBinary encodes the Error into an error frame payload.
+// Layout (big-endian): Code(2) + RequestID(16) + MessageLen(2) + Message + DetailsLen(4) + Details.
+func (e *Error) MarshalBinary() ([]byte, error) {
+	if len(e.Message) > 0xFFFF {
+		return nil, fmt.Errorf("sockethub: error message too long (%d bytes, max %d)", len(e.Message), 0xFFFF)
+	}
+	if uint64(len(e.Details)) > 0xFFFFFFFF {
+		return nil, fmt.Errorf("sockethub: error details too long (%d bytes)", len(e.Details))
+	}
+
+	buf := make([]byte, errorPayloadMinSize+len(e.Message)+len(e.Details))
+	offset := 0
+
+	binary.BigEndian.PutUint16(buf[offset:], uint16(e.Code))
+	offset += 2
+
+	copy(buf[offset:], e.RequestID[:])
+	offset += 16
+
+	binary.BigEndian.PutUint16(buf[offset:], uint16(len(e.Message)))
+	offset += 2
+	copy(buf[offset:], e.Message)
+	offset += len(e.Message)
+
+	binary.BigEndian.PutUint32(buf[offset:], uint32(len(e.Details)))
+	offset += 4
+	copy(buf[offset:], e.Details)
+
+	return buf, nil
+}
+
+// UnmarshalBinary decodes an error frame payload into the Error.
+func (e *Error) UnmarshalBinary(data []byte) error {
+	if len(data) < errorPayloadMinSize {
+		return fmt.Errorf("sockethub: error payload too small (got %d, min %d)", len(data), errorPayloadMinSize)
+	}
+	offset := 0
+
+	code := ErrorCode(binary.BigEndian.Uint16(data[offset:]))
+	offset += 2
+
+	var requestID uuid.UUID
+	copy(requestID[:], data[offset:offset+16])
+	offset += 16
+
+	msgLen := int(binary.BigEndian.Uint16(data[offset:]))
+	offset += 2
+	if len(data) < offset+msgLen+4 {
+		return fmt.Errorf("sockethub: error payload truncated in message (need %d, got %d)", offset+msgLen+4, len(data))
+	}
+	message := string(data[offset : offset+msgLen])
+	offset += msgLen
+
+	detailsLen := uint64(binary.BigEndian.Uint32(data[offset:]))
+	offset += 4
+	if uint64(len(data)-offset) != detailsLen {
+		return fmt.Errorf("sockethub: error payload size mismatch (details %d, remaining %d)", detailsLen, len(data)-offset)
+	}
+
+	var details []byte
+	if detailsLen > 0 {
+		details = make([]byte, detailsLen)
+		copy(details, data[offset:])
+	}
+
+	e.Code = code
+	e.RequestID = requestID
+	e.Message = message
+	e.Details = details
+	return nil
+}
+
+// =============================================================================
+// Frame Helpers
+// =============================================================================
+
+// ErrorFrame builds an error frame (header + payload) from err, addressed from sender to receiver.
+// Errors that are not an *Error are sent as ErrorCodeInternal with their text as the message.
+func ErrorFrame(err error, sender, receiver uuid.UUID, router uint8) (*protocol.SocketHeader, []byte, error) {
+	if err == nil {
+		return nil, nil, errors.New("sockethub: cannot build error frame from nil error")
+	}
+
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Code: ErrorCodeInternal, Message: err.Error()}
+	}
+
+	payload, mErr := e.MarshalBinary()
+	if mErr != nil {
+		return nil, nil, mErr
+	}
+
+	header := &protocol.SocketHeader{
+		ID:          uuid.New(),
+		Sender:      sender,
+		Receiver:    receiver,
+		MessageType: protocol.MessageTypeData,
+		Flags:       protocol.FlagError,
+		Router:      router,
+	}
+	if e.RequestID != uuid.Nil {
+		header.SetCorrelationID(e.RequestID)
+	}
+	return header, payload, nil
+}
+
+// IsErrorFrame reports true if the header marks its payload as an error frame.
+func IsErrorFrame(h *protocol.SocketHeader) bool {
+	return h != nil && protocol.HasFlag(h.Flags, protocol.FlagError)
+}
+
+// ErrorFromFrame decodes the *Error carried by an error frame.
+// Returns an error if the header is not flagged with FlagError or the payload is malformed.
+func ErrorFromFrame(h *protocol.SocketHeader, payload []byte) (*Error, error) {
+	if !IsErrorFrame(h) {
+		return nil, errors.New("sockethub: frame is not an error frame")
+	}
+	e := &Error{}
+	if err := e.UnmarshalBinary(payload); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,188 @@
+// Package sockethub provides zero-downtime restarts by handing listening sockets to a new process.
+// The running hub starts the child with its sockets as inherited file descriptors, waits until
+// the child reports that it is accepting, and then drains its own clients with Shutdown.
+package sockethub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+
+	"github.com/Jdcabreradev/sockethub/logger"
+)
+
+// Environment variables used to pass sockets to a child process
+const (
+	EnvListenFDs = "SOCKETHUB_LISTEN_FDS" // URL-encoded listener name to file descriptor pairs
+	EnvReadyFD   = "SOCKETHUB_READY_FD"   // Pipe the child writes to once its listeners are served
+)
+
+var (
+	// ErrNoHandoffSockets is returned by Handoff when no listener was opened by the hub itself.
+	ErrNoHandoffSockets = errors.New("sockethub: no listeners to hand off")
+
+	// ErrChildNotReady is returned when the child process exits before serving its listeners.
+	ErrChildNotReady = errors.New("sockethub: child process exited before it was ready")
+)
+
+// socketFile is the OS socket under a listener; File returns a duplicate descriptor.
+type socketFile interface {
+	File() (*os.File, error)
+	SyscallConn() (syscall.RawConn, error)
+}
+
+// Handoff starts cmd with the hub's listening sockets and, once it is accepting, gracefully
+// shuts this hub down. The child must create its hub with the same listener names; Start picks
+// the sockets up from the environment. ctx bounds both the wait for the child and the drain.
+// Datagram listeners are closed on shutdown, so their peers continue with the child.
+func (h *SocketHub) Handoff(ctx context.Context, cmd *exec.Cmd) error {
+	if err := h.StartChild(ctx, cmd); err != nil {
+		return err
+	}
+	return h.Shutdown(ctx)
+}
+
+// StartChild starts cmd with the hub's listening sockets and waits until the child serves them.
+// This hub keeps accepting too, so both processes share the sockets until it is shut down.
+// If ctx expires first, the child is killed.
+func (h *SocketHub) StartChild(ctx context.Context, cmd *exec.Cmd) error {
+	h.mu.RLock()
+	var handed []*hubListener
+	for _, hl := range h.listeners {
+		if hl.socket != nil {
+			handed = append(handed, hl)
+		}
+	}
+	h.mu.RUnlock()
+	if len(handed) == 0 {
+		return ErrNoHandoffSockets
+	}
+
+	// Descriptors 0-2 are stdio; ExtraFiles follow in order
+	fds := url.Values{}
+	base := 3 + len(cmd.ExtraFiles)
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, hl := range handed {
+		f, err := hl.socket.File()
+		if err != nil {
+			return fmt.Errorf("sockethub: listener %s: %w", hl.config.Name, err)
+		}
+		fds.Set(hl.config.Name, strconv.Itoa(base+len(files)))
+		files = append(files, f)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("sockethub: ready pipe: %w", err)
+	}
+	defer r.Close()
+	files = append(files, w)
+
+	cmd.ExtraFiles = append(cmd.ExtraFiles, files...)
+	cmd.Env = append(cmd.Environ(),
+		EnvListenFDs+"="+fds.Encode(),
+		EnvReadyFD+"="+strconv.Itoa(base+len(files)-1),
+	)
+	err = cmd.Start()
+	for _, hl := range handed {
+		restoreNonblock(hl.socket)
+	}
+	if err != nil {
+		return fmt.Errorf("sockethub: start child: %w", err)
+	}
+	w.Close() // Only the child holds the write end now, so its exit reads as EOF
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		if errors.Is(err, io.EOF) {
+			err = ErrChildNotReady
+		}
+		return err
+	}
+
+	// The sockets now belong to the child as well; closing ours must not remove their files
+	for _, hl := range handed {
+		if ul, ok := hl.socket.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+		if u, ok := hl.listener.(*unixgramListener); ok {
+			u.keep.Store(true)
+		}
+	}
+	h.log(socketlog.INFO, "Handed %d listeners to process %d", len(handed), cmd.Process.Pid)
+	return nil
+}
+
+// inheritSockets returns the sockets passed by a parent process, keyed by listener name, and
+// the pipe used to report readiness. The variables are cleared so they are consumed once.
+func inheritSockets() (map[string]*os.File, *os.File, error) {
+	listenFDs, readyFD := os.Getenv(EnvListenFDs), os.Getenv(EnvReadyFD)
+	if listenFDs == "" && readyFD == "" {
+		return nil, nil, nil
+	}
+	os.Unsetenv(EnvListenFDs)
+	os.Unsetenv(EnvReadyFD)
+
+	fds, err := url.ParseQuery(listenFDs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sockethub: invalid %s: %w", EnvListenFDs, err)
+	}
+	inherited := make(map[string]*os.File, len(fds))
+	for name := range fds {
+		fd, err := strconv.Atoi(fds.Get(name))
+		if err != nil || fd < 3 {
+			closeFiles(inherited)
+			return nil, nil, fmt.Errorf("sockethub: invalid descriptor for listener %s in %s", name, EnvListenFDs)
+		}
+		inherited[name] = os.NewFile(uintptr(fd), name)
+	}
+
+	var ready *os.File
+	if readyFD != "" {
+		fd, err := strconv.Atoi(readyFD)
+		if err != nil || fd < 3 {
+			closeFiles(inherited)
+			return nil, nil, fmt.Errorf("sockethub: invalid %s", EnvReadyFD)
+		}
+		ready = os.NewFile(uintptr(fd), "ready")
+	}
+	return inherited, ready, nil
+}
+
+// closeFiles closes inherited sockets that will not be used.
+func closeFiles(files map[string]*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// notifyReady tells the parent process that the inherited listeners are being served.
+func notifyReady(ready *os.File) {
+	if ready != nil {
+		ready.Write([]byte{1})
+		ready.Close()
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package sockethub
+
+// restoreNonblock is a no-op: exec.Cmd rejects ExtraFiles here, so no socket reaches a child.
+func restoreNonblock(socketFile) {}
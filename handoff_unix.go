@@ -0,0 +1,15 @@
+//go:build unix
+
+package sockethub
+
+import "syscall"
+
+// restoreNonblock puts the socket back in non-blocking mode. Passing a duplicate to a child
+// process switches the shared file description to blocking, which would stall our Accept.
+func restoreNonblock(socket socketFile) {
+	if raw, err := socket.SyscallConn(); err == nil {
+		raw.Control(func(fd uintptr) {
+			syscall.SetNonblock(int(fd), true)
+		})
+	}
+}
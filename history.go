@@ -0,0 +1,71 @@
+// Package sockethub provides room history. With a room log configured (SocketConfig.RoomLog),
+// every frame broadcast to a room is appended to the log before it is fanned out, and
+// JoinWithHistory lets a client catch up on what it missed before it receives live broadcasts.
+package sockethub
+
+import (
+	"errors"
+
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/roomlog"
+	"github.com/google/uuid"
+)
+
+// ErrNoRoomLog is returned by JoinWithHistory when the hub has no room log.
+var ErrNoRoomLog = errors.New("sockethub: no room log configured")
+
+// JoinWithHistory adds a client, given by ID or bound address, to the named room like Join,
+// after sending it the room's logged frames from the given position. A ControlReplayEnd frame
+// follows the history. Room frames after it are live broadcasts, and none is sent twice or lost
+// in between. It returns the number of frames replayed.
+//
+// The history and the end marker are all queued in PriorityHigh, the most urgent class a room
+// frame can have, whatever their own priority: they keep their logged order, the marker is not
+// written ahead of them, and no live frame queued after the Join overtakes them.
+//
+// History waits for room in the client's send channel, and broadcasts to the room wait for the
+// replay to finish, so replays of a long history should use a narrow Position. Other rooms are
+// not held up.
+func (h *SocketHub) JoinWithHistory(clientID uuid.UUID, name string, from roomlog.Position) (int, error) {
+	log := h.config.RoomLog
+	if log == nil {
+		return 0, ErrNoRoomLog
+	}
+	c, ok := h.Client(clientID)
+	if !ok {
+		return 0, ErrUnknownReceiver
+	}
+
+	id := RoomID(name)
+	unlock := h.roomLogMu.lock(id)
+	defer unlock()
+
+	bp := h.catchUpPolicy()
+	n, err := log.Replay(id, from, func(header *protocol.SocketHeader, payload []byte) error {
+		return c.sendIn(&bp, protocol.PriorityHigh, header, payload)
+	})
+	if err != nil {
+		h.log(socketlog.WARNING, "Replayed %d frames of room %q to %s: %v", n, name, c.ID, err)
+		return n, err
+	}
+	if err := h.Join(clientID, name); err != nil {
+		return n, err
+	}
+	header, payload := protocol.ReplayEndFrame(id, uint32(n))
+	return n, c.sendIn(&bp, protocol.PriorityHigh, header, payload)
+}
+
+// logHeader returns a copy of a room frame's header with the fields a replay matches on
+// filled in, so logged and live copies of the frame agree.
+func (h *SocketHub) logHeader(header *protocol.SocketHeader) *protocol.SocketHeader {
+	logged := header.Clone()
+	if logged.ID == uuid.Nil {
+		logged.ID = uuid.New()
+	}
+	if logged.Sender == uuid.Nil {
+		logged.Sender = h.id
+	}
+	logged.SetTimestampIfZero()
+	return logged
+}
@@ -0,0 +1,154 @@
+// Package sockethub provides the listeners opened from configuration.
+// Every network is turned into a protocol.Listener so the hub serves them all the same way.
+package sockethub
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// maxDatagramSize is the largest UDP payload, used to bound datagram listener buffers.
+const maxDatagramSize = 65535
+
+// openListener opens the socket described by lc (already resolved against the hub config),
+// or adopts inherited when a parent process handed it off. It also returns the OS socket.
+func openListener(lc sockethub_config.ListenerConfig, inherited *os.File) (protocol.Listener, socketFile, error) {
+	opts := []protocol.ConnOption{protocol.WithMaxPayloadSize(lc.MaxMessageSize)}
+
+	switch lc.Network {
+	case sockethub_config.NetworkTCP:
+		ln, err := listenStream(inherited, func() (net.Listener, error) { return net.Listen("tcp", lc.Address) })
+		if err != nil {
+			return nil, nil, err
+		}
+		socket := ln.(socketFile)
+		if lc.TLSConfig != nil {
+			ln = tls.NewListener(ln, lc.TLSConfig)
+		}
+		return protocol.NewStreamListener(ln, opts...), socket, nil
+
+	case sockethub_config.NetworkUnix:
+		ln, err := listenStream(inherited, func() (net.Listener, error) { return protocol.ListenUnix(lc.Address, lc.UnixSocket) })
+		if err != nil {
+			return nil, nil, err
+		}
+		ul, ok := ln.(*net.UnixListener)
+		if !ok {
+			ln.Close()
+			return nil, nil, fmt.Errorf("inherited socket is not a Unix listener")
+		}
+		ul.SetUnlinkOnClose(true) // Inherited listeners do not unlink by default
+		return protocol.NewStreamListener(ul, opts...), ul, nil
+
+	case sockethub_config.NetworkUDP:
+		pc, err := listenPacket(inherited, func() (net.PacketConn, error) { return net.ListenPacket("udp", lc.Address) })
+		if err != nil {
+			return nil, nil, err
+		}
+		return protocol.NewPacketListener(pc, min(lc.MaxMessageSize, maxDatagramSize), opts...), pc.(socketFile), nil
+
+	case sockethub_config.NetworkUnixgram:
+		pc, err := listenPacket(inherited, func() (net.PacketConn, error) { return protocol.ListenUnixgram(lc.Address, lc.UnixSocket) })
+		if err != nil {
+			return nil, nil, err
+		}
+		return &unixgramListener{
+			Listener: protocol.NewPacketListener(pc, lc.MaxMessageSize, opts...),
+			path:     lc.Address,
+		}, pc.(socketFile), nil
+
+	case sockethub_config.NetworkWebSocket:
+		return openWebSocketListener(lc, inherited, opts)
+	}
+	return nil, nil, fmt.Errorf("unsupported network %q", lc.Network)
+}
+
+// listenStream adopts an inherited stream socket, or calls listen when there is none.
+func listenStream(inherited *os.File, listen func() (net.Listener, error)) (net.Listener, error) {
+	if inherited == nil {
+		return listen()
+	}
+	defer inherited.Close() // FileListener works on a duplicate
+	return net.FileListener(inherited)
+}
+
+// listenPacket adopts an inherited datagram socket, or calls listen when there is none.
+func listenPacket(inherited *os.File, listen func() (net.PacketConn, error)) (net.PacketConn, error) {
+	if inherited == nil {
+		return listen()
+	}
+	defer inherited.Close() // FilePacketConn works on a duplicate
+	return net.FilePacketConn(inherited)
+}
+
+// unixgramListener removes the socket file when closed, like a Unix stream listener does.
+type unixgramListener struct {
+	protocol.Listener
+	path string
+	keep atomic.Bool // Set once the socket is handed off, so the file outlives this process
+}
+
+func (u *unixgramListener) Close() error {
+	err := u.Listener.Close()
+	if !u.keep.Load() {
+		os.Remove(u.path)
+	}
+	return err
+}
+
+// webSocketServer runs an http.Server dedicated to one WebSocket listener.
+type webSocketServer struct {
+	*protocol.WebSocketListener
+	server *http.Server
+	ln     net.Listener
+}
+
+// openWebSocketListener serves lc.Path on its own HTTP server.
+func openWebSocketListener(lc sockethub_config.ListenerConfig, inherited *os.File, opts []protocol.ConnOption) (protocol.Listener, socketFile, error) {
+	ln, err := listenStream(inherited, func() (net.Listener, error) { return net.Listen("tcp", lc.Address) })
+	if err != nil {
+		return nil, nil, err
+	}
+	socket := ln.(socketFile)
+	if lc.TLSConfig != nil {
+		ln = tls.NewListener(ln, lc.TLSConfig)
+	}
+
+	// A WebSocket message carries a whole frame, so leave room for the header and checksum
+	wl := protocol.NewWebSocketListener(protocol.WebSocketConfig{
+		MaxMessageSize: lc.MaxMessageSize + protocol.FramePrefixSize + protocol.HeaderMaxSize + protocol.FrameChecksumSize,
+	}, opts...)
+
+	path := lc.Path
+	if path == "" {
+		path = "/"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, wl)
+	ws := &webSocketServer{
+		WebSocketListener: wl,
+		server:            &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		ln:                ln,
+	}
+	go ws.server.Serve(ln)
+	return ws, socket, nil
+}
+
+// Close stops accepting upgrades and shuts the HTTP server down; upgraded connections stay open.
+func (w *webSocketServer) Close() error {
+	w.WebSocketListener.Close()
+	return w.server.Close()
+}
+
+// Addr returns the address the HTTP server listens on.
+func (w *webSocketServer) Addr() net.Addr {
+	return w.ln.Addr()
+}
@@ -0,0 +1,223 @@
+// Package sockethub provides addresses and the offline queue for direct messages.
+// A client is reachable at its connection ID, and additionally at a stable address once Bind
+// gives it one (typically after the application has authenticated it). With an offline queue
+// configured, direct frames to an address that was bound before but that nobody holds now are
+// stored and delivered in order to the next client that binds it.
+package sockethub
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/offline"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// ErrAddressInUse is returned when binding an address that is the connection ID of another client.
+var ErrAddressInUse = errors.New("sockethub: address is the ID of another client")
+
+// offlineQueue is the hub's store-and-forward state.
+type offlineQueue struct {
+	store offline.Store
+	cfg   sockethub_config.OfflineQueueConfig
+	mu    sync.Mutex             // Orders the cap checks against appends across receivers
+	bound map[uuid.UUID]struct{} // Addresses given to a client by Bind since the hub started (guarded by mu)
+	swept time.Time              // Last Expire run (guarded by mu)
+}
+
+// newOfflineQueue returns the queue for cfg, or nil if queueing is disabled.
+func newOfflineQueue(cfg *sockethub_config.OfflineQueueConfig) *offlineQueue {
+	if cfg == nil {
+		return nil
+	}
+	q := &offlineQueue{store: cfg.Store, cfg: *cfg, bound: make(map[uuid.UUID]struct{}), swept: time.Now()}
+	if q.store == nil {
+		q.store = offline.NewMemoryStore()
+	}
+	return q
+}
+
+// Address returns the address the client was bound to, or its ID if it has none.
+// Frames the client sends carry its address as Sender.
+func (c *Client) Address() uuid.UUID {
+	if addr := c.addr.Load(); addr != nil {
+		return *addr
+	}
+	return c.ID
+}
+
+// Bind makes the client with ID clientID reachable at address, replacing any address it had.
+// Frames queued for the address are delivered to it first, in the order they were sent, after
+// any reliable frames still unacknowledged there (see SocketConfig.Delivery). A client that
+// held the address before is closed, as the new connection supersedes it.
+func (h *SocketHub) Bind(clientID, address uuid.UUID) error {
+	unlock := h.addressMu.lock(address)
+	defer unlock()
+
+	// Unbind the address first, so frames sent meanwhile wait for the queue lock
+	h.mu.Lock()
+	c, ok := h.clients[clientID]
+	if !ok {
+		h.mu.Unlock()
+		return ErrUnknownReceiver
+	}
+	if other, ok := h.clients[address]; ok && other != c {
+		h.mu.Unlock()
+		return ErrAddressInUse
+	}
+	if old := c.addr.Load(); old != nil && h.addresses[*old] == c {
+		delete(h.addresses, *old)
+	}
+	previous := h.addresses[address]
+	delete(h.addresses, address)
+	from := c.Address()
+	c.addr.Store(&address) // Frames redelivered below are awaited at the new address
+	h.mu.Unlock()
+
+	if previous != nil && previous != c {
+		h.log(socketlog.INFO, "Client %s takes over address %s from %s", c.ID, address, previous.ID)
+		previous.Close()
+	}
+	if q := h.offline; q != nil {
+		q.mu.Lock()
+		q.bound[address] = struct{}{}
+		q.mu.Unlock()
+	}
+	h.resumeDelivery(c, from, address)
+	h.deliverOffline(c, address)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[clientID]; !ok {
+		return ErrClientClosed
+	}
+	h.addresses[address] = c
+	return nil
+}
+
+// clientLocked returns a connected client by ID or address. h.mu must be held.
+func (h *SocketHub) clientLocked(id uuid.UUID) (*Client, bool) {
+	if c, ok := h.clients[id]; ok {
+		return c, true
+	}
+	c, ok := h.addresses[id]
+	return c, ok
+}
+
+// queueOffline stores a direct frame for a receiver that is not connected. Only addresses that
+// were bound since the hub started, or that still have frames queued in the store, are queued
+// for, so frames to made-up receivers cannot fill the store. It returns ErrUnknownReceiver if
+// queueing is disabled, the receiver is unknown, or a cap is reached.
+func (h *SocketHub) queueOffline(to uuid.UUID, header *protocol.SocketHeader, payload []byte) error {
+	q := h.offline
+	if q == nil {
+		return ErrUnknownReceiver
+	}
+	unlock := h.addressMu.lock(to)
+	defer unlock()
+
+	// The receiver may have been bound while this frame waited for the lock
+	if c, ok := h.Client(to); ok {
+		return c.Send(header, payload)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	if q.cfg.TTL > 0 && now.Sub(q.swept) >= q.cfg.TTL {
+		q.swept = now
+		if err := q.store.Expire(now); err != nil {
+			h.log(socketlog.WARNING, "Expiring offline queues failed: %v", err)
+		}
+	}
+	count, size, err := q.store.Usage(to, now)
+	if err != nil {
+		h.log(socketlog.ERROR, "Offline queue for %s unavailable: %v", to, err)
+		return ErrUnknownReceiver
+	}
+	if _, ok := q.bound[to]; !ok && count == 0 {
+		return ErrUnknownReceiver
+	}
+	if (q.cfg.MaxMessages > 0 && count >= q.cfg.MaxMessages) || (q.cfg.MaxBytes > 0 && size+int64(len(payload)) > q.cfg.MaxBytes) {
+		h.log(socketlog.WARNING, "Offline queue for %s is full (%d frames, %d bytes)", to, count, size)
+		return ErrUnknownReceiver
+	}
+	if q.cfg.MaxReceivers > 0 || q.cfg.MaxTotalBytes > 0 {
+		receivers, total, err := q.store.Totals(now)
+		if err != nil {
+			h.log(socketlog.ERROR, "Offline queue totals unavailable: %v", err)
+			return ErrUnknownReceiver
+		}
+		if (q.cfg.MaxReceivers > 0 && count == 0 && receivers >= q.cfg.MaxReceivers) || (q.cfg.MaxTotalBytes > 0 && total+int64(len(payload)) > q.cfg.MaxTotalBytes) {
+			h.log(socketlog.WARNING, "Offline queues are full (%d receivers, %d bytes)", receivers, total)
+			return ErrUnknownReceiver
+		}
+	}
+
+	entry := offline.Entry{Header: header.Clone(), Payload: payload}
+	if entry.Header.Sender == uuid.Nil {
+		entry.Header.Sender = h.id
+	}
+	if q.cfg.TTL > 0 {
+		entry.Expires = now.Add(q.cfg.TTL)
+	}
+	if err := q.store.Append(to, entry); err != nil {
+		h.log(socketlog.ERROR, "Queueing frame %s for %s failed: %v", header.ID, to, err)
+		return ErrUnknownReceiver
+	}
+	return nil
+}
+
+// deliverOffline sends the frames queued for address to c. Delivery waits for room in the
+// send channel rather than dropping, so a long queue arrives whole; frames that could not be
+// queued to c go back to the store. The lock of address in h.addressMu must be held, so no
+// frame is queued for it meanwhile and the remainder keeps its place.
+func (h *SocketHub) deliverOffline(c *Client, address uuid.UUID) {
+	q := h.offline
+	if q == nil {
+		return
+	}
+	entries, err := q.store.Take(address, time.Now())
+	if err != nil {
+		h.log(socketlog.ERROR, "Reading offline queue for %s failed: %v", address, err)
+		return
+	}
+	bp := h.catchUpPolicy()
+	for i, e := range entries {
+		if err := c.sendWith(&bp, e.Header, e.Payload); err != nil {
+			h.log(socketlog.WARNING, "Delivered %d of %d offline frames to %s: %v", i, len(entries), c.ID, err)
+			h.requeueOffline(address, entries[i:])
+			return
+		}
+	}
+	if len(entries) > 0 {
+		h.log(socketlog.INFO, "Delivered %d offline frames to %s", len(entries), c.ID)
+	}
+}
+
+// requeueOffline stores entries taken from the queue of address again, after a failed delivery.
+func (h *SocketHub) requeueOffline(address uuid.UUID, entries []offline.Entry) {
+	q := h.offline
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, e := range entries {
+		if err := q.store.Append(address, e); err != nil {
+			h.log(socketlog.ERROR, "Requeueing offline frames for %s failed, %d dropped: %v", address, len(entries)-i, err)
+			return
+		}
+	}
+}
+
+// catchUpPolicy is the slow-consumer policy for frames a client is owed from storage (offline
+// queues, room history): it waits for room in the send channel for up to the write timeout.
+func (h *SocketHub) catchUpPolicy() sockethub_config.BackpressureConfig {
+	bp := sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerBlock, BlockTimeout: time.Second}
+	if h.config.WriteTimeout != nil && *h.config.WriteTimeout > 0 {
+		bp.BlockTimeout = *h.config.WriteTimeout
+	}
+	return bp
+}
@@ -0,0 +1,346 @@
+// Package offline provides FileStore, a Store that keeps one append-only file per receiver.
+// Each record is laid out as (big-endian):
+//
+//	Expires(8, Unix nanoseconds, 0 for never) + HeaderLen(4) + Header + PayloadLen(4) + Payload + CRC32(4)
+//
+// with the CRC covering everything before it. A torn record at the end of a file, left by a
+// crash during Append, is cut off the next time the file is read.
+package offline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// fileSuffix names queue files: <receiver UUID>.queue.
+const fileSuffix = ".queue"
+
+// recordOverhead is the size of a record without its header and payload.
+const recordOverhead = 8 + 4 + 4 + 4
+
+// errCorrupt marks a record that fails its length or checksum checks.
+var errCorrupt = errors.New("offline: corrupt queue record")
+
+// FileStore is a Store that keeps queues in files under a directory, so they survive a restart.
+type FileStore struct {
+	dir     string
+	sync    bool // Sync files after every append
+	mu      sync.Mutex
+	meta    map[uuid.UUID][]recordMeta // Loaded lazily from the files
+	scanned bool                       // Every queue file in dir is loaded into meta
+}
+
+// recordMeta is what Usage needs to know about a stored record.
+type recordMeta struct {
+	expires time.Time
+	size    int64 // Payload size
+}
+
+// NewFileStore creates a FileStore in dir, creating the directory if needed. Queues left in the
+// directory by a previous run are kept. With sync set, every Append is flushed to stable
+// storage before it returns.
+func NewFileStore(dir string, sync bool) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("offline: %w", err)
+	}
+	return &FileStore{dir: dir, sync: sync, meta: make(map[uuid.UUID][]recordMeta)}, nil
+}
+
+// path returns the queue file of a receiver.
+func (s *FileStore) path(to uuid.UUID) string {
+	return filepath.Join(s.dir, to.String()+fileSuffix)
+}
+
+// Append implements Store.
+func (s *FileStore) Append(to uuid.UUID, e Entry) error {
+	record, err := encodeRecord(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.load(to)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(to), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("offline: %w", err)
+	}
+	_, err = f.Write(record)
+	if err == nil && s.sync {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		delete(s.meta, to) // Reload from the file, which may hold part of the record
+		s.scanned = false
+		return fmt.Errorf("offline: %w", err)
+	}
+	s.meta[to] = append(meta, recordMeta{e.Expires, int64(len(e.Payload))})
+	return nil
+}
+
+// Take implements Store.
+func (s *FileStore) Take(to uuid.UUID, now time.Time) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read(to)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(s.path(to)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("offline: %w", err)
+	}
+	delete(s.meta, to)
+	return unexpired(entries, now), nil
+}
+
+// Usage implements Store.
+func (s *FileStore) Usage(to uuid.UUID, now time.Time) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, err := s.load(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, size := 0, int64(0)
+	for _, m := range meta {
+		if m.expires.IsZero() || now.Before(m.expires) {
+			count++
+			size += m.size
+		}
+	}
+	return count, size, nil
+}
+
+// Totals implements Store. The first call loads every queue file in the directory.
+func (s *FileStore) Totals(now time.Time) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.scanned {
+		if err := s.each(func(to uuid.UUID) error {
+			_, err := s.load(to)
+			return err
+		}); err != nil {
+			return 0, 0, err
+		}
+		s.scanned = true
+	}
+	receivers, size := 0, int64(0)
+	for _, meta := range s.meta {
+		counted := false
+		for _, m := range meta {
+			if m.expires.IsZero() || now.Before(m.expires) {
+				size += m.size
+				counted = true
+			}
+		}
+		if counted {
+			receivers++
+		}
+	}
+	return receivers, size, nil
+}
+
+// Expire implements Store. Files whose entries have all expired are removed; files with some
+// expired entries are rewritten without them.
+func (s *FileStore) Expire(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.each(func(to uuid.UUID) error { return s.expire(to, now) })
+}
+
+// each calls fn for the receiver of every queue file in the directory, stopping at the first
+// error. s.mu must be held.
+func (s *FileStore) each(fn func(to uuid.UUID) error) error {
+	names, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("offline: %w", err)
+	}
+	for _, name := range names {
+		base, ok := strings.CutSuffix(name.Name(), fileSuffix)
+		to, err := uuid.Parse(base)
+		if !ok || err != nil {
+			continue
+		}
+		if err := fn(to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire drops the expired entries of one queue. s.mu must be held.
+func (s *FileStore) expire(to uuid.UUID, now time.Time) error {
+	meta, err := s.load(to)
+	if err != nil {
+		return err
+	}
+	if !hasExpired(meta, now) {
+		return nil
+	}
+	entries, err := s.read(to)
+	if err != nil {
+		return err
+	}
+	kept := unexpired(entries, now)
+	if len(kept) == 0 {
+		delete(s.meta, to)
+		if err := os.Remove(s.path(to)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("offline: %w", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	meta = meta[:0]
+	for _, e := range kept {
+		record, err := encodeRecord(e)
+		if err != nil {
+			return err
+		}
+		buf.Write(record)
+		meta = append(meta, recordMeta{e.Expires, int64(len(e.Payload))})
+	}
+	tmp := s.path(to) + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("offline: %w", err)
+	}
+	if err := os.Rename(tmp, s.path(to)); err != nil {
+		return fmt.Errorf("offline: %w", err)
+	}
+	s.meta[to] = meta
+	return nil
+}
+
+// hasExpired reports whether any record has expired at now.
+func hasExpired(meta []recordMeta, now time.Time) bool {
+	for _, m := range meta {
+		if !m.expires.IsZero() && !now.Before(m.expires) {
+			return true
+		}
+	}
+	return false
+}
+
+// load returns the record index of a queue, reading the file the first time. s.mu must be held.
+func (s *FileStore) load(to uuid.UUID) ([]recordMeta, error) {
+	if meta, ok := s.meta[to]; ok {
+		return meta, nil
+	}
+	entries, err := s.read(to)
+	if err != nil {
+		return nil, err
+	}
+	meta := make([]recordMeta, len(entries))
+	for i, e := range entries {
+		meta[i] = recordMeta{e.Expires, int64(len(e.Payload))}
+	}
+	s.meta[to] = meta
+	return meta, nil
+}
+
+// read decodes every record of a queue file, truncating the file after the last intact record.
+// s.mu must be held.
+func (s *FileStore) read(to uuid.UUID) ([]Entry, error) {
+	data, err := os.ReadFile(s.path(to))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("offline: %w", err)
+	}
+
+	var entries []Entry
+	offset := 0
+	for offset < len(data) {
+		e, n, err := decodeRecord(data[offset:])
+		if err != nil {
+			// Only the tail can be torn; drop it so later appends follow an intact record
+			if terr := os.Truncate(s.path(to), int64(offset)); terr != nil {
+				return nil, fmt.Errorf("offline: %w", terr)
+			}
+			break
+		}
+		entries = append(entries, e)
+		offset += n
+	}
+	return entries, nil
+}
+
+// =============================================================================
+// Record Format
+// =============================================================================
+
+// encodeRecord returns the file form of an entry.
+func encodeRecord(e Entry) ([]byte, error) {
+	header := e.Header.Clone()
+	header.Length = uint64(len(e.Payload))
+	encoded, err := protocol.HeaderEncode(header)
+	if err != nil {
+		return nil, fmt.Errorf("offline: %w", err)
+	}
+
+	var expires int64
+	if !e.Expires.IsZero() {
+		expires = e.Expires.UnixNano()
+	}
+	buf := make([]byte, 0, recordOverhead+len(encoded)+len(e.Payload))
+	buf = binary.BigEndian.AppendUint64(buf, uint64(expires))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(encoded)))
+	buf = append(buf, encoded...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(e.Payload)))
+	buf = append(buf, e.Payload...)
+	return binary.BigEndian.AppendUint32(buf, protocol.Checksum(buf)), nil
+}
+
+// decodeRecord parses the record at the start of data and returns its length.
+func decodeRecord(data []byte) (Entry, int, error) {
+	var e Entry
+	if len(data) < recordOverhead {
+		return e, 0, errCorrupt
+	}
+	headerLen := int(binary.BigEndian.Uint32(data[8:]))
+	if headerLen > len(data)-recordOverhead {
+		return e, 0, errCorrupt
+	}
+	payloadLen := int(binary.BigEndian.Uint32(data[12+headerLen:]))
+	n := recordOverhead + headerLen + payloadLen
+	if payloadLen > len(data)-recordOverhead-headerLen {
+		return e, 0, errCorrupt
+	}
+	if protocol.Checksum(data[:n-4]) != binary.BigEndian.Uint32(data[n-4:]) {
+		return e, 0, errCorrupt
+	}
+
+	// Entries are stored as given, so fields the connection fills in on write (ID) may be unset
+	header, err := protocol.HeaderDecodeWithOptions(data[12:12+headerLen], protocol.ValidationOptions{Disabled: true})
+	if err != nil {
+		return e, 0, fmt.Errorf("%w: %v", errCorrupt, err)
+	}
+	if expires := int64(binary.BigEndian.Uint64(data)); expires != 0 {
+		e.Expires = time.Unix(0, expires)
+	}
+	e.Header = header
+	e.Payload = bytes.Clone(data[16+headerLen : n-4])
+	return e, n, nil
+}
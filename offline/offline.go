@@ -0,0 +1,137 @@
+// Package offline provides storage for direct messages to receivers that are not connected.
+// The hub appends a frame to its receiver's queue and takes the whole queue, in order, once a
+// client binds that receiver's address. MemoryStore keeps queues in memory; FileStore keeps
+// them on disk so they survive a restart.
+package offline
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// Entry is a queued frame.
+type Entry struct {
+	Header  *protocol.SocketHeader
+	Payload []byte
+	Expires time.Time // When the entry is discarded (zero for never)
+}
+
+// Expired reports whether the entry has expired at now.
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+// Store keeps a queue of entries per receiver. Implementations must be safe for concurrent
+// use; the hub serializes calls for the same receiver.
+type Store interface {
+	// Append adds an entry to the end of the receiver's queue.
+	Append(to uuid.UUID, e Entry) error
+	// Take removes the receiver's queue and returns its unexpired entries in order.
+	Take(to uuid.UUID, now time.Time) ([]Entry, error)
+	// Usage returns the number of unexpired entries queued for the receiver and their payload size.
+	Usage(to uuid.UUID, now time.Time) (count int, bytes int64, err error)
+	// Totals returns the number of receivers with unexpired entries queued and the payload
+	// size of those entries across all receivers.
+	Totals(now time.Time) (receivers int, bytes int64, err error)
+	// Expire discards the expired entries of every queue.
+	Expire(now time.Time) error
+}
+
+// =============================================================================
+// Memory Store
+// =============================================================================
+
+// MemoryStore is a Store that keeps queues in memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	queues map[uuid.UUID][]Entry
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{queues: make(map[uuid.UUID][]Entry)}
+}
+
+// Append implements Store.
+func (s *MemoryStore) Append(to uuid.UUID, e Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queues[to] = append(s.queues[to], e)
+	return nil
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(to uuid.UUID, now time.Time) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := unexpired(s.queues[to], now)
+	delete(s.queues, to)
+	return entries, nil
+}
+
+// Usage implements Store.
+func (s *MemoryStore) Usage(to uuid.UUID, now time.Time) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := s.prune(to, now)
+	var size int64
+	for _, e := range entries {
+		size += int64(len(e.Payload))
+	}
+	return len(entries), size, nil
+}
+
+// Totals implements Store.
+func (s *MemoryStore) Totals(now time.Time) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	receivers, size := 0, int64(0)
+	for _, entries := range s.queues {
+		counted := false
+		for _, e := range entries {
+			if !e.Expired(now) {
+				size += int64(len(e.Payload))
+				counted = true
+			}
+		}
+		if counted {
+			receivers++
+		}
+	}
+	return receivers, size, nil
+}
+
+// Expire implements Store.
+func (s *MemoryStore) Expire(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for to := range s.queues {
+		s.prune(to, now)
+	}
+	return nil
+}
+
+// prune drops the expired entries of one queue and returns the rest. s.mu must be held.
+func (s *MemoryStore) prune(to uuid.UUID, now time.Time) []Entry {
+	entries := unexpired(s.queues[to], now)
+	if len(entries) == 0 {
+		delete(s.queues, to)
+	} else {
+		s.queues[to] = entries
+	}
+	return entries
+}
+
+// unexpired filters entries in place, keeping their order.
+func unexpired(entries []Entry, now time.Time) []Entry {
+	kept := entries[:0]
+	for _, e := range entries {
+		if !e.Expired(now) {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,68 @@
+// Package protocol provides sync.Pool-backed byte buffers for the frame read and write paths.
+// Buffers are bucketed by power-of-two capacity so frames of similar size reuse the same memory.
+package protocol
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// =============================================================================
+// Pool Constants
+// =============================================================================
+
+const (
+	minPooledBufferBits = 8  // Smallest bucket: 256 bytes
+	maxPooledBufferBits = 20 // Largest bucket: 1MB (larger buffers are not pooled)
+)
+
+// bufferPools holds one pool per power-of-two bucket, indexed by bits - minPooledBufferBits.
+var bufferPools [maxPooledBufferBits - minPooledBufferBits + 1]sync.Pool
+
+// =============================================================================
+// Pool Operations
+// =============================================================================
+
+// bucketFor returns the pool index whose buffers hold at least size bytes, or -1 if too large.
+func bucketFor(size int) int {
+	if size <= 1<<minPooledBufferBits {
+		return 0
+	}
+	b := bits.Len(uint(size - 1)) // ceil(log2(size))
+	if b > maxPooledBufferBits {
+		return -1
+	}
+	return b - minPooledBufferBits
+}
+
+// getBuffer returns a buffer of length size, reusing pooled memory when possible.
+// The returned pointer must be handed back with putBuffer once the buffer is no longer referenced.
+func getBuffer(size int) *[]byte {
+	idx := bucketFor(size)
+	if idx < 0 {
+		buf := make([]byte, size)
+		return &buf
+	}
+	if v := bufferPools[idx].Get(); v != nil {
+		bp := v.(*[]byte)
+		*bp = (*bp)[:size]
+		return bp
+	}
+	buf := make([]byte, size, 1<<(idx+minPooledBufferBits))
+	return &buf
+}
+
+// putBuffer returns a buffer obtained from getBuffer to its pool.
+// Buffers whose capacity does not match a bucket exactly are dropped.
+func putBuffer(bp *[]byte) {
+	if bp == nil {
+		return
+	}
+	c := cap(*bp)
+	idx := bucketFor(c)
+	if idx < 0 || c != 1<<(idx+minPooledBufferBits) {
+		return
+	}
+	*bp = (*bp)[:0]
+	bufferPools[idx].Put(bp)
+}
@@ -9,7 +9,6 @@ var CRC32Table = crc32.MakeTable(crc32.IEEE)
 
 // Checksum calculates CRC32 over headerBytes (everything before Checksum field) + payload.
 func Checksum(payload []byte) uint32 {
-	crc := crc32.New(CRC32Table)
-	crc.Write(payload)
-	return crc.Sum32()
+	// crc32.Checksum avoids allocating a hash.Hash32 per call
+	return crc32.Checksum(payload, CRC32Table)
 }
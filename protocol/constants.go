@@ -6,11 +6,50 @@ package protocol
 // Protocol Constants
 // =============================================================================
 
-// CurrentVersion: SocketHub protocol version.
-const CurrentVersion uint8 = 0x01
+// CurrentVersion: SocketHub protocol version
+// (0x02 introduced the presence bitmap, 0x03 the extension area and 2-byte header size prefix).
+const CurrentVersion uint8 = 0x03
 
-// HeaderSize: fixed size (bytes) of SocketHeader struct.
-const HeaderSize = 76
+// FramePrefixSize: size (bytes) of the big-endian header length prefix that starts every frame.
+const FramePrefixSize = 2
+
+// HeaderMinSize: encoded size (bytes) of a header with no optional fields:
+// ID(16) + Sender(16) + Timestamp(8) + Length(8) + Control(6).
+const HeaderMinSize = 16 + 16 + 8 + 8 + 6
+
+// HeaderSize: maximum encoded size (bytes) of a header with every fixed-size optional field present.
+const HeaderSize = HeaderMinSize + 16 + 4
+
+// HeaderMaxSize: maximum encoded size (bytes) of a header including a full extension area.
+const HeaderMaxSize = HeaderSize + 2 + MaxExtensionsSize
+
+// =============================================================================
+// Optional Field Presence
+// =============================================================================
+
+// Presence: bitmap in the control bytes marking which optional fields follow them.
+// Optional fields are encoded in bit order.
+type Presence uint8
+
+const (
+	PresenceReceiver   Presence = 1 << 0 // Receiver (16 bytes)
+	PresenceSequence   Presence = 1 << 1 // Sequence (4 bytes)
+	PresenceExtensions Presence = 1 << 2 // Extension area: ExtLen(2) + TLV entries (always last)
+	// Add more optional fields as needed (one bit each, and extend presenceMask).
+)
+
+// presenceMask holds every defined presence bit.
+const presenceMask = PresenceReceiver | PresenceSequence | PresenceExtensions
+
+// Has reports true if the optional field bit p is set.
+func (p Presence) Has(field Presence) bool {
+	return p&field != 0
+}
+
+// IsValid returns true if the Presence only contains defined bits.
+func (p Presence) IsValid() bool {
+	return p&^presenceMask == 0
+}
 
 // =============================================================================
 // Message Types
@@ -24,6 +63,7 @@ const (
 	MessageTypeData                         // Application data
 	MessageTypeBroadcast                    // Broadcast message
 	MessageTypeHeartbeat                    // Keep-alive
+	MessageTypeControl                      // Connection control (payload starts with a ControlCode)
 	// Extend with more message types as needed.
 )
 
@@ -38,6 +78,8 @@ func (m MessageType) String() string {
 		return "Broadcast"
 	case MessageTypeHeartbeat:
 		return "Heartbeat"
+	case MessageTypeControl:
+		return "Control"
 	default:
 		return "InvalidMessageType"
 	}
@@ -45,7 +87,7 @@ func (m MessageType) String() string {
 
 // IsValid returns true if the MessageType is within valid range.
 func (m MessageType) IsValid() bool {
-	return m <= MessageTypeHeartbeat
+	return m <= MessageTypeControl
 }
 
 // =============================================================================
@@ -56,35 +98,56 @@ func (m MessageType) IsValid() bool {
 type Flag uint8
 
 const (
-	FlagNone       Flag = iota // No flags set
-	FlagACK                    // Acknowledgment
-	FlagError                  // Indicates an error in the message
-	FlagCompressed             // Indicates the payload is compressed
-	FlagEncrypted              // Indicates the payload is encrypted
-	// Add more flags as needed.
+	FlagNone       Flag = 0      // No flags set
+	FlagACK        Flag = 1 << 0 // Acknowledgment
+	FlagError      Flag = 1 << 1 // Indicates an error in the message
+	FlagCompressed Flag = 1 << 2 // Indicates the payload is compressed
+	FlagEncrypted  Flag = 1 << 3 // Indicates the payload is encrypted
+	FlagReliable   Flag = 1 << 4 // The sender redelivers the frame until the receiver ACKs its ID
+	// Add more flags as needed (one bit each, and extend flagMask).
 )
 
+// flagMask holds every defined flag bit.
+const flagMask = FlagACK | FlagError | FlagCompressed | FlagEncrypted | FlagReliable
+
+// flagNames maps each flag bit to its name, in bit order.
+var flagNames = [...]struct {
+	flag Flag
+	name string
+}{
+	{FlagACK, "ACK"},
+	{FlagError, "Error"},
+	{FlagCompressed, "Compressed"},
+	{FlagEncrypted, "Encrypted"},
+	{FlagReliable, "Reliable"},
+}
+
 // String returns the string representation of Flag.
+// Combined flags are joined with "|" (e.g., "ACK|Compressed").
 func (f Flag) String() string {
-	switch f {
-	case FlagNone:
+	if f == FlagNone {
 		return "None"
-	case FlagACK:
-		return "ACK"
-	case FlagError:
-		return "Error"
-	case FlagCompressed:
-		return "Compressed"
-	case FlagEncrypted:
-		return "Encrypted"
-	default:
+	}
+	if !f.IsValid() {
 		return "InvalidFlag"
 	}
+
+	s := ""
+	for _, fn := range flagNames {
+		if f&fn.flag == 0 {
+			continue
+		}
+		if s != "" {
+			s += "|"
+		}
+		s += fn.name
+	}
+	return s
 }
 
-// IsValid returns true if the Flag is within valid range.
+// IsValid returns true if the Flag only contains defined bits.
 func (f Flag) IsValid() bool {
-	return f <= FlagEncrypted
+	return f&^flagMask == 0
 }
 
 // HasFlag checks if a specific flag is set in a bitmask.
@@ -112,6 +175,9 @@ type ProtocolType uint8
 const (
 	ProtocolTCP ProtocolType = iota
 	ProtocolUDP
+	ProtocolMemory    // In-process pipe (see NewMemoryPipe)
+	ProtocolUnix      // Unix domain socket, stream (TCP framing) or datagram (UDP framing)
+	ProtocolWebSocket // One frame per binary WebSocket message
 )
 
 // String returns the string representation of ProtocolType.
@@ -121,6 +187,12 @@ func (p ProtocolType) String() string {
 		return "TCP"
 	case ProtocolUDP:
 		return "UDP"
+	case ProtocolMemory:
+		return "Memory"
+	case ProtocolUnix:
+		return "Unix"
+	case ProtocolWebSocket:
+		return "WebSocket"
 	default:
 		return "InvalidProtocol"
 	}
@@ -128,5 +200,5 @@ func (p ProtocolType) String() string {
 
 // IsValid returns true if the ProtocolType is within valid range.
 func (p ProtocolType) IsValid() bool {
-	return p == ProtocolTCP || p == ProtocolUDP
+	return p <= ProtocolWebSocket
 }
@@ -0,0 +1,60 @@
+// Package protocol provides context support for the connection wrappers.
+// Cancellation and deadlines are mapped onto the connection's I/O deadlines, so a
+// blocked read or write returns as soon as its context is done.
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// aLongTimeAgo is a non-zero deadline in the past, used to wake up blocked I/O on cancellation.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withContext runs op with ctx mapped onto a connection deadline through setDeadline.
+// The deadline is cleared when op returns. If op failed because ctx was done, the returned
+// error wraps both ctx.Err() and the I/O error, so errors.Is(err, context.Canceled) works
+// and IsFatal still reflects the state of the connection.
+func withContext(ctx context.Context, setDeadline func(time.Time) error, op func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		if err := setDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	// Wake up op if ctx is canceled while it is blocked
+	var stop func() bool
+	var woken chan struct{}
+	if ctx.Done() != nil {
+		woken = make(chan struct{})
+		stop = context.AfterFunc(ctx, func() {
+			setDeadline(aLongTimeAgo)
+			close(woken)
+		})
+	}
+
+	err := op()
+
+	if stop != nil && !stop() {
+		<-woken // The wake-up already ran; wait so it cannot override the reset below
+	}
+	if hasDeadline || stop != nil {
+		setDeadline(time.Time{})
+	}
+
+	if err != nil && IsTimeout(err) {
+		if cerr := ctx.Err(); cerr != nil {
+			return fmt.Errorf("%w: %w", cerr, err)
+		}
+		if hasDeadline && !time.Now().Before(deadline) {
+			return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+// Package protocol provides control frames: MessageTypeControl frames whose payload starts
+// with a ControlCode followed by a code-specific body. They manage the connection itself
+// and are never routed to other clients.
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ControlCode identifies the kind of control frame.
+type ControlCode uint8
+
+const (
+	ControlUnknown      ControlCode = iota // Uninitialized/default
+	ControlGoingAway                       // The sender is shutting down; body is a UTF-8 reason
+	ControlWindowUpdate                    // Grants flow-control credit; body is stream(4) + increment(4)
+	ControlStreamOpen                      // Opens a multiplexed stream; body is stream(4)
+	ControlStreamClose                     // The sender will write no more on a stream; body is stream(4)
+	ControlStreamReset                     // Aborts a stream in both directions; body is stream(4) + UTF-8 reason
+	ControlReplayEnd                       // Ends the history replayed for a room; body is room(16) + frames(4)
+	// Extend with more control codes as needed.
+)
+
+// ErrInvalidControl is returned when a control frame is empty or carries an unknown code.
+var ErrInvalidControl = errors.New("protohub: invalid control frame")
+
+// String returns the string representation of ControlCode.
+func (c ControlCode) String() string {
+	switch c {
+	case ControlUnknown:
+		return "Unknown"
+	case ControlGoingAway:
+		return "GoingAway"
+	case ControlWindowUpdate:
+		return "WindowUpdate"
+	case ControlStreamOpen:
+		return "StreamOpen"
+	case ControlStreamClose:
+		return "StreamClose"
+	case ControlStreamReset:
+		return "StreamReset"
+	case ControlReplayEnd:
+		return "ReplayEnd"
+	default:
+		return "InvalidControlCode"
+	}
+}
+
+// IsValid returns true if the ControlCode is a known, non-zero code.
+func (c ControlCode) IsValid() bool {
+	return c > ControlUnknown && c <= ControlReplayEnd
+}
+
+// NewControlFrame builds a control frame header and payload for code with the given body.
+func NewControlFrame(code ControlCode, body []byte) (*SocketHeader, []byte) {
+	payload := make([]byte, 1+len(body))
+	payload[0] = byte(code)
+	copy(payload[1:], body)
+	return &SocketHeader{MessageType: MessageTypeControl}, payload
+}
+
+// ParseControl returns the code and body of a control frame.
+func ParseControl(h *SocketHeader, payload []byte) (ControlCode, []byte, error) {
+	if h == nil {
+		return ControlUnknown, nil, ErrNilHeader
+	}
+	if h.MessageType != MessageTypeControl {
+		return ControlUnknown, nil, fmt.Errorf("%w: message type %s", ErrInvalidControl, h.MessageType)
+	}
+	if len(payload) == 0 {
+		return ControlUnknown, nil, fmt.Errorf("%w: empty payload", ErrInvalidControl)
+	}
+	code := ControlCode(payload[0])
+	if !code.IsValid() {
+		return ControlUnknown, nil, fmt.Errorf("%w: code %d", ErrInvalidControl, code)
+	}
+	return code, payload[1:], nil
+}
+
+// GoingAwayFrame builds the control frame a server sends before it shuts down.
+// Clients should stop sending and reconnect later, possibly to another instance.
+func GoingAwayFrame(reason string) (*SocketHeader, []byte) {
+	return NewControlFrame(ControlGoingAway, []byte(reason))
+}
+
+// WindowUpdateFrame builds a control frame granting increment bytes of send credit on a
+// stream (zero for the connection itself, StreamDefaults for the initial window of new streams).
+func WindowUpdateFrame(stream, increment uint32) (*SocketHeader, []byte) {
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint32(body[0:], stream)
+	binary.BigEndian.PutUint32(body[4:], increment)
+	return NewControlFrame(ControlWindowUpdate, body)
+}
+
+// ParseWindowUpdate decodes the body of a ControlWindowUpdate frame.
+func ParseWindowUpdate(body []byte) (stream, increment uint32, err error) {
+	if len(body) != 8 {
+		return 0, 0, fmt.Errorf("%w: window update body is %d bytes (want 8)", ErrInvalidControl, len(body))
+	}
+	return binary.BigEndian.Uint32(body[0:]), binary.BigEndian.Uint32(body[4:]), nil
+}
+
+// StreamControlFrame builds a ControlStreamOpen, ControlStreamClose or ControlStreamReset frame.
+// The reason is only sent with ControlStreamReset.
+func StreamControlFrame(code ControlCode, stream uint32, reason string) (*SocketHeader, []byte) {
+	body := make([]byte, 4, 4+len(reason))
+	binary.BigEndian.PutUint32(body, stream)
+	if code == ControlStreamReset {
+		body = append(body, reason...)
+	}
+	return NewControlFrame(code, body)
+}
+
+// ParseStreamControl decodes the body of a stream control frame.
+func ParseStreamControl(body []byte) (stream uint32, reason string, err error) {
+	if len(body) < 4 {
+		return 0, "", fmt.Errorf("%w: stream control body is %d bytes (want at least 4)", ErrInvalidControl, len(body))
+	}
+	return binary.BigEndian.Uint32(body), string(body[4:]), nil
+}
+
+// ReplayEndFrame builds the control frame that follows the history replayed for a room: frames
+// after it are live broadcasts. count is the number of history frames that were replayed.
+func ReplayEndFrame(room uuid.UUID, count uint32) (*SocketHeader, []byte) {
+	body := make([]byte, 20)
+	copy(body, room[:])
+	binary.BigEndian.PutUint32(body[16:], count)
+	return NewControlFrame(ControlReplayEnd, body)
+}
+
+// ParseReplayEnd decodes the body of a ControlReplayEnd frame.
+func ParseReplayEnd(body []byte) (room uuid.UUID, count uint32, err error) {
+	if len(body) != 20 {
+		return uuid.Nil, 0, fmt.Errorf("%w: replay end body is %d bytes (want 20)", ErrInvalidControl, len(body))
+	}
+	copy(room[:], body)
+	return room, binary.BigEndian.Uint32(body[16:]), nil
+}
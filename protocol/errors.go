@@ -0,0 +1,141 @@
+// Package protocol provides the sentinel errors returned by the SocketHub codec and connection wrappers.
+// Errors are wrapped with %w so callers can match them with errors.Is and classify them with IsFatal.
+package protocol
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+// =============================================================================
+// Sentinel Errors
+// =============================================================================
+
+var (
+	// ErrNilHeader is returned when a nil *SocketHeader is passed to the codec or a WriteFrame call.
+	ErrNilHeader = errors.New("protohub: header is nil")
+
+	// ErrHeaderSize is returned when an encoded header is shorter or longer than its fields require.
+	ErrHeaderSize = errors.New("protohub: invalid header size")
+
+	// ErrChecksumMismatch is returned when the payload CRC32 does not match the frame trailer.
+	ErrChecksumMismatch = errors.New("protohub: checksum mismatch")
+
+	// ErrFrameTooLarge is returned when a frame exceeds the maximum size allowed by the transport.
+	ErrFrameTooLarge = errors.New("protohub: frame too large")
+
+	// ErrTruncatedFrame is returned when a datagram ends before the header, payload or checksum.
+	ErrTruncatedFrame = errors.New("protohub: truncated frame")
+
+	// ErrUnsupportedVersion is returned when a frame advertises a protocol version this build cannot decode.
+	ErrUnsupportedVersion = errors.New("protohub: unsupported protocol version")
+
+	// ErrInvalidMessageType is returned when a header carries a MessageType outside the known range.
+	ErrInvalidMessageType = errors.New("protohub: invalid message type")
+
+	// ErrInvalidPresence is returned when a header marks optional fields this build does not know.
+	ErrInvalidPresence = errors.New("protohub: invalid presence bitmap")
+
+	// ErrInvalidExtension is returned when a header extension is malformed or does not match its registered size.
+	ErrInvalidExtension = errors.New("protohub: invalid header extension")
+
+	// ErrInvalidFlags is returned when a header sets flag bits that are not defined.
+	ErrInvalidFlags = errors.New("protohub: invalid flags")
+
+	// ErrInvalidProtocol is returned when a header carries an unknown ProtocolType.
+	ErrInvalidProtocol = errors.New("protohub: invalid protocol type")
+
+	// ErrProtocolMismatch is returned when a header Protocol differs from the transport it arrived on.
+	ErrProtocolMismatch = errors.New("protohub: protocol mismatch")
+
+	// ErrZeroID is returned when a header has a nil message or sender ID.
+	ErrZeroID = errors.New("protohub: zero ID")
+
+	// ErrInvalidTimestamp is returned when a header Timestamp is zero or too far in the future.
+	ErrInvalidTimestamp = errors.New("protohub: invalid timestamp")
+)
+
+// frameErrors lists the sentinels that describe a single bad frame rather than a broken connection.
+var frameErrors = [...]error{
+	ErrHeaderSize,
+	ErrChecksumMismatch,
+	ErrFrameTooLarge,
+	ErrTruncatedFrame,
+	ErrUnsupportedVersion,
+	ErrInvalidMessageType,
+	ErrInvalidPresence,
+	ErrInvalidExtension,
+	ErrInvalidFlags,
+	ErrInvalidProtocol,
+	ErrProtocolMismatch,
+	ErrZeroID,
+	ErrInvalidTimestamp,
+}
+
+// =============================================================================
+// Error Classification
+// =============================================================================
+
+// fatalError marks a frame error that also left the connection unusable
+// (e.g., a TCP header that could not be decoded, so the stream position is lost).
+type fatalError struct {
+	err error
+}
+
+func (f *fatalError) Error() string { return f.err.Error() }
+func (f *fatalError) Unwrap() error { return f.err }
+
+// fatal wraps err so that IsFatal reports true regardless of the underlying sentinel.
+func fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err: err}
+}
+
+// IsFrameError reports true if err describes a malformed or rejected frame.
+func IsFrameError(err error) bool {
+	for _, target := range frameErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTimeout reports true if err was caused by an expired read or write deadline.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// IsFatal reports true if the connection that produced err must be closed.
+// Frame errors on a connection that is still aligned (e.g., checksum mismatch, bad UDP datagram)
+// and deadline timeouts between frames are not fatal; EOF, closed connections and desynchronized
+// streams (including a stream read interrupted mid-frame) are.
+func IsFatal(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var f *fatalError
+	if errors.As(err, &f) {
+		return true
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	if IsTimeout(err) || IsFrameError(err) || errors.Is(err, ErrNilHeader) {
+		return false
+	}
+
+	// Unknown I/O errors are treated as fatal
+	return true
+}
+
+// IsDroppable reports true if err only affects the current frame, so the caller may
+// discard it and keep reading from the same connection.
+func IsDroppable(err error) bool {
+	return err != nil && IsFrameError(err) && !IsFatal(err)
+}
@@ -0,0 +1,346 @@
+// Package protocol provides the type-length-value (TLV) extension area of SocketHub headers.
+// Extensions follow the optional fields when PresenceExtensions is set, so new header data
+// (correlation IDs, trace context, content types, ...) can be added without changing the fixed layout.
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Extension Constants
+// =============================================================================
+
+// MaxExtensionsSize: maximum encoded size (bytes) of the extension area, excluding its length field.
+const MaxExtensionsSize = 4096
+
+// extensionEntryOverhead: Key(1) + Len(2) preceding every extension value.
+const extensionEntryOverhead = 1 + 2
+
+// =============================================================================
+// Extension Keys
+// =============================================================================
+
+// ExtensionKey identifies the type of a header extension.
+type ExtensionKey uint8
+
+const (
+	ExtensionInvalid       ExtensionKey = iota // Reserved, never encoded
+	ExtensionCorrelationID                     // UUID of the request a frame responds to (16 bytes)
+	ExtensionTraceContext                      // W3C traceparent string (variable)
+	ExtensionContentType                       // Payload media type, e.g. "application/json" (variable)
+	ExtensionFragment                          // Fragment index and count (4 + 4 bytes)
+	ExtensionPriority                          // Priority class of the frame (1 byte)
+	ExtensionStreamID                          // Logical stream the frame belongs to (4 bytes)
+	ExtensionAccept                            // Comma-separated media types the sender can decode (variable)
+	// Extend with more well-known extensions as needed (must stay below ExtensionUserBase).
+)
+
+// ExtensionUserBase is the first key available to applications.
+// Keys below it are reserved for SocketHub itself.
+const ExtensionUserBase ExtensionKey = 0x80
+
+// ExtensionSizeVariable marks an extension whose value may have any length.
+const ExtensionSizeVariable = -1
+
+// extensionInfo describes a registered extension.
+type extensionInfo struct {
+	name string // Display name
+	size int    // Fixed value size in bytes, or ExtensionSizeVariable
+}
+
+// extensionRegistry maps every known key to its description.
+var (
+	extensionMu       sync.RWMutex
+	extensionRegistry = map[ExtensionKey]extensionInfo{
+		ExtensionCorrelationID: {"CorrelationID", 16},
+		ExtensionTraceContext:  {"TraceContext", ExtensionSizeVariable},
+		ExtensionContentType:   {"ContentType", ExtensionSizeVariable},
+		ExtensionFragment:      {"Fragment", 8},
+		ExtensionPriority:      {"Priority", 1},
+		ExtensionStreamID:      {"StreamID", 4},
+		ExtensionAccept:        {"Accept", ExtensionSizeVariable},
+	}
+)
+
+// RegisterExtension adds an application-defined extension to the registry.
+// size is the fixed value length in bytes, or ExtensionSizeVariable.
+// Returns an error if the key is reserved or already registered.
+func RegisterExtension(key ExtensionKey, name string, size int) error {
+	if key < ExtensionUserBase {
+		return fmt.Errorf("protohub: extension key %d is reserved (user keys start at %d)", key, ExtensionUserBase)
+	}
+	if name == "" {
+		return fmt.Errorf("protohub: extension name cannot be empty")
+	}
+	if size != ExtensionSizeVariable && (size < 0 || size > 0xFFFF) {
+		return fmt.Errorf("protohub: invalid extension size %d", size)
+	}
+
+	extensionMu.Lock()
+	defer extensionMu.Unlock()
+
+	if existing, ok := extensionRegistry[key]; ok {
+		return fmt.Errorf("protohub: extension key %d already registered as %q", key, existing.name)
+	}
+	extensionRegistry[key] = extensionInfo{name: name, size: size}
+	return nil
+}
+
+// lookupExtension returns the registry entry for key.
+func lookupExtension(key ExtensionKey) (extensionInfo, bool) {
+	extensionMu.RLock()
+	info, ok := extensionRegistry[key]
+	extensionMu.RUnlock()
+	return info, ok
+}
+
+// String returns the registered name of the ExtensionKey.
+func (k ExtensionKey) String() string {
+	if info, ok := lookupExtension(k); ok {
+		return info.name
+	}
+	return fmt.Sprintf("Extension(%d)", uint8(k))
+}
+
+// IsRegistered reports true if the key is well-known or was registered by the application.
+func (k ExtensionKey) IsRegistered() bool {
+	_, ok := lookupExtension(k)
+	return ok
+}
+
+// checkExtension verifies value against the registered size of key.
+// Unregistered keys are accepted as opaque values.
+func checkExtension(key ExtensionKey, value []byte) error {
+	if key == ExtensionInvalid {
+		return fmt.Errorf("%w: key 0 is reserved", ErrInvalidExtension)
+	}
+	if len(value) > 0xFFFF {
+		return fmt.Errorf("%w: %s value too long (%d bytes)", ErrInvalidExtension, key, len(value))
+	}
+	if info, ok := lookupExtension(key); ok && info.size != ExtensionSizeVariable && info.size != len(value) {
+		return fmt.Errorf("%w: %s must be %d bytes (got %d)", ErrInvalidExtension, key, info.size, len(value))
+	}
+	return nil
+}
+
+// =============================================================================
+// Header Extension Accessors
+// =============================================================================
+
+// Extension is a single TLV entry of a header extension area.
+type Extension struct {
+	Key   ExtensionKey
+	Value []byte
+}
+
+// Ext returns the value of extension key and whether it is set.
+func (h *SocketHeader) Ext(key ExtensionKey) ([]byte, bool) {
+	for _, e := range h.extensions {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+// SetExt sets extension key to a copy of value, replacing any previous value.
+// Returns an error if value does not match the registered size of key.
+func (h *SocketHeader) SetExt(key ExtensionKey, value []byte) error {
+	if err := checkExtension(key, value); err != nil {
+		return err
+	}
+	value = append([]byte(nil), value...) // The caller may reuse its buffer
+	for i := range h.extensions {
+		if h.extensions[i].Key == key {
+			h.extensions[i].Value = value
+			return nil
+		}
+	}
+	h.extensions = append(h.extensions, Extension{Key: key, Value: value})
+	return nil
+}
+
+// DelExt removes extension key if it is set.
+func (h *SocketHeader) DelExt(key ExtensionKey) {
+	for i, e := range h.extensions {
+		if e.Key == key {
+			h.extensions = append(h.extensions[:i], h.extensions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Extensions returns a copy of the extensions set on the header, in encoding order. The values
+// are copied too, as decoding into the header again reuses their buffers.
+func (h *SocketHeader) Extensions() []Extension {
+	if len(h.extensions) == 0 {
+		return nil
+	}
+	out := make([]Extension, len(h.extensions))
+	values := make([]byte, 0, h.extensionsSize())
+	for i, e := range h.extensions {
+		start := len(values)
+		values = append(values, e.Value...)
+		out[i] = Extension{Key: e.Key, Value: values[start:len(values):len(values)]} // Capped, so appends never spill into the next value
+	}
+	return out
+}
+
+// extensionsSize returns the encoded size of the extension entries (excluding the length field).
+func (h *SocketHeader) extensionsSize() int {
+	size := 0
+	for _, e := range h.extensions {
+		size += extensionEntryOverhead + len(e.Value)
+	}
+	return size
+}
+
+// =============================================================================
+// Typed Extension Helpers
+// =============================================================================
+
+// SetCorrelationID sets the ExtensionCorrelationID extension.
+func (h *SocketHeader) SetCorrelationID(id uuid.UUID) {
+	value := make([]byte, 16)
+	copy(value, id[:])
+	h.SetExt(ExtensionCorrelationID, value)
+}
+
+// CorrelationID returns the ExtensionCorrelationID extension and whether it is set.
+func (h *SocketHeader) CorrelationID() (uuid.UUID, bool) {
+	value, ok := h.Ext(ExtensionCorrelationID)
+	if !ok || len(value) != 16 {
+		return uuid.Nil, false
+	}
+	var id uuid.UUID
+	copy(id[:], value)
+	return id, true
+}
+
+// SetTraceContext sets the ExtensionTraceContext extension (W3C traceparent).
+func (h *SocketHeader) SetTraceContext(traceparent string) error {
+	return h.SetExt(ExtensionTraceContext, []byte(traceparent))
+}
+
+// TraceContext returns the ExtensionTraceContext extension and whether it is set.
+func (h *SocketHeader) TraceContext() (string, bool) {
+	value, ok := h.Ext(ExtensionTraceContext)
+	return string(value), ok
+}
+
+// SetContentType sets the ExtensionContentType extension.
+func (h *SocketHeader) SetContentType(contentType string) error {
+	return h.SetExt(ExtensionContentType, []byte(contentType))
+}
+
+// ContentType returns the ExtensionContentType extension and whether it is set.
+func (h *SocketHeader) ContentType() (string, bool) {
+	value, ok := h.Ext(ExtensionContentType)
+	return string(value), ok
+}
+
+// SetAccept sets the ExtensionAccept extension, e.g. "application/msgpack, application/json;q=0.5".
+func (h *SocketHeader) SetAccept(accept string) error {
+	return h.SetExt(ExtensionAccept, []byte(accept))
+}
+
+// Accept returns the ExtensionAccept extension and whether it is set.
+func (h *SocketHeader) Accept() (string, bool) {
+	value, ok := h.Ext(ExtensionAccept)
+	return string(value), ok
+}
+
+// SetFragment sets the ExtensionFragment extension (zero-based index out of count fragments).
+func (h *SocketHeader) SetFragment(index, count uint32) {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint32(value[0:], index)
+	binary.BigEndian.PutUint32(value[4:], count)
+	h.SetExt(ExtensionFragment, value)
+}
+
+// Fragment returns the ExtensionFragment extension and whether it is set.
+func (h *SocketHeader) Fragment() (index, count uint32, ok bool) {
+	value, ok := h.Ext(ExtensionFragment)
+	if !ok || len(value) != 8 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(value[0:]), binary.BigEndian.Uint32(value[4:]), true
+}
+
+// SetStreamID sets the ExtensionStreamID extension.
+func (h *SocketHeader) SetStreamID(id uint32) {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, id)
+	h.SetExt(ExtensionStreamID, value)
+}
+
+// StreamID returns the ExtensionStreamID extension and whether it is set.
+func (h *SocketHeader) StreamID() (uint32, bool) {
+	value, ok := h.Ext(ExtensionStreamID)
+	if !ok || len(value) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(value), true
+}
+
+// =============================================================================
+// Extension Area Codec
+// =============================================================================
+
+// encodeExtensions writes ExtLen(2) followed by each Key(1) + Len(2) + Value entry into buf.
+// Returns the number of bytes written.
+func (h *SocketHeader) encodeExtensions(buf []byte) int {
+	binary.BigEndian.PutUint16(buf, uint16(h.extensionsSize()))
+	offset := 2
+	for _, e := range h.extensions {
+		buf[offset] = byte(e.Key)
+		binary.BigEndian.PutUint16(buf[offset+1:], uint16(len(e.Value)))
+		offset += extensionEntryOverhead
+		offset += copy(buf[offset:], e.Value)
+	}
+	return offset
+}
+
+// decodeExtensions parses the extension entries in data (excluding the length field), appending them to dst[:0].
+// Values are copied out of data, reusing the buffers of entries previously held by dst.
+// Unregistered keys are skipped over and kept as opaque values so relays can forward them.
+func decodeExtensions(dst []Extension, data []byte) ([]Extension, error) {
+	exts := dst[:0]
+	offset := 0
+	for offset < len(data) {
+		if len(data)-offset < extensionEntryOverhead {
+			return nil, fmt.Errorf("%w: truncated entry at offset %d", ErrInvalidExtension, offset)
+		}
+		key := ExtensionKey(data[offset])
+		length := int(binary.BigEndian.Uint16(data[offset+1:]))
+		offset += extensionEntryOverhead
+
+		if len(data)-offset < length {
+			return nil, fmt.Errorf("%w: %s value truncated (need %d, have %d)",
+				ErrInvalidExtension, key, length, len(data)-offset)
+		}
+		value := data[offset : offset+length]
+		offset += length
+
+		if err := checkExtension(key, value); err != nil {
+			return nil, err
+		}
+		for _, e := range exts {
+			if e.Key == key {
+				return nil, fmt.Errorf("%w: duplicate %s", ErrInvalidExtension, key)
+			}
+		}
+		// Reuse the value buffer left in dst's backing array from a previous decode, if any
+		var reuse []byte
+		if len(exts) < cap(exts) {
+			reuse = exts[:len(exts)+1][len(exts)].Value[:0]
+		}
+		exts = append(exts, Extension{Key: key, Value: append(reuse, value...)})
+	}
+	return exts, nil
+}
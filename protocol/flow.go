@@ -0,0 +1,505 @@
+// Package protocol provides credit-based flow control on top of any Conn.
+// Each side grants the other a window of payload bytes for the connection and for every
+// stream (ExtensionStreamID) through ControlWindowUpdate frames, and grants more as the
+// application reads. A sender that runs out of credit waits, so a stalled stream or a slow
+// reader holds back only its own data instead of filling the socket buffers.
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Flow Control Constants
+// =============================================================================
+
+const (
+	DefaultConnWindow   = 1 << 20   // Default connection receive window (1MB)
+	DefaultStreamWindow = 256 << 10 // Default per-stream receive window (256KB)
+)
+
+// StreamDefaults is the stream of a window update that sets the initial window of new streams.
+const StreamDefaults uint32 = 0xFFFFFFFF
+
+// ErrFlowControl is returned when the peer sends more than the credit it was granted.
+var ErrFlowControl = errors.New("protohub: flow-control window exceeded")
+
+// FlowControl configures the receive windows granted to the peer.
+type FlowControl struct {
+	ConnWindow   uint32 // Payload bytes the peer may send ahead on the connection (zero for DefaultConnWindow)
+	StreamWindow uint32 // Payload bytes the peer may send ahead on each stream (zero for DefaultStreamWindow)
+}
+
+// FlowStats reports the credit state of a connection or stream.
+type FlowStats struct {
+	Window     uint32 // Receive window granted to the peer
+	SendCredit int64  // Bytes we may send before the peer grants more
+	RecvCredit int64  // Bytes the peer may send before we grant more
+	Stalls     uint64 // Writes that had to wait for credit (connection only)
+	Streams    int    // Streams with flow-control state (connection only)
+}
+
+// FlowControlConn is a Conn with credit-based flow control.
+type FlowControlConn interface {
+	Conn
+	Flusher
+	FlowStats() FlowStats                            // Connection-level credit
+	StreamFlowStats(stream uint32) (FlowStats, bool) // Credit of one stream, if it has been used
+	ReleaseStream(stream uint32)                     // Forget a finished stream's credit
+
+	// Ready reports whether a frame can be written without waiting for credit. Otherwise it
+	// counts a stall and returns a channel that is closed when credit grows, so a caller
+	// with other frames to send can hold this one back instead of blocking in WriteFrame.
+	Ready(h *SocketHeader, payload []byte) (bool, <-chan struct{})
+}
+
+// =============================================================================
+// Flow Control Conn
+// =============================================================================
+
+// flowWindow tracks credit in both directions for the connection or one stream.
+type flowWindow struct {
+	send     int64  // Credit for frames we send
+	recv     int64  // Credit the peer has left for frames it sends
+	consumed uint32 // Bytes read since the last grant
+	seeded   bool   // Whether the peer's initial stream window has been added to send
+}
+
+type flowConn struct {
+	Conn
+	cfg           FlowControl
+	startOnce     sync.Once
+	startErr      error
+	mu            sync.Mutex
+	conn          flowWindow
+	streams       map[uint32]*flowWindow
+	streamSend    int64         // Peer's initial stream window (-1 until announced)
+	deferStreams  bool          // Stream credit is granted by release rather than on read
+	changed       chan struct{} // Closed when send credit grows
+	stalls        uint64
+	writeDeadline memoryDeadline
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+// NewFlowControlConn adds flow control to conn. Both peers must use it. The initial windows
+// are announced with the first read or write, and credit arrives with read frames, so reads
+// must run concurrently with writes (as the hub's client loops do). Control and heartbeat
+// frames are never subject to credit.
+func NewFlowControlConn(conn Conn, cfg FlowControl) FlowControlConn {
+	return newFlowConn(conn, cfg)
+}
+
+func newFlowConn(conn Conn, cfg FlowControl) *flowConn {
+	if cfg.ConnWindow == 0 {
+		cfg.ConnWindow = DefaultConnWindow
+	}
+	if cfg.StreamWindow == 0 {
+		cfg.StreamWindow = DefaultStreamWindow
+	}
+	return &flowConn{
+		Conn:       conn,
+		cfg:        cfg,
+		conn:       flowWindow{recv: int64(cfg.ConnWindow)},
+		streams:    make(map[uint32]*flowWindow),
+		streamSend: -1,
+		closed:     make(chan struct{}),
+	}
+}
+
+// start announces the receive windows to the peer.
+func (c *flowConn) start() error {
+	c.startOnce.Do(func() {
+		c.startErr = c.grant([][2]uint32{{0, c.cfg.ConnWindow}, {StreamDefaults, c.cfg.StreamWindow}})
+	})
+	return c.startErr
+}
+
+// flowCost returns the credit a frame consumes and the stream it is charged to.
+func flowCost(h *SocketHeader, payload []byte) (int64, uint32) {
+	if h.MessageType == MessageTypeControl || h.MessageType == MessageTypeHeartbeat {
+		return 0, 0
+	}
+	stream, _ := h.StreamID()
+	return int64(len(payload)), stream
+}
+
+// streamLocked returns the window of a stream, creating it if needed. c.mu must be held.
+func (c *flowConn) streamLocked(stream uint32) *flowWindow {
+	w, ok := c.streams[stream]
+	if !ok {
+		w = &flowWindow{recv: int64(c.cfg.StreamWindow)}
+		if c.streamSend >= 0 {
+			w.send, w.seeded = c.streamSend, true
+		}
+		c.streams[stream] = w
+	}
+	return w
+}
+
+// =============================================================================
+// Writing
+// =============================================================================
+
+func (c *flowConn) WriteFrame(h *SocketHeader, payload []byte) error {
+	return c.writeFrame(nil, h, payload)
+}
+
+func (c *flowConn) WriteFrameContext(ctx context.Context, h *SocketHeader, payload []byte) error {
+	return c.writeFrame(ctx, h, payload)
+}
+
+func (c *flowConn) writeFrame(ctx context.Context, h *SocketHeader, payload []byte) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	if h != nil {
+		if n, stream := flowCost(h, payload); n > 0 {
+			if err := c.acquire(ctx, stream, n); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx != nil {
+		return c.Conn.WriteFrameContext(ctx, h, payload)
+	}
+	return c.Conn.WriteFrame(h, payload)
+}
+
+// acquire waits until the connection and stream both have credit, then charges n bytes.
+// Any positive credit admits a whole frame, so frames larger than the window still go out.
+func (c *flowConn) acquire(ctx context.Context, stream uint32, n int64) error {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	stalled := false
+	for {
+		ok, changed := c.tryAcquire(stream, n)
+		if ok {
+			return nil
+		}
+		if !stalled {
+			stalled = true
+			c.stall()
+		}
+
+		var expired <-chan time.Time
+		var t *time.Timer
+		if deadline := c.writeDeadline.get(); !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return os.ErrDeadlineExceeded
+			}
+			t = time.NewTimer(d)
+			expired = t.C
+		}
+		var err error
+		select {
+		case <-changed:
+		case <-c.writeDeadline.wait():
+		case <-expired:
+			err = os.ErrDeadlineExceeded
+		case <-done:
+			err = ctx.Err()
+		case <-c.closed:
+			err = net.ErrClosed
+		}
+		if t != nil {
+			t.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// tryAcquire charges n bytes if the connection and stream both have credit. Otherwise it
+// returns a channel that is closed when credit grows.
+func (c *flowConn) tryAcquire(stream uint32, n int64) (bool, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var w *flowWindow
+	if stream != 0 {
+		w = c.streamLocked(stream)
+	}
+	if c.conn.send > 0 && (w == nil || w.send > 0) {
+		c.conn.send -= n
+		if w != nil {
+			w.send -= n
+		}
+		return true, nil
+	}
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+	return false, c.changed
+}
+
+func (c *flowConn) Ready(h *SocketHeader, payload []byte) (bool, <-chan struct{}) {
+	n, stream := flowCost(h, payload)
+	if n == 0 {
+		return true, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var w *flowWindow
+	if stream != 0 {
+		w = c.streamLocked(stream)
+	}
+	if c.conn.send > 0 && (w == nil || w.send > 0) {
+		return true, nil
+	}
+	c.stalls++
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+	return false, c.changed
+}
+
+// stall counts a write that had to wait for credit.
+func (c *flowConn) stall() {
+	c.mu.Lock()
+	c.stalls++
+	c.mu.Unlock()
+}
+
+// writeCredited writes a frame whose credit was already charged by tryAcquire.
+func (c *flowConn) writeCredited(h *SocketHeader, payload []byte) error {
+	if err := c.start(); err != nil {
+		return err
+	}
+	return c.Conn.WriteFrame(h, payload)
+}
+
+func (c *flowConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// Flush flushes the underlying Conn if it coalesces writes.
+func (c *flowConn) Flush() error {
+	if f, ok := c.Conn.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (c *flowConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// =============================================================================
+// Reading
+// =============================================================================
+
+func (c *flowConn) ReadFrame() (*SocketHeader, []byte, error) {
+	return c.readFrame(c.Conn.ReadFrame)
+}
+
+func (c *flowConn) ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error) {
+	return c.readFrame(func() (*SocketHeader, []byte, error) {
+		return c.Conn.ReadFrameContext(ctx)
+	})
+}
+
+func (c *flowConn) ReadFrameInto(h *SocketHeader, buf []byte) ([]byte, error) {
+	_, payload, err := c.readFrame(func() (*SocketHeader, []byte, error) {
+		payload, err := c.Conn.ReadFrameInto(h, buf)
+		return h, payload, err
+	})
+	return payload, err
+}
+
+// readFrame reads the next frame, applying window updates from the peer and granting it
+// more credit as frames are consumed. Window updates are not returned to the caller.
+func (c *flowConn) readFrame(read func() (*SocketHeader, []byte, error)) (*SocketHeader, []byte, error) {
+	if err := c.start(); err != nil {
+		return nil, nil, err
+	}
+	for {
+		h, payload, err := read()
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.MessageType == MessageTypeControl {
+			if code, body, err := ParseControl(h, payload); err == nil && code == ControlWindowUpdate {
+				if err := c.credit(body); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+		}
+		if err := c.consume(h, payload); err != nil {
+			return nil, nil, err
+		}
+		return h, payload, nil
+	}
+}
+
+// credit applies a window update received from the peer.
+func (c *flowConn) credit(body []byte) error {
+	stream, increment, err := ParseWindowUpdate(body)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch stream {
+	case 0:
+		c.conn.send += int64(increment)
+	case StreamDefaults:
+		c.streamSend = int64(increment)
+		for _, w := range c.streams {
+			if !w.seeded {
+				w.send, w.seeded = w.send+int64(increment), true
+			}
+		}
+	default:
+		w, ok := c.streams[stream]
+		if !ok {
+			return nil // Released locally
+		}
+		w.send += int64(increment)
+	}
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+	return nil
+}
+
+// consume charges a received frame against the peer's credit and grants credit back once
+// half of a window has been read. Credit is granted as frames are read rather than as they
+// arrive, so a reader that falls behind stops the peer within one window. A peer that sends
+// beyond its credit has broken the protocol, so the error is fatal.
+func (c *flowConn) consume(h *SocketHeader, payload []byte) error {
+	n, stream := flowCost(h, payload)
+	if n == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	var w *flowWindow
+	if c.deferStreams {
+		w = c.streams[stream] // Added by addStream; data for an unknown stream is discarded by its owner
+	} else if stream != 0 {
+		w = c.streamLocked(stream)
+	}
+
+	// Any positive credit admits a whole frame, so only a frame sent with none left overruns
+	if c.conn.recv <= 0 || (w != nil && w.recv <= 0) {
+		c.mu.Unlock()
+		return fatal(fmt.Errorf("%w: %d bytes on stream %d", ErrFlowControl, n, stream))
+	}
+
+	var grants [][2]uint32
+	c.conn.recv -= n
+	c.conn.consumed += uint32(n)
+	if c.conn.consumed >= c.cfg.ConnWindow/2 {
+		grants = append(grants, [2]uint32{0, c.conn.consumed})
+		c.conn.recv += int64(c.conn.consumed)
+		c.conn.consumed = 0
+	}
+	if w != nil {
+		w.recv -= n
+		if !c.deferStreams {
+			grants = c.consumeStreamLocked(grants, stream, w, n)
+		}
+	}
+	c.mu.Unlock()
+
+	// A failed grant means the connection is broken; the next read or write reports it
+	if len(grants) > 0 {
+		c.grant(grants)
+	}
+	return nil
+}
+
+// addStream creates the windows of a stream whose grants are deferred.
+func (c *flowConn) addStream(stream uint32) {
+	c.mu.Lock()
+	c.streamLocked(stream)
+	c.mu.Unlock()
+}
+
+// release returns stream credit for n bytes the application has read, when stream grants
+// are deferred until then.
+func (c *flowConn) release(stream uint32, n int64) {
+	c.mu.Lock()
+	w, ok := c.streams[stream]
+	var grants [][2]uint32
+	if ok {
+		grants = c.consumeStreamLocked(nil, stream, w, n)
+	}
+	c.mu.Unlock()
+	if len(grants) > 0 {
+		c.grant(grants)
+	}
+}
+
+// consumeStreamLocked accounts n bytes read from a stream and appends a grant once half of
+// the stream window has been read. c.mu must be held.
+func (c *flowConn) consumeStreamLocked(grants [][2]uint32, stream uint32, w *flowWindow, n int64) [][2]uint32 {
+	w.consumed += uint32(n)
+	if w.consumed >= c.cfg.StreamWindow/2 {
+		grants = append(grants, [2]uint32{stream, w.consumed})
+		w.recv += int64(w.consumed)
+		w.consumed = 0
+	}
+	return grants
+}
+
+// grant sends window updates for the given stream and increment pairs. They are flushed
+// at once, since the peer may be waiting for them.
+func (c *flowConn) grant(grants [][2]uint32) error {
+	for _, g := range grants {
+		header, payload := WindowUpdateFrame(g[0], g[1])
+		if err := c.Conn.WriteFrame(header, payload); err != nil {
+			return err
+		}
+	}
+	return c.Flush()
+}
+
+// =============================================================================
+// Statistics
+// =============================================================================
+
+func (c *flowConn) FlowStats() FlowStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return FlowStats{
+		Window:     c.cfg.ConnWindow,
+		SendCredit: c.conn.send,
+		RecvCredit: c.conn.recv,
+		Stalls:     c.stalls,
+		Streams:    len(c.streams),
+	}
+}
+
+func (c *flowConn) StreamFlowStats(stream uint32) (FlowStats, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w, ok := c.streams[stream]
+	if !ok {
+		return FlowStats{}, false
+	}
+	return FlowStats{Window: c.cfg.StreamWindow, SendCredit: w.send, RecvCredit: w.recv}, true
+}
+
+func (c *flowConn) ReleaseStream(stream uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.streams, stream)
+}
@@ -0,0 +1,91 @@
+// Package protocol provides allocation-free encoding and decoding of complete SocketHub frames.
+// A frame is: HeaderLen(2) + Header + Payload + Checksum(4), where Checksum is the CRC32 of Payload.
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FrameChecksumSize: size (bytes) of the CRC32 trailer that ends every frame.
+const FrameChecksumSize = 4
+
+// maxInt bounds payload lengths so they can be used as slice sizes.
+const maxInt = int(^uint(0) >> 1)
+
+// FrameSize returns the encoded size of a frame carrying h and a payload of payloadLen bytes.
+func FrameSize(h *SocketHeader, payloadLen int) int {
+	return FramePrefixSize + h.HeaderSize() + payloadLen + FrameChecksumSize
+}
+
+// EncodeFrameTo serializes a complete frame into dst without allocating and returns the
+// number of bytes written. It sets h.Length to len(payload); dst must hold FrameSize(h, len(payload)) bytes.
+func EncodeFrameTo(dst []byte, h *SocketHeader, payload []byte) (int, error) {
+	if h == nil {
+		return 0, ErrNilHeader
+	}
+
+	// Set payload length in header
+	h.Length = uint64(len(payload))
+
+	size := FrameSize(h, len(payload))
+	if len(dst) < size {
+		return 0, fmt.Errorf("protohub: %w (need %d, have %d)", io.ErrShortBuffer, size, len(dst))
+	}
+
+	// Encode header directly after the size prefix
+	headerLen, err := HeaderEncodeTo(dst[FramePrefixSize:], h)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint16(dst, uint16(headerLen))
+
+	// Copy payload and append checksum
+	payloadStart := FramePrefixSize + headerLen
+	copy(dst[payloadStart:], payload)
+	binary.BigEndian.PutUint32(dst[payloadStart+len(payload):], Checksum(payload))
+
+	return size, nil
+}
+
+// DecodeFrameInto parses a complete frame from the start of data into h and returns the payload
+// and the number of bytes consumed. The payload aliases data. The checksum is verified before
+// the header is validated with opts.
+func DecodeFrameInto(h *SocketHeader, data []byte, opts ValidationOptions) ([]byte, int, error) {
+	if h == nil {
+		return nil, 0, ErrNilHeader
+	}
+
+	// Read the header size prefix
+	if len(data) < FramePrefixSize {
+		return nil, 0, fmt.Errorf("%w: too small for header size prefix", ErrTruncatedFrame)
+	}
+	headerSize := int(binary.BigEndian.Uint16(data))
+	if len(data) < FramePrefixSize+headerSize {
+		return nil, 0, fmt.Errorf("%w: too small for header", ErrTruncatedFrame)
+	}
+
+	// Decode the header structure (semantic validation runs after the checksum)
+	if err := HeaderDecodeInto(h, data[FramePrefixSize:FramePrefixSize+headerSize], ValidationOptions{Disabled: true}); err != nil {
+		return nil, 0, err
+	}
+
+	// Verify we have enough data for payload + checksum
+	payloadStart := FramePrefixSize + headerSize
+	if h.Length > uint64(maxInt-FrameChecksumSize) || uint64(len(data)-payloadStart) < h.Length+FrameChecksumSize {
+		return nil, 0, fmt.Errorf("%w: too small for payload and checksum", ErrTruncatedFrame)
+	}
+	payloadEnd := payloadStart + int(h.Length)
+	payload := data[payloadStart:payloadEnd:payloadEnd]
+
+	// Analyze the checksum
+	if binary.BigEndian.Uint32(data[payloadEnd:]) != Checksum(payload) {
+		return nil, 0, ErrChecksumMismatch
+	}
+
+	if err := h.Validate(opts); err != nil {
+		return nil, 0, err
+	}
+	return payload, payloadEnd + FrameChecksumSize, nil
+}
@@ -1,5 +1,5 @@
 // Package protocol provides socket header structures for the SocketHub protocol.
-// Defines the core SocketHeader struct (80 bytes) for network message routing and integrity.
+// Defines the core SocketHeader struct (54-74 bytes encoded) for network message routing and integrity.
 package protocol
 
 import (
@@ -11,19 +11,34 @@ import (
 type SocketHeader struct {
 	ID          uuid.UUID    // Unique identifier for the message/packet
 	Sender      uuid.UUID    // ID of the sender
-	Receiver    uuid.UUID    // ID of the receiver (for direct or broadcast messages)
+	Receiver    uuid.UUID    // ID of the receiver (for direct or broadcast messages, optional)
 	Timestamp   uint64       // Unix timestamp (e.g., milliseconds) when the message was sent
 	Length      uint64       // Length of the payload in bytes
-	Sequence    uint32       // Monotonically increasing sequence number for ordering and reliability (UDP Only)
+	Sequence    uint32       // Monotonically increasing sequence number for ordering and reliability (optional)
 	Protocol    ProtocolType // Protocol type (e.g., TCP, UDP)
 	Flags       Flag         // Flags for the message (e.g., ACK, Compressed, Encrypted, IsError)
 	MessageType MessageType  // Type of message (e.g., Data, Control, Heartbeat, LoginRequest, LoginResponse)
 	Router      uint8        // Router ID for routing messages to specific handlers
+	extensions  []Extension  // TLV extensions (see Ext/SetExt), encoded after the optional fields
 }
 
 // IsBroadcast reports true if the MessageType is a broadcast message.
+// A broadcast may optionally carry a Receiver (e.g., a group to scope it to).
 func (h *SocketHeader) IsBroadcast() bool {
-	return h.MessageType == MessageTypeBroadcast && h.Receiver != uuid.Nil
+	return h.MessageType == MessageTypeBroadcast
+}
+
+// reset clears every field while keeping the extension slice capacity for reuse.
+func (h *SocketHeader) reset() {
+	exts := h.extensions[:0]
+	*h = SocketHeader{extensions: exts}
+}
+
+// Clone returns a copy of the header that does not share its extensions, or their values, with h.
+func (h *SocketHeader) Clone() *SocketHeader {
+	c := *h
+	c.extensions = h.Extensions()
+	return &c
 }
 
 // SetTimestampIfZero sets the Timestamp to “now” (in ms) if it is still zero.
@@ -33,19 +48,39 @@ func (h *SocketHeader) SetTimestampIfZero() {
 	}
 }
 
-// headerSize returns the serialized length of the header (excluding payload).
-// It includes Receiver when IsBroadcast() and Sequence when Protocol == ProtocolUDP.
+// Presence returns the bitmap of optional fields that will be encoded for this header.
+// An optional field is present whenever it holds a non-zero value, for every MessageType.
+func (h *SocketHeader) Presence() Presence {
+	var p Presence
+	if h.Receiver != uuid.Nil {
+		p |= PresenceReceiver
+	}
+	if h.Sequence != 0 {
+		p |= PresenceSequence
+	}
+	if len(h.extensions) > 0 {
+		p |= PresenceExtensions
+	}
+	return p
+}
+
+// HeaderSize returns the serialized length of the header (excluding payload).
+// It includes each optional field marked in Presence() and the extension area.
 func (h *SocketHeader) HeaderSize() int {
-	// Base size always emitted, in struct order:
-	//   ID(16) + Sender(16) + Receiver(16, if broadcast) + Sequence(4, if UDP) +
-	//   Timestamp(8) + Length(8) +
-	//   Flags(1) + MessageType(1) + Router(1) + Protocol(1)
-	size := 16 + 16 + 8 + 8 + 1 + 1 + 1 + 1
+	size := HeaderMinSize + optionalFieldsSize(h.Presence())
+	if len(h.extensions) > 0 {
+		size += 2 + h.extensionsSize() // ExtLen + entries
+	}
+	return size
+}
 
-	if h.IsBroadcast() {
+// optionalFieldsSize returns the encoded size of the fixed-size optional fields marked in p.
+func optionalFieldsSize(p Presence) int {
+	size := 0
+	if p.Has(PresenceReceiver) {
 		size += 16 // Receiver
 	}
-	if h.Protocol == ProtocolUDP {
+	if p.Has(PresenceSequence) {
 		size += 4 // Sequence
 	}
 	return size
@@ -5,23 +5,41 @@ import (
 	"fmt"
 )
 
-// Decode parses a frame (header + payload) from data, reconstructs a SocketHeader,
-// and returns (header, payload). It verifies the CRC32 checksum and returns an error
-// if the frame is malformed.
+// HeaderDecode parses an encoded header from data and reconstructs a SocketHeader.
+// It applies strict validation (see ValidationOptions) and returns an error
+// if the header is malformed or invalid.
 func HeaderDecode(data []byte) (*SocketHeader, error) {
+	return HeaderDecodeWithOptions(data, ValidationOptions{})
+}
+
+// HeaderDecodeWithOptions parses an encoded header like HeaderDecode, validating it with opts.
+func HeaderDecodeWithOptions(data []byte, opts ValidationOptions) (*SocketHeader, error) {
+	h := &SocketHeader{}
+	if err := HeaderDecodeInto(h, data, opts); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// HeaderDecodeInto parses an encoded header into h, overwriting every field, and validates it with opts.
+// It does not allocate unless the header carries extensions that do not fit the buffers h already holds;
+// extension values returned by h.Ext before the call may be overwritten.
+func HeaderDecodeInto(h *SocketHeader, data []byte, opts ValidationOptions) error {
+	if h == nil {
+		return ErrNilHeader
+	}
+
 	// Start after size prefix
 	headerSize := len(data)
 	offset := 0
 
-	// Update the minimum and maximum sizes (NOT including size prefix)
-	minSize := 16 + 16 + 8 + 8 + 4 // Base: ID + Sender + Timestamp + Length + Control
-	maxSize := minSize + 16 + 4    // + Receiver + Sequence
-
-	if headerSize < minSize || headerSize > maxSize {
-		return nil, fmt.Errorf("protohub: invalid header size (got %d, min %d, max %d)",
-			headerSize, minSize, maxSize)
+	// Sizes NOT including size prefix
+	if headerSize < HeaderMinSize || headerSize > HeaderMaxSize {
+		return fmt.Errorf("%w (got %d, min %d, max %d)",
+			ErrHeaderSize, headerSize, HeaderMinSize, HeaderMaxSize)
 	}
-	h := &SocketHeader{}
+	h.reset()
+
 	// Read fixed fields
 	copy(h.ID[:], data[offset:offset+16])
 	offset += 16
@@ -36,27 +54,51 @@ func HeaderDecode(data []byte) (*SocketHeader, error) {
 	offset += 8
 
 	// Control bytes
-	h.Flags = Flag(data[offset])
-	h.MessageType = MessageType(data[offset+1])
-	h.Router = data[offset+2]
-	h.Protocol = ProtocolType(data[offset+3])
-	offset += 4
-
-	// Optional fields
-	if h.MessageType == MessageTypeBroadcast {
+	if version := data[offset]; version != CurrentVersion {
+		return fmt.Errorf("%w: %#02x (supported %#02x)", ErrUnsupportedVersion, version, CurrentVersion)
+	}
+	presence := Presence(data[offset+1])
+	h.Flags = Flag(data[offset+2])
+	h.MessageType = MessageType(data[offset+3])
+	h.Router = data[offset+4]
+	h.Protocol = ProtocolType(data[offset+5])
+	offset += 6
+
+	// Optional fields: verify the bitmap and size before reading them
+	if !presence.IsValid() {
+		return fmt.Errorf("%w: %#02x", ErrInvalidPresence, uint8(presence))
+	}
+	expected := offset + optionalFieldsSize(presence)
+	if presence.Has(PresenceExtensions) {
+		// The extension area length follows the fixed-size optional fields
+		if headerSize < expected+2 {
+			return fmt.Errorf("%w: missing extension length (got %d, need %d)",
+				ErrHeaderSize, headerSize, expected+2)
+		}
+		expected += 2 + int(binary.BigEndian.Uint16(data[expected:]))
+	}
+	if expected != headerSize {
+		return fmt.Errorf("%w: size mismatch (got %d, expected %d)",
+			ErrHeaderSize, headerSize, expected)
+	}
+
+	if presence.Has(PresenceReceiver) {
 		copy(h.Receiver[:], data[offset:offset+16])
 		offset += 16
 	}
 
-	if h.Protocol == ProtocolUDP {
+	if presence.Has(PresenceSequence) {
 		h.Sequence = binary.BigEndian.Uint32(data[offset : offset+4])
 		offset += 4
 	}
 
-	// Update size verification to match encoder's calculation
-	if offset != headerSize {
-		return nil, fmt.Errorf("protohub: header size mismatch (got %d, expected %d)",
-			uint32(offset-1), headerSize)
+	if presence.Has(PresenceExtensions) {
+		exts, err := decodeExtensions(h.extensions, data[offset+2:])
+		if err != nil {
+			return err
+		}
+		h.extensions = exts
 	}
-	return h, nil
+
+	return h.Validate(opts)
 }
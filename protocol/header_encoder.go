@@ -2,13 +2,34 @@ package protocol
 
 import (
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"io"
 )
 
-// HeaderEncode serializes the header and payload into a single byte slice.
+// HeaderEncode serializes the header into a newly allocated byte slice.
 func HeaderEncode(h *SocketHeader) ([]byte, error) {
 	if h == nil {
-		return nil, errors.New("protohub: header is nil")
+		return nil, ErrNilHeader
+	}
+
+	buf := make([]byte, h.HeaderSize())
+	if _, err := HeaderEncodeTo(buf, h); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// HeaderEncodeTo serializes the header into dst without allocating and returns the number
+// of bytes written. dst must hold at least h.HeaderSize() bytes.
+func HeaderEncodeTo(dst []byte, h *SocketHeader) (int, error) {
+	if h == nil {
+		return 0, ErrNilHeader
+	}
+	if !h.MessageType.IsValid() {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidMessageType, h.MessageType)
+	}
+	if size := h.extensionsSize(); size > MaxExtensionsSize {
+		return 0, fmt.Errorf("%w: extension area too large (%d bytes, max %d)", ErrInvalidExtension, size, MaxExtensionsSize)
 	}
 
 	// Set timestamp
@@ -16,9 +37,11 @@ func HeaderEncode(h *SocketHeader) ([]byte, error) {
 
 	// Calculate base size
 	headerSize := h.HeaderSize()
+	if len(dst) < headerSize {
+		return 0, fmt.Errorf("protohub: %w (need %d, have %d)", io.ErrShortBuffer, headerSize, len(dst))
+	}
 
-	// Create buffer with size header
-	buf := make([]byte, headerSize)
+	buf := dst[:headerSize]
 	offset := 0
 
 	// Write fixed fields in same order as decoder
@@ -35,21 +58,28 @@ func HeaderEncode(h *SocketHeader) ([]byte, error) {
 	offset += 8
 
 	// Control bytes
-	buf[offset] = byte(h.Flags)
-	buf[offset+1] = byte(h.MessageType)
-	buf[offset+2] = h.Router
-	buf[offset+3] = byte(h.Protocol)
-	offset += 4
-
-	// Optional fields
-	if h.IsBroadcast() {
+	presence := h.Presence()
+	buf[offset] = CurrentVersion
+	buf[offset+1] = byte(presence)
+	buf[offset+2] = byte(h.Flags)
+	buf[offset+3] = byte(h.MessageType)
+	buf[offset+4] = h.Router
+	buf[offset+5] = byte(h.Protocol)
+	offset += 6
+
+	// Optional fields, in presence bit order
+	if presence.Has(PresenceReceiver) {
 		copy(buf[offset:], h.Receiver[:])
 		offset += 16
 	}
 
-	if h.Protocol == ProtocolUDP {
+	if presence.Has(PresenceSequence) {
 		binary.BigEndian.PutUint32(buf[offset:], h.Sequence)
 		offset += 4
 	}
-	return buf, nil
+
+	if presence.Has(PresenceExtensions) {
+		offset += h.encodeExtensions(buf[offset:])
+	}
+	return offset, nil
 }
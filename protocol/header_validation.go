@@ -0,0 +1,86 @@
+// Package protocol provides semantic validation for decoded SocketHub headers.
+// Validation is on by default and rejects invalid enums, mismatched transports, zero IDs and absurd timestamps.
+package protocol
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultMaxClockSkew is how far into the future a header Timestamp may be before it is rejected.
+const DefaultMaxClockSkew = time.Hour
+
+// ValidationOptions controls the checks applied to decoded headers.
+// The zero value enables strict validation.
+type ValidationOptions struct {
+	Disabled                 bool          // Skip all semantic checks (structural decoding still applies)
+	AllowUnknownMessageTypes bool          // Accept MessageType values beyond the known range (forward compatibility)
+	MaxClockSkew             time.Duration // Max Timestamp distance into the future (zero for default, negative to disable)
+}
+
+// Validate checks the header fields against opts.
+// Returns an error wrapping the matching sentinel (ErrInvalidMessageType, ErrInvalidFlags, ...).
+func (h *SocketHeader) Validate(opts ValidationOptions) error {
+	if h == nil {
+		return ErrNilHeader
+	}
+	if opts.Disabled {
+		return nil
+	}
+
+	// Enums
+	if !h.Protocol.IsValid() {
+		return fmt.Errorf("%w: %d", ErrInvalidProtocol, h.Protocol)
+	}
+	if !h.Flags.IsValid() {
+		return fmt.Errorf("%w: %#02x", ErrInvalidFlags, uint8(h.Flags))
+	}
+	if h.MessageType == MessageTypeUnknown || (!h.MessageType.IsValid() && !opts.AllowUnknownMessageTypes) {
+		return fmt.Errorf("%w: %d", ErrInvalidMessageType, h.MessageType)
+	}
+
+	// Identifiers
+	if h.ID == uuid.Nil {
+		return fmt.Errorf("%w: message ID is nil", ErrZeroID)
+	}
+	if h.Sender == uuid.Nil {
+		return fmt.Errorf("%w: sender ID is nil", ErrZeroID)
+	}
+
+	// Timestamp (milliseconds since Unix epoch)
+	if h.Timestamp == 0 {
+		return fmt.Errorf("%w: timestamp is zero", ErrInvalidTimestamp)
+	}
+	skew := opts.MaxClockSkew
+	if skew == 0 {
+		skew = DefaultMaxClockSkew
+	}
+	if skew > 0 {
+		limit := uint64(time.Now().Add(skew).UnixMilli())
+		if h.Timestamp > limit {
+			return fmt.Errorf("%w: %d ms is more than %v in the future", ErrInvalidTimestamp, h.Timestamp, skew)
+		}
+	}
+	return nil
+}
+
+// ValidateTransport checks that the header Protocol matches the transport it arrived on.
+func (h *SocketHeader) ValidateTransport(transport ProtocolType, opts ValidationOptions) error {
+	if h == nil {
+		return ErrNilHeader
+	}
+	if opts.Disabled || h.Protocol == transport {
+		return nil
+	}
+	return fmt.Errorf("%w: header says %s, received over %s", ErrProtocolMismatch, h.Protocol, transport)
+}
+
+// validateFrame runs the header and transport checks used by the connection wrappers.
+func validateFrame(h *SocketHeader, transport ProtocolType, opts ValidationOptions) error {
+	if err := h.Validate(opts); err != nil {
+		return err
+	}
+	return h.ValidateTransport(transport, opts)
+}
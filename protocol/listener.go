@@ -0,0 +1,279 @@
+// Package protocol provides listeners that turn any transport into a stream of Conns.
+// Stream listeners (TCP, TLS, Unix) wrap each accepted connection; packet listeners (UDP,
+// unixgram) demultiplex datagrams by source address into one Conn per peer.
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Listener accepts framed connections. MemoryListener, WebSocketListener and the listeners
+// returned by NewStreamListener and NewPacketListener all implement it.
+type Listener interface {
+	Accept() (Conn, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// =============================================================================
+// Stream Listener
+// =============================================================================
+
+// streamListener wraps connections accepted from a net.Listener.
+type streamListener struct {
+	l    net.Listener
+	opts []ConnOption
+}
+
+// NewStreamListener wraps a stream net.Listener (TCP, tls.NewListener or Unix). Unix
+// connections get NewUnixConnWrapper (with peer credentials), all others NewTCPConnWrapper.
+func NewStreamListener(l net.Listener, opts ...ConnOption) Listener {
+	return &streamListener{l: l, opts: opts}
+}
+
+func (s *streamListener) Accept() (Conn, error) {
+	c, err := s.l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if uc, ok := c.(*net.UnixConn); ok {
+		return NewUnixConnWrapper(uc, s.opts...), nil
+	}
+	return NewTCPConnWrapper(c, s.opts...), nil
+}
+
+func (s *streamListener) Close() error {
+	return s.l.Close()
+}
+
+func (s *streamListener) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+// =============================================================================
+// Packet Listener
+// =============================================================================
+
+const (
+	packetAcceptBacklog = 64  // New peers waiting for Accept before their datagrams are dropped
+	packetPeerBacklog   = 256 // Datagrams queued per peer before new ones are dropped
+)
+
+// packetListener reads a shared PacketConn and routes each datagram to its peer's Conn.
+type packetListener struct {
+	pc        net.PacketConn
+	maxSize   int
+	transport ProtocolType
+	opts      []ConnOption
+	mu        sync.Mutex
+	peers     map[string]*packetPeer
+	pending   chan Conn
+	done      chan struct{}
+	closeOnce sync.Once
+	err       error // Read error that stopped the listener (guarded by mu)
+}
+
+// NewPacketListener serves a datagram socket (UDP or unixgram) as a Listener. The first
+// datagram from a new source address produces a Conn from Accept; closing that Conn forgets
+// the peer. Like UDP itself, datagrams are dropped when a peer falls behind.
+// Unix datagram clients must bind their own address (see DialUnixgram) to be told apart.
+func NewPacketListener(pc net.PacketConn, maxSize int, opts ...ConnOption) Listener {
+	transport := ProtocolUDP
+	if _, ok := pc.(*net.UnixConn); ok {
+		transport = ProtocolUnix
+	}
+	p := &packetListener{
+		pc:        pc,
+		maxSize:   maxSize,
+		transport: transport,
+		opts:      opts,
+		peers:     make(map[string]*packetPeer),
+		pending:   make(chan Conn, packetAcceptBacklog),
+		done:      make(chan struct{}),
+	}
+	go p.readLoop()
+	return p
+}
+
+// readLoop demultiplexes datagrams until the PacketConn fails or the listener is closed.
+func (p *packetListener) readLoop() {
+	buf := make([]byte, p.maxSize)
+	for {
+		n, addr, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			p.mu.Lock()
+			p.err = err
+			p.mu.Unlock()
+			p.Close()
+			return
+		}
+
+		key := addr.String()
+		p.mu.Lock()
+		peer, ok := p.peers[key]
+		if !ok {
+			peer = newPacketPeer(p, addr)
+			conn := &packetPeerConn{udpConnWrapper: newDatagramConnWrapper(peer, addr, p.maxSize, p.transport, p.opts), peer: peer}
+			select {
+			case p.pending <- conn:
+				p.peers[key] = peer
+			default:
+				peer = nil // Accept backlog full: drop the datagram, the peer may retry
+			}
+		}
+		p.mu.Unlock()
+
+		if peer != nil {
+			peer.deliver(append([]byte(nil), buf[:n]...))
+		}
+	}
+}
+
+// Accept returns the Conn of the next new peer.
+func (p *packetListener) Accept() (Conn, error) {
+	select {
+	case c := <-p.pending:
+		return c, nil
+	case <-p.done:
+		p.mu.Lock()
+		err := p.err
+		p.mu.Unlock()
+		if err == nil {
+			err = net.ErrClosed
+		}
+		return nil, fmt.Errorf("%s: accept error: %w", p.transport, err)
+	}
+}
+
+// Close closes the PacketConn, which also ends every peer Conn.
+func (p *packetListener) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.done)
+		err = p.pc.Close()
+	})
+	return err
+}
+
+func (p *packetListener) Addr() net.Addr {
+	return p.pc.LocalAddr()
+}
+
+// forget removes a closed peer so its next datagram is accepted as a new Conn.
+func (p *packetListener) forget(key string, peer *packetPeer) {
+	p.mu.Lock()
+	if p.peers[key] == peer {
+		delete(p.peers, key)
+	}
+	p.mu.Unlock()
+}
+
+// packetPeer is a virtual net.PacketConn that only sees one peer's datagrams.
+type packetPeer struct {
+	listener     *packetListener
+	addr         net.Addr
+	key          string
+	inbox        chan []byte
+	readDeadline memoryDeadline
+	closeOnce    sync.Once
+	closed       chan struct{}
+}
+
+func newPacketPeer(l *packetListener, addr net.Addr) *packetPeer {
+	return &packetPeer{
+		listener: l,
+		addr:     addr,
+		key:      addr.String(),
+		inbox:    make(chan []byte, packetPeerBacklog),
+		closed:   make(chan struct{}),
+	}
+}
+
+// deliver queues a datagram, dropping it if the peer is not keeping up.
+func (pp *packetPeer) deliver(b []byte) {
+	select {
+	case pp.inbox <- b:
+	default:
+	}
+}
+
+func (pp *packetPeer) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		dl := pp.readDeadline.get()
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !dl.IsZero() {
+			wait := time.Until(dl)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			fire = timer.C
+		}
+		var d []byte
+		var err error
+		select {
+		case d = <-pp.inbox:
+		case <-pp.closed:
+			err = net.ErrClosed
+		case <-pp.listener.done:
+			err = net.ErrClosed
+		case <-pp.readDeadline.wait():
+			// Deadline changed: re-evaluate
+		case <-fire:
+			err = os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if d != nil {
+			return copy(b, d), pp.addr, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func (pp *packetPeer) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return pp.listener.pc.WriteTo(b, addr)
+}
+
+func (pp *packetPeer) Close() error {
+	pp.closeOnce.Do(func() {
+		close(pp.closed)
+		pp.listener.forget(pp.key, pp)
+	})
+	return nil
+}
+
+func (pp *packetPeer) LocalAddr() net.Addr {
+	return pp.listener.pc.LocalAddr()
+}
+
+func (pp *packetPeer) SetDeadline(t time.Time) error {
+	return pp.readDeadline.set(t)
+}
+
+func (pp *packetPeer) SetReadDeadline(t time.Time) error {
+	return pp.readDeadline.set(t)
+}
+
+// SetWriteDeadline is a no-op: the socket is shared by every peer, and datagram writes do not block for long.
+func (pp *packetPeer) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// packetPeerConn is the Conn of one peer; closing it forgets the peer without closing the socket.
+type packetPeerConn struct {
+	*udpConnWrapper
+	peer *packetPeer
+}
+
+func (c *packetPeerConn) Close() error {
+	return c.peer.Close()
+}
@@ -0,0 +1,473 @@
+// Package protocol provides an in-memory transport for tests and embedding.
+// NewMemoryPipe returns two connected Conns and MemoryListener accepts them like a socket
+// listener, without touching the network. Each direction can simulate latency, bandwidth,
+// loss and reordering, driven by a seeded random source so runs are reproducible.
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Link Simulation
+// =============================================================================
+
+// MemoryLink describes the simulated network between the two ends of a memory pipe.
+// The zero value is an ideal link: frames arrive immediately, in order and intact.
+type MemoryLink struct {
+	Latency     time.Duration // One-way delay added to every frame
+	Jitter      time.Duration // Random extra delay in [0, Jitter) added to every frame
+	Bandwidth   int           // Bytes per second (zero for unlimited); writers block while the frame is "on the wire"
+	LossRate    float64       // Probability in [0, 1] that a frame is silently dropped
+	ReorderRate float64       // Probability in [0, 1] that a frame is held back behind later frames
+	ReorderGap  time.Duration // Extra delay applied to a reordered frame (zero for 1ms)
+	Seed        int64         // Seed for loss, jitter and reordering decisions
+}
+
+// defaultReorderGap is the hold-back used when MemoryLink.ReorderGap is zero.
+const defaultReorderGap = time.Millisecond
+
+// memoryFrame is an encoded frame waiting in a memoryQueue.
+type memoryFrame struct {
+	data      []byte
+	deliverAt time.Time
+	seq       uint64 // Tie-breaker that keeps equal delivery times in send order
+}
+
+// memoryQueue carries frames in one direction of a pipe and applies the link simulation.
+type memoryQueue struct {
+	mu         sync.Mutex
+	link       MemoryLink
+	rng        *rand.Rand
+	frames     []memoryFrame // Sorted by deliverAt, then seq
+	seq        uint64
+	linkFreeAt time.Time     // When the simulated wire finishes sending the previous frame
+	closed     bool          // Writer side closed: readers get io.EOF once frames are drained
+	changed    chan struct{} // Closed and replaced whenever readers should re-check the queue
+}
+
+func newMemoryQueue(link MemoryLink) *memoryQueue {
+	return &memoryQueue{
+		link:    link,
+		rng:     rand.New(rand.NewSource(link.Seed)),
+		changed: make(chan struct{}),
+	}
+}
+
+// notifyLocked wakes up every goroutine waiting on the queue. q.mu must be held.
+func (q *memoryQueue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// push schedules data for delivery and returns when the simulated wire is free again
+// (immediately on an unlimited link). It fails once the queue is closed.
+func (q *memoryQueue) push(data []byte, deadline func() time.Time) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return io.ErrClosedPipe
+	}
+
+	// Transmission time on a bandwidth-limited link
+	now := time.Now()
+	sent := now
+	if q.link.Bandwidth > 0 {
+		if q.linkFreeAt.After(sent) {
+			sent = q.linkFreeAt
+		}
+		sent = sent.Add(time.Duration(int64(len(data)) * int64(time.Second) / int64(q.link.Bandwidth)))
+		q.linkFreeAt = sent
+	}
+
+	deliverAt := sent.Add(q.link.Latency)
+	if q.link.Jitter > 0 {
+		deliverAt = deliverAt.Add(time.Duration(q.rng.Int63n(int64(q.link.Jitter))))
+	}
+	if q.link.ReorderRate > 0 && q.rng.Float64() < q.link.ReorderRate {
+		gap := q.link.ReorderGap
+		if gap <= 0 {
+			gap = defaultReorderGap
+		}
+		deliverAt = deliverAt.Add(gap)
+	}
+
+	// A lost frame still occupies the wire, it just never arrives
+	if q.link.LossRate <= 0 || q.rng.Float64() >= q.link.LossRate {
+		q.seq++
+		f := memoryFrame{data: data, deliverAt: deliverAt, seq: q.seq}
+		i := sort.Search(len(q.frames), func(i int) bool {
+			return q.frames[i].deliverAt.After(deliverAt)
+		})
+		q.frames = append(q.frames, memoryFrame{})
+		copy(q.frames[i+1:], q.frames[i:])
+		q.frames[i] = f
+		q.notifyLocked()
+	}
+	q.mu.Unlock()
+
+	// Block the writer for the transmission time, like a full socket buffer would
+	if sent.After(now) {
+		return sleepUntil(sent, deadline)
+	}
+	return nil
+}
+
+// pop returns the next deliverable frame, blocking until one is due, the queue is closed
+// and drained (io.EOF), the reader is closed (net.ErrClosed) or the deadline passes.
+func (q *memoryQueue) pop(readerClosed <-chan struct{}, deadline func() time.Time, deadlineChanged func() <-chan struct{}) ([]byte, error) {
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		if len(q.frames) > 0 && !q.frames[0].deliverAt.After(now) {
+			data := q.frames[0].data
+			q.frames[0] = memoryFrame{}
+			q.frames = q.frames[1:]
+			q.mu.Unlock()
+			return data, nil
+		}
+		if len(q.frames) == 0 && q.closed {
+			q.mu.Unlock()
+			return nil, io.EOF
+		}
+		var next time.Time
+		if len(q.frames) > 0 {
+			next = q.frames[0].deliverAt
+		}
+		changed := q.changed
+		q.mu.Unlock()
+
+		// Wait for the next frame, a queue change, a deadline change or the deadline itself
+		dl := deadline()
+		if !dl.IsZero() && !dl.After(now) {
+			return nil, os.ErrDeadlineExceeded
+		}
+		if next.IsZero() || (!dl.IsZero() && dl.Before(next)) {
+			next = dl
+		}
+		var timer *time.Timer
+		var fire <-chan time.Time
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+		select {
+		case <-changed:
+		case <-deadlineChanged():
+		case <-readerClosed:
+			return nil, net.ErrClosed
+		case <-fire:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// close marks the writer side as done; queued frames remain readable.
+func (q *memoryQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.notifyLocked()
+	}
+	q.mu.Unlock()
+}
+
+// sleepUntil blocks until t, failing early with os.ErrDeadlineExceeded if the deadline comes first.
+func sleepUntil(t time.Time, deadline func() time.Time) error {
+	if dl := deadline(); !dl.IsZero() && dl.Before(t) {
+		time.Sleep(time.Until(dl))
+		return os.ErrDeadlineExceeded
+	}
+	time.Sleep(time.Until(t))
+	return nil
+}
+
+// =============================================================================
+// Memory Deadlines
+// =============================================================================
+
+// memoryDeadline is a deadline that blocked readers can watch for changes.
+type memoryDeadline struct {
+	mu      sync.Mutex
+	t       time.Time
+	changed chan struct{}
+}
+
+func (d *memoryDeadline) get() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.t
+}
+
+func (d *memoryDeadline) set(t time.Time) error {
+	d.mu.Lock()
+	d.t = t
+	if d.changed != nil {
+		close(d.changed)
+		d.changed = nil
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *memoryDeadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.changed == nil {
+		d.changed = make(chan struct{})
+	}
+	return d.changed
+}
+
+// =============================================================================
+// Memory Conn
+// =============================================================================
+
+// memoryAddr names one end of a memory pipe.
+type memoryAddr string
+
+func (a memoryAddr) Network() string { return "memory" }
+func (a memoryAddr) String() string  { return string(a) }
+
+// memoryConn is one end of an in-memory pipe. Frames are fully encoded and decoded,
+// so validation and checksums behave exactly as on a socket.
+type memoryConn struct {
+	in            *memoryQueue
+	out           *memoryQueue
+	local         net.Addr
+	remote        net.Addr
+	sender        senderID
+	opts          connOptions
+	readDeadline  memoryDeadline
+	writeDeadline memoryDeadline
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+// NewMemoryPipe returns two connected in-memory Conns. Frames written to one are read
+// from the other, shaped in each direction by link.
+func NewMemoryPipe(link MemoryLink, opts ...ConnOption) (Conn, Conn) {
+	return newMemoryPipe("pipe", link, opts...)
+}
+
+func newMemoryPipe(name string, link MemoryLink, opts ...ConnOption) (*memoryConn, *memoryConn) {
+	// Use distinct random streams per direction so they do not correlate
+	reverse := link
+	reverse.Seed = ^link.Seed
+	ab, ba := newMemoryQueue(link), newMemoryQueue(reverse)
+
+	id := uuid.NewString()[:8]
+	aAddr, bAddr := memoryAddr(name+"/"+id+"/a"), memoryAddr(name+"/"+id+"/b")
+	o := newConnOptions(opts)
+	a := &memoryConn{in: ba, out: ab, local: aAddr, remote: bAddr, opts: o, closed: make(chan struct{})}
+	b := &memoryConn{in: ab, out: ba, local: bAddr, remote: aAddr, opts: o, closed: make(chan struct{})}
+	a.sender.set(uuid.New())
+	b.sender.set(uuid.New())
+	return a, b
+}
+
+// ReadFrame reads the next frame delivered by the peer.
+func (m *memoryConn) ReadFrame() (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	payload, err := m.ReadFrameInto(h, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+// ReadFrameInto reads the next frame into h, copying the payload into buf when it is large enough.
+func (m *memoryConn) ReadFrameInto(h *SocketHeader, buf []byte) ([]byte, error) {
+	if h == nil {
+		return nil, fmt.Errorf("Memory: %w", ErrNilHeader)
+	}
+
+	select {
+	case <-m.closed:
+		return nil, fmt.Errorf("Memory: read error: %w", net.ErrClosed)
+	default:
+	}
+
+	data, err := m.in.pop(m.closed, m.readDeadline.get, m.readDeadline.wait)
+	if err != nil {
+		return nil, fmt.Errorf("Memory: read error: %w", err)
+	}
+
+	// Decode the frame structure and checksum (semantic validation runs afterwards)
+	payload, _, err := DecodeFrameInto(h, data, ValidationOptions{Disabled: true})
+	if err != nil {
+		return nil, fmt.Errorf("Memory: decode frame error: %w", err)
+	}
+	if err := validateFrame(h, ProtocolMemory, m.opts.validation); err != nil {
+		return nil, fmt.Errorf("Memory: %w", err)
+	}
+
+	if cap(buf) >= len(payload) {
+		buf = buf[:len(payload)]
+	} else {
+		buf = make([]byte, len(payload))
+	}
+	copy(buf, payload)
+	return buf, nil
+}
+
+// ReadFrameContext reads the next frame, giving up when ctx is done.
+func (m *memoryConn) ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	var payload []byte
+	err := withContext(ctx, m.readDeadline.set, func() (err error) {
+		payload, err = m.ReadFrameInto(h, nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+// WriteFrame encodes the frame and hands it to the peer through the simulated link.
+func (m *memoryConn) WriteFrame(header *SocketHeader, payload []byte) error {
+	if header == nil {
+		return fmt.Errorf("Memory: %w", ErrNilHeader)
+	}
+
+	select {
+	case <-m.closed:
+		return fmt.Errorf("Memory: write error: %w", net.ErrClosed)
+	default:
+	}
+	if dl := m.writeDeadline.get(); !dl.IsZero() && !dl.After(time.Now()) {
+		return fmt.Errorf("Memory: write error: %w", os.ErrDeadlineExceeded)
+	}
+
+	header.Protocol = ProtocolMemory
+	if header.ID == uuid.Nil {
+		header.ID = uuid.New()
+	}
+	if header.Sender == uuid.Nil {
+		header.Sender = m.sender.get()
+	}
+
+	// The frame is queued, so it needs its own buffer
+	data := make([]byte, FrameSize(header, len(payload)))
+	if _, err := EncodeFrameTo(data, header, payload); err != nil {
+		return fmt.Errorf("Memory: header encode error: %w", err)
+	}
+	if err := m.out.push(data, m.writeDeadline.get); err != nil {
+		return fmt.Errorf("Memory: write error: %w", err)
+	}
+	return nil
+}
+
+// WriteFrameContext writes a frame, giving up when ctx is done.
+func (m *memoryConn) WriteFrameContext(ctx context.Context, header *SocketHeader, payload []byte) error {
+	return withContext(ctx, m.writeDeadline.set, func() error {
+		return m.WriteFrame(header, payload)
+	})
+}
+
+// Close stops both directions: the peer reads io.EOF after draining frames already sent.
+func (m *memoryConn) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		m.out.close()
+		m.in.close()
+	})
+	return nil
+}
+
+func (m *memoryConn) RemoteAddr() net.Addr {
+	return m.remote
+}
+
+func (m *memoryConn) LocalAddr() net.Addr {
+	return m.local
+}
+
+func (m *memoryConn) SetReadDeadline(tm time.Time) error {
+	return m.readDeadline.set(tm)
+}
+
+func (m *memoryConn) SetWriteDeadline(tm time.Time) error {
+	return m.writeDeadline.set(tm)
+}
+
+func (m *memoryConn) SetSender(id uuid.UUID) {
+	m.sender.set(id)
+}
+
+func (m *memoryConn) GetSender() uuid.UUID {
+	return m.sender.get()
+}
+
+// =============================================================================
+// Memory Listener
+// =============================================================================
+
+// MemoryListener hands out in-memory connections to dialers in the same process.
+type MemoryListener struct {
+	name      string
+	link      MemoryLink
+	opts      []ConnOption
+	pending   chan *memoryConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewMemoryListener creates a listener whose connections use link and opts.
+func NewMemoryListener(name string, link MemoryLink, opts ...ConnOption) *MemoryListener {
+	return &MemoryListener{
+		name:    name,
+		link:    link,
+		opts:    opts,
+		pending: make(chan *memoryConn),
+		done:    make(chan struct{}),
+	}
+}
+
+// Accept waits for the next Dial and returns the server end of the new connection.
+func (l *MemoryListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.pending:
+		return c, nil
+	case <-l.done:
+		return nil, fmt.Errorf("Memory: accept error: %w", net.ErrClosed)
+	}
+}
+
+// Dial connects to the listener and returns the client end once Accept has taken the server end.
+func (l *MemoryListener) Dial(ctx context.Context) (Conn, error) {
+	client, server := newMemoryPipe(l.name, l.link, l.opts...)
+	select {
+	case l.pending <- server:
+		return client, nil
+	case <-l.done:
+		return nil, fmt.Errorf("Memory: dial error: %w", net.ErrClosed)
+	case <-ctx.Done():
+		return nil, fmt.Errorf("Memory: dial error: %w", ctx.Err())
+	}
+}
+
+// Close stops the listener; pending and future Accept and Dial calls fail with net.ErrClosed.
+// Connections already accepted stay open.
+func (l *MemoryListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns the listener name as a net.Addr.
+func (l *MemoryListener) Addr() net.Addr {
+	return memoryAddr(l.name)
+}
@@ -0,0 +1,705 @@
+// Package protocol provides stream multiplexing: many independent byte streams over one Conn.
+// A stream is opened with a ControlStreamOpen frame and carries its data in MessageTypeData
+// frames tagged with ExtensionStreamID. One writer interleaves the streams a chunk at a time in
+// round-robin order, so a large transfer cannot starve the streams around it.
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// =============================================================================
+// Session Configuration
+// =============================================================================
+
+const (
+	DefaultChunkSize       = 16 << 10 // Default maximum payload per stream frame (16KB)
+	DefaultAcceptBacklog   = 64       // Default number of streams waiting for AcceptStream
+	DefaultMaxStreamBuffer = 1 << 20  // Default unread bytes held per stream without flow control (1MB)
+)
+
+var (
+	// ErrSessionClosed is returned by streams and sessions once the session has ended.
+	ErrSessionClosed = errors.New("protohub: session closed")
+
+	// ErrStreamReset is returned by streams reset by either side.
+	ErrStreamReset = errors.New("protohub: stream reset")
+
+	// ErrStreamClosed is returned when writing to a stream after CloseWrite or Close.
+	ErrStreamClosed = errors.New("protohub: stream closed")
+
+	// ErrStreamIDsExhausted is returned by OpenStream once every local stream ID has been used.
+	ErrStreamIDsExhausted = errors.New("protohub: stream IDs exhausted")
+)
+
+// SessionConfig configures a multiplexed session.
+type SessionConfig struct {
+	ChunkSize       int          // Maximum payload per frame (zero for DefaultChunkSize)
+	AcceptBacklog   int          // Streams opened by the peer waiting for AcceptStream (zero for DefaultAcceptBacklog)
+	FlowControl     *FlowControl // Credit windows; stream credit is granted as the application reads (nil for none)
+	MaxStreamBuffer int          // Unread bytes held per stream without flow control before it is reset (zero for DefaultMaxStreamBuffer)
+}
+
+// =============================================================================
+// Session
+// =============================================================================
+
+// Session multiplexes streams over a Conn. Both peers must use a session, one created with
+// NewClientSession and the other with NewServerSession, so their stream IDs never collide.
+// The session owns the Conn: it reads and writes it from its own goroutines until Close.
+type Session struct {
+	conn      Conn
+	flow      *flowConn // Same as conn when flow control is enabled
+	cfg       SessionConfig
+	mu        sync.Mutex
+	nextID    uint32
+	streams   map[uint32]*Stream
+	ready     []*Stream     // Streams with queued writes, in round-robin order
+	inflight  *streamWrite  // Write whose chunk is being sent
+	wake      chan struct{} // Signaled when a stream becomes ready
+	accept    chan *Stream
+	err       error // Why the session ended
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewClientSession starts a session on the side that dialed conn. Its streams have odd IDs.
+func NewClientSession(conn Conn, cfg SessionConfig) *Session {
+	return newSession(conn, cfg, 1)
+}
+
+// NewServerSession starts a session on the side that accepted conn. Its streams have even IDs.
+func NewServerSession(conn Conn, cfg SessionConfig) *Session {
+	return newSession(conn, cfg, 2)
+}
+
+func newSession(conn Conn, cfg SessionConfig, firstID uint32) *Session {
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.AcceptBacklog <= 0 {
+		cfg.AcceptBacklog = DefaultAcceptBacklog
+	}
+	if cfg.MaxStreamBuffer <= 0 {
+		cfg.MaxStreamBuffer = DefaultMaxStreamBuffer
+	}
+	s := &Session{
+		conn:    conn,
+		cfg:     cfg,
+		nextID:  firstID,
+		streams: make(map[uint32]*Stream),
+		wake:    make(chan struct{}, 1),
+		accept:  make(chan *Stream, cfg.AcceptBacklog),
+		closed:  make(chan struct{}),
+	}
+	if cfg.FlowControl != nil {
+		s.flow = newFlowConn(conn, *cfg.FlowControl)
+		s.flow.deferStreams = true
+		s.conn = s.flow
+	}
+	go s.readLoop()
+	go s.writeLoop()
+	return s
+}
+
+// OpenStream opens a new stream. The peer receives it from AcceptStream.
+func (s *Session) OpenStream(ctx context.Context) (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return nil, err
+	}
+	id := s.nextID
+	if id >= StreamDefaults-1 {
+		s.mu.Unlock()
+		return nil, ErrStreamIDsExhausted
+	}
+	s.nextID += 2
+	st := newStream(s, id)
+	s.addLocked(st)
+	s.mu.Unlock()
+
+	header, payload := StreamControlFrame(ControlStreamOpen, id, "")
+	if err := s.conn.WriteFrameContext(ctx, header, payload); err != nil {
+		s.mu.Lock()
+		s.removeLocked(st)
+		s.mu.Unlock()
+		return nil, err
+	}
+	return st, nil
+}
+
+// AcceptStream waits for a stream opened by the peer.
+func (s *Session) AcceptStream(ctx context.Context) (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.closed:
+		return nil, s.Err()
+	}
+}
+
+// NumStreams returns the number of open streams.
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// Done is closed once the session has ended.
+func (s *Session) Done() <-chan struct{} {
+	return s.closed
+}
+
+// Err returns why the session ended, or nil while it is running.
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close ends the session and closes the Conn. Every stream fails with ErrSessionClosed.
+func (s *Session) Close() error {
+	s.fail(ErrSessionClosed)
+	return nil
+}
+
+// fail ends the session with err, failing every stream and pending write.
+func (s *Session) fail(err error) {
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.err = err
+		for _, st := range s.streams {
+			if st.reset == nil {
+				st.reset = err
+			}
+			st.failWritesLocked(err, true)
+			st.notifyLocked()
+		}
+		s.streams = make(map[uint32]*Stream)
+		s.mu.Unlock()
+		close(s.closed)
+		s.conn.Close()
+	})
+}
+
+// addLocked registers a new stream. s.mu must be held.
+func (s *Session) addLocked(st *Stream) {
+	s.streams[st.id] = st
+	if s.flow != nil {
+		s.flow.addStream(st.id)
+	}
+}
+
+// removeLocked forgets a stream that is finished in both directions. s.mu must be held.
+func (s *Session) removeLocked(st *Stream) {
+	if s.streams[st.id] != st {
+		return
+	}
+	delete(s.streams, st.id)
+	if s.flow != nil {
+		s.flow.ReleaseStream(st.id)
+	}
+}
+
+// =============================================================================
+// Session Goroutines
+// =============================================================================
+
+// readLoop dispatches incoming frames to their streams until the Conn fails.
+func (s *Session) readLoop() {
+	for {
+		header, payload, err := s.conn.ReadFrame()
+		if err != nil {
+			if IsDroppable(err) {
+				continue
+			}
+			select {
+			case <-s.closed:
+			default:
+				s.fail(fmt.Errorf("%w: %w", ErrSessionClosed, err))
+			}
+			return
+		}
+
+		switch header.MessageType {
+		case MessageTypeControl:
+			code, body, err := ParseControl(header, payload)
+			if err != nil {
+				continue
+			}
+			switch code {
+			case ControlStreamOpen, ControlStreamClose, ControlStreamReset:
+				if id, reason, err := ParseStreamControl(body); err == nil {
+					s.streamControl(code, id, reason)
+				}
+			}
+		case MessageTypeData:
+			if id, ok := header.StreamID(); ok {
+				s.deliver(id, payload)
+			}
+		}
+	}
+}
+
+// streamControl applies a stream control frame from the peer.
+func (s *Session) streamControl(code ControlCode, id uint32, reason string) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	st := s.streams[id]
+
+	switch code {
+	case ControlStreamOpen:
+		if st != nil || id == 0 || id >= StreamDefaults {
+			break
+		}
+		st = newStream(s, id)
+		select {
+		case s.accept <- st:
+			s.addLocked(st)
+		default:
+			s.mu.Unlock()
+			header, payload := StreamControlFrame(ControlStreamReset, id, "accept backlog full")
+			s.conn.WriteFrame(header, payload)
+			return
+		}
+
+	case ControlStreamClose:
+		if st != nil {
+			st.recvFin = true
+			st.notifyLocked()
+			if st.sentFin {
+				s.removeLocked(st)
+			}
+		}
+
+	case ControlStreamReset:
+		if st != nil {
+			st.reset = fmt.Errorf("%w by peer: %s", ErrStreamReset, reason)
+			st.failWritesLocked(st.reset, true)
+			st.notifyLocked()
+			s.removeLocked(st)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// deliver queues data received on a stream for Read. With flow control the stream window
+// bounds what is queued; without it, a stream whose reader falls more than MaxStreamBuffer
+// bytes behind is reset.
+func (s *Session) deliver(id uint32, payload []byte) {
+	s.mu.Lock()
+	st := s.streams[id]
+	if st == nil || st.recvFin || st.reset != nil {
+		s.mu.Unlock()
+		return // Data after the end of a stream is dropped
+	}
+	if st.readClosed {
+		s.mu.Unlock()
+		s.release(id, len(payload))
+		return
+	}
+	if s.flow == nil && st.buffered+len(payload) > s.cfg.MaxStreamBuffer {
+		st.recv, st.buffered = nil, 0
+		s.mu.Unlock()
+		st.Reset("receive buffer full")
+		return
+	}
+	if len(payload) > 0 {
+		st.recv = append(st.recv, payload)
+		st.buffered += len(payload)
+		st.notifyLocked()
+	}
+	s.mu.Unlock()
+}
+
+// release returns stream credit for bytes the application has read or discarded.
+func (s *Session) release(id uint32, n int) {
+	if s.flow != nil && n > 0 {
+		s.flow.release(id, int64(n))
+	}
+}
+
+// writeLoop sends queued stream writes, one chunk per ready stream in turn.
+func (s *Session) writeLoop() {
+	for {
+		st, w, chunk, credit := s.pick()
+		if st == nil {
+			if credit != nil {
+				s.flow.stall()
+			}
+			select {
+			case <-s.wake:
+			case <-credit:
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+
+		var err error
+		if w.fin {
+			header, payload := StreamControlFrame(ControlStreamClose, st.id, "")
+			err = s.conn.WriteFrame(header, payload)
+		} else {
+			header := &SocketHeader{MessageType: MessageTypeData}
+			header.SetStreamID(st.id)
+			if s.flow != nil {
+				err = s.flow.writeCredited(header, chunk)
+			} else {
+				err = s.conn.WriteFrame(header, chunk)
+			}
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("%w: %w", ErrSessionClosed, err))
+			return
+		}
+
+		s.mu.Lock()
+		s.inflight = nil
+		if !w.finished {
+			if w.fin {
+				st.sentFin = true
+				if st.recvFin {
+					s.removeLocked(st)
+				}
+			} else {
+				w.written += len(chunk)
+			}
+			if w.fin || w.written == len(w.data) {
+				st.completeLocked(w, nil)
+			} else if w.canceled {
+				st.completeLocked(w, st.reset) // Reset, or nil when the caller's deadline passed
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// pick returns the next chunk to send, rotating through the ready streams. When every ready
+// stream is out of credit it returns nil and a channel that is closed when credit arrives.
+func (s *Session) pick() (*Stream, *streamWrite, []byte, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var credit <-chan struct{}
+	for range len(s.ready) {
+		st := s.ready[0]
+		s.ready = s.ready[1:]
+		if len(st.writes) == 0 || st.reset != nil {
+			st.queued = false
+			continue
+		}
+		s.ready = append(s.ready, st)
+
+		w := st.writes[0]
+		chunk := w.data[w.written:min(len(w.data), w.written+s.cfg.ChunkSize)]
+		if !w.fin && s.flow != nil {
+			if ok, changed := s.flow.tryAcquire(st.id, int64(len(chunk))); !ok {
+				credit = changed
+				continue
+			}
+		}
+		s.inflight = w
+		return st, w, chunk, nil
+	}
+	return nil, nil, nil, credit
+}
+
+// =============================================================================
+// Stream
+// =============================================================================
+
+// streamWrite is a Write or CloseWrite waiting to be sent.
+type streamWrite struct {
+	data     []byte
+	fin      bool // Send ControlStreamClose instead of data
+	written  int
+	canceled bool // The caller gave up; stop after the chunk in flight
+	finished bool
+	err      error
+	done     chan struct{}
+}
+
+// Stream is one multiplexed byte stream. Read, Write and Close may be called concurrently,
+// but concurrent Writes interleave their data in an unspecified way.
+type Stream struct {
+	id            uint32
+	session       *Session
+	recv          [][]byte // Received payloads not yet read
+	buffered      int      // Bytes in recv
+	recvFin       bool     // The peer will send no more
+	readClosed    bool     // Close was called: received data is discarded
+	writes        []*streamWrite
+	queued        bool // In session.ready
+	finQueued     bool // CloseWrite was called
+	sentFin       bool
+	reset         error
+	changed       chan struct{} // Closed when the stream state changes
+	readDeadline  memoryDeadline
+	writeDeadline memoryDeadline
+}
+
+func newStream(s *Session, id uint32) *Stream {
+	return &Stream{id: id, session: s}
+}
+
+// ID returns the stream ID, as carried in ExtensionStreamID.
+func (st *Stream) ID() uint32 {
+	return st.id
+}
+
+// Read reads data sent on the stream. It returns io.EOF once the peer has closed it for
+// writing and every byte has been read.
+func (st *Stream) Read(p []byte) (int, error) {
+	s := st.session
+	for {
+		s.mu.Lock()
+		if len(st.recv) > 0 {
+			n := copy(p, st.recv[0])
+			st.buffered -= n
+			if n == len(st.recv[0]) {
+				st.recv[0] = nil
+				st.recv = st.recv[1:]
+			} else {
+				st.recv[0] = st.recv[0][n:]
+			}
+			s.mu.Unlock()
+			s.release(st.id, n)
+			return n, nil
+		}
+		var err error
+		switch {
+		case st.reset != nil:
+			err = st.reset
+		case st.recvFin:
+			err = io.EOF
+		case st.readClosed:
+			err = ErrStreamClosed
+		}
+		changed := st.changedLocked()
+		s.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if err := waitDeadline(changed, &st.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write sends p on the stream, split into frames of at most SessionConfig.ChunkSize bytes.
+// It returns once every byte has been handed to the Conn.
+func (st *Stream) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return st.write(&streamWrite{data: p, done: make(chan struct{})})
+}
+
+// CloseWrite half-closes the stream: the peer reads io.EOF after the data already written,
+// and can still send data until it closes its side.
+func (st *Stream) CloseWrite() error {
+	s := st.session
+	s.mu.Lock()
+	done := st.finQueued
+	st.finQueued = true
+	s.mu.Unlock()
+	if done {
+		return nil
+	}
+	_, err := st.write(&streamWrite{fin: true, done: make(chan struct{})})
+	return err
+}
+
+// Close closes the stream for writing and discards anything the peer sends from now on.
+func (st *Stream) Close() error {
+	s := st.session
+	s.mu.Lock()
+	st.readClosed = true
+	discarded := 0
+	for _, b := range st.recv {
+		discarded += len(b)
+	}
+	st.recv, st.buffered = nil, 0
+	st.notifyLocked()
+	reset := st.reset
+	s.mu.Unlock()
+	s.release(st.id, discarded)
+
+	if reset != nil {
+		return nil // Nothing left to close
+	}
+	return st.CloseWrite()
+}
+
+// Reset aborts the stream in both directions. Pending reads and writes on both sides fail
+// with ErrStreamReset, and the peer sees the reason.
+func (st *Stream) Reset(reason string) error {
+	s := st.session
+	s.mu.Lock()
+	if st.reset != nil {
+		s.mu.Unlock()
+		return nil
+	}
+	st.reset = fmt.Errorf("%w: %s", ErrStreamReset, reason)
+	st.failWritesLocked(st.reset, false)
+	st.notifyLocked()
+	s.removeLocked(st)
+	s.mu.Unlock()
+
+	header, payload := StreamControlFrame(ControlStreamReset, st.id, reason)
+	return s.conn.WriteFrame(header, payload)
+}
+
+// SetDeadline sets the read and write deadlines.
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read; expired reads return os.ErrDeadlineExceeded.
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write and CloseWrite.
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.writeDeadline.set(t)
+	return nil
+}
+
+// write queues w and waits until it is sent, fails or times out.
+func (st *Stream) write(w *streamWrite) (int, error) {
+	s := st.session
+	s.mu.Lock()
+	switch {
+	case st.reset != nil:
+		s.mu.Unlock()
+		return 0, st.reset
+	case st.sentFin || (!w.fin && st.finQueued):
+		s.mu.Unlock()
+		return 0, ErrStreamClosed
+	}
+	st.writes = append(st.writes, w)
+	if !st.queued {
+		st.queued = true
+		s.ready = append(s.ready, st)
+	}
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	if err := waitDeadline(w.done, &st.writeDeadline); err != nil {
+		s.mu.Lock()
+		if !w.finished {
+			if s.inflight == w {
+				w.canceled = true // The chunk in flight will still be sent
+			} else {
+				st.completeLocked(w, err)
+			}
+		}
+		s.mu.Unlock()
+		<-w.done
+		return w.written, err
+	}
+	return w.written, w.err
+}
+
+// completeLocked finishes a write and removes it from the queue. session.mu must be held.
+func (st *Stream) completeLocked(w *streamWrite, err error) {
+	if w.finished {
+		return
+	}
+	w.finished, w.err = true, err
+	for i, queued := range st.writes {
+		if queued == w {
+			st.writes = append(st.writes[:i], st.writes[i+1:]...)
+			break
+		}
+	}
+	close(w.done)
+}
+
+// failWritesLocked fails every queued write with err. The write in flight is failed too when
+// all is set, since the session will never report its completion. session.mu must be held.
+func (st *Stream) failWritesLocked(err error, all bool) {
+	for _, w := range append([]*streamWrite(nil), st.writes...) {
+		if w == st.session.inflight && !all {
+			w.canceled = true
+			continue
+		}
+		st.completeLocked(w, err)
+	}
+}
+
+// changedLocked returns a channel that is closed on the next state change. session.mu must be held.
+func (st *Stream) changedLocked() <-chan struct{} {
+	if st.changed == nil {
+		st.changed = make(chan struct{})
+	}
+	return st.changed
+}
+
+// notifyLocked wakes everything waiting on the stream. session.mu must be held.
+func (st *Stream) notifyLocked() {
+	if st.changed != nil {
+		close(st.changed)
+		st.changed = nil
+	}
+}
+
+// waitDeadline waits for done, giving up with os.ErrDeadlineExceeded when the deadline passes.
+func waitDeadline(done <-chan struct{}, d *memoryDeadline) error {
+	for {
+		var expired <-chan time.Time
+		var t *time.Timer
+		if deadline := d.get(); !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				select {
+				case <-done:
+					return nil
+				default:
+					return os.ErrDeadlineExceeded
+				}
+			}
+			t = time.NewTimer(wait)
+			expired = t.C
+		}
+		changed := d.wait()
+
+		select {
+		case <-done:
+		case <-changed:
+		case <-expired:
+		}
+		if t != nil {
+			t.Stop()
+		}
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+	}
+}
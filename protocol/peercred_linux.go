@@ -0,0 +1,29 @@
+//go:build linux
+
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentials reads SO_PEERCRED from a connected Unix stream socket.
+func peerCredentials(c *net.UnixConn) (PeerCredentials, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return PeerCredentials{}, fmt.Errorf("%w: %w", ErrNoPeerCredentials, err)
+	}
+
+	var ucred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return PeerCredentials{}, fmt.Errorf("%w: %w", ErrNoPeerCredentials, err)
+	}
+	if credErr != nil {
+		return PeerCredentials{}, fmt.Errorf("%w: %w", ErrNoPeerCredentials, credErr)
+	}
+	return PeerCredentials{PID: ucred.Pid, UID: ucred.Uid, GID: ucred.Gid}, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package protocol
+
+import "net"
+
+// peerCredentials is only implemented on Linux (SO_PEERCRED).
+func peerCredentials(c *net.UnixConn) (PeerCredentials, error) {
+	return PeerCredentials{}, ErrNoPeerCredentials
+}
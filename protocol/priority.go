@@ -0,0 +1,63 @@
+// Package protocol provides priority classes for frames. A frame's class is taken from its
+// ExtensionPriority extension or derived from its MessageType, and decides the order in which
+// queued frames are written, so heartbeats, control frames and ACKs never wait behind bulk data.
+package protocol
+
+// Priority is the scheduling class of a frame; lower values are written first.
+type Priority uint8
+
+const (
+	PriorityControl Priority = iota // Connection management: control frames and heartbeats
+	PriorityHigh                    // Latency-sensitive application data
+	PriorityNormal                  // Default for application data
+	PriorityBulk                    // Large or background transfers
+)
+
+// NumPriorities is the number of priority classes.
+const NumPriorities = int(PriorityBulk) + 1
+
+// String returns the string representation of Priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "Control"
+	case PriorityHigh:
+		return "High"
+	case PriorityNormal:
+		return "Normal"
+	case PriorityBulk:
+		return "Bulk"
+	default:
+		return "InvalidPriority"
+	}
+}
+
+// IsValid returns true if the Priority is within valid range.
+func (p Priority) IsValid() bool {
+	return p <= PriorityBulk
+}
+
+// SetPriority sets the ExtensionPriority extension.
+func (h *SocketHeader) SetPriority(p Priority) {
+	h.SetExt(ExtensionPriority, []byte{byte(p)})
+}
+
+// Priority returns the class the frame is scheduled in. Control and heartbeat frames, and
+// acknowledgments (FlagACK), are always PriorityControl. Other frames use ExtensionPriority, or
+// PriorityNormal when it is missing or invalid; they cannot claim PriorityControl, which is
+// raised to PriorityHigh.
+func (h *SocketHeader) Priority() Priority {
+	switch h.MessageType {
+	case MessageTypeControl, MessageTypeHeartbeat:
+		return PriorityControl
+	}
+	if HasFlag(h.Flags, FlagACK) {
+		return PriorityControl
+	}
+
+	value, ok := h.Ext(ExtensionPriority)
+	if !ok || len(value) != 1 || !Priority(value[0]).IsValid() {
+		return PriorityNormal
+	}
+	return max(Priority(value[0]), PriorityHigh)
+}
@@ -2,10 +2,13 @@
 package protocol
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,9 +16,24 @@ import (
 
 // Conn abstracts a framed connection (TCP or UDP) with sender metadata.
 // ReadFrame returns the full SocketHeader and payload; WriteFrame accepts a SocketHeader.
+// ReadFrameInto decodes into a caller-owned header and reuses buf for the payload when it
+// has enough capacity, so the returned payload can be passed back in as buf on the next call.
+//
+// Concurrency: every method may be called from multiple goroutines. Concurrent WriteFrame
+// calls never interleave frame bytes, and concurrent ReadFrame calls each receive whole
+// frames (stream transports serialize them). A header passed to WriteFrame is updated in place (ID, Sender,
+// Length, ...), so it must not be shared between concurrent writers.
+//
+// ReadFrameContext and WriteFrameContext map the context deadline and cancellation onto the
+// connection's read or write deadline for the duration of the call, then clear it; they
+// replace any deadline set with SetReadDeadline or SetWriteDeadline. A frame interrupted
+// halfway on a stream transport leaves the connection unusable, which IsFatal reports.
 type Conn interface {
 	ReadFrame() (*SocketHeader, []byte, error)
+	ReadFrameInto(header *SocketHeader, buf []byte) ([]byte, error)
+	ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error)
 	WriteFrame(header *SocketHeader, payload []byte) error
+	WriteFrameContext(ctx context.Context, header *SocketHeader, payload []byte) error
 	Close() error
 	RemoteAddr() net.Addr
 	LocalAddr() net.Addr
@@ -25,96 +43,272 @@ type Conn interface {
 	GetSender() uuid.UUID
 }
 
+// connOptions holds the settings shared by the connection wrappers.
+type connOptions struct {
+	validation ValidationOptions // Checks applied to every received header
+	coalescing *WriteCoalescing  // Write batching for stream connections (nil for one write per frame)
+	maxPayload uint64            // Largest payload accepted from stream connections (zero for no limit)
+}
+
+// DefaultMaxPayloadSize bounds incoming payloads on stream connections unless WithMaxPayloadSize
+// changes it. It matches the hub's default MaxMessageSize.
+const DefaultMaxPayloadSize = 4 * 1024 * 1024
+
+// ConnOption configures a connection wrapper at construction time.
+type ConnOption func(*connOptions)
+
+// WithValidation sets the header validation applied by ReadFrame (strict by default).
+func WithValidation(opts ValidationOptions) ConnOption {
+	return func(o *connOptions) {
+		o.validation = opts
+	}
+}
+
+// WithWriteCoalescing batches frames written to a stream connection (see WriteCoalescing).
+// It has no effect on UDP, where every frame is its own datagram.
+func WithWriteCoalescing(cfg WriteCoalescing) ConnOption {
+	return func(o *connOptions) {
+		o.coalescing = &cfg
+	}
+}
+
+// WithMaxPayloadSize rejects incoming frames whose payload exceeds n bytes before it is read,
+// so a peer cannot force large allocations (DefaultMaxPayloadSize when not given). A zero or
+// negative n removes the limit. Datagram transports are already bounded by their maximum message size.
+func WithMaxPayloadSize(n int) ConnOption {
+	return func(o *connOptions) {
+		o.maxPayload = uint64(max(n, 0))
+	}
+}
+
+// newConnOptions applies opts over the defaults.
+func newConnOptions(opts []ConnOption) connOptions {
+	o := connOptions{maxPayload: DefaultMaxPayloadSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// senderID is a UUID that can be read and replaced concurrently.
+type senderID struct {
+	mu sync.RWMutex
+	id uuid.UUID
+}
+
+func (s *senderID) get() uuid.UUID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.id
+}
+
+func (s *senderID) set(id uuid.UUID) {
+	s.mu.Lock()
+	s.id = id
+	s.mu.Unlock()
+}
+
 // tcpConnWrapper wraps a net.Conn for framed I/O using protohub protocol.
 type tcpConnWrapper struct {
-	conn   net.Conn
-	sender uuid.UUID
+	conn      net.Conn
+	transport ProtocolType // Protocol stamped on and expected from frames (TCP or Unix)
+	sender    senderID
+	opts      connOptions
+	rmu       sync.Mutex            // Serializes readers (guards prefix and the stream position)
+	prefix    [FramePrefixSize]byte // Scratch space for the header size prefix (reads only)
+	wmu       sync.Mutex            // Serializes direct writes so frames never interleave
+	coalescer *writeCoalescer       // Batched write path with its own locking (nil when disabled)
 }
 
 // NewTCPConnWrapper constructs a Conn from a net.Conn.
-func NewTCPConnWrapper(c net.Conn) Conn {
-	return &tcpConnWrapper{
-		conn:   c,
-		sender: uuid.New(), // default sender ID
+func NewTCPConnWrapper(c net.Conn, opts ...ConnOption) Conn {
+	return newStreamConnWrapper(c, ProtocolTCP, opts)
+}
+
+// newStreamConnWrapper builds a stream wrapper that frames c for the given transport.
+func newStreamConnWrapper(c net.Conn, transport ProtocolType, opts []ConnOption) *tcpConnWrapper {
+	t := &tcpConnWrapper{
+		conn:      c,
+		transport: transport,
+		opts:      newConnOptions(opts),
 	}
+	t.sender.set(uuid.New()) // default sender ID
+	if t.opts.coalescing != nil {
+		t.coalescer = newWriteCoalescer(c, *t.opts.coalescing)
+	}
+	return t
 }
 
 // ReadFrame reads a full frame (header + payload) from TCP.
 func (t *tcpConnWrapper) ReadFrame() (*SocketHeader, []byte, error) {
-	// Read the header size prefix to determine how much to read.
-	HeaderSize := make([]byte, 1) // 1 byte for header size
-	if _, err := io.ReadFull(t.conn, HeaderSize); err != nil {
-		return nil, nil, fmt.Errorf("TCP: failed to read header size prefix: %w", err)
+	h := &SocketHeader{}
+	payload, err := t.ReadFrameInto(h, nil)
+	if err != nil {
+		return nil, nil, err
 	}
+	return h, payload, nil
+}
 
-	// Read the header
-	headerBytes :=make([]byte, HeaderSize[0]) // Use the size from the prefix
-	if _, err := io.ReadFull(t.conn, headerBytes); err != nil {
-		return nil, nil, fmt.Errorf("TCP: failed to read header: %w", err)
+// ReadFrameInto reads a full frame from TCP into h, reusing buf for the payload when possible.
+func (t *tcpConnWrapper) ReadFrameInto(h *SocketHeader, buf []byte) ([]byte, error) {
+	if h == nil {
+		return nil, fmt.Errorf("TCP: %w", ErrNilHeader)
 	}
-	// Decode the header
-	h, err := HeaderDecode(headerBytes)
+
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	return t.readFrameLocked(h, buf)
+}
+
+// ReadFrameContext reads a full frame from TCP, giving up when ctx is done.
+func (t *tcpConnWrapper) ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	var payload []byte
+
+	t.rmu.Lock()
+	defer t.rmu.Unlock()
+	err := withContext(ctx, t.conn.SetReadDeadline, func() (err error) {
+		payload, err = t.readFrameLocked(h, nil)
+		return err
+	})
 	if err != nil {
-		return nil, nil, fmt.Errorf("TCP: decode header error: %w", err)
+		return nil, nil, err
 	}
+	return h, payload, nil
+}
 
-	// Now we allocate the buffer for the payload
-	PayloadSize := make([]byte, h.Length+4) // +4 for checksum
+// readFrameLocked reads one frame from the stream. t.rmu must be held.
+func (t *tcpConnWrapper) readFrameLocked(h *SocketHeader, buf []byte) ([]byte, error) {
+	// Read the header size prefix to determine how much to read.
+	if n, err := io.ReadFull(t.conn, t.prefix[:]); err != nil {
+		err = fmt.Errorf("TCP: failed to read header size prefix: %w", err)
+		if n > 0 {
+			// Part of the frame was consumed, so the stream can no longer be resynchronized
+			return nil, fatal(err)
+		}
+		return nil, err
+	}
+	headerSize := int(binary.BigEndian.Uint16(t.prefix[:]))
+	if headerSize < HeaderMinSize || headerSize > HeaderMaxSize {
+		return nil, fatal(fmt.Errorf("TCP: %w (got %d, min %d, max %d)",
+			ErrHeaderSize, headerSize, HeaderMinSize, HeaderMaxSize))
+	}
+
+	// Read the header into a pooled buffer (decoding copies everything out of it)
+	hb := getBuffer(headerSize)
+	defer putBuffer(hb)
+	if _, err := io.ReadFull(t.conn, *hb); err != nil {
+		return nil, fatal(fmt.Errorf("TCP: failed to read header: %w", err))
+	}
+
+	// Decode the header structure (semantic validation runs once the payload is consumed)
+	if err := HeaderDecodeInto(h, *hb, ValidationOptions{Disabled: true}); err != nil {
+		// The payload length is unknown, so the stream can no longer be resynchronized
+		return nil, fatal(fmt.Errorf("TCP: decode header error: %w", err))
+	}
+	if h.Length > uint64(maxInt-FrameChecksumSize) || (t.opts.maxPayload > 0 && h.Length > t.opts.maxPayload) {
+		return nil, fatal(fmt.Errorf("TCP: %w: payload length %d", ErrFrameTooLarge, h.Length))
+	}
+
+	// Reuse the caller buffer for payload + checksum when it is large enough
+	need := int(h.Length) + FrameChecksumSize
+	if cap(buf) >= need {
+		buf = buf[:need]
+	} else {
+		buf = make([]byte, need)
+	}
 
 	// Read the payload
-	if _, err := io.ReadFull(t.conn, PayloadSize); err != nil {
-		return nil, nil, fmt.Errorf("TCP: failed to read payload: %w", err)
+	if _, err := io.ReadFull(t.conn, buf); err != nil {
+		return nil, fatal(fmt.Errorf("TCP: failed to read payload: %w", err))
 	}
 
 	// analyze the checksum
-	checksumBytes := PayloadSize[len(PayloadSize)-4:]
-	payloadData := PayloadSize[:len(PayloadSize)-4]
-	checksum := binary.BigEndian.Uint32(checksumBytes)
-	if checksum != Checksum(payloadData) {
-		return nil, nil, fmt.Errorf("TCP: checksum mismatch")
+	payloadData := buf[:h.Length]
+	if binary.BigEndian.Uint32(buf[h.Length:]) != Checksum(payloadData) {
+		return nil, fmt.Errorf("TCP: %w", ErrChecksumMismatch)
+	}
+
+	// Validate the header; the stream stays aligned, so a rejected frame is droppable
+	if err := validateFrame(h, t.transport, t.opts.validation); err != nil {
+		return nil, fmt.Errorf("TCP: %w", err)
 	}
 
 	// return the payload
-	return h, payloadData, nil
+	return payloadData, nil
 }
 
 // WriteFrame encodes the provided SocketHeader and payload, then writes to TCP.
 func (t *tcpConnWrapper) WriteFrame(header *SocketHeader, payload []byte) error {
+	return t.writeFrame(nil, header, payload)
+}
+
+// WriteFrameContext writes a frame to TCP, giving up when ctx is done.
+// With write coalescing the deadline bounds any flush the call performs.
+func (t *tcpConnWrapper) WriteFrameContext(ctx context.Context, header *SocketHeader, payload []byte) error {
+	return t.writeFrame(ctx, header, payload)
+}
+
+// writeFrame prepares and sends one frame, bounded by ctx when it is non-nil.
+func (t *tcpConnWrapper) writeFrame(ctx context.Context, header *SocketHeader, payload []byte) error {
 	if header == nil {
-		return fmt.Errorf("TCP: header cannot be nil")
+		return fmt.Errorf("TCP: %w", ErrNilHeader)
 	}
 
-	// Set payload length in header
+	// Set TCP-specific fields and fill identifiers the receiver requires
+	header.Protocol = t.transport
+	if header.ID == uuid.Nil {
+		header.ID = uuid.New()
+	}
+	if header.Sender == uuid.Nil {
+		header.Sender = t.sender.get()
+	}
 	header.Length = uint64(len(payload))
-	// (Other fields like Sender remain as is.)
 
-	// Encode header and use its actual encoded length
-	headerBytes, err := HeaderEncode(header)
+	// Batched write path
+	if t.coalescer != nil {
+		if err := t.coalescer.writeFrame(ctx, t.conn.SetWriteDeadline, header, payload); err != nil {
+			return fmt.Errorf("TCP: coalesced write error: %w", err)
+		}
+		return nil
+	}
+
+	// Encode the whole frame into a pooled buffer
+	bp := getBuffer(FrameSize(header, len(payload)))
+	defer putBuffer(bp)
+	n, err := EncodeFrameTo(*bp, header, payload)
 	if err != nil {
 		return fmt.Errorf("TCP: header encode error: %w", err)
 	}
-	encodedHeaderLen := len(headerBytes)
-
-	// Calculate total message size using the actual header length
-	messageSize := 1 + encodedHeaderLen + len(payload) + 4
-	message := make([]byte, messageSize)
 
-	// Write header size prefix using the encoded header length
-	message[0] = uint8(encodedHeaderLen)
-	// Copy header
-	copy(message[1:], headerBytes)
-	// Calculate payload start based on actual header length
-	payloadStart := 1 + encodedHeaderLen
-	copy(message[payloadStart:], payload)
-	// Calculate checksum (over the payload)
-	checksum := Checksum(payload)
-	binary.BigEndian.PutUint32(message[len(message)-4:], checksum)
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	if ctx == nil {
+		_, err = t.conn.Write((*bp)[:n])
+		return err
+	}
+	return withContext(ctx, t.conn.SetWriteDeadline, func() error {
+		_, err := t.conn.Write((*bp)[:n])
+		return err
+	})
+}
 
-	_, err = t.conn.Write(message)
-	return err
+// Flush writes out frames held by write coalescing (no-op when coalescing is disabled).
+func (t *tcpConnWrapper) Flush() error {
+	if t.coalescer == nil {
+		return nil
+	}
+	if err := t.coalescer.flush(); err != nil {
+		return fmt.Errorf("TCP: flush error: %w", err)
+	}
+	return nil
 }
 
+// Close flushes any coalesced frames and closes the connection.
 func (t *tcpConnWrapper) Close() error {
+	if t.coalescer != nil {
+		t.coalescer.flush()
+	}
 	return t.conn.Close()
 }
 
@@ -135,137 +329,155 @@ func (t *tcpConnWrapper) SetWriteDeadline(tm time.Time) error {
 }
 
 func (t *tcpConnWrapper) SetSender(id uuid.UUID) {
-	t.sender = id
+	t.sender.set(id)
 }
 
 func (t *tcpConnWrapper) GetSender() uuid.UUID {
-	return t.sender
+	return t.sender.get()
 }
 
 // udpConnWrapper wraps a net.PacketConn + remote address for framed I/O via protohub.
 type udpConnWrapper struct {
 	pc             net.PacketConn
+	transport      ProtocolType // Protocol stamped on and expected from frames (UDP or Unix)
+	addrMu         sync.RWMutex // Guards addr, which ReadFrame updates to the latest peer
 	addr           net.Addr
 	maxMessageSize int
-	sender         uuid.UUID
-	sequence       uint32
+	sender         senderID
+	sequence       atomic.Uint32 // Next sequence number, shared by concurrent writers
+	rctxMu         sync.Mutex    // Serializes ReadFrameContext calls so their deadlines never overlap
+	wctxMu         sync.Mutex    // Serializes WriteFrameContext calls so their deadlines never overlap
+	opts           connOptions
 }
 
 // NewUDPConnWrapper constructs a Conn from a PacketConn and remote Addr.
-func NewUDPConnWrapper(pc net.PacketConn, addr net.Addr, maxSize int) Conn {
-	return &udpConnWrapper{
+func NewUDPConnWrapper(pc net.PacketConn, addr net.Addr, maxSize int, opts ...ConnOption) Conn {
+	return newDatagramConnWrapper(pc, addr, maxSize, ProtocolUDP, opts)
+}
+
+// newDatagramConnWrapper builds a datagram wrapper that frames pc for the given transport.
+func newDatagramConnWrapper(pc net.PacketConn, addr net.Addr, maxSize int, transport ProtocolType, opts []ConnOption) *udpConnWrapper {
+	u := &udpConnWrapper{
 		pc:             pc,
+		transport:      transport,
 		addr:           addr,
 		maxMessageSize: maxSize,
-		sender:         uuid.New(),
-		sequence:       0,
+		opts:           newConnOptions(opts),
 	}
+	u.sender.set(uuid.New())
+	return u
 }
 
-// Corrected UDP ReadFrame and WriteFrame methods
-
 // ReadFrame reads a full UDP datagram, decodes via protohub, and returns header + payload.
 func (u *udpConnWrapper) ReadFrame() (*SocketHeader, []byte, error) {
-	// Read complete UDP packet
-	buf := make([]byte, u.maxMessageSize)
-	n, addr, err := u.pc.ReadFrom(buf)
+	h := &SocketHeader{}
+	payload, err := u.ReadFrameInto(h, nil)
 	if err != nil {
-		return nil, nil, fmt.Errorf("UDP: read error: %w", err)
+		return nil, nil, err
 	}
-	u.addr = addr
+	return h, payload, nil
+}
 
-	// Read the header size prefix to determine how much to read.
-	if n < 1 {
-		return nil, nil, fmt.Errorf("UDP: packet too small for header size prefix")
+// ReadFrameInto reads a full UDP datagram into h, copying the payload into buf when it is large enough.
+func (u *udpConnWrapper) ReadFrameInto(h *SocketHeader, buf []byte) ([]byte, error) {
+	if h == nil {
+		return nil, fmt.Errorf("UDP: %w", ErrNilHeader)
 	}
-	headerSize := buf[0]
 
-	// Verify we have enough data for header
-	if n < 1+int(headerSize) {
-		return nil, nil, fmt.Errorf("UDP: packet too small for header")
+	// Read complete UDP packet into a pooled buffer
+	bp := getBuffer(u.maxMessageSize)
+	defer putBuffer(bp)
+	n, addr, err := u.pc.ReadFrom(*bp)
+	if err != nil {
+		return nil, fmt.Errorf("UDP: read error: %w", err)
 	}
+	u.addrMu.Lock()
+	u.addr = addr
+	u.addrMu.Unlock()
 
-	// Read the header
-	headerBytes := buf[1:1+headerSize]
-	
-	// Decode the header
-	h, err := HeaderDecode(headerBytes)
+	// Decode the frame structure and checksum (semantic validation runs afterwards)
+	payload, _, err := DecodeFrameInto(h, (*bp)[:n], ValidationOptions{Disabled: true})
 	if err != nil {
-		return nil, nil, fmt.Errorf("UDP: decode header error: %w", err)
+		return nil, fmt.Errorf("UDP: decode frame error: %w", err)
 	}
 
-	// Calculate payload start position
-	payloadStart := 1 + int(headerSize)
-	
-	// Verify we have enough data for payload + checksum
-	if uint64(n) < uint64(payloadStart)+h.Length+4 { // +4 for checksum
-		return nil, nil, fmt.Errorf("UDP: packet too small for payload and checksum")
+	// Validate the header
+	if err := validateFrame(h, u.transport, u.opts.validation); err != nil {
+		return nil, fmt.Errorf("UDP: %w", err)
 	}
 
-	// Now we allocate the buffer for the payload
-	payloadSize := make([]byte, h.Length+4) // +4 for checksum
-	copy(payloadSize, buf[payloadStart:payloadStart+int(h.Length+4)])
+	// Copy the payload out of the pooled buffer
+	if cap(buf) >= len(payload) {
+		buf = buf[:len(payload)]
+	} else {
+		buf = make([]byte, len(payload))
+	}
+	copy(buf, payload)
+	return buf, nil
+}
 
-	// analyze the checksum
-	checksumBytes := payloadSize[len(payloadSize)-4:]
-	payloadData := payloadSize[:len(payloadSize)-4]
-	checksum := binary.BigEndian.Uint32(checksumBytes)
-	if checksum != Checksum(payloadData) {
-		return nil, nil, fmt.Errorf("UDP: checksum mismatch")
+// ReadFrameContext reads a full UDP datagram, giving up when ctx is done.
+// The deadline is set on the PacketConn, so it also bounds concurrent ReadFrame calls.
+func (u *udpConnWrapper) ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	var payload []byte
+
+	u.rctxMu.Lock()
+	defer u.rctxMu.Unlock()
+	err := withContext(ctx, u.pc.SetReadDeadline, func() (err error) {
+		payload, err = u.ReadFrameInto(h, nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return h, payload, nil
+}
 
-	// return the payload
-	return h, payloadData, nil
+// WriteFrameContext sends a frame as a UDP packet, giving up when ctx is done.
+func (u *udpConnWrapper) WriteFrameContext(ctx context.Context, header *SocketHeader, payload []byte) error {
+	u.wctxMu.Lock()
+	defer u.wctxMu.Unlock()
+	return withContext(ctx, u.pc.SetWriteDeadline, func() error {
+		return u.WriteFrame(header, payload)
+	})
 }
 
 // WriteFrame encodes the provided SocketHeader and payload, then sends as a UDP packet.
 func (u *udpConnWrapper) WriteFrame(header *SocketHeader, payload []byte) error {
 	if header == nil {
-		return fmt.Errorf("UDP: header cannot be nil")
+		return fmt.Errorf("UDP: %w", ErrNilHeader)
 	}
 
 	// Set UDP-specific fields (if needed)
-	header.Sender = u.sender
-	header.Protocol = ProtocolUDP
-	header.Sequence = u.sequence
-	u.sequence++
-
-	// Set payload length in header
+	if header.Sender == uuid.Nil {
+		header.Sender = u.sender.get()
+	}
+	header.Protocol = u.transport
+	header.Sequence = u.sequence.Add(1) - 1
+	if header.ID == uuid.Nil {
+		header.ID = uuid.New()
+	}
 	header.Length = uint64(len(payload))
-	// (Other fields like Sender remain as is.)
 
-	// Encode header and use its actual encoded length
-	headerBytes, err := HeaderEncode(header)
+	// Calculate total message size
+	messageSize := FrameSize(header, len(payload))
+	if messageSize > u.maxMessageSize {
+		return fmt.Errorf("UDP: %w: message size %d exceeds maximum %d", ErrFrameTooLarge, messageSize, u.maxMessageSize)
+	}
+
+	// Encode the whole frame into a pooled buffer
+	bp := getBuffer(messageSize)
+	defer putBuffer(bp)
+	n, err := EncodeFrameTo(*bp, header, payload)
 	if err != nil {
 		return fmt.Errorf("UDP: header encode error: %w", err)
 	}
-	encodedHeaderLen := len(headerBytes)
-
-	// Calculate total message size using the actual header length
-	messageSize := 1 + encodedHeaderLen + len(payload) + 4
-	if messageSize > u.maxMessageSize {
-		return fmt.Errorf("UDP: message size %d exceeds maximum %d", messageSize, u.maxMessageSize)
-	}
-	
-	message := make([]byte, messageSize)
-
-	// Write header size prefix using the encoded header length
-	message[0] = uint8(encodedHeaderLen)
-	// Copy header
-	copy(message[1:], headerBytes)
-	// Calculate payload start based on actual header length
-	payloadStart := 1 + encodedHeaderLen
-	copy(message[payloadStart:], payload)
-	// Calculate checksum (over the payload)
-	checksum := Checksum(payload)
-	binary.BigEndian.PutUint32(message[len(message)-4:], checksum)
 
 	// Send UDP packet
-	_, err = u.pc.WriteTo(message, u.addr)
-	if err != nil {
+	if _, err := u.pc.WriteTo((*bp)[:n], u.RemoteAddr()); err != nil {
 		return fmt.Errorf("UDP: write error: %w", err)
 	}
-
 	return nil
 }
 
@@ -274,6 +486,8 @@ func (u *udpConnWrapper) Close() error {
 }
 
 func (u *udpConnWrapper) RemoteAddr() net.Addr {
+	u.addrMu.RLock()
+	defer u.addrMu.RUnlock()
 	return u.addr
 }
 
@@ -290,9 +504,9 @@ func (u *udpConnWrapper) SetWriteDeadline(tm time.Time) error {
 }
 
 func (u *udpConnWrapper) SetSender(id uuid.UUID) {
-	u.sender = id
+	u.sender.set(id)
 }
 
 func (u *udpConnWrapper) GetSender() uuid.UUID {
-	return u.sender
+	return u.sender.get()
 }
@@ -0,0 +1,190 @@
+// Package protocol provides the Unix domain socket transport.
+// Stream sockets ("unix") reuse the TCP framing and datagram sockets ("unixgram") reuse the
+// UDP framing; frames carry ProtocolUnix. Stream connections expose the peer process
+// credentials (SO_PEERCRED) through the PeerCredentialsConn interface.
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// =============================================================================
+// Peer Credentials
+// =============================================================================
+
+// ErrNoPeerCredentials is returned when the platform or socket type cannot report peer credentials.
+var ErrNoPeerCredentials = errors.New("protohub: peer credentials unavailable")
+
+// PeerCredentials identifies the process on the other end of a Unix stream socket,
+// as reported by the kernel when the connection was established.
+type PeerCredentials struct {
+	PID int32  // Process ID of the peer
+	UID uint32 // Effective user ID of the peer
+	GID uint32 // Effective group ID of the peer
+}
+
+// PeerCredentialsConn is implemented by Conns that know which local process is on the other end.
+type PeerCredentialsConn interface {
+	PeerCredentials() (PeerCredentials, error)
+}
+
+// =============================================================================
+// Socket Options
+// =============================================================================
+
+// UnixSocketOptions controls the socket file created by ListenUnix and ListenUnixgram.
+type UnixSocketOptions struct {
+	Mode        os.FileMode // Permissions applied to the socket file (zero to keep the umask default)
+	UID         *int        // Owner applied to the socket file (nil for unchanged)
+	GID         *int        // Group applied to the socket file (nil for unchanged)
+	RemoveStale bool        // Remove a leftover socket file that nobody is listening on
+}
+
+// prepare removes a stale socket file at path when requested.
+func (o UnixSocketOptions) prepare(network, path string) error {
+	if !o.RemoveStale {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil // Missing, or not a socket we should touch
+	}
+	if network == "unix" {
+		if c, err := net.Dial(network, path); err == nil {
+			c.Close()
+			return fmt.Errorf("Unix: %s is in use", path)
+		}
+	}
+	return os.Remove(path)
+}
+
+// apply sets the configured permissions and ownership on the socket file.
+func (o UnixSocketOptions) apply(path string) error {
+	if o.Mode != 0 {
+		if err := os.Chmod(path, o.Mode); err != nil {
+			return fmt.Errorf("Unix: chmod socket: %w", err)
+		}
+	}
+	if o.UID != nil || o.GID != nil {
+		uid, gid := -1, -1
+		if o.UID != nil {
+			uid = *o.UID
+		}
+		if o.GID != nil {
+			gid = *o.GID
+		}
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("Unix: chown socket: %w", err)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Stream Sockets
+// =============================================================================
+
+// unixConnWrapper is a stream wrapper that also reports the peer credentials.
+type unixConnWrapper struct {
+	*tcpConnWrapper
+	cred    PeerCredentials
+	credErr error
+}
+
+// NewUnixConnWrapper constructs a Conn from a Unix stream connection, reading the
+// peer credentials once up front.
+func NewUnixConnWrapper(c *net.UnixConn, opts ...ConnOption) Conn {
+	u := &unixConnWrapper{tcpConnWrapper: newStreamConnWrapper(c, ProtocolUnix, opts)}
+	u.cred, u.credErr = peerCredentials(c)
+	return u
+}
+
+// PeerCredentials returns the credentials of the peer process.
+func (u *unixConnWrapper) PeerCredentials() (PeerCredentials, error) {
+	return u.cred, u.credErr
+}
+
+// ListenUnix listens for Unix stream connections on path and applies opts to the socket file.
+// The file is removed when the listener is closed. Accepted connections are wrapped with NewUnixConnWrapper.
+func ListenUnix(path string, opts UnixSocketOptions) (*net.UnixListener, error) {
+	if err := opts.prepare("unix", path); err != nil {
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("Unix: listen error: %w", err)
+	}
+	if err := opts.apply(path); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// DialUnix connects to a Unix stream socket and wraps the connection.
+func DialUnix(ctx context.Context, path string, opts ...ConnOption) (Conn, error) {
+	var d net.Dialer
+	c, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("Unix: dial error: %w", err)
+	}
+	return NewUnixConnWrapper(c.(*net.UnixConn), opts...), nil
+}
+
+// =============================================================================
+// Datagram Sockets
+// =============================================================================
+
+// NewUnixgramConnWrapper constructs a Conn from a Unix datagram socket and the peer address.
+// Datagram sockets carry no connection, so peer credentials are not available.
+func NewUnixgramConnWrapper(pc *net.UnixConn, addr net.Addr, maxSize int, opts ...ConnOption) Conn {
+	return newDatagramConnWrapper(pc, addr, maxSize, ProtocolUnix, opts)
+}
+
+// ListenUnixgram binds a Unix datagram socket on path and applies opts to the socket file.
+// Unlike stream listeners, the file is left behind on Close; remove it when done.
+func ListenUnixgram(path string, opts UnixSocketOptions) (*net.UnixConn, error) {
+	if err := opts.prepare("unixgram", path); err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("Unix: listen error: %w", err)
+	}
+	if err := opts.apply(path); err != nil {
+		pc.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// DialUnixgram binds a datagram socket on localPath, so the server can reply, and returns
+// a Conn that sends to the socket at path.
+func DialUnixgram(path, localPath string, maxSize int, opts ...ConnOption) (Conn, error) {
+	pc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: localPath, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("Unix: bind error: %w", err)
+	}
+	return &unixgramClient{
+		udpConnWrapper: newDatagramConnWrapper(pc, &net.UnixAddr{Name: path, Net: "unixgram"}, maxSize, ProtocolUnix, opts),
+		pc:             pc,
+		localPath:      localPath,
+	}, nil
+}
+
+// unixgramClient owns its socket, so Close releases it and removes the bound file.
+type unixgramClient struct {
+	*udpConnWrapper
+	pc        *net.UnixConn
+	localPath string
+}
+
+func (c *unixgramClient) Close() error {
+	err := c.pc.Close()
+	os.Remove(c.localPath)
+	return err
+}
@@ -0,0 +1,720 @@
+// Package protocol provides a WebSocket transport (RFC 6455) built on the standard library.
+// Every SocketHub frame travels as one binary WebSocket message, so browser clients can join
+// the same hub as native TCP clients. WebSocketListener is an http.Handler that upgrades
+// requests and hands the resulting Conns to Accept; DialWebSocket is the native client side.
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// WebSocket Constants
+// =============================================================================
+
+const (
+	// WebSocketSubprotocol is negotiated through Sec-WebSocket-Protocol when the client offers it.
+	WebSocketSubprotocol = "sockethub"
+
+	// DefaultWebSocketMessageSize bounds an incoming message when no limit is configured.
+	DefaultWebSocketMessageSize = 16 * 1024 * 1024
+
+	webSocketGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11" // Handshake accept key suffix (RFC 6455 §1.3)
+	webSocketMaxHeader  = 14                                     // 2 + 8 (length) + 4 (mask key)
+	webSocketMaxControl = 125                                    // Largest control frame payload
+)
+
+// WebSocket opcodes
+const (
+	wsOpContinuation byte = 0x0
+	wsOpText         byte = 0x1
+	wsOpBinary       byte = 0x2
+	wsOpClose        byte = 0x8
+	wsOpPing         byte = 0x9
+	wsOpPong         byte = 0xA
+)
+
+// WebSocket close status codes
+const (
+	wsCloseNormal        uint16 = 1000
+	wsCloseProtocolError uint16 = 1002
+	wsCloseUnsupported   uint16 = 1003
+	wsCloseTooBig        uint16 = 1009
+)
+
+// ErrWebSocketProtocol is returned when the peer violates RFC 6455 framing rules.
+var ErrWebSocketProtocol = errors.New("protohub: websocket protocol error")
+
+// webSocketAccept computes the Sec-WebSocket-Accept value for a handshake key.
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContainsToken reports whether a comma-separated header contains token (case-insensitive).
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// WebSocket Conn
+// =============================================================================
+
+// wsConn carries SocketHub frames as binary WebSocket messages.
+type wsConn struct {
+	conn           net.Conn
+	br             *bufio.Reader // Reader left over from the handshake (may hold buffered bytes)
+	client         bool          // Client ends mask outgoing frames; servers require masked input
+	maxMessageSize int
+	sender         senderID
+	opts           connOptions
+	rmu            sync.Mutex // Serializes readers (guards msg, rhdr and the stream position)
+	msg            []byte     // Reassembly buffer for the current message
+	rhdr           [8]byte    // Scratch space for frame headers
+	wmu            sync.Mutex // Serializes writes so WebSocket frames never interleave
+	closeSent      atomic.Bool
+}
+
+func newWebSocketConn(c net.Conn, br *bufio.Reader, client bool, maxMessageSize int, opts []ConnOption) *wsConn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultWebSocketMessageSize
+	}
+	w := &wsConn{
+		conn:           c,
+		br:             br,
+		client:         client,
+		maxMessageSize: maxMessageSize,
+		opts:           newConnOptions(opts),
+	}
+	w.sender.set(uuid.New())
+	return w
+}
+
+// ReadFrame reads the next binary message and decodes it as a frame.
+func (w *wsConn) ReadFrame() (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	payload, err := w.ReadFrameInto(h, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+// ReadFrameInto reads the next binary message into h, copying the payload into buf when it is large enough.
+func (w *wsConn) ReadFrameInto(h *SocketHeader, buf []byte) ([]byte, error) {
+	if h == nil {
+		return nil, fmt.Errorf("WebSocket: %w", ErrNilHeader)
+	}
+
+	w.rmu.Lock()
+	defer w.rmu.Unlock()
+	return w.readFrameLocked(h, buf)
+}
+
+// ReadFrameContext reads the next frame, giving up when ctx is done.
+func (w *wsConn) ReadFrameContext(ctx context.Context) (*SocketHeader, []byte, error) {
+	h := &SocketHeader{}
+	var payload []byte
+
+	w.rmu.Lock()
+	defer w.rmu.Unlock()
+	err := withContext(ctx, w.conn.SetReadDeadline, func() (err error) {
+		payload, err = w.readFrameLocked(h, nil)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, payload, nil
+}
+
+// readFrameLocked reads one message and decodes the frame inside it. w.rmu must be held.
+func (w *wsConn) readFrameLocked(h *SocketHeader, buf []byte) ([]byte, error) {
+	msg, err := w.readMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	// Decode the frame structure and checksum (semantic validation runs afterwards)
+	payload, n, err := DecodeFrameInto(h, msg, ValidationOptions{Disabled: true})
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket: decode frame error: %w", err)
+	}
+	if n != len(msg) {
+		return nil, fmt.Errorf("WebSocket: %w: %d trailing bytes after frame", ErrTruncatedFrame, len(msg)-n)
+	}
+	if err := validateFrame(h, ProtocolWebSocket, w.opts.validation); err != nil {
+		return nil, fmt.Errorf("WebSocket: %w", err)
+	}
+
+	if cap(buf) >= len(payload) {
+		buf = buf[:len(payload)]
+	} else {
+		buf = make([]byte, len(payload))
+	}
+	copy(buf, payload)
+	return buf, nil
+}
+
+// readMessage reassembles the next binary message, answering control frames on the way.
+// Message boundaries keep the stream aligned, so only errors inside a frame are fatal.
+func (w *wsConn) readMessage() ([]byte, error) {
+	w.msg = w.msg[:0]
+	started := false
+
+	for {
+		// Fixed frame header
+		if n, err := io.ReadFull(w.br, w.rhdr[:2]); err != nil {
+			err = fmt.Errorf("WebSocket: failed to read frame header: %w", err)
+			if n > 0 || started {
+				return nil, fatal(err)
+			}
+			return nil, err
+		}
+		fin := w.rhdr[0]&0x80 != 0
+		rsv := w.rhdr[0] & 0x70
+		op := w.rhdr[0] & 0x0F
+		masked := w.rhdr[1]&0x80 != 0
+		length := uint64(w.rhdr[1] & 0x7F)
+
+		if rsv != 0 {
+			return nil, w.fail(wsCloseProtocolError, "reserved bits set")
+		}
+		if masked == w.client {
+			return nil, w.fail(wsCloseProtocolError, "wrong masking for direction")
+		}
+
+		// Extended payload length
+		switch length {
+		case 126:
+			if _, err := io.ReadFull(w.br, w.rhdr[:2]); err != nil {
+				return nil, fatal(fmt.Errorf("WebSocket: failed to read frame length: %w", err))
+			}
+			length = uint64(binary.BigEndian.Uint16(w.rhdr[:2]))
+		case 127:
+			if _, err := io.ReadFull(w.br, w.rhdr[:8]); err != nil {
+				return nil, fatal(fmt.Errorf("WebSocket: failed to read frame length: %w", err))
+			}
+			length = binary.BigEndian.Uint64(w.rhdr[:8])
+		}
+
+		var maskKey [4]byte
+		if masked {
+			if _, err := io.ReadFull(w.br, maskKey[:]); err != nil {
+				return nil, fatal(fmt.Errorf("WebSocket: failed to read mask key: %w", err))
+			}
+		}
+
+		// Control frames may arrive between fragments and are handled on the spot
+		if op >= wsOpClose {
+			if !fin || length > webSocketMaxControl {
+				return nil, w.fail(wsCloseProtocolError, "invalid control frame")
+			}
+			var body [webSocketMaxControl]byte
+			if _, err := io.ReadFull(w.br, body[:length]); err != nil {
+				return nil, fatal(fmt.Errorf("WebSocket: failed to read control frame: %w", err))
+			}
+			if masked {
+				maskBytes(maskKey, body[:length])
+			}
+			if err := w.handleControl(op, body[:length]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Data frames: a message starts with binary and continues with continuation frames
+		switch {
+		case op == wsOpText:
+			return nil, w.fail(wsCloseUnsupported, "text messages are not supported")
+		case op == wsOpBinary && started, op == wsOpContinuation && !started:
+			return nil, w.fail(wsCloseProtocolError, "unexpected fragment")
+		case op != wsOpBinary && op != wsOpContinuation:
+			return nil, w.fail(wsCloseProtocolError, fmt.Sprintf("unknown opcode %#x", op))
+		}
+		started = true
+
+		if length > uint64(w.maxMessageSize-len(w.msg)) {
+			w.sendClose(wsCloseTooBig)
+			return nil, fatal(fmt.Errorf("WebSocket: %w: message exceeds %d bytes", ErrFrameTooLarge, w.maxMessageSize))
+		}
+		start := len(w.msg)
+		w.msg = slices.Grow(w.msg, int(length))[:start+int(length)]
+		if _, err := io.ReadFull(w.br, w.msg[start:]); err != nil {
+			return nil, fatal(fmt.Errorf("WebSocket: failed to read frame payload: %w", err))
+		}
+		if masked {
+			maskBytes(maskKey, w.msg[start:])
+		}
+		if fin {
+			return w.msg, nil
+		}
+	}
+}
+
+// handleControl answers pings and close frames.
+func (w *wsConn) handleControl(op byte, body []byte) error {
+	switch op {
+	case wsOpPing:
+		if err := w.writeControl(wsOpPong, body); err != nil {
+			return fatal(fmt.Errorf("WebSocket: pong error: %w", err))
+		}
+	case wsOpClose:
+		code := wsCloseNormal
+		if len(body) >= 2 {
+			code = binary.BigEndian.Uint16(body)
+		}
+		w.sendClose(code)
+		return fmt.Errorf("WebSocket: peer closed with code %d: %w", code, io.EOF)
+	}
+	return nil // Unsolicited pongs are ignored
+}
+
+// fail sends a close frame with code and returns a fatal protocol error.
+func (w *wsConn) fail(code uint16, reason string) error {
+	w.sendClose(code)
+	return fatal(fmt.Errorf("WebSocket: %w: %s", ErrWebSocketProtocol, reason))
+}
+
+// sendClose writes a close frame once; later calls are no-ops.
+func (w *wsConn) sendClose(code uint16) {
+	if w.closeSent.Swap(true) {
+		return
+	}
+	var body [2]byte
+	binary.BigEndian.PutUint16(body[:], code)
+	w.writeControl(wsOpClose, body[:])
+}
+
+// writeControl writes a single control frame.
+func (w *wsConn) writeControl(op byte, body []byte) error {
+	var frame [webSocketMaxHeader + webSocketMaxControl]byte
+	n := w.putFrameHeader(frame[:], op, len(body))
+	copy(frame[n:], body)
+	w.maskPayload(frame[:n], frame[n:n+len(body)])
+
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	_, err := w.conn.Write(frame[:n+len(body)])
+	return err
+}
+
+// webSocketHeaderSize returns the WebSocket frame header size for a payload of n bytes.
+func (w *wsConn) webSocketHeaderSize(n int) int {
+	size := 2
+	switch {
+	case n > 0xFFFF:
+		size += 8
+	case n > 125:
+		size += 2
+	}
+	if w.client {
+		size += 4
+	}
+	return size
+}
+
+// putFrameHeader writes a final frame header for op into dst and returns its size.
+// Client frames get a fresh mask key in the last four header bytes.
+func (w *wsConn) putFrameHeader(dst []byte, op byte, n int) int {
+	dst[0] = 0x80 | op
+	i := 2
+	switch {
+	case n > 0xFFFF:
+		dst[1] = 127
+		binary.BigEndian.PutUint64(dst[2:], uint64(n))
+		i += 8
+	case n > 125:
+		dst[1] = 126
+		binary.BigEndian.PutUint16(dst[2:], uint16(n))
+		i += 2
+	default:
+		dst[1] = byte(n)
+	}
+	if w.client {
+		dst[1] |= 0x80
+		rand.Read(dst[i : i+4])
+		i += 4
+	}
+	return i
+}
+
+// maskPayload masks payload with the key at the end of header when this end is a client.
+func (w *wsConn) maskPayload(header, payload []byte) {
+	if !w.client {
+		return
+	}
+	var key [4]byte
+	copy(key[:], header[len(header)-4:])
+	maskBytes(key, payload)
+}
+
+// maskBytes XORs b with the repeating mask key (masking and unmasking are the same operation).
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i&3]
+	}
+}
+
+// WriteFrame encodes the frame and sends it as one binary WebSocket message.
+func (w *wsConn) WriteFrame(header *SocketHeader, payload []byte) error {
+	return w.writeFrame(nil, header, payload)
+}
+
+// WriteFrameContext writes a frame, giving up when ctx is done.
+func (w *wsConn) WriteFrameContext(ctx context.Context, header *SocketHeader, payload []byte) error {
+	return w.writeFrame(ctx, header, payload)
+}
+
+// writeFrame encodes and sends one frame, bounded by ctx when it is non-nil.
+func (w *wsConn) writeFrame(ctx context.Context, header *SocketHeader, payload []byte) error {
+	if header == nil {
+		return fmt.Errorf("WebSocket: %w", ErrNilHeader)
+	}
+
+	header.Protocol = ProtocolWebSocket
+	if header.ID == uuid.Nil {
+		header.ID = uuid.New()
+	}
+	if header.Sender == uuid.Nil {
+		header.Sender = w.sender.get()
+	}
+	header.Length = uint64(len(payload))
+
+	// Encode the frame behind room for the WebSocket header in a single pooled buffer
+	size := FrameSize(header, len(payload))
+	wsHeader := w.webSocketHeaderSize(size)
+	bp := getBuffer(wsHeader + size)
+	defer putBuffer(bp)
+	n, err := EncodeFrameTo((*bp)[wsHeader:], header, payload)
+	if err != nil {
+		return fmt.Errorf("WebSocket: header encode error: %w", err)
+	}
+	w.putFrameHeader(*bp, wsOpBinary, n)
+	w.maskPayload((*bp)[:wsHeader], (*bp)[wsHeader:wsHeader+n])
+
+	w.wmu.Lock()
+	defer w.wmu.Unlock()
+	write := func() error {
+		_, err := w.conn.Write((*bp)[:wsHeader+n])
+		return err
+	}
+	if ctx == nil {
+		err = write()
+	} else {
+		err = withContext(ctx, w.conn.SetWriteDeadline, write)
+	}
+	if err != nil {
+		return fmt.Errorf("WebSocket: write error: %w", err)
+	}
+	return nil
+}
+
+// Close sends a normal close frame and closes the connection without waiting for the reply.
+func (w *wsConn) Close() error {
+	w.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	w.sendClose(wsCloseNormal)
+	return w.conn.Close()
+}
+
+func (w *wsConn) RemoteAddr() net.Addr {
+	return w.conn.RemoteAddr()
+}
+
+func (w *wsConn) LocalAddr() net.Addr {
+	return w.conn.LocalAddr()
+}
+
+func (w *wsConn) SetReadDeadline(tm time.Time) error {
+	return w.conn.SetReadDeadline(tm)
+}
+
+func (w *wsConn) SetWriteDeadline(tm time.Time) error {
+	return w.conn.SetWriteDeadline(tm)
+}
+
+func (w *wsConn) SetSender(id uuid.UUID) {
+	w.sender.set(id)
+}
+
+func (w *wsConn) GetSender() uuid.UUID {
+	return w.sender.get()
+}
+
+// =============================================================================
+// WebSocket Listener (server side)
+// =============================================================================
+
+// WebSocketConfig configures the server side of the WebSocket transport.
+type WebSocketConfig struct {
+	CheckOrigin    func(r *http.Request) bool // Accepts or rejects the Origin (nil for same-host or no Origin)
+	MaxMessageSize int                        // Largest incoming message in bytes (zero for DefaultWebSocketMessageSize)
+}
+
+// WebSocketListener is an http.Handler that upgrades requests to WebSocket connections
+// and queues them for Accept, so it can be mounted on any path of an existing http.Server.
+type WebSocketListener struct {
+	cfg       WebSocketConfig
+	opts      []ConnOption
+	pending   chan Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWebSocketListener creates a listener whose connections use cfg and opts.
+func NewWebSocketListener(cfg WebSocketConfig, opts ...ConnOption) *WebSocketListener {
+	return &WebSocketListener{
+		cfg:     cfg,
+		opts:    opts,
+		pending: make(chan Conn),
+		done:    make(chan struct{}),
+	}
+}
+
+// ServeHTTP performs the RFC 6455 handshake and blocks until Accept takes the connection.
+func (l *WebSocketListener) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	select {
+	case <-l.done:
+		http.Error(rw, "WebSocket: listener closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+
+	// Validate the upgrade request
+	key := r.Header.Get("Sec-WebSocket-Key")
+	switch {
+	case r.Method != http.MethodGet:
+		http.Error(rw, "WebSocket: method not allowed", http.StatusMethodNotAllowed)
+		return
+	case !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket"):
+		http.Error(rw, "WebSocket: upgrade required", http.StatusUpgradeRequired)
+		return
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		rw.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(rw, "WebSocket: unsupported version", http.StatusUpgradeRequired)
+		return
+	case !validWebSocketKey(key):
+		http.Error(rw, "WebSocket: invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return
+	case !l.checkOrigin(r):
+		http.Error(rw, "WebSocket: origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "WebSocket: connection cannot be hijacked", http.StatusInternalServerError)
+		return
+	}
+	c, brw, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(rw, "WebSocket: hijack failed", http.StatusInternalServerError)
+		return
+	}
+	c.SetDeadline(time.Time{}) // The server's ReadTimeout and WriteTimeout may still be set
+
+	// Complete the handshake
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	brw.WriteString(webSocketAccept(key))
+	if headerContainsToken(r.Header, "Sec-WebSocket-Protocol", WebSocketSubprotocol) {
+		brw.WriteString("\r\nSec-WebSocket-Protocol: " + WebSocketSubprotocol)
+	}
+	brw.WriteString("\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		c.Close()
+		return
+	}
+
+	conn := newWebSocketConn(c, brw.Reader, false, l.cfg.MaxMessageSize, l.opts)
+	select {
+	case l.pending <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// checkOrigin applies cfg.CheckOrigin, defaulting to requests without an Origin or from the same host.
+func (l *WebSocketListener) checkOrigin(r *http.Request) bool {
+	if l.cfg.CheckOrigin != nil {
+		return l.cfg.CheckOrigin(r)
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// validWebSocketKey reports whether key is a base64-encoded 16-byte nonce.
+func validWebSocketKey(key string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	return err == nil && len(decoded) == 16
+}
+
+// Accept waits for the next upgraded connection.
+func (l *WebSocketListener) Accept() (Conn, error) {
+	select {
+	case c := <-l.pending:
+		return c, nil
+	case <-l.done:
+		return nil, fmt.Errorf("WebSocket: accept error: %w", net.ErrClosed)
+	}
+}
+
+// Close stops accepting; further upgrade requests get 503. Accepted connections stay open.
+func (l *WebSocketListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return nil
+}
+
+// Addr returns a placeholder address; the http.Server that mounts the handler owns the socket.
+func (l *WebSocketListener) Addr() net.Addr {
+	return memoryAddr("websocket")
+}
+
+// =============================================================================
+// WebSocket Dialer (client side)
+// =============================================================================
+
+// WebSocketDialer opens client WebSocket connections.
+type WebSocketDialer struct {
+	TLSConfig      *tls.Config // Used for wss:// URLs (nil for defaults)
+	Header         http.Header // Extra handshake headers (e.g., Authorization, Origin)
+	MaxMessageSize int         // Largest incoming message in bytes (zero for DefaultWebSocketMessageSize)
+}
+
+// DialWebSocket connects to a ws:// or wss:// URL with the default dialer.
+func DialWebSocket(ctx context.Context, rawURL string, opts ...ConnOption) (Conn, error) {
+	var d WebSocketDialer
+	return d.Dial(ctx, rawURL, opts...)
+}
+
+// Dial connects to a ws:// or wss:// URL, performs the handshake and returns the Conn.
+func (d *WebSocketDialer) Dial(ctx context.Context, rawURL string, opts ...ConnOption) (Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket: invalid URL: %w", err)
+	}
+	host := u.Host
+	var useTLS bool
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+	case "wss":
+		useTLS = true
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	default:
+		return nil, fmt.Errorf("WebSocket: unsupported scheme %q", u.Scheme)
+	}
+
+	var nd net.Dialer
+	c, err := nd.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket: dial error: %w", err)
+	}
+	if useTLS {
+		cfg := d.TLSConfig.Clone()
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tc := tls.Client(c, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("WebSocket: TLS handshake error: %w", err)
+		}
+		c = tc
+	}
+
+	var conn Conn
+	err = withContext(ctx, c.SetDeadline, func() (err error) {
+		conn, err = d.handshake(c, u, opts)
+		return err
+	})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handshake sends the upgrade request over c and verifies the server response.
+func (d *WebSocketDialer) handshake(c net.Conn, u *url.URL, opts []ConnOption) (Conn, error) {
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", WebSocketSubprotocol)
+	if err := req.Write(c); err != nil {
+		return nil, fmt.Errorf("WebSocket: handshake write error: %w", err)
+	}
+
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("WebSocket: handshake read error: %w", err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode != http.StatusSwitchingProtocols:
+		return nil, fmt.Errorf("WebSocket: handshake rejected: %s", resp.Status)
+	case !headerContainsToken(resp.Header, "Upgrade", "websocket"):
+		return nil, fmt.Errorf("WebSocket: %w: missing Upgrade header", ErrWebSocketProtocol)
+	case resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key):
+		return nil, fmt.Errorf("WebSocket: %w: bad Sec-WebSocket-Accept", ErrWebSocketProtocol)
+	}
+
+	return newWebSocketConn(c, br, true, d.MaxMessageSize, opts), nil
+}
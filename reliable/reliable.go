@@ -0,0 +1,283 @@
+// Package reliable provides at-least-once delivery of frames over a Conn. A frame sent with
+// protocol.FlagReliable is kept by its sender and written again until the receiver
+// acknowledges it: an ACK is a frame with protocol.FlagACK whose ID is the ID of the frame it
+// acknowledges. Receivers acknowledge a frame once the application has processed it and drop
+// redeliveries of frames they already acknowledged, so each frame is processed once as long
+// as the receiver remembers its ID (see Config.DedupWindow).
+//
+// Acknowledgments are hop by hop: through a hub with delivery enabled, the hub acknowledges a
+// client's frame once its handler succeeds and takes over redelivering it to the receiver. A
+// frame the hub rejects is answered with an error frame, which Send returns as RejectedError.
+package reliable
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Configuration
+// =============================================================================
+
+const (
+	DefaultAckTimeout  = 5 * time.Second // Default wait for an ACK before redelivering
+	DefaultDedupWindow = 1024            // Default number of acknowledged IDs remembered
+	DefaultMaxAttempts = 10              // Default writes of a frame before a hub drops it
+	DefaultMaxUnacked  = 1024            // Default unacknowledged frames a hub keeps per client address
+)
+
+var (
+	// ErrUnacknowledged is returned by Send when the frame was written Config.MaxAttempts times
+	// without being acknowledged.
+	ErrUnacknowledged = errors.New("reliable: frame not acknowledged")
+
+	// ErrPending is returned by Send when a frame with the same ID is already being sent.
+	ErrPending = errors.New("reliable: frame already pending")
+
+	// ErrClosed is returned by Send once the Endpoint is closed.
+	ErrClosed = errors.New("reliable: endpoint closed")
+)
+
+// RejectedError is returned by Send when the receiver answered the frame with an error frame
+// (protocol.FlagError, correlated to the frame ID) instead of an ACK. Use
+// sockethub.ErrorFromFrame to decode it.
+type RejectedError struct {
+	Header  *protocol.SocketHeader
+	Payload []byte
+}
+
+// Error implements error.
+func (e *RejectedError) Error() string {
+	return "reliable: frame rejected by the receiver"
+}
+
+// Config tunes an Endpoint.
+type Config struct {
+	AckTimeout  time.Duration // Wait for an ACK before writing the frame again (zero for DefaultAckTimeout)
+	MaxAttempts int           // Writes of a frame before Send gives up (zero for no limit)
+	DedupWindow int           // Acknowledged frame IDs remembered to drop redeliveries (zero for DefaultDedupWindow)
+}
+
+// AckFrame builds the acknowledgment of the frame with the given ID, addressed to its sender.
+func AckFrame(id, to uuid.UUID) *protocol.SocketHeader {
+	return &protocol.SocketHeader{ID: id, Receiver: to, MessageType: protocol.MessageTypeData, Flags: protocol.FlagACK}
+}
+
+// IsAck reports whether a frame acknowledges another.
+func IsAck(h *protocol.SocketHeader) bool {
+	return protocol.HasFlag(h.Flags, protocol.FlagACK)
+}
+
+// IsReliable reports whether a frame must be acknowledged.
+func IsReliable(h *protocol.SocketHeader) bool {
+	return protocol.HasFlag(h.Flags, protocol.FlagReliable) && !IsAck(h)
+}
+
+// =============================================================================
+// Endpoint
+// =============================================================================
+
+// Endpoint sends and receives reliable frames over a Conn. The application passes every frame
+// it reads to HandleFrame, and calls Ack for each reliable frame once it has processed it.
+type Endpoint struct {
+	cfg         Config
+	mu          sync.Mutex
+	conn        protocol.Conn
+	pending     map[uuid.UUID]chan error // Frames being sent, by ID; receives the outcome
+	seen        *Window                  // IDs of acknowledged frames
+	reconnected chan struct{}            // Closed and replaced by Reconnect
+	closeOnce   sync.Once
+	closed      chan struct{}
+}
+
+// NewEndpoint returns an Endpoint that writes to conn.
+func NewEndpoint(conn protocol.Conn, cfg Config) *Endpoint {
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = DefaultAckTimeout
+	}
+	if cfg.DedupWindow <= 0 {
+		cfg.DedupWindow = DefaultDedupWindow
+	}
+	return &Endpoint{
+		cfg:         cfg,
+		conn:        conn,
+		pending:     make(map[uuid.UUID]chan error),
+		seen:        NewWindow(cfg.DedupWindow),
+		reconnected: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+}
+
+// Close stops every Send in progress.
+func (e *Endpoint) Close() error {
+	e.closeOnce.Do(func() { close(e.closed) })
+	return nil
+}
+
+// Reconnect switches the Endpoint to a new connection, such as after the old one failed, and
+// writes every unacknowledged frame to it at once.
+func (e *Endpoint) Reconnect(conn protocol.Conn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.conn = conn
+	close(e.reconnected)
+	e.reconnected = make(chan struct{})
+}
+
+// Pending returns the number of frames waiting for an acknowledgment.
+func (e *Endpoint) Pending() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.pending)
+}
+
+// Send writes a frame with FlagReliable set and waits until the receiver acknowledges it,
+// writing it again after every AckTimeout and on Reconnect. A frame without an ID is given
+// one. Write errors are not returned: the frame is retried until ctx is done, the Endpoint is
+// closed, or MaxAttempts is reached.
+func (e *Endpoint) Send(ctx context.Context, header *protocol.SocketHeader, payload []byte) error {
+	h := header.Clone()
+	h.Flags = protocol.SetFlag(protocol.ClearFlag(h.Flags, protocol.FlagACK), protocol.FlagReliable)
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+
+	done := make(chan error, 1)
+	e.mu.Lock()
+	if _, ok := e.pending[h.ID]; ok {
+		e.mu.Unlock()
+		return ErrPending
+	}
+	e.pending[h.ID] = done
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, h.ID)
+		e.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(e.cfg.AckTimeout)
+	defer timer.Stop()
+	for attempts := 0; ; {
+		if e.cfg.MaxAttempts > 0 && attempts == e.cfg.MaxAttempts {
+			return ErrUnacknowledged
+		}
+		attempts++
+		e.mu.Lock()
+		conn, reconnected := e.conn, e.reconnected
+		e.mu.Unlock()
+		conn.WriteFrameContext(ctx, h, payload) // Lost writes are covered by the retries
+
+		timer.Reset(e.cfg.AckTimeout)
+		select {
+		case err := <-done:
+			return err
+		case <-timer.C:
+		case <-reconnected:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.closed:
+			return ErrClosed
+		}
+	}
+}
+
+// HandleFrame processes a frame read from the Conn. It returns false, leaving the frame to the
+// caller, unless the frame is an ACK, an error answering a frame being sent, or a redelivery
+// of a frame that was already acknowledged (which is acknowledged again and dropped).
+func (e *Endpoint) HandleFrame(header *protocol.SocketHeader, payload []byte) bool {
+	switch {
+	case IsAck(header):
+		e.settle(header.ID, nil)
+		return true
+	case protocol.HasFlag(header.Flags, protocol.FlagError):
+		id, ok := header.CorrelationID()
+		return ok && e.settle(id, &RejectedError{Header: header, Payload: payload})
+	case IsReliable(header):
+		e.mu.Lock()
+		dup := e.seen.Contains(header.ID)
+		e.mu.Unlock()
+		if dup {
+			e.writeAck(header)
+		}
+		return dup
+	}
+	return false
+}
+
+// Ack acknowledges a reliable frame the application has processed. Frames without
+// FlagReliable are ignored.
+func (e *Endpoint) Ack(header *protocol.SocketHeader) error {
+	if !IsReliable(header) {
+		return nil
+	}
+	e.mu.Lock()
+	e.seen.Add(header.ID)
+	e.mu.Unlock()
+	return e.writeAck(header)
+}
+
+// writeAck acknowledges a frame to its sender.
+func (e *Endpoint) writeAck(header *protocol.SocketHeader) error {
+	e.mu.Lock()
+	conn := e.conn
+	e.mu.Unlock()
+	return conn.WriteFrame(AckFrame(header.ID, header.Sender), nil)
+}
+
+// settle completes the Send of the frame with the given ID, reporting whether there was one.
+func (e *Endpoint) settle(id uuid.UUID, err error) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	done, ok := e.pending[id]
+	if ok {
+		select {
+		case done <- err:
+		default: // Already settled
+		}
+	}
+	return ok
+}
+
+// =============================================================================
+// Deduplication
+// =============================================================================
+
+// Window remembers the most recent frame IDs, up to a fixed number, to recognize
+// redeliveries. It is not safe for concurrent use.
+type Window struct {
+	ids  []uuid.UUID // Ring buffer, oldest at next once full
+	next int
+	set  map[uuid.UUID]struct{}
+}
+
+// NewWindow returns a Window that remembers size IDs.
+func NewWindow(size int) *Window {
+	return &Window{ids: make([]uuid.UUID, 0, size), set: make(map[uuid.UUID]struct{}, size)}
+}
+
+// Contains reports whether id is remembered.
+func (w *Window) Contains(id uuid.UUID) bool {
+	_, ok := w.set[id]
+	return ok
+}
+
+// Add remembers id, forgetting the oldest ID once the window is full.
+func (w *Window) Add(id uuid.UUID) {
+	if w.Contains(id) || cap(w.ids) == 0 {
+		return
+	}
+	if len(w.ids) < cap(w.ids) {
+		w.ids = append(w.ids, id)
+	} else {
+		delete(w.set, w.ids[w.next])
+		w.ids[w.next] = id
+		w.next = (w.next + 1) % len(w.ids)
+	}
+	w.set[id] = struct{}{}
+}
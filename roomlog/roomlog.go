@@ -0,0 +1,561 @@
+// Package roomlog provides a durable, append-only log of the frames broadcast to each room,
+// and replay of that history so a client that joins late (or reconnects) can catch up.
+//
+// Every room has its own directory of segment files under the log directory:
+//
+//	<dir>/<room UUID>/<sequence of the first frame, 20 digits>.log
+//
+// A segment is a plain concatenation of encoded frames (see protocol.EncodeFrameTo), so each
+// record carries its own length and payload checksum. Appends go to the newest segment until it
+// reaches Config.SegmentSize; retention then removes whole segments from the front. A torn frame
+// at the end of the newest segment, left by a crash during Append, is cut off when the room is
+// first used.
+//
+// Rooms are locked separately, so a slow replay only holds up its own room. A background sweep
+// closes the segment files of rooms that have gone idle, and removes rooms whose every frame is
+// older than Config.MaxAge, so quiet rooms neither keep a file open nor outlive their retention.
+package roomlog
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSegmentSize = 8 << 20     // Segment size used when Config.SegmentSize is zero (8MB)
+	DefaultIdleTimeout = time.Minute // Idle time used when Config.IdleTimeout is zero
+)
+
+// segmentSuffix names segment files.
+const segmentSuffix = ".log"
+
+var (
+	// ErrClosed is returned by operations on a closed Log.
+	ErrClosed = errors.New("roomlog: log is closed")
+	// ErrStopReplay may be returned by a replay callback to end the replay early without an error.
+	ErrStopReplay = errors.New("roomlog: replay stopped")
+)
+
+// Config describes where a Log keeps its segments and how long it keeps them.
+type Config struct {
+	Dir         string        // Directory holding one subdirectory per room (created if needed)
+	SegmentSize int64         // Bytes per segment before a new one is started (zero for DefaultSegmentSize)
+	MaxAge      time.Duration // Segments whose newest frame is older are removed (zero to keep them)
+	MaxBytes    int64         // Size per room above which the oldest segments are removed (zero for no limit)
+	IdleTimeout time.Duration // Rooms unused for this long have their segment file closed (zero for DefaultIdleTimeout)
+	Sync        bool          // Sync the segment after every append
+}
+
+// Validate checks if the room log configuration is valid
+func (c *Config) Validate() error {
+	if c.Dir == "" {
+		return fmt.Errorf("dir is required")
+	}
+	if c.SegmentSize < 0 || c.MaxAge < 0 || c.MaxBytes < 0 || c.IdleTimeout < 0 {
+		return fmt.Errorf("segmentSize, maxAge, maxBytes and idleTimeout must not be negative")
+	}
+	return nil
+}
+
+// Position selects where a replay starts.
+type Position struct {
+	Since time.Time // Replay frames with a Timestamp at or after Since (zero for all retained frames)
+	After uuid.UUID // Replay frames after the one with this ID (takes precedence over Since)
+}
+
+// Log is an on-disk log of room broadcasts. It is safe for concurrent use.
+type Log struct {
+	cfg    Config
+	mu     sync.Mutex             // Guards rooms and closed; never held while waiting for a room
+	rooms  map[uuid.UUID]*roomLog // Loaded lazily from the room directories, dropped once idle
+	closed bool
+	stop   chan struct{} // Closed by Close to end the sweep
+}
+
+// roomLog is the in-memory index of one room's segments.
+type roomLog struct {
+	mu       sync.Mutex // Serializes the room's appends and replays (guards the fields below)
+	dir      string
+	segments []*segment // Oldest first; the last one receives appends
+	active   *os.File   // Open handle on the last segment (nil until the first append)
+	used     time.Time  // Last Append or Replay
+	unloaded bool       // Dropped from Log.rooms; the room must be looked up again
+}
+
+// segment describes one segment file.
+type segment struct {
+	path   string
+	first  uint64    // Sequence number of the first frame
+	count  uint64    // Frames in the segment
+	size   int64     // Bytes in the segment
+	newest time.Time // Latest frame Timestamp
+}
+
+// next returns the sequence number the frame after the segment gets.
+func (s *segment) next() uint64 {
+	return s.first + s.count
+}
+
+// Open opens the log described by cfg. Rooms logged by a previous run are kept.
+func Open(cfg Config) (*Log, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("roomlog: invalid config: %w", err)
+	}
+	if cfg.SegmentSize == 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("roomlog: %w", err)
+	}
+	l := &Log{cfg: cfg, rooms: make(map[uuid.UUID]*roomLog), stop: make(chan struct{})}
+	interval := cfg.IdleTimeout
+	if cfg.MaxAge > 0 {
+		interval = min(interval, cfg.MaxAge)
+	}
+	go l.sweeper(interval)
+	return l, nil
+}
+
+// Append adds a frame to the end of a room's log. The header is stored as given, so it should
+// already carry the ID and Timestamp that replays match Position against.
+func (l *Log) Append(room uuid.UUID, header *protocol.SocketHeader, payload []byte) error {
+	h := *header
+	buf := make([]byte, protocol.FrameSize(&h, len(payload)))
+	if _, err := protocol.EncodeFrameTo(buf, &h, payload); err != nil {
+		return fmt.Errorf("roomlog: %w", err)
+	}
+
+	r, err := l.lockRoom(room)
+	if err != nil {
+		return err
+	}
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.used = now
+	last := r.segments[len(r.segments)-1]
+	if last.count > 0 && last.size+int64(len(buf)) > l.cfg.SegmentSize {
+		if err := r.roll(); err != nil {
+			return err
+		}
+		last = r.segments[len(r.segments)-1]
+	}
+	if r.active == nil {
+		if err := os.MkdirAll(r.dir, 0o700); err != nil {
+			return fmt.Errorf("roomlog: %w", err)
+		}
+		f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("roomlog: %w", err)
+		}
+		r.active = f
+	}
+
+	_, err = r.active.Write(buf)
+	if err == nil && l.cfg.Sync {
+		err = r.active.Sync()
+	}
+	if err != nil {
+		// Reload from disk next time, cutting off whatever part of the frame was written
+		r.close()
+		l.unload(room, r)
+		return fmt.Errorf("roomlog: %w", err)
+	}
+	last.count++
+	last.size += int64(len(buf))
+	if ts := time.UnixMilli(int64(h.Timestamp)); ts.After(last.newest) {
+		last.newest = ts
+	}
+	return l.retain(r, now, false)
+}
+
+// Replay calls fn for every retained frame of a room from the given position, oldest first, and
+// returns the number of frames replayed. If from.After names a frame that is no longer retained,
+// every retained frame is replayed. Replay stops at the first error fn returns, which is passed
+// on unless it is ErrStopReplay. The room is locked during the replay, so fn must not append to
+// or replay it; other rooms are not held up.
+func (l *Log) Replay(room uuid.UUID, from Position, fn func(header *protocol.SocketHeader, payload []byte) error) (int, error) {
+	r, err := l.lockRoom(room)
+	if err != nil {
+		return 0, err
+	}
+	defer r.mu.Unlock()
+
+	r.used = time.Now()
+	if err := l.retain(r, r.used, false); err != nil {
+		return 0, err
+	}
+
+	segments := r.segments
+	var skip uint64 // Frames of segments[0] to pass over
+	if from.After != uuid.Nil {
+		if i, n, ok := r.find(from.After); ok {
+			segments, skip = segments[i:], n+1
+		}
+	} else if !from.Since.IsZero() {
+		for len(segments) > 1 && segments[0].newest.Before(from.Since) {
+			segments = segments[1:]
+		}
+	}
+	since := uint64(0)
+	if from.After == uuid.Nil && !from.Since.IsZero() {
+		since = uint64(from.Since.UnixMilli())
+	}
+
+	replayed := 0
+	for _, s := range segments {
+		var ferr error
+		var index uint64
+		err := s.scan(func(header *protocol.SocketHeader, payload []byte) bool {
+			index++
+			if index <= skip || header.Timestamp < since {
+				return true
+			}
+			if ferr = fn(header, payload); ferr != nil {
+				return false
+			}
+			replayed++
+			return true
+		})
+		skip = 0
+		if ferr != nil {
+			if errors.Is(ferr, ErrStopReplay) {
+				return replayed, nil
+			}
+			return replayed, ferr
+		}
+		if err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// Close stops the sweep and closes the open segment files. Further calls return ErrClosed.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	rooms := l.rooms
+	if !l.closed {
+		close(l.stop)
+	}
+	l.rooms = nil
+	l.closed = true
+	l.mu.Unlock()
+
+	var errs []error
+	for _, r := range rooms {
+		r.mu.Lock()
+		r.unloaded = true
+		errs = append(errs, r.close())
+		r.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// lockRoom returns the index of a room with its lock held, loading it the first time.
+func (l *Log) lockRoom(id uuid.UUID) (*roomLog, error) {
+	for {
+		l.mu.Lock()
+		r, err := l.room(id)
+		l.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		if !r.unloaded {
+			return r, nil
+		}
+		r.mu.Unlock() // Dropped while we waited
+	}
+}
+
+// unload drops the index of a room, which is locked, so the next use reloads it from disk.
+func (l *Log) unload(id uuid.UUID, r *roomLog) {
+	r.unloaded = true
+	l.mu.Lock()
+	if l.rooms[id] == r {
+		delete(l.rooms, id)
+	}
+	l.mu.Unlock()
+}
+
+// room returns the index of a room, loading it the first time. l.mu must be held.
+func (l *Log) room(id uuid.UUID) (*roomLog, error) {
+	if l.closed {
+		return nil, ErrClosed
+	}
+	if r, ok := l.rooms[id]; ok {
+		return r, nil
+	}
+	r, err := loadRoom(filepath.Join(l.cfg.Dir, id.String()))
+	if err != nil {
+		return nil, err
+	}
+	l.rooms[id] = r
+	return r, nil
+}
+
+// retain removes the segments that fall outside the retention limits. The newest segment is
+// kept unless all is set, which only the sweep does for rooms nobody uses. r.mu must be held.
+func (l *Log) retain(r *roomLog, now time.Time, all bool) error {
+	var total int64
+	for _, s := range r.segments {
+		total += s.size
+	}
+	keep := 1
+	if all {
+		keep = 0
+	}
+	for len(r.segments) > keep {
+		s := r.segments[0]
+		expired := l.cfg.MaxAge > 0 && now.Sub(s.newest) > l.cfg.MaxAge
+		oversize := l.cfg.MaxBytes > 0 && total > l.cfg.MaxBytes
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("roomlog: %w", err)
+		}
+		total -= s.size
+		r.segments = r.segments[1:]
+	}
+	return nil
+}
+
+// =============================================================================
+// Sweep
+// =============================================================================
+
+// sweeper runs sweep every interval until the log is closed.
+func (l *Log) sweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.sweep(now)
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+// sweep closes and drops the rooms unused for IdleTimeout, then removes the expired segments of
+// rooms that are not loaded. Rooms busy with an append or a replay are left for the next sweep.
+func (l *Log) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	for id, r := range l.rooms {
+		if !r.mu.TryLock() {
+			continue
+		}
+		if now.Sub(r.used) >= l.cfg.IdleTimeout {
+			r.close()
+			r.unloaded = true
+			delete(l.rooms, id)
+		}
+		r.mu.Unlock()
+	}
+	if l.cfg.MaxAge == 0 {
+		return
+	}
+
+	names, err := os.ReadDir(l.cfg.Dir)
+	if err != nil {
+		return
+	}
+	for _, name := range names {
+		id, err := uuid.Parse(name.Name())
+		if err != nil || !name.IsDir() {
+			continue
+		}
+		if _, ok := l.rooms[id]; !ok {
+			l.expire(filepath.Join(l.cfg.Dir, name.Name()), now)
+		}
+	}
+}
+
+// expire removes the expired segments of a room that is not loaded, and the room directory once
+// it is empty. Only rooms whose newest segment was last written more than MaxAge ago are read,
+// as a room written since holds frames that may be retained. l.mu must be held.
+func (l *Log) expire(dir string, now time.Time) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	info, err := os.Stat(slices.Max(names)) // Names sort in sequence order
+	if err != nil {
+		return fmt.Errorf("roomlog: %w", err)
+	}
+	if now.Sub(info.ModTime()) <= l.cfg.MaxAge {
+		return nil
+	}
+
+	r, err := loadRoom(dir)
+	if err != nil {
+		return err
+	}
+	if err := l.retain(r, now, true); err != nil {
+		return err
+	}
+	if len(r.segments) == 0 {
+		if err := os.Remove(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("roomlog: %w", err)
+		}
+	}
+	return nil
+}
+
+// =============================================================================
+// Segments
+// =============================================================================
+
+// loadRoom indexes the segments of a room directory. The directory is created by the first append.
+func loadRoom(dir string) (*roomLog, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("roomlog: %w", err)
+	}
+
+	r := &roomLog{dir: dir}
+	for _, name := range names {
+		base, ok := strings.CutSuffix(name.Name(), segmentSuffix)
+		first, err := strconv.ParseUint(base, 10, 64)
+		if !ok || err != nil {
+			continue
+		}
+		r.segments = append(r.segments, &segment{path: filepath.Join(dir, name.Name()), first: first})
+	}
+	slices.SortFunc(r.segments, func(a, b *segment) int {
+		return cmp.Compare(a.first, b.first)
+	})
+	for i, s := range r.segments {
+		if err := s.index(i == len(r.segments)-1); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.segments) == 0 {
+		r.segments = []*segment{r.newSegment(0)}
+	}
+	return r, nil
+}
+
+// newSegment describes an empty segment whose first frame gets sequence number first.
+func (r *roomLog) newSegment(first uint64) *segment {
+	return &segment{path: filepath.Join(r.dir, fmt.Sprintf("%020d%s", first, segmentSuffix)), first: first}
+}
+
+// roll closes the active segment and starts a new one.
+func (r *roomLog) roll() error {
+	if err := r.close(); err != nil {
+		return err
+	}
+	r.segments = append(r.segments, r.newSegment(r.segments[len(r.segments)-1].next()))
+	return nil
+}
+
+// close closes the active segment file, if open.
+func (r *roomLog) close() error {
+	if r.active == nil {
+		return nil
+	}
+	err := r.active.Close()
+	r.active = nil
+	if err != nil {
+		return fmt.Errorf("roomlog: %w", err)
+	}
+	return nil
+}
+
+// find returns the segment holding the frame with the given ID and the frame's index within it.
+func (r *roomLog) find(id uuid.UUID) (int, uint64, bool) {
+	for i := len(r.segments) - 1; i >= 0; i-- {
+		var index uint64
+		found := false
+		r.segments[i].scan(func(header *protocol.SocketHeader, _ []byte) bool {
+			if header.ID == id {
+				found = true
+				return false
+			}
+			index++
+			return true
+		})
+		if found {
+			return i, index, true
+		}
+	}
+	return 0, 0, false
+}
+
+// index counts the frames of a segment. A segment that ends in a torn frame is truncated after
+// its last intact frame if it is the newest; in older segments the remainder is just skipped.
+func (s *segment) index(newest bool) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("roomlog: %w", err)
+	}
+	offset := 0
+	for offset < len(data) {
+		var header protocol.SocketHeader
+		_, n, err := protocol.DecodeFrameInto(&header, data[offset:], protocol.ValidationOptions{Disabled: true})
+		if err != nil {
+			break
+		}
+		s.count++
+		if ts := time.UnixMilli(int64(header.Timestamp)); ts.After(s.newest) {
+			s.newest = ts
+		}
+		offset += n
+	}
+	if offset < len(data) && newest {
+		if err := os.Truncate(s.path, int64(offset)); err != nil {
+			return fmt.Errorf("roomlog: %w", err)
+		}
+	}
+	s.size = int64(offset)
+	return nil
+}
+
+// scan calls fn for each frame of the segment until it returns false. Every frame gets its own
+// header; payloads alias a buffer that is not reused, so fn may keep them.
+func (s *segment) scan(fn func(header *protocol.SocketHeader, payload []byte) bool) error {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("roomlog: %w", err)
+	}
+	data = data[:min(int64(len(data)), s.size)] // Only frames the index knows of
+	for offset := 0; offset < len(data); {
+		header := new(protocol.SocketHeader)
+		payload, n, err := protocol.DecodeFrameInto(header, data[offset:], protocol.ValidationOptions{Disabled: true})
+		if err != nil {
+			return fmt.Errorf("roomlog: %s: %w", filepath.Base(s.path), err)
+		}
+		if !fn(header, payload) {
+			return nil
+		}
+		offset += n
+	}
+	return nil
+}
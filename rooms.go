@@ -0,0 +1,139 @@
+// Package sockethub provides rooms: named groups of clients that share broadcasts.
+// A room is addressed on the wire by RoomID(name) in the Receiver field of a broadcast frame.
+package sockethub
+
+import (
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// roomNamespace derives room IDs from room names (UUIDv5), so every client computes the same ID.
+var roomNamespace = uuid.MustParse("5c0e4b1a-3d2f-5e8a-9b7c-1f6d2a4e8c30")
+
+// RoomID returns the Receiver value that addresses the room with the given name.
+func RoomID(name string) uuid.UUID {
+	return uuid.NewSHA1(roomNamespace, []byte(name))
+}
+
+// room is a set of clients; empty rooms are removed.
+type room struct {
+	name    string
+	members map[uuid.UUID]*Client
+}
+
+// Join adds a client, given by ID or bound address, to the named room, creating it if needed.
+func (h *SocketHub) Join(clientID uuid.UUID, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c, ok := h.clientLocked(clientID)
+	if !ok {
+		return ErrUnknownReceiver
+	}
+	id := RoomID(name)
+	r, ok := h.rooms[id]
+	if !ok {
+		r = &room{name: name, members: make(map[uuid.UUID]*Client)}
+		h.rooms[id] = r
+	}
+	r.members[c.ID] = c
+	c.rooms[id] = struct{}{}
+	return nil
+}
+
+// Leave removes a client from the named room.
+func (h *SocketHub) Leave(clientID uuid.UUID, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if c, ok := h.clientLocked(clientID); ok {
+		h.leaveLocked(c, RoomID(name))
+	}
+}
+
+// leaveLocked removes c from a room and drops the room once empty. h.mu must be held.
+func (h *SocketHub) leaveLocked(c *Client, id uuid.UUID) {
+	delete(c.rooms, id)
+	if r, ok := h.rooms[id]; ok {
+		delete(r.members, c.ID)
+		if len(r.members) == 0 {
+			delete(h.rooms, id)
+		}
+	}
+}
+
+// RoomMembers returns the IDs of the clients in the named room.
+func (h *SocketHub) RoomMembers(name string) []uuid.UUID {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	r, ok := h.rooms[RoomID(name)]
+	if !ok {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(r.members))
+	for id := range r.members {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// BroadcastRoom queues a frame for every member of the named room except the one with ID except.
+func (h *SocketHub) BroadcastRoom(name string, header *protocol.SocketHeader, payload []byte, except uuid.UUID) error {
+	return h.broadcastRoom(RoomID(name), header, payload, except)
+}
+
+// broadcastRoom sends to the members of the room with the given ID, under the room's
+// slow-consumer policy if it has one. With a room log configured the frame is logged first.
+func (h *SocketHub) broadcastRoom(id uuid.UUID, header *protocol.SocketHeader, payload []byte, except uuid.UUID) error {
+	var unlock func()
+	if h.config.RoomLog != nil {
+		// Logging and listing the members under the room's log lock hands a client joining
+		// with history each frame exactly once: from the log if it was appended first, live
+		// otherwise
+		unlock = h.roomLogMu.lock(id)
+		header = h.logHeader(header)
+	}
+	members, bp, ok := h.roomMembers(id, except)
+	if h.config.RoomLog != nil {
+		if ok {
+			if err := h.config.RoomLog.Append(id, header, payload); err != nil {
+				h.log(socketlog.ERROR, "Logging frame %s for room %s failed: %v", header.ID, id, err)
+			}
+		}
+		unlock()
+	}
+	if !ok {
+		return ErrUnknownReceiver
+	}
+
+	for _, c := range members {
+		c.sendWith(&bp, header, payload)
+	}
+	return nil
+}
+
+// roomMembers returns the members of a room except the one with ID except, and the room's
+// slow-consumer policy.
+func (h *SocketHub) roomMembers(id uuid.UUID, except uuid.UUID) ([]*Client, sockethub_config.BackpressureConfig, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	bp := h.config.Backpressure
+	r, ok := h.rooms[id]
+	if !ok {
+		return nil, bp, false
+	}
+	if override, ok := h.roomBackpressure[id]; ok {
+		bp = override
+	}
+	members := make([]*Client, 0, len(r.members))
+	for _, c := range r.members {
+		if c.ID != except {
+			members = append(members, c)
+		}
+	}
+	return members, bp, true
+}
@@ -0,0 +1,149 @@
+// Package schema provides the Go code generator for schema files.
+// For every message it emits a struct, a Router constant, an Encode method, a checked Decode
+// function and a typed Handle registration; the schema itself is embedded so the hub can check
+// payloads at run time.
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+)
+
+// goTypes maps field types to the Go types of generated struct fields.
+var goTypes = map[Type]string{
+	TypeBool:   "bool",
+	TypeInt:    "int64",
+	TypeUint:   "uint64",
+	TypeFloat:  "float64",
+	TypeString: "string",
+	TypeBytes:  "[]byte",
+	TypeUUID:   "uuid.UUID",
+	TypeTime:   "time.Time",
+}
+
+// Generate returns the formatted Go source for a schema file. src is the schema text to embed
+// and name the file it was read from, as mentioned in the generated header.
+func Generate(f *File, src []byte, name string) ([]byte, error) {
+	var usesTime, usesUUID bool
+	for _, m := range f.Messages {
+		for _, field := range m.Fields {
+			usesTime = usesTime || field.Type == TypeTime
+			usesUUID = usesUUID || field.Type == TypeUUID
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by sockethub gen from %s. DO NOT EDIT.\n\n", name)
+	fmt.Fprintf(&b, "// Package %s provides the messages declared in %s.\n", f.Package, name)
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", f.Package)
+	if usesTime {
+		b.WriteString("\t\"time\"\n\n")
+	}
+	b.WriteString("\t\"github.com/Jdcabreradev/sockethub\"\n")
+	b.WriteString("\t\"github.com/Jdcabreradev/sockethub/codec\"\n")
+	b.WriteString("\t\"github.com/Jdcabreradev/sockethub/protocol\"\n")
+	b.WriteString("\t\"github.com/Jdcabreradev/sockethub/schema\"\n")
+	if usesUUID {
+		b.WriteString("\t\"github.com/google/uuid\"\n")
+	}
+	b.WriteString(")\n\n")
+
+	fmt.Fprintf(&b, "// Schema is the schema the messages were generated from.\nvar Schema = schema.MustParse(%s)\n\n", quote(src))
+
+	if len(f.Messages) > 0 {
+		b.WriteString("// Router IDs of the messages.\nconst (\n")
+		for _, m := range f.Messages {
+			fmt.Fprintf(&b, "\t%sRouter uint8 = %d\n", m.Name, m.Router)
+		}
+		b.WriteString(")\n\n")
+	}
+
+	for _, m := range f.Messages {
+		generateMessage(&b, m)
+	}
+
+	b.WriteString(`// RegisterSchemas makes hub check every message of the schema on its router, including
+// messages that are only relayed by Route.
+func RegisterSchemas(hub *sockethub.SocketHub) {
+	for _, m := range Schema.Messages {
+		hub.SetSchema(m.Router, m)
+	}
+}
+`)
+
+	out, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("schema: formatting generated code: %w", err)
+	}
+	return out, nil
+}
+
+// generateMessage writes the declarations of one message.
+func generateMessage(b *bytes.Buffer, m *Message) {
+	if m.Doc != "" {
+		for line := range strings.SplitSeq(m.Doc, "\n") {
+			fmt.Fprintf(b, "// %s\n", line)
+		}
+	} else {
+		fmt.Fprintf(b, "// %s is the payload of router %d.\n", m.Name, m.Router)
+	}
+	fmt.Fprintf(b, "type %s struct {\n", m.Name)
+	for _, f := range m.Fields {
+		typ := goTypes[f.Type]
+		if f.List {
+			typ = "[]" + typ
+		}
+		tag := f.Name
+		if f.Optional {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(b, "\t%s %s `json:%q msgpack:%q`\n", GoName(f.Name), typ, tag, tag)
+	}
+	b.WriteString("}\n\n")
+
+	fmt.Fprintf(b, `// Encode marshals m with c and returns a data frame header for %[1]sRouter and the payload.
+func (m *%[1]s) Encode(c codec.Codec) (*protocol.SocketHeader, []byte, error) {
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: %[1]sRouter}
+	payload, err := codec.Encode(header, c, m)
+	return header, payload, err
+}
+
+// Decode%[1]s checks a payload against the %[1]s schema and decodes it.
+func Decode%[1]s(header *protocol.SocketHeader, payload []byte) (*%[1]s, error) {
+	if err := Schema.Message(%[2]q).Validate(header, payload); err != nil {
+		return nil, err
+	}
+	m, err := codec.Decode[%[1]s](header, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Handle%[1]s registers fn for %[1]s frames. The hub answers frames that do not match the
+// schema with an ErrInvalidPayload error frame, so they never reach fn.
+func Handle%[1]s(hub *sockethub.SocketHub, fn func(c *sockethub.Client, header *protocol.SocketHeader, m *%[1]s)) {
+	hub.SetSchema(%[1]sRouter, Schema.Message(%[2]q))
+	hub.HandleReliable(%[1]sRouter, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) error {
+		m, err := codec.Decode[%[1]s](header, payload)
+		if err != nil {
+			return sockethub.ErrInvalidPayload.WithDetails([]byte(err.Error()))
+		}
+		fn(c, header, &m)
+		return nil
+	})
+}
+
+`, m.Name, m.Name)
+}
+
+// quote returns src as a Go string literal, raw when possible.
+func quote(src []byte) string {
+	if bytes.ContainsAny(src, "`\r") {
+		return strconv.Quote(string(src))
+	}
+	return "`" + string(src) + "`"
+}
@@ -0,0 +1,313 @@
+// Package schema provides message definitions checked per Router ID.
+// A schema file declares one message type per router, one field per line:
+//
+//	package chat
+//
+//	// Sent to join a room
+//	message Join router 1 {
+//		room   string max 64
+//		name   string
+//		avatar bytes optional
+//		tags   []string max 8
+//	}
+//
+// Field types are bool, int, uint, float, string, bytes, uuid and time, optionally as a list
+// ([]T). Modifiers: optional (the field may be absent or null) and max N (a length limit in
+// bytes for string and bytes, in elements for lists). Generate turns a file into Go structs and
+// typed handlers, and SocketHub.SetSchema rejects payloads that do not match their route.
+package schema
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Type is the type of a message field.
+type Type uint8
+
+const (
+	TypeInvalid Type = iota // Reserved
+	TypeBool                // bool
+	TypeInt                 // int64
+	TypeUint                // uint64
+	TypeFloat               // float64
+	TypeString              // UTF-8 string
+	TypeBytes               // []byte
+	TypeUUID                // uuid.UUID
+	TypeTime                // time.Time
+)
+
+// typeNames maps schema type names to types.
+var typeNames = map[string]Type{
+	"bool":   TypeBool,
+	"int":    TypeInt,
+	"uint":   TypeUint,
+	"float":  TypeFloat,
+	"string": TypeString,
+	"bytes":  TypeBytes,
+	"uuid":   TypeUUID,
+	"time":   TypeTime,
+}
+
+// String returns the schema name of the Type.
+func (t Type) String() string {
+	for name, typ := range typeNames {
+		if typ == t {
+			return name
+		}
+	}
+	return fmt.Sprintf("Type(%d)", uint8(t))
+}
+
+// Field is a field of a message.
+type Field struct {
+	Name     string // Wire name, as written in the schema
+	Type     Type
+	List     bool // The field is a list of Type
+	Optional bool // The field may be absent or null
+	Max      int  // Length limit (0 for none): bytes for string and bytes, elements for lists
+}
+
+// Message is a message type bound to a router.
+type Message struct {
+	Name   string // Go type name
+	Router uint8  // Router ID the message is sent on
+	Doc    string // Comment lines preceding the declaration
+	Fields []Field
+}
+
+// File is a parsed schema file.
+type File struct {
+	Package  string // Go package of the generated code
+	Messages []*Message
+}
+
+// ErrSyntax is returned for schema files that cannot be parsed.
+var ErrSyntax = errors.New("schema: syntax error")
+
+// Message returns the message with the given name, or nil.
+func (f *File) Message(name string) *Message {
+	for _, m := range f.Messages {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Parse parses a schema file.
+func Parse(src []byte) (*File, error) {
+	p := &parser{scanner: bufio.NewScanner(bytes.NewReader(src))}
+	return p.file()
+}
+
+// MustParse is like Parse but panics on error. Generated code uses it for the embedded schema.
+func MustParse(src string) *File {
+	f, err := Parse([]byte(src))
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// =============================================================================
+// Parser
+// =============================================================================
+
+// parser reads a schema file line by line.
+type parser struct {
+	scanner *bufio.Scanner
+	line    int
+	doc     []string // Comment lines since the last declaration
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrSyntax, p.line, fmt.Sprintf(format, args...))
+}
+
+// next returns the fields of the next non-empty line, collecting comments as documentation.
+func (p *parser) next() ([]string, bool) {
+	for p.scanner.Scan() {
+		p.line++
+		line := strings.TrimSpace(p.scanner.Text())
+		if comment, ok := strings.CutPrefix(line, "//"); ok {
+			p.doc = append(p.doc, strings.TrimSpace(comment))
+			continue
+		}
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			return fields, true
+		}
+		p.doc = nil // A blank line detaches the comment above it
+	}
+	return nil, false
+}
+
+func (p *parser) file() (*File, error) {
+	f := &File{}
+	routers := make(map[uint8]string)
+	for {
+		tokens, ok := p.next()
+		if !ok {
+			break
+		}
+		switch tokens[0] {
+		case "package":
+			if f.Package != "" {
+				return nil, p.errorf("duplicate package clause")
+			}
+			if len(tokens) != 2 || !isIdent(tokens[1]) {
+				return nil, p.errorf("expected: package <name>")
+			}
+			f.Package = tokens[1]
+		case "message":
+			if f.Package == "" {
+				return nil, p.errorf("message declared before the package clause")
+			}
+			m, err := p.message(tokens)
+			if err != nil {
+				return nil, err
+			}
+			if f.Message(m.Name) != nil {
+				return nil, p.errorf("message %s redeclared", m.Name)
+			}
+			if other, ok := routers[m.Router]; ok {
+				return nil, p.errorf("router %d already used by %s", m.Router, other)
+			}
+			routers[m.Router] = m.Name
+			f.Messages = append(f.Messages, m)
+		default:
+			return nil, p.errorf("unexpected %q", tokens[0])
+		}
+		p.doc = nil
+	}
+	if err := p.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if f.Package == "" {
+		return nil, fmt.Errorf("%w: missing package clause", ErrSyntax)
+	}
+	return f, nil
+}
+
+// message parses "message Name router N {" and the field lines up to the closing brace.
+func (p *parser) message(tokens []string) (*Message, error) {
+	if len(tokens) != 5 || tokens[2] != "router" || tokens[4] != "{" {
+		return nil, p.errorf("expected: message <Name> router <id> {")
+	}
+	if !isIdent(tokens[1]) || !unicode.IsUpper(rune(tokens[1][0])) {
+		return nil, p.errorf("message name %q must be an exported identifier", tokens[1])
+	}
+	router, err := strconv.ParseUint(tokens[3], 10, 8)
+	if err != nil {
+		return nil, p.errorf("invalid router %q (0-255)", tokens[3])
+	}
+	m := &Message{Name: tokens[1], Router: uint8(router), Doc: strings.Join(p.doc, "\n")}
+
+	goNames := make(map[string]bool)
+	for {
+		p.doc = nil
+		tokens, ok := p.next()
+		if !ok {
+			return nil, p.errorf("message %s is not closed", m.Name)
+		}
+		if tokens[0] == "}" {
+			if len(tokens) != 1 {
+				return nil, p.errorf("unexpected %q after }", tokens[1])
+			}
+			return m, nil
+		}
+		field, err := p.field(tokens)
+		if err != nil {
+			return nil, err
+		}
+		if goNames[GoName(field.Name)] {
+			return nil, p.errorf("field %s redeclared in %s", field.Name, m.Name)
+		}
+		goNames[GoName(field.Name)] = true
+		m.Fields = append(m.Fields, field)
+	}
+}
+
+// field parses "name [[]]type [optional] [max N]".
+func (p *parser) field(tokens []string) (Field, error) {
+	if len(tokens) < 2 {
+		return Field{}, p.errorf("expected: <field> <type> [optional] [max N]")
+	}
+	f := Field{Name: tokens[0]}
+	if !isIdent(f.Name) {
+		return f, p.errorf("invalid field name %q", f.Name)
+	}
+	typeName, list := strings.CutPrefix(tokens[1], "[]")
+	typ, ok := typeNames[typeName]
+	if !ok {
+		return f, p.errorf("unknown type %q", tokens[1])
+	}
+	f.Type, f.List = typ, list
+
+	for i := 2; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "optional":
+			if f.Optional {
+				return f, p.errorf("duplicate modifier optional")
+			}
+			f.Optional = true
+		case "max":
+			if f.Max != 0 {
+				return f, p.errorf("duplicate modifier max")
+			}
+			if !f.List && f.Type != TypeString && f.Type != TypeBytes {
+				return f, p.errorf("max applies to string, bytes and lists, not %s", f.Type)
+			}
+			if i+1 == len(tokens) {
+				return f, p.errorf("max needs a length")
+			}
+			i++
+			n, err := strconv.Atoi(tokens[i])
+			if err != nil || n <= 0 {
+				return f, p.errorf("invalid max %q", tokens[i])
+			}
+			f.Max = n
+		default:
+			return f, p.errorf("unknown modifier %q", tokens[i])
+		}
+	}
+	return f, nil
+}
+
+// isIdent reports whether s is an ASCII identifier starting with a letter.
+func isIdent(s string) bool {
+	for i, r := range s {
+		letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+		if !letter && (i == 0 || r != '_' && (r < '0' || r > '9')) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// GoName returns the exported Go name of a schema field: "user_id" becomes "UserID".
+func GoName(name string) string {
+	var b strings.Builder
+	for part := range strings.SplitSeq(name, "_") {
+		if part == "" {
+			continue
+		}
+		if upper := strings.ToUpper(part); initialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// initialisms are written in upper case by GoName, as Go style asks.
+var initialisms = map[string]bool{"ID": true, "URL": true, "UUID": true, "IP": true, "TTL": true}
@@ -0,0 +1,202 @@
+// Package schema provides runtime checks of payloads against message definitions.
+// A payload is decoded into its generic form with the codec named by its ContentType, so only
+// self-describing codecs (JSON and MessagePack) can be checked; anything else is rejected.
+package schema
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Jdcabreradev/sockethub/codec"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// ErrMismatch is returned for payloads that do not match their message definition.
+var ErrMismatch = errors.New("schema: payload does not match schema")
+
+// Validate decodes a payload with the codec named by the header and checks it against m.
+func (m *Message) Validate(header *protocol.SocketHeader, payload []byte) error {
+	value, err := decodeGeneric(header, payload)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrMismatch, m.Name, err)
+	}
+	return m.Check(value)
+}
+
+// Check checks a decoded payload (a map[string]any) against m. Unknown fields are rejected.
+func (m *Message) Check(value any) error {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%w: %s: expected an object, got %T", ErrMismatch, m.Name, value)
+	}
+	for _, f := range m.Fields {
+		v, present := obj[f.Name]
+		if v == nil {
+			// Lists are encoded as null when empty, so only their absence is an error
+			if f.Optional || (f.List && present) {
+				continue
+			}
+			return fmt.Errorf("%w: %s.%s is required", ErrMismatch, m.Name, f.Name)
+		}
+		if err := f.check(v); err != nil {
+			return fmt.Errorf("%w: %s.%s %v", ErrMismatch, m.Name, f.Name, err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(obj)) {
+		if !slices.ContainsFunc(m.Fields, func(f Field) bool { return f.Name == name }) {
+			return fmt.Errorf("%w: %s has no field %q", ErrMismatch, m.Name, name)
+		}
+	}
+	return nil
+}
+
+// decodeGeneric decodes a payload into maps, slices and scalars. JSON numbers are kept as
+// json.Number so large integers are checked exactly.
+func decodeGeneric(header *protocol.SocketHeader, payload []byte) (any, error) {
+	c, err := codec.ForHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	if c != codec.JSON {
+		err := c.Unmarshal(payload, &value)
+		return value, err
+	}
+
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if err := d.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, errors.New("trailing data after the JSON value")
+	}
+	return value, nil
+}
+
+// check checks one non-null field value.
+func (f *Field) check(v any) error {
+	if !f.List {
+		return f.checkValue(v)
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return fmt.Errorf("expected a list of %s, got %T", f.Type, v)
+	}
+	if f.Max > 0 && len(list) > f.Max {
+		return fmt.Errorf("has %d elements (max %d)", len(list), f.Max)
+	}
+	for i, e := range list {
+		if err := f.checkValue(e); err != nil {
+			return fmt.Errorf("[%d] %v", i, err)
+		}
+	}
+	return nil
+}
+
+// checkValue checks a scalar against the field type. Max applies to string and bytes values
+// only when the field is not a list.
+func (f *Field) checkValue(v any) error {
+	ok := false
+	switch f.Type {
+	case TypeBool:
+		_, ok = v.(bool)
+	case TypeInt:
+		ok = isInt(v)
+	case TypeUint:
+		ok = isUint(v)
+	case TypeFloat:
+		ok = isFloat(v)
+	case TypeString:
+		s, isString := v.(string)
+		if isString && !utf8.ValidString(s) {
+			return errors.New("is not valid UTF-8")
+		}
+		if isString && !f.List && f.Max > 0 && len(s) > f.Max {
+			return fmt.Errorf("is %d bytes long (max %d)", len(s), f.Max)
+		}
+		ok = isString
+	case TypeBytes:
+		b, isBytes := v.([]byte)
+		if s, isString := v.(string); isString {
+			decoded, err := base64.StdEncoding.DecodeString(s) // JSON encodes []byte as base64
+			b, isBytes = decoded, err == nil
+		}
+		if isBytes && !f.List && f.Max > 0 && len(b) > f.Max {
+			return fmt.Errorf("is %d bytes long (max %d)", len(b), f.Max)
+		}
+		ok = isBytes
+	case TypeUUID:
+		switch v := v.(type) {
+		case string:
+			_, err := uuid.Parse(v)
+			ok = err == nil
+		case []byte:
+			ok = len(v) == 16
+		}
+	case TypeTime:
+		var t time.Time
+		switch v := v.(type) {
+		case string:
+			ok = t.UnmarshalText([]byte(v)) == nil
+		case []byte:
+			ok = t.UnmarshalBinary(v) == nil
+		}
+	}
+	if !ok {
+		return fmt.Errorf("expected %s, got %T", f.Type, v)
+	}
+	return nil
+}
+
+func isInt(v any) bool {
+	switch v := v.(type) {
+	case json.Number:
+		_, err := strconv.ParseInt(string(v), 10, 64)
+		return err == nil
+	case int64:
+		return true
+	case uint64:
+		return v <= math.MaxInt64
+	case float64:
+		return v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64
+	}
+	return false
+}
+
+func isUint(v any) bool {
+	switch v := v.(type) {
+	case json.Number:
+		_, err := strconv.ParseUint(string(v), 10, 64)
+		return err == nil
+	case int64:
+		return v >= 0
+	case uint64:
+		return true
+	case float64:
+		return v == math.Trunc(v) && v >= 0 && v < math.MaxUint64
+	}
+	return false
+}
+
+func isFloat(v any) bool {
+	switch v := v.(type) {
+	case json.Number:
+		_, err := strconv.ParseFloat(string(v), 64)
+		return err == nil
+	case int64, uint64, float64:
+		return true
+	}
+	return false
+}
@@ -1,7 +1,612 @@
+// Package sockethub provides the SocketHub server.
+// A hub accepts framed connections on any number of listeners (TCP, TLS, UDP, Unix,
+// WebSocket, in-memory) and treats every resulting protocol.Conn the same way: frames are
+// dispatched to router handlers or routed to clients, rooms and broadcasts regardless of
+// the transport each client arrived on.
 package sockethub
 
-// import sockethub_config "github.com/Jdcabreradev/sockethub/config"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
-// type SocketHub struct {
-// 	sockethub_config.SocketConfig
-// }
\ No newline at end of file
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/logger"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/schema"
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Hub Errors
+// =============================================================================
+
+var (
+	// ErrHubClosed is returned when using a hub after Close.
+	ErrHubClosed = errors.New("sockethub: hub is closed")
+
+	// ErrClientClosed is returned when sending to a client whose connection has ended.
+	ErrClientClosed = errors.New("sockethub: client is closed")
+
+	// ErrSendQueueFull is returned when a client's send channel has no room for another frame.
+	ErrSendQueueFull = errors.New("sockethub: client send queue is full")
+
+	// ErrListenerExists is returned when serving a listener under a name already in use.
+	ErrListenerExists = errors.New("sockethub: listener name already in use")
+)
+
+// =============================================================================
+// Hub Structure
+// =============================================================================
+
+// HandlerFunc processes a frame received from a client. Handlers run on the client's read
+// goroutine, so frames from one client are handled in order; header.Sender is the client's
+// Address.
+type HandlerFunc func(c *Client, header *protocol.SocketHeader, payload []byte)
+
+// ReliableHandlerFunc processes a frame like HandlerFunc and reports whether it succeeded. An
+// error is answered with an error frame correlated to the frame (an *Error is sent as is, other
+// errors as ErrorCodeInternal), and a frame flagged protocol.FlagReliable is acknowledged only
+// once its handler returns nil (see SocketConfig.Delivery).
+type ReliableHandlerFunc func(c *Client, header *protocol.SocketHeader, payload []byte) error
+
+// hubListener is a listener being served together with its resolved settings.
+type hubListener struct {
+	listener protocol.Listener
+	socket   socketFile                      // OS socket that can be handed off (nil if not opened by the hub)
+	config   sockethub_config.ListenerConfig // Resolved against the hub-wide config
+	clients  uint32                          // Connected clients (guarded by SocketHub.mu)
+}
+
+// idLocks hands out a mutex per ID, so work on one address or room is serialized without a
+// hub-wide lock. A mutex is dropped once nobody holds or waits for it. The zero value is ready
+// to use.
+type idLocks struct {
+	mu    sync.Mutex
+	locks map[uuid.UUID]*idLock
+}
+
+// idLock is the mutex of one ID.
+type idLock struct {
+	sync.Mutex
+	refs int // Holders and waiters (guarded by idLocks.mu)
+}
+
+// lock locks the mutex of id and returns the function that unlocks it.
+func (l *idLocks) lock(id uuid.UUID) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[uuid.UUID]*idLock)
+	}
+	m, ok := l.locks[id]
+	if !ok {
+		m = &idLock{}
+		l.locks[id] = m
+	}
+	m.refs++
+	l.mu.Unlock()
+
+	m.Lock()
+	return func() {
+		m.Unlock()
+		l.mu.Lock()
+		if m.refs--; m.refs == 0 {
+			delete(l.locks, id)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// SocketHub accepts clients on multiple listeners and routes frames between them.
+type SocketHub struct {
+	id               uuid.UUID // Sender ID of frames that originate in the hub itself
+	config           *sockethub_config.SocketConfig
+	logger           *socketlog.Logger // Optional (nil for no logging)
+	ctx              context.Context   // Canceled by Close; every hub goroutine watches it
+	cancel           context.CancelFunc
+	mu               sync.RWMutex
+	clients          map[uuid.UUID]*Client
+	rooms            map[uuid.UUID]*room
+	handlers         map[uint8]ReliableHandlerFunc
+	schemas          map[uint8]*schema.Message // Payload checks per router (see SetSchema)
+	addresses        map[uuid.UUID]*Client     // Clients by bound address (see Bind)
+	offline          *offlineQueue             // Store-and-forward queue (nil if disabled)
+	addressMu        idLocks                   // Orders queueing for an address against Bind delivering its queue
+	roomLogMu        idLocks                   // Orders a room's log appends against JoinWithHistory replays
+	delivery         *delivery                 // At-least-once delivery state (nil if disabled)
+	listeners        map[string]*hubListener
+	roomBackpressure map[uuid.UUID]sockethub_config.BackpressureConfig // Per-room overrides of config.Backpressure
+	onSlowConsumer   SlowConsumerFunc
+	sendStats        sendCounters
+	wg               sync.WaitGroup // Accept loops and client goroutines
+	readers          sync.WaitGroup // Client read goroutines, which run the handlers
+	draining         atomic.Bool    // Set by Shutdown; no new clients are admitted
+	closeOnce        sync.Once
+}
+
+// New creates a hub from cfg. The logger is optional. Listeners are opened by Start.
+func New(cfg *sockethub_config.SocketConfig, logger *socketlog.Logger) (*SocketHub, error) {
+	if cfg == nil {
+		cfg = sockethub_config.DefaultConfig()
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("sockethub: invalid config: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SocketHub{
+		id:               uuid.New(),
+		config:           cfg,
+		logger:           logger,
+		ctx:              ctx,
+		cancel:           cancel,
+		clients:          make(map[uuid.UUID]*Client),
+		rooms:            make(map[uuid.UUID]*room),
+		handlers:         make(map[uint8]ReliableHandlerFunc),
+		schemas:          make(map[uint8]*schema.Message),
+		addresses:        make(map[uuid.UUID]*Client),
+		offline:          newOfflineQueue(cfg.OfflineQueue),
+		delivery:         newDelivery(cfg.Delivery),
+		listeners:        make(map[string]*hubListener),
+		roomBackpressure: make(map[uuid.UUID]sockethub_config.BackpressureConfig),
+	}, nil
+}
+
+// ID returns the sender ID the hub uses for frames it originates (errors, heartbeats).
+func (h *SocketHub) ID() uuid.UUID {
+	return h.id
+}
+
+// log writes to the optional logger.
+func (h *SocketHub) log(logType socketlog.LogType, format string, args ...any) {
+	if h.logger != nil {
+		h.logger.Log("SocketHub", logType, fmt.Sprintf(format, args...))
+	}
+}
+
+// =============================================================================
+// Lifecycle
+// =============================================================================
+
+// Start opens every configured listener and serves them in the background. If ctx is
+// canceled the hub is closed. On error, listeners opened so far are closed again.
+// Sockets handed off by a parent process (see Handoff) are used instead of opening new ones.
+func (h *SocketHub) Start(ctx context.Context) error {
+	if h.ctx.Err() != nil {
+		return ErrHubClosed
+	}
+
+	inherited, ready, err := inheritSockets()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range inherited {
+			f.Close() // Sockets the new config no longer uses
+		}
+	}()
+
+	var opened []string
+	for _, lc := range h.config.Listeners {
+		lc = h.config.Resolved(lc)
+		f := inherited[lc.Name]
+		delete(inherited, lc.Name)
+		l, socket, err := openListener(lc, f)
+		if err == nil {
+			err = h.serve(l, lc, socket)
+			if err != nil {
+				l.Close()
+			}
+		}
+		if err != nil {
+			for _, name := range opened {
+				h.closeListener(name)
+			}
+			if ready != nil {
+				ready.Close()
+			}
+			return fmt.Errorf("sockethub: listener %s: %w", lc.Name, err)
+		}
+		if f != nil {
+			h.log(socketlog.INFO, "Inherited listener %s from parent process", lc.Name)
+		}
+		opened = append(opened, lc.Name)
+	}
+	notifyReady(ready)
+
+	context.AfterFunc(ctx, func() { h.Close() })
+	return nil
+}
+
+// Serve accepts clients from l in the background using the settings in lc (unset values
+// fall back to the hub config). It is how pre-built listeners, such as a
+// protocol.MemoryListener, join the hub next to the configured ones.
+func (h *SocketHub) Serve(l protocol.Listener, lc sockethub_config.ListenerConfig) error {
+	return h.serve(l, lc, nil)
+}
+
+// serve registers and serves a listener; socket is set for listeners the hub opened itself.
+func (h *SocketHub) serve(l protocol.Listener, lc sockethub_config.ListenerConfig, socket socketFile) error {
+	if lc.Name == "" && lc.Network == "" && lc.Address == "" {
+		lc.Name = l.Addr().Network() + "://" + l.Addr().String()
+	}
+	lc = h.config.Resolved(lc)
+
+	h.mu.Lock()
+	if h.ctx.Err() != nil {
+		h.mu.Unlock()
+		return ErrHubClosed
+	}
+	if _, exists := h.listeners[lc.Name]; exists {
+		h.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrListenerExists, lc.Name)
+	}
+	hl := &hubListener{listener: l, socket: socket, config: lc}
+	h.listeners[lc.Name] = hl
+	h.wg.Add(1)
+	h.mu.Unlock()
+
+	h.log(socketlog.INFO, "Listening on %s (%s)", lc.Name, l.Addr())
+	go h.acceptLoop(hl)
+	return nil
+}
+
+// acceptLoop registers clients from one listener until it is closed.
+func (h *SocketHub) acceptLoop(hl *hubListener) {
+	defer h.wg.Done()
+
+	var backoff time.Duration
+	for {
+		conn, err := hl.listener.Accept()
+		if err != nil {
+			if h.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			// Transient failures (e.g., out of file descriptors): retry with backoff
+			if backoff == 0 {
+				backoff = 5 * time.Millisecond
+			} else if backoff *= 2; backoff > time.Second {
+				backoff = time.Second
+			}
+			h.log(socketlog.WARNING, "Accept error on %s: %v; retrying in %v", hl.config.Name, err, backoff)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-h.ctx.Done():
+				return
+			}
+		}
+		backoff = 0
+		h.register(conn, hl)
+	}
+}
+
+// closeListener stops one listener; its clients stay connected.
+func (h *SocketHub) closeListener(name string) {
+	h.mu.Lock()
+	hl, ok := h.listeners[name]
+	delete(h.listeners, name)
+	h.mu.Unlock()
+	if ok {
+		hl.listener.Close()
+	}
+}
+
+// ListenerAddr returns the bound address of a listener, e.g. to find the port chosen for ":0".
+func (h *SocketHub) ListenerAddr(name string) (net.Addr, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	hl, ok := h.listeners[name]
+	if !ok {
+		return nil, false
+	}
+	return hl.listener.Addr(), true
+}
+
+// Done is closed once the hub starts closing.
+func (h *SocketHub) Done() <-chan struct{} {
+	return h.ctx.Done()
+}
+
+// Close stops every listener, disconnects every client and waits for hub goroutines to exit.
+func (h *SocketHub) Close() error {
+	h.stop()
+	h.wg.Wait()
+	return nil
+}
+
+// stop cancels the hub and disconnects everything without waiting for goroutines.
+func (h *SocketHub) stop() {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		h.cancel()
+		listeners := h.listeners
+		h.listeners = make(map[string]*hubListener)
+		clients := make([]*Client, 0, len(h.clients))
+		for _, c := range h.clients {
+			clients = append(clients, c)
+		}
+		h.mu.Unlock()
+
+		for _, hl := range listeners {
+			hl.listener.Close()
+		}
+		for _, c := range clients {
+			c.Close()
+		}
+		h.log(socketlog.INFO, "Hub closed")
+	})
+}
+
+// ShutdownReason is the reason carried by the going-away frame sent by Shutdown.
+const ShutdownReason = "server shutting down"
+
+// Shutdown gracefully stops the hub. It stops accepting clients, sends every client a
+// going-away control frame, waits for in-flight handlers to return, then delivers what is
+// left in each send channel before closing the connection. If ctx expires first, the
+// remaining connections are closed immediately and ctx.Err() is returned without waiting
+// for handlers that are still running.
+func (h *SocketHub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	if h.ctx.Err() != nil {
+		h.mu.Unlock()
+		return ErrHubClosed
+	}
+	first := !h.draining.Swap(true)
+	listeners := h.listeners
+	h.listeners = make(map[string]*hubListener)
+	clients := make([]*Client, 0, len(h.clients))
+	for _, c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+
+	if first {
+		h.log(socketlog.INFO, "Shutting down: draining %d clients", len(clients))
+		for _, hl := range listeners {
+			hl.listener.Close()
+		}
+		for _, c := range clients {
+			c.goAway()
+		}
+	}
+
+	// Wait for handlers to finish; read loops stop once their current frame is handled
+	handled := make(chan struct{})
+	go func() {
+		h.readers.Wait()
+		close(handled)
+	}()
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		h.log(socketlog.WARNING, "Shutdown timed out waiting for handlers; closing connections")
+		h.stop()
+		return ctx.Err()
+	}
+
+	// Flush the send channels, then wait for every client to close
+	for _, c := range clients {
+		c.drain()
+	}
+	for _, c := range clients {
+		select {
+		case <-c.Done():
+		case <-ctx.Done():
+			h.log(socketlog.WARNING, "Shutdown timed out flushing clients; closing connections")
+			h.stop()
+			return ctx.Err()
+		}
+	}
+	return h.Close()
+}
+
+// =============================================================================
+// Clients
+// =============================================================================
+
+// register admits a new connection as a Client, enforcing hub and listener limits.
+func (h *SocketHub) register(conn protocol.Conn, hl *hubListener) {
+	h.mu.Lock()
+	switch {
+	case h.ctx.Err() != nil, h.draining.Load():
+		h.mu.Unlock()
+		conn.Close()
+		return
+	case h.config.MaxClients > 0 && uint32(len(h.clients)) >= h.config.MaxClients,
+		hl.config.MaxClients > 0 && hl.clients >= hl.config.MaxClients:
+		h.mu.Unlock()
+		h.log(socketlog.WARNING, "Rejected %s on %s: too many clients", conn.RemoteAddr(), hl.config.Name)
+		conn.Close()
+		return
+	}
+
+	c := newClient(h, conn, hl)
+	h.clients[c.ID] = c
+	hl.clients++
+	h.wg.Add(2)
+	h.readers.Add(1)
+	h.mu.Unlock()
+
+	h.log(socketlog.INFO, "Client %s connected from %s on %s", c.ID, conn.RemoteAddr(), hl.config.Name)
+	go c.readLoop()
+	go c.writeLoop()
+}
+
+// unregister removes a closed client from the hub, its listener and its rooms.
+func (h *SocketHub) unregister(c *Client) {
+	h.mu.Lock()
+	if _, ok := h.clients[c.ID]; ok {
+		delete(h.clients, c.ID)
+		if addr := c.addr.Load(); addr != nil && h.addresses[*addr] == c {
+			delete(h.addresses, *addr)
+		}
+		if h.delivery != nil {
+			h.delivery.leave(c.Address(), c.ID, c.addr.Load() != nil, time.Now())
+		}
+		c.listener.clients--
+		for id := range c.rooms {
+			h.leaveLocked(c, id)
+		}
+	}
+	h.mu.Unlock()
+	h.log(socketlog.INFO, "Client %s disconnected", c.ID)
+}
+
+// Client returns a connected client by ID or bound address.
+func (h *SocketHub) Client(id uuid.UUID) (*Client, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.clientLocked(id)
+}
+
+// Clients returns a snapshot of the connected clients.
+func (h *SocketHub) Clients() []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, c := range h.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// ClientCount returns the number of connected clients.
+func (h *SocketHub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// =============================================================================
+// Routing
+// =============================================================================
+
+// Handle registers fn for frames whose Router field equals router, replacing any
+// previous handler. Frames without a handler are routed by Route.
+func (h *SocketHub) Handle(router uint8, fn HandlerFunc) {
+	if fn == nil {
+		h.HandleReliable(router, nil)
+		return
+	}
+	h.HandleReliable(router, func(c *Client, header *protocol.SocketHeader, payload []byte) error {
+		fn(c, header, payload)
+		return nil
+	})
+}
+
+// HandleReliable registers fn like Handle, for handlers that can fail: a reliable frame whose
+// handler returns an error is rejected instead of acknowledged.
+func (h *SocketHub) HandleReliable(router uint8, fn ReliableHandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if fn == nil {
+		delete(h.handlers, router)
+		return
+	}
+	h.handlers[router] = fn
+}
+
+// SetSchema makes the hub check frames on router against m before they reach a handler or
+// Route. Frames that do not match are answered with an ErrInvalidPayload error frame and
+// dropped. A nil m removes the check.
+func (h *SocketHub) SetSchema(router uint8, m *schema.Message) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m == nil {
+		delete(h.schemas, router)
+		return
+	}
+	h.schemas[router] = m
+}
+
+// dispatch hands a received frame to its router handler, or to Route. It reports whether the
+// frame was accepted; otherwise c was sent an error frame.
+func (h *SocketHub) dispatch(c *Client, header *protocol.SocketHeader, payload []byte) bool {
+	h.mu.RLock()
+	fn := h.handlers[header.Router]
+	m := h.schemas[header.Router]
+	h.mu.RUnlock()
+
+	if m != nil {
+		if err := m.Validate(header, payload); err != nil {
+			h.log(socketlog.DEBUG, "Rejected frame %s from %s: %v", header.ID, c.ID, err)
+			c.SendError(ErrInvalidPayload.WithRequest(header.ID).WithDetails([]byte(err.Error())), header.Router)
+			return false
+		}
+	}
+	if fn == nil {
+		return h.route(c, header, payload)
+	}
+	if err := fn(c, header, payload); err != nil {
+		h.log(socketlog.DEBUG, "Handler for router %d failed on frame %s from %s: %v", header.Router, header.ID, c.ID, err)
+		var e *Error
+		if !errors.As(err, &e) {
+			e = &Error{Code: ErrorCodeInternal, Message: err.Error()}
+		}
+		if e.RequestID == uuid.Nil {
+			e = e.WithRequest(header.ID)
+		}
+		c.SendError(e, header.Router)
+		return false
+	}
+	return true
+}
+
+// Route delivers a frame from c using the header alone: a broadcast goes to every other
+// client, or to a room when Receiver is a RoomID; a frame with a Receiver goes to that client.
+// Unknown receivers are answered with an ErrUnknownReceiver error frame. Handlers may call
+// Route to fall back to the default behavior.
+func (h *SocketHub) Route(c *Client, header *protocol.SocketHeader, payload []byte) {
+	h.route(c, header, payload)
+}
+
+// route implements Route, reporting false if c was sent an error frame.
+func (h *SocketHub) route(c *Client, header *protocol.SocketHeader, payload []byte) bool {
+	switch {
+	case header.IsBroadcast() && header.Receiver == uuid.Nil:
+		h.Broadcast(header, payload, c.ID)
+	case header.IsBroadcast():
+		if err := h.broadcastRoom(header.Receiver, header, payload, c.ID); err != nil {
+			c.SendError(ErrUnknownReceiver.WithRequest(header.ID), header.Router)
+			return false
+		}
+	case header.Receiver != uuid.Nil:
+		if err := h.Send(header.Receiver, header, payload); errors.Is(err, ErrUnknownReceiver) {
+			c.SendError(ErrUnknownReceiver.WithRequest(header.ID), header.Router)
+			return false
+		}
+	default:
+		h.log(socketlog.DEBUG, "Dropped frame %s from %s: no handler for router %d and no receiver", header.ID, c.ID, header.Router)
+	}
+	return true
+}
+
+// Send queues a frame for one client, by ID or bound address. If no client is connected at to,
+// the frame is stored in the offline queue if the address was bound before; otherwise, without
+// a queue, or when the queue is full, Send returns ErrUnknownReceiver.
+func (h *SocketHub) Send(to uuid.UUID, header *protocol.SocketHeader, payload []byte) error {
+	c, ok := h.Client(to)
+	if !ok {
+		return h.queueOffline(to, header, payload)
+	}
+	return c.Send(header, payload)
+}
+
+// Broadcast queues a frame for every client except the one with ID except (uuid.Nil for none).
+// Clients whose send queue is full miss the frame.
+func (h *SocketHub) Broadcast(header *protocol.SocketHeader, payload []byte, except uuid.UUID) {
+	for _, c := range h.Clients() {
+		if c.ID == except {
+			continue
+		}
+		if err := c.Send(header, payload); err != nil {
+			h.log(socketlog.WARNING, "Broadcast to %s failed: %v", c.ID, err)
+		}
+	}
+}
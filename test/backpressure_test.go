@@ -0,0 +1,298 @@
+package test
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// gatedListener hands out connections whose writes wait until the gate is opened,
+// which turns any client into a slow consumer on demand.
+type gatedListener struct {
+	*protocol.MemoryListener
+	writing chan struct{} // Signaled when a write starts waiting
+	gate    chan struct{} // Closed to let writes through
+}
+
+func (l *gatedListener) Accept() (protocol.Conn, error) {
+	c, err := l.MemoryListener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &gatedConn{Conn: c, l: l}, nil
+}
+
+type gatedConn struct {
+	protocol.Conn
+	l *gatedListener
+}
+
+func (c *gatedConn) WriteFrame(header *protocol.SocketHeader, payload []byte) error {
+	select {
+	case c.l.writing <- struct{}{}:
+	default:
+	}
+	<-c.l.gate
+	return c.Conn.WriteFrame(header, payload)
+}
+
+// slowConsumer connects a client through a gated listener with a send channel of two frames.
+func slowConsumer(t *testing.T, bp sockethub_config.BackpressureConfig) (*sockethub.SocketHub, *sockethub.Client, protocol.Conn, *gatedListener) {
+	t.Helper()
+
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Listeners = nil
+	cfg.SendChanSize = 2
+	cfg.Backpressure = bp
+	hub := newTestHub(t, cfg)
+
+	gl := &gatedListener{
+		MemoryListener: protocol.NewMemoryListener("slow", protocol.MemoryLink{}),
+		writing:        make(chan struct{}, 1),
+		gate:           make(chan struct{}),
+	}
+	if err := hub.Serve(gl, sockethub_config.ListenerConfig{Name: "slow"}); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	conn, id := dialMemory(t, hub, gl.MemoryListener)
+	c, _ := hub.Client(id)
+	return hub, c, conn, gl
+}
+
+// fillQueue sends frame 0, waits for the writer to block on it, then fills the channel with 1 and 2.
+func fillQueue(t *testing.T, c *sockethub.Client, gl *gatedListener) {
+	t.Helper()
+
+	for i := range 3 {
+		if err := c.Send(dataFrame(uint8(i)), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+		if i == 0 {
+			<-gl.writing
+		}
+	}
+}
+
+// received reads the given number of frames and returns their payloads.
+func received(t *testing.T, conn protocol.Conn, n int) []string {
+	t.Helper()
+
+	var got []string
+	for range n {
+		_, payload := readData(t, conn)
+		got = append(got, string(payload))
+	}
+	expectNothing(t, conn)
+	return got
+}
+
+// recordEvents collects slow-consumer events.
+func recordEvents(hub *sockethub.SocketHub) func() []sockethub.SlowConsumerEvent {
+	var mu sync.Mutex
+	var events []sockethub.SlowConsumerEvent
+	hub.OnSlowConsumer(func(c *sockethub.Client, ev sockethub.SlowConsumerEvent) {
+		mu.Lock()
+		events = append(events, ev)
+		mu.Unlock()
+	})
+	return func() []sockethub.SlowConsumerEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]sockethub.SlowConsumerEvent(nil), events...)
+	}
+}
+
+func TestBackpressurePolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		bp      sockethub_config.BackpressureConfig
+		err     error
+		outcome sockethub.SlowConsumerOutcome
+		dropped uint8 // Router of the frame reported in the event
+		want    []string
+	}{
+		{
+			name:    "drop newest",
+			err:     sockethub.ErrSendQueueFull,
+			outcome: sockethub.OutcomeDroppedNewest,
+			dropped: 3,
+			want:    []string{"0", "1", "2"},
+		},
+		{
+			name:    "drop oldest",
+			bp:      sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerDropOldest},
+			outcome: sockethub.OutcomeDroppedOldest,
+			dropped: 1,
+			want:    []string{"0", "2", "3"},
+		},
+		{
+			name:    "block times out",
+			bp:      sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerBlock, BlockTimeout: 20 * time.Millisecond},
+			err:     sockethub.ErrSendQueueFull,
+			outcome: sockethub.OutcomeDroppedNewest,
+			dropped: 3,
+			want:    []string{"0", "1", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, c, conn, gl := slowConsumer(t, tt.bp)
+			events := recordEvents(hub)
+
+			fillQueue(t, c, gl)
+			if err := c.Send(dataFrame(3), []byte("3")); !errors.Is(err, tt.err) {
+				t.Fatalf("Send on full queue: err = %v, want %v", err, tt.err)
+			}
+			evs := events()
+			if len(evs) != 1 || evs[0].Outcome != tt.outcome || evs[0].Header.Router != tt.dropped || evs[0].Policy != tt.bp.Policy {
+				t.Fatalf("events = %+v, want one %v of frame %d", evs, tt.outcome, tt.dropped)
+			}
+			if stats := c.SendStats(); stats.Dropped != 1 || hub.SendStats() != stats {
+				t.Fatalf("client stats %+v, hub stats %+v", stats, hub.SendStats())
+			}
+
+			close(gl.gate)
+			got := received(t, conn, len(tt.want))
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("received %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackpressureBlockWaitsForRoom(t *testing.T) {
+	_, c, conn, gl := slowConsumer(t, sockethub_config.BackpressureConfig{
+		Policy:       sockethub_config.SlowConsumerBlock,
+		BlockTimeout: 2 * time.Second,
+	})
+	fillQueue(t, c, gl)
+
+	time.AfterFunc(20*time.Millisecond, func() { close(gl.gate) })
+	if err := c.Send(dataFrame(3), []byte("3")); err != nil {
+		t.Fatalf("blocked Send failed: %v", err)
+	}
+	if got := received(t, conn, 4); got[3] != "3" {
+		t.Fatalf("received %q", got)
+	}
+	if stats := c.SendStats(); stats != (sockethub.SendStats{}) {
+		t.Fatalf("stats = %+v, want none", stats)
+	}
+}
+
+func TestBackpressureCoalesce(t *testing.T) {
+	hub, c, conn, gl := slowConsumer(t, sockethub_config.BackpressureConfig{
+		Policy:      sockethub_config.SlowConsumerCoalesce,
+		CoalesceKey: func(h *protocol.SocketHeader) string { return strconv.Itoa(int(h.Router)) },
+	})
+	events := recordEvents(hub)
+
+	// Frame 0 is being written; the queued frame 1 is replaced by a newer value
+	fillQueue(t, c, gl)
+	if err := c.Send(dataFrame(1), []byte("1b")); err != nil {
+		t.Fatalf("coalesced Send failed: %v", err)
+	}
+	if err := c.Send(dataFrame(3), []byte("3")); !errors.Is(err, sockethub.ErrSendQueueFull) {
+		t.Fatalf("Send without a matching key: err = %v, want ErrSendQueueFull", err)
+	}
+
+	evs := events()
+	if len(evs) != 2 || evs[0].Outcome != sockethub.OutcomeCoalesced || evs[1].Outcome != sockethub.OutcomeDroppedNewest {
+		t.Fatalf("events = %+v", evs)
+	}
+	if stats := c.SendStats(); stats.Coalesced != 1 || stats.Dropped != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	close(gl.gate)
+	got := received(t, conn, 3)
+	if got[0] != "0" || got[1] != "1b" || got[2] != "2" {
+		t.Fatalf("received %q, want [0 1b 2]", got)
+	}
+
+	// Once written, a key can be queued again
+	if err := c.Send(dataFrame(1), []byte("1c")); err != nil {
+		t.Fatalf("Send after flush failed: %v", err)
+	}
+	if _, payload := readData(t, conn); string(payload) != "1c" {
+		t.Fatalf("payload = %q, want 1c", payload)
+	}
+}
+
+func TestBackpressureDisconnect(t *testing.T) {
+	hub, c, _, gl := slowConsumer(t, sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerDisconnect})
+	defer close(gl.gate)
+	events := recordEvents(hub)
+
+	fillQueue(t, c, gl)
+	if err := c.Send(dataFrame(3), nil); !errors.Is(err, sockethub.ErrSlowConsumer) {
+		t.Fatalf("Send: err = %v, want ErrSlowConsumer", err)
+	}
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not disconnected")
+	}
+	if evs := events(); len(evs) != 1 || evs[0].Outcome != sockethub.OutcomeDisconnected {
+		t.Fatalf("events = %+v", evs)
+	}
+	if stats := hub.SendStats(); stats.Disconnected != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if err := c.Send(dataFrame(4), nil); !errors.Is(err, sockethub.ErrClientClosed) {
+		t.Fatalf("Send after disconnect: err = %v, want ErrClientClosed", err)
+	}
+}
+
+func TestBackpressureRoomOverride(t *testing.T) {
+	hub, c, conn, gl := slowConsumer(t, sockethub_config.BackpressureConfig{})
+	events := recordEvents(hub)
+
+	err := hub.SetRoomBackpressure("lobby", &sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerBlock})
+	if err == nil {
+		t.Fatal("SetRoomBackpressure accepted a block policy without timeout")
+	}
+	if err := hub.SetRoomBackpressure("lobby", &sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerDropOldest}); err != nil {
+		t.Fatalf("SetRoomBackpressure failed: %v", err)
+	}
+	if err := hub.Join(c.ID, "lobby"); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+
+	fillQueue(t, c, gl)
+	if err := hub.BroadcastRoom("lobby", dataFrame(3), []byte("3"), hub.ID()); err != nil {
+		t.Fatalf("BroadcastRoom failed: %v", err)
+	}
+	if evs := events(); len(evs) != 1 || evs[0].Policy != sockethub_config.SlowConsumerDropOldest {
+		t.Fatalf("events = %+v, want the room policy", evs)
+	}
+
+	// Direct sends still use the hub policy
+	if err := c.Send(dataFrame(4), []byte("4")); !errors.Is(err, sockethub.ErrSendQueueFull) {
+		t.Fatalf("Send: err = %v, want ErrSendQueueFull", err)
+	}
+
+	close(gl.gate)
+	if got := received(t, conn, 3); got[2] != "3" {
+		t.Fatalf("received %q", got)
+	}
+}
+
+func TestBackpressureConfigValidation(t *testing.T) {
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Backpressure = sockethub_config.BackpressureConfig{Policy: sockethub_config.SlowConsumerCoalesce}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("coalesce policy without a key was accepted")
+	}
+	cfg.Backpressure = sockethub_config.BackpressureConfig{Policy: 99}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("unknown policy was accepted")
+	}
+}
@@ -0,0 +1,184 @@
+// Code generated by sockethub gen from chat.schema. DO NOT EDIT.
+
+// Package chat provides the messages declared in chat.schema.
+package chat
+
+import (
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	"github.com/Jdcabreradev/sockethub/codec"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/schema"
+	"github.com/google/uuid"
+)
+
+// Schema is the schema the messages were generated from.
+var Schema = schema.MustParse(`package chat
+
+// Join is sent by a client to enter a room.
+message Join router 10 {
+	room     uuid
+	nickname string max 32
+	avatar   bytes optional max 1024
+}
+
+// Post is a chat message sent to a room.
+message Post router 11 {
+	room     uuid
+	text     string max 280
+	mentions []string max 8
+	sent_at  time
+	reply_to uuid optional
+}
+
+// Reaction votes on a post; weight must fit a signed integer.
+message Reaction router 12 {
+	post_id uuid
+	emoji   string
+	weight  int
+	score   float optional
+	count   uint optional
+	pinned  bool optional
+}
+`)
+
+// Router IDs of the messages.
+const (
+	JoinRouter     uint8 = 10
+	PostRouter     uint8 = 11
+	ReactionRouter uint8 = 12
+)
+
+// Join is sent by a client to enter a room.
+type Join struct {
+	Room     uuid.UUID `json:"room" msgpack:"room"`
+	Nickname string    `json:"nickname" msgpack:"nickname"`
+	Avatar   []byte    `json:"avatar,omitempty" msgpack:"avatar,omitempty"`
+}
+
+// Encode marshals m with c and returns a data frame header for JoinRouter and the payload.
+func (m *Join) Encode(c codec.Codec) (*protocol.SocketHeader, []byte, error) {
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: JoinRouter}
+	payload, err := codec.Encode(header, c, m)
+	return header, payload, err
+}
+
+// DecodeJoin checks a payload against the Join schema and decodes it.
+func DecodeJoin(header *protocol.SocketHeader, payload []byte) (*Join, error) {
+	if err := Schema.Message("Join").Validate(header, payload); err != nil {
+		return nil, err
+	}
+	m, err := codec.Decode[Join](header, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// HandleJoin registers fn for Join frames. The hub answers frames that do not match the
+// schema with an ErrInvalidPayload error frame, so they never reach fn.
+func HandleJoin(hub *sockethub.SocketHub, fn func(c *sockethub.Client, header *protocol.SocketHeader, m *Join)) {
+	hub.SetSchema(JoinRouter, Schema.Message("Join"))
+	hub.HandleReliable(JoinRouter, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) error {
+		m, err := codec.Decode[Join](header, payload)
+		if err != nil {
+			return sockethub.ErrInvalidPayload.WithDetails([]byte(err.Error()))
+		}
+		fn(c, header, &m)
+		return nil
+	})
+}
+
+// Post is a chat message sent to a room.
+type Post struct {
+	Room     uuid.UUID `json:"room" msgpack:"room"`
+	Text     string    `json:"text" msgpack:"text"`
+	Mentions []string  `json:"mentions" msgpack:"mentions"`
+	SentAt   time.Time `json:"sent_at" msgpack:"sent_at"`
+	ReplyTo  uuid.UUID `json:"reply_to,omitempty" msgpack:"reply_to,omitempty"`
+}
+
+// Encode marshals m with c and returns a data frame header for PostRouter and the payload.
+func (m *Post) Encode(c codec.Codec) (*protocol.SocketHeader, []byte, error) {
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: PostRouter}
+	payload, err := codec.Encode(header, c, m)
+	return header, payload, err
+}
+
+// DecodePost checks a payload against the Post schema and decodes it.
+func DecodePost(header *protocol.SocketHeader, payload []byte) (*Post, error) {
+	if err := Schema.Message("Post").Validate(header, payload); err != nil {
+		return nil, err
+	}
+	m, err := codec.Decode[Post](header, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// HandlePost registers fn for Post frames. The hub answers frames that do not match the
+// schema with an ErrInvalidPayload error frame, so they never reach fn.
+func HandlePost(hub *sockethub.SocketHub, fn func(c *sockethub.Client, header *protocol.SocketHeader, m *Post)) {
+	hub.SetSchema(PostRouter, Schema.Message("Post"))
+	hub.HandleReliable(PostRouter, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) error {
+		m, err := codec.Decode[Post](header, payload)
+		if err != nil {
+			return sockethub.ErrInvalidPayload.WithDetails([]byte(err.Error()))
+		}
+		fn(c, header, &m)
+		return nil
+	})
+}
+
+// Reaction votes on a post; weight must fit a signed integer.
+type Reaction struct {
+	PostID uuid.UUID `json:"post_id" msgpack:"post_id"`
+	Emoji  string    `json:"emoji" msgpack:"emoji"`
+	Weight int64     `json:"weight" msgpack:"weight"`
+	Score  float64   `json:"score,omitempty" msgpack:"score,omitempty"`
+	Count  uint64    `json:"count,omitempty" msgpack:"count,omitempty"`
+	Pinned bool      `json:"pinned,omitempty" msgpack:"pinned,omitempty"`
+}
+
+// Encode marshals m with c and returns a data frame header for ReactionRouter and the payload.
+func (m *Reaction) Encode(c codec.Codec) (*protocol.SocketHeader, []byte, error) {
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: ReactionRouter}
+	payload, err := codec.Encode(header, c, m)
+	return header, payload, err
+}
+
+// DecodeReaction checks a payload against the Reaction schema and decodes it.
+func DecodeReaction(header *protocol.SocketHeader, payload []byte) (*Reaction, error) {
+	if err := Schema.Message("Reaction").Validate(header, payload); err != nil {
+		return nil, err
+	}
+	m, err := codec.Decode[Reaction](header, payload)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// HandleReaction registers fn for Reaction frames. The hub answers frames that do not match the
+// schema with an ErrInvalidPayload error frame, so they never reach fn.
+func HandleReaction(hub *sockethub.SocketHub, fn func(c *sockethub.Client, header *protocol.SocketHeader, m *Reaction)) {
+	hub.SetSchema(ReactionRouter, Schema.Message("Reaction"))
+	hub.HandleReliable(ReactionRouter, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) error {
+		m, err := codec.Decode[Reaction](header, payload)
+		if err != nil {
+			return sockethub.ErrInvalidPayload.WithDetails([]byte(err.Error()))
+		}
+		fn(c, header, &m)
+		return nil
+	})
+}
+
+// RegisterSchemas makes hub check every message of the schema on its router, including
+// messages that are only relayed by Route.
+func RegisterSchemas(hub *sockethub.SocketHub) {
+	for _, m := range Schema.Messages {
+		hub.SetSchema(m.Router, m)
+	}
+}
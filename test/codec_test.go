@@ -0,0 +1,264 @@
+package test
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	"github.com/Jdcabreradev/sockethub/codec"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+type codecInner struct {
+	Tags  []string
+	Score float64
+}
+
+type codecMessage struct {
+	ID      uuid.UUID
+	Name    string `msgpack:"name" json:"name"`
+	Count   int
+	Big     uint64
+	Neg     int64
+	Flag    bool
+	Data    []byte
+	Inner   codecInner
+	Extra   map[string]int
+	Skipped string `msgpack:"-" json:"-"`
+	Empty   string `msgpack:",omitempty" json:",omitempty"`
+	When    time.Time
+}
+
+func sampleMessage() codecMessage {
+	return codecMessage{
+		ID:    uuid.New(),
+		Name:  "sensor",
+		Count: -42,
+		Big:   math.MaxUint64,
+		Neg:   math.MinInt64,
+		Flag:  true,
+		Data:  []byte{0, 1, 2, 0xFF},
+		Inner: codecInner{Tags: []string{"a", "b"}, Score: 0.5},
+		Extra: map[string]int{"x": 1, "y": 70000},
+		When:  time.Date(2024, 5, 1, 12, 0, 0, 123, time.UTC),
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, c := range []codec.Codec{codec.JSON, codec.Gob, codec.MessagePack} {
+		t.Run(c.ContentType(), func(t *testing.T) {
+			in := sampleMessage()
+			header := dataFrame(1)
+			payload, err := codec.Encode(header, c, in)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			if ct, _ := header.ContentType(); ct != c.ContentType() {
+				t.Errorf("ContentType = %q", ct)
+			}
+			out, err := codec.Decode[codecMessage](header, payload)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if !out.When.Equal(in.When) {
+				t.Errorf("When = %v, want %v", out.When, in.When)
+			}
+			out.When, in.When = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("round trip mismatch:\n got %+v\nwant %+v", out, in)
+			}
+		})
+	}
+
+	header := dataFrame(1)
+	payload, _ := codec.Encode(header, codec.Raw, "plain")
+	if s, err := codec.Decode[string](header, payload); err != nil || s != "plain" {
+		t.Errorf("raw round trip: %q, %v", s, err)
+	}
+	if _, err := codec.Raw.Marshal(42); err == nil {
+		t.Error("expected raw codec to reject non-byte values")
+	}
+}
+
+func TestMessagePackEncoding(t *testing.T) {
+	// Known encodings from the MessagePack specification
+	cases := []struct {
+		value any
+		want  []byte
+	}{
+		{nil, []byte{0xC0}},
+		{true, []byte{0xC3}},
+		{5, []byte{0x05}},
+		{-1, []byte{0xFF}},
+		{-33, []byte{0xD0, 0xDF}},
+		{200, []byte{0xCC, 0xC8}},
+		{70000, []byte{0xCE, 0x00, 0x01, 0x11, 0x70}},
+		{"hi", []byte{0xA2, 'h', 'i'}},
+		{[]byte{1}, []byte{0xC4, 0x01, 0x01}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xA1, 'a', 0x01, 0xA1, 'b', 0x02}},
+		{1.5, []byte{0xCB, 0x3F, 0xF8, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tc := range cases {
+		got, err := codec.MessagePack.Marshal(tc.value)
+		if err != nil {
+			t.Errorf("Marshal(%v) failed: %v", tc.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Marshal(%v) = % x, want % x", tc.value, got, tc.want)
+		}
+	}
+
+	// Decoding into an interface yields the generic forms
+	data, _ := codec.MessagePack.Marshal(map[string]any{"n": -3, "s": "x", "l": []any{true, nil}})
+	var generic any
+	if err := codec.MessagePack.Unmarshal(data, &generic); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := map[string]any{"n": int64(-3), "s": "x", "l": []any{true, nil}}
+	if !reflect.DeepEqual(generic, want) {
+		t.Errorf("generic decode = %#v", generic)
+	}
+
+	// Malformed input is rejected, never panics
+	full, _ := codec.MessagePack.Marshal(sampleMessage())
+	for n := range len(full) {
+		var m codecMessage
+		if err := codec.MessagePack.Unmarshal(full[:n], &m); err == nil {
+			t.Fatalf("truncated input of %d bytes decoded", n)
+		}
+	}
+	var small uint8
+	if err := codec.MessagePack.Unmarshal([]byte{0xCD, 0x01, 0x00}, &small); err == nil {
+		t.Error("expected overflow error decoding 256 into uint8")
+	}
+	if err := codec.MessagePack.Unmarshal([]byte{0xDD, 0xFF, 0xFF, 0xFF, 0xFF}, &generic); err == nil {
+		t.Error("expected error for an array longer than its input")
+	}
+}
+
+func TestCodecRegistry(t *testing.T) {
+	if c, err := codec.Lookup("Application/MsgPack; charset=binary"); err != nil || c != codec.MessagePack {
+		t.Errorf("Lookup normalized type: %v, %v", c, err)
+	}
+	if _, err := codec.Lookup("application/xml"); !errors.Is(err, codec.ErrUnknownContentType) {
+		t.Errorf("expected ErrUnknownContentType, got %v", err)
+	}
+	if err := codec.Register(codec.JSON); err == nil {
+		t.Error("expected duplicate registration to fail")
+	}
+
+	header := dataFrame(1)
+	header.SetContentType("application/xml")
+	if _, err := codec.Decode[string](header, []byte("<x/>")); !errors.Is(err, codec.ErrUnknownContentType) {
+		t.Errorf("expected ErrUnknownContentType decoding, got %v", err)
+	}
+	if c, _ := codec.ForHeader(dataFrame(1)); c != codec.Default {
+		t.Errorf("missing ContentType should select the default codec, got %v", c)
+	}
+}
+
+func TestCodecNegotiate(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   codec.Codec
+	}{
+		{"application/msgpack", codec.MessagePack},
+		{"application/xml, application/x-gob", codec.Gob},
+		{"application/json;q=0.5, application/msgpack", codec.MessagePack},
+		{"application/msgpack;q=0, application/json", codec.JSON},
+		{"text/html, */*;q=0.1", codec.Default},
+		{"application/xml", nil},
+		{"", nil},
+	}
+	for _, tc := range cases {
+		got, ok := codec.Negotiate(tc.accept)
+		if ok != (tc.want != nil) || got != tc.want {
+			t.Errorf("Negotiate(%q) = %v, %v; want %v", tc.accept, got, ok, tc.want)
+		}
+	}
+}
+
+func TestHubSendTypedNegotiatesCodec(t *testing.T) {
+	type reading struct {
+		Sensor string
+		Value  float64
+	}
+
+	hub := newTestHub(t, nil)
+	hub.Handle(3, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) {
+		in, err := sockethub.DecodeTyped[reading](header, payload)
+		if err != nil {
+			c.SendError(err, 3)
+			return
+		}
+		in.Value *= 2
+		sockethub.SendTyped(c, dataFrame(3), in)
+	})
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+
+	// A client that never announces Accept gets the default codec
+	plain, _ := dialMemory(t, hub, l)
+	header := dataFrame(3)
+	payload, _ := codec.Encode(header, codec.JSON, reading{"t1", 1.5})
+	plain.WriteFrame(header, payload)
+	reply, body := readData(t, plain)
+	if ct, _ := reply.ContentType(); ct != codec.Default.ContentType() {
+		t.Errorf("default reply ContentType = %q", ct)
+	}
+	if out, err := sockethub.DecodeTyped[reading](reply, body); err != nil || out != (reading{"t1", 3}) {
+		t.Errorf("default reply = %+v, %v", out, err)
+	}
+
+	// Accept selects the reply codec independently of the request's own ContentType
+	conn, _ := dialMemory(t, hub, l)
+	header = dataFrame(3)
+	header.SetAccept("application/msgpack, application/json;q=0.5")
+	payload, _ = codec.Encode(header, codec.Gob, reading{"t2", 2})
+	conn.WriteFrame(header, payload)
+	reply, body = readData(t, conn)
+	if ct, _ := reply.ContentType(); ct != codec.MessagePack.ContentType() {
+		t.Errorf("negotiated reply ContentType = %q", ct)
+	}
+	if out, err := sockethub.DecodeTyped[reading](reply, body); err != nil || out != (reading{"t2", 4}) {
+		t.Errorf("negotiated reply = %+v, %v", out, err)
+	}
+
+	// An explicit ContentType on the header wins over negotiation
+	var client *sockethub.Client
+	for _, c := range hub.Clients() {
+		if c.Codec() == codec.MessagePack {
+			client = c
+		}
+	}
+	if client == nil {
+		t.Fatal("negotiated codec not recorded on the client")
+	}
+	header = dataFrame(4)
+	header.SetContentType("application/json")
+	if err := sockethub.SendTyped(client, header, reading{"t3", 1}); err != nil {
+		t.Fatalf("SendTyped failed: %v", err)
+	}
+	if reply, _ = readData(t, conn); reply.Router != 4 {
+		t.Fatalf("unexpected reply on router %d", reply.Router)
+	}
+	if ct, _ := reply.ContentType(); ct != "application/json" {
+		t.Errorf("explicit ContentType overridden: %q", ct)
+	}
+}
+
+func TestHeaderClone(t *testing.T) {
+	header := dataFrame(1)
+	header.SetContentType("application/json")
+	clone := header.Clone()
+	clone.SetContentType("application/msgpack")
+	if ct, _ := header.ContentType(); ct != "application/json" {
+		t.Errorf("Clone shares extensions with the original: %q", ct)
+	}
+}
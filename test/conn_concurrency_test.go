@@ -0,0 +1,204 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// These tests hammer the wrappers from many goroutines; run them with -race.
+
+func TestTCPConcurrentWriters(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []protocol.ConnOption
+	}{
+		{"Direct writes", nil},
+		{"Coalesced writes", []protocol.ConnOption{protocol.WithWriteCoalescing(protocol.WriteCoalescing{})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("TCP listen error: %v", err)
+			}
+			defer listener.Close()
+
+			const writers, perWriter = 16, 100
+			received := make(chan error, 1)
+
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					received <- err
+					return
+				}
+				defer conn.Close()
+
+				server := protocol.NewTCPConnWrapper(conn)
+				seen := make(map[string]bool)
+				for len(seen) < writers*perWriter {
+					_, payload, err := server.ReadFrame()
+					if err != nil {
+						received <- fmt.Errorf("after %d frames: %w", len(seen), err)
+						return
+					}
+					seen[string(payload)] = true
+				}
+				received <- nil
+			}()
+
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("TCP dial error: %v", err)
+			}
+			client := protocol.NewTCPConnWrapper(conn, tc.opts...)
+			defer client.Close()
+
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						// Vary sizes so interleaved bytes would break framing or checksums
+						payload := append([]byte(fmt.Sprintf("w%d-m%d-", w, i)), bytes.Repeat([]byte{byte(w)}, i*7)...)
+						header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData}
+						if err := client.WriteFrame(header, payload); err != nil {
+							t.Errorf("WriteFrame failed: %v", err)
+							return
+						}
+						if i%10 == 0 {
+							client.SetSender(uuid.New())
+							_ = client.GetSender()
+						}
+					}
+				}(w)
+			}
+			wg.Wait()
+			if f, ok := client.(protocol.Flusher); ok {
+				f.Flush()
+			}
+
+			select {
+			case err := <-received:
+				if err != nil {
+					t.Fatalf("server read failed: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for frames")
+			}
+		})
+	}
+}
+
+func TestTCPConcurrentReaders(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const frames = 200
+	go func() {
+		writer := protocol.NewTCPConnWrapper(client)
+		for i := 0; i < frames; i++ {
+			writer.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte(fmt.Sprintf("frame-%d", i)))
+		}
+	}()
+
+	reader := protocol.NewTCPConnWrapper(server)
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < frames/4; i++ {
+				_, payload, err := reader.ReadFrame()
+				if err != nil {
+					t.Errorf("ReadFrame failed: %v", err)
+					return
+				}
+				mu.Lock()
+				seen[string(payload)] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != frames {
+		t.Errorf("received %d distinct frames, want %d", len(seen), frames)
+	}
+}
+
+func TestUDPConcurrentWritersUniqueSequences(t *testing.T) {
+	serverPC, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("UDP listen error: %v", err)
+	}
+	defer serverPC.Close()
+	if udp, ok := serverPC.(*net.UDPConn); ok {
+		udp.SetReadBuffer(1 << 20)
+	}
+
+	clientPC, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("UDP listen error: %v", err)
+	}
+	defer clientPC.Close()
+
+	const writers, perWriter = 8, 25
+	server := protocol.NewUDPConnWrapper(serverPC, nil, 2048)
+	client := protocol.NewUDPConnWrapper(clientPC, serverPC.LocalAddr(), 2048)
+
+	sequences := make(chan uint32, writers*perWriter)
+	go func() {
+		for {
+			serverPC.SetReadDeadline(time.Now().Add(2 * time.Second))
+			header, _, err := server.ReadFrame()
+			if err != nil {
+				close(sequences)
+				return
+			}
+			_ = server.RemoteAddr()
+			sequences <- header.Sequence
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData}
+				if err := client.WriteFrame(header, []byte("udp")); err != nil {
+					t.Errorf("WriteFrame failed: %v", err)
+					return
+				}
+				client.SetSender(uuid.New())
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[uint32]bool)
+	for seq := range sequences {
+		if seen[seq] {
+			t.Fatalf("duplicate sequence number %d", seq)
+		}
+		seen[seq] = true
+		if len(seen) == writers*perWriter {
+			break
+		}
+	}
+	if len(seen) < writers*perWriter/2 {
+		t.Errorf("received only %d of %d datagrams", len(seen), writers*perWriter)
+	}
+}
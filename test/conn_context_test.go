@@ -0,0 +1,136 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+func TestReadFrameContextCancel(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	reader := protocol.NewTCPConnWrapper(server)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, _, err := reader.ReadFrameContext(ctx)
+		errCh <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if protocol.IsFatal(err) {
+			t.Errorf("cancel between frames should not be fatal: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadFrameContext did not return after cancel")
+	}
+
+	// The deadline is cleared, so the connection keeps working
+	go protocol.NewTCPConnWrapper(client).WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("after"))
+	_, payload, err := reader.ReadFrameContext(context.Background())
+	if err != nil || string(payload) != "after" {
+		t.Fatalf("read after cancel: payload %q, err %v", payload, err)
+	}
+}
+
+func TestReadFrameContextDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := protocol.NewTCPConnWrapper(server).ReadFrameContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || !protocol.IsTimeout(err) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("deadline honored late: %v", elapsed)
+	}
+}
+
+func TestReadFrameContextMidFrameIsFatal(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	// Send only the header size prefix and stall
+	go client.Write([]byte{0, byte(protocol.HeaderMinSize)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, _, err := protocol.NewTCPConnWrapper(server).ReadFrameContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !protocol.IsFatal(err) {
+		t.Errorf("interrupted frame should be fatal: %v", err)
+	}
+}
+
+func TestWriteFrameContext(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []protocol.ConnOption
+	}{
+		{"Direct writes", nil},
+		{"Coalesced writes", []protocol.ConnOption{protocol.WithWriteCoalescing(protocol.WriteCoalescing{})}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			writer := protocol.NewTCPConnWrapper(client, tc.opts...)
+			header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData}
+
+			// Already canceled: nothing is written
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := writer.WriteFrameContext(ctx, header, []byte("x")); !errors.Is(err, context.Canceled) {
+				t.Fatalf("expected context.Canceled, got %v", err)
+			}
+
+			// Nobody reads the pipe, so the write blocks until the deadline
+			ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := writer.WriteFrameContext(ctx, header, []byte("blocked"))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected deadline exceeded, got %v", err)
+			}
+		})
+	}
+}
+
+func TestUDPReadFrameContextCancel(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("UDP listen error: %v", err)
+	}
+	defer pc.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	_, _, err = protocol.NewUDPConnWrapper(pc, nil, 2048).ReadFrameContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
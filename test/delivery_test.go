@@ -0,0 +1,283 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/reliable"
+	"github.com/Jdcabreradev/sockethub/test/chat"
+	"github.com/google/uuid"
+)
+
+func TestReliableWindow(t *testing.T) {
+	w := reliable.NewWindow(2)
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	w.Add(a)
+	w.Add(b)
+	w.Add(a) // Already remembered: does not push b out
+	w.Add(c)
+	if w.Contains(a) || !w.Contains(b) || !w.Contains(c) {
+		t.Errorf("window holds a=%v b=%v c=%v, want only b and c", w.Contains(a), w.Contains(b), w.Contains(c))
+	}
+}
+
+func TestReliableEndpointRedelivers(t *testing.T) {
+	local, remote := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	defer local.Close()
+	defer remote.Close()
+	sender := reliable.NewEndpoint(local, reliable.Config{AckTimeout: 20 * time.Millisecond})
+	receiver := reliable.NewEndpoint(remote, reliable.Config{})
+	go func() {
+		for {
+			header, payload, err := local.ReadFrame()
+			if err != nil {
+				return
+			}
+			sender.HandleFrame(header, payload)
+		}
+	}()
+
+	result := make(chan error, 1)
+	go func() { result <- sender.Send(context.Background(), dataFrame(1), []byte("hello")) }()
+
+	// The first delivery is lost in processing; the redelivery is acknowledged
+	first, _ := readData(t, remote)
+	if !reliable.IsReliable(first) || receiver.HandleFrame(first, nil) {
+		t.Fatalf("first delivery: flags %v", first.Flags)
+	}
+	second, payload := readData(t, remote)
+	if second.ID != first.ID || string(payload) != "hello" || receiver.HandleFrame(second, payload) {
+		t.Fatalf("redelivery: id %v %q", second.ID, payload)
+	}
+	if err := receiver.Ack(second); err != nil {
+		t.Fatalf("Ack failed: %v", err)
+	}
+	if err := <-result; err != nil {
+		t.Fatalf("Send = %v", err)
+	}
+
+	// Later copies are acknowledged again without reaching the application
+	if !receiver.HandleFrame(second, payload) {
+		t.Errorf("duplicate was not dropped")
+	}
+	if sender.Pending() != 0 {
+		t.Errorf("%d frames still pending", sender.Pending())
+	}
+}
+
+func TestReliableEndpointGivesUp(t *testing.T) {
+	local, remote := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	defer local.Close()
+	defer remote.Close()
+	sender := reliable.NewEndpoint(local, reliable.Config{AckTimeout: 10 * time.Millisecond, MaxAttempts: 2})
+	go func() {
+		for {
+			header, payload, err := local.ReadFrame()
+			if err != nil {
+				return
+			}
+			sender.HandleFrame(header, payload)
+		}
+	}()
+
+	if err := sender.Send(context.Background(), dataFrame(1), nil); !errors.Is(err, reliable.ErrUnacknowledged) {
+		t.Errorf("expected ErrUnacknowledged, got %v", err)
+	}
+	for range 2 {
+		readData(t, remote)
+	}
+	expectNothing(t, remote)
+
+	// An error frame correlated to the frame settles it too
+	result := make(chan error, 1)
+	go func() { result <- sender.Send(context.Background(), dataFrame(2), nil) }()
+	header, _ := readData(t, remote)
+	reply, payload, _ := sockethub.ErrorFrame(sockethub.ErrInvalidPayload.WithRequest(header.ID), uuid.Nil, uuid.Nil, 2)
+	remote.WriteFrame(reply, payload)
+	var rejected *reliable.RejectedError
+	if err := <-result; !errors.As(err, &rejected) {
+		t.Fatalf("expected RejectedError, got %v", err)
+	}
+	if e, err := sockethub.ErrorFromFrame(rejected.Header, rejected.Payload); err != nil || !errors.Is(e, sockethub.ErrInvalidPayload) {
+		t.Errorf("rejection carries %v, %v", e, err)
+	}
+}
+
+func reliableFrame(router uint8) *protocol.SocketHeader {
+	header := dataFrame(router)
+	header.ID = uuid.New()
+	header.Flags = protocol.FlagReliable
+	return header
+}
+
+func TestHubAcknowledgesReliableFrames(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.Delivery = &sockethub_config.DeliveryConfig{}
+	})
+	var handled atomic.Int32
+	hub.Handle(1, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) {
+		handled.Add(1)
+	})
+	conn, connID := dialMemory(t, hub, l)
+
+	frame := reliableFrame(1)
+	for range 2 {
+		conn.WriteFrame(frame, []byte("once"))
+		ack, _ := readData(t, conn)
+		if !reliable.IsAck(ack) || ack.ID != frame.ID || ack.Receiver != connID {
+			t.Fatalf("expected an ACK of %v, got flags %v id %v", frame.ID, ack.Flags, ack.ID)
+		}
+	}
+	if n := handled.Load(); n != 1 {
+		t.Errorf("handler ran %d times for a redelivered frame", n)
+	}
+
+	// Frames without the flag are not acknowledged
+	conn.WriteFrame(dataFrame(1), nil)
+	expectNothing(t, conn)
+}
+
+func TestHubRejectsReliableFramesThatFail(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.Delivery = &sockethub_config.DeliveryConfig{}
+	})
+	var calls atomic.Int32
+	hub.HandleReliable(1, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) error {
+		if calls.Add(1) == 1 {
+			return sockethub.ErrUnauthorized
+		}
+		return nil
+	})
+	hub.SetSchema(chat.JoinRouter, chat.Schema.Message("Join"))
+	conn, _ := dialMemory(t, hub, l)
+
+	// A failed handler answers with an error frame, and the redelivery is handled again
+	frame := reliableFrame(1)
+	conn.WriteFrame(frame, nil)
+	reply, payload := readData(t, conn)
+	if e, err := sockethub.ErrorFromFrame(reply, payload); err != nil || !errors.Is(e, sockethub.ErrUnauthorized) || e.RequestID != frame.ID {
+		t.Fatalf("expected ErrUnauthorized for %v, got %v, %v", frame.ID, e, err)
+	}
+	conn.WriteFrame(frame, nil)
+	if ack, _ := readData(t, conn); !reliable.IsAck(ack) || ack.ID != frame.ID {
+		t.Fatalf("expected an ACK of the redelivery, got flags %v", ack.Flags)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+
+	// Frames that fail their schema are never acknowledged
+	invalid := reliableFrame(chat.JoinRouter)
+	conn.WriteFrame(invalid, []byte("not a join"))
+	reply, payload = readData(t, conn)
+	if e, err := sockethub.ErrorFromFrame(reply, payload); err != nil || !errors.Is(e, sockethub.ErrInvalidPayload) {
+		t.Fatalf("expected ErrInvalidPayload, got %v, %v", e, err)
+	}
+	expectNothing(t, conn)
+}
+
+func TestHubRedeliversUntilAcknowledged(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.Delivery = &sockethub_config.DeliveryConfig{AckTimeout: 40 * time.Millisecond}
+	})
+	user := uuid.New()
+	conn, connID := dialMemory(t, hub, l)
+	hub.Bind(connID, user)
+
+	// Unacknowledged frames are written again after the timeout, until the ACK arrives
+	frame := reliableFrame(3)
+	hub.Send(user, frame, []byte("important"))
+	for range 2 {
+		if header, payload := readData(t, conn); header.ID != frame.ID || string(payload) != "important" {
+			t.Fatalf("unexpected frame %v %q", header.ID, payload)
+		}
+	}
+	conn.WriteFrame(reliable.AckFrame(frame.ID, uuid.Nil), nil)
+	time.Sleep(100 * time.Millisecond)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, _, err := conn.ReadFrameContext(ctx) // Drain a redelivery that crossed the ACK
+		cancel()
+		if err != nil {
+			break
+		}
+	}
+	expectNothing(t, conn)
+
+	// A frame the client never acknowledged follows its address to the next connection
+	lost := reliableFrame(4)
+	hub.Send(user, lost, []byte("in flight"))
+	readData(t, conn)
+	conn.Close()
+	waitFor(t, "client to disconnect", func() bool {
+		_, ok := hub.Client(connID)
+		return !ok
+	})
+
+	again, againID := dialMemory(t, hub, l)
+	endpoint := reliable.NewEndpoint(again, reliable.Config{})
+	if err := hub.Bind(againID, user); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	header, payload := readData(t, again)
+	if header.ID != lost.ID || string(payload) != "in flight" || endpoint.HandleFrame(header, payload) {
+		t.Fatalf("unexpected redelivery %v %q", header.ID, payload)
+	}
+	endpoint.Ack(header)
+	time.Sleep(100 * time.Millisecond)
+	expectNothing(t, again)
+}
+
+func TestHubDropsFramesAfterMaxAttempts(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.Delivery = &sockethub_config.DeliveryConfig{AckTimeout: 20 * time.Millisecond, MaxAttempts: 2}
+	})
+	conn, connID := dialMemory(t, hub, l)
+
+	hub.Send(connID, reliableFrame(5), nil)
+	for range 2 {
+		readData(t, conn)
+	}
+	time.Sleep(60 * time.Millisecond)
+	expectNothing(t, conn)
+}
+
+func TestHubDropsOldestUnacknowledgedFrames(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.Delivery = &sockethub_config.DeliveryConfig{AckTimeout: 30 * time.Millisecond, MaxUnacked: 2}
+	})
+	conn, connID := dialMemory(t, hub, l)
+
+	frames := []*protocol.SocketHeader{reliableFrame(1), reliableFrame(2), reliableFrame(3)}
+	for _, frame := range frames {
+		hub.Send(connID, frame, nil)
+		readData(t, conn)
+	}
+
+	// Only the newest two are kept for redelivery
+	for _, want := range frames[1:] {
+		if header, _ := readData(t, conn); header.ID != want.ID {
+			t.Fatalf("redelivered router %d, want router %d", header.Router, want.Router)
+		}
+		conn.WriteFrame(reliable.AckFrame(want.ID, uuid.Nil), nil)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		header, _, err := conn.ReadFrameContext(ctx) // Drain redeliveries that crossed the ACKs
+		cancel()
+		if err != nil {
+			break
+		}
+		if header.ID == frames[0].ID {
+			t.Fatalf("evicted frame was redelivered")
+		}
+	}
+	expectNothing(t, conn)
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Jdcabreradev/sockethub"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+func TestErrorFrameRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		err  *sockethub.Error
+	}{
+		{"Well-known without details", sockethub.ErrUnknownReceiver.WithRequest(uuid.New())},
+		{"Well-known with details", sockethub.ErrRateLimited.WithDetails([]byte(`{"retry_after_ms":500}`))},
+		{"Empty message", &sockethub.Error{Code: sockethub.ErrorCodeInternal}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sender, receiver := uuid.New(), uuid.New()
+			header, payload, err := sockethub.ErrorFrame(tc.err, sender, receiver, 7)
+			if err != nil {
+				t.Fatalf("ErrorFrame failed: %v", err)
+			}
+			if !sockethub.IsErrorFrame(header) {
+				t.Fatalf("header is not flagged as error: flags=%v", header.Flags)
+			}
+			if header.Sender != sender || header.Router != 7 {
+				t.Errorf("header addressing mismatch: sender=%v router=%d", header.Sender, header.Router)
+			}
+
+			decoded, err := sockethub.ErrorFromFrame(header, payload)
+			if err != nil {
+				t.Fatalf("ErrorFromFrame failed: %v", err)
+			}
+			if decoded.Code != tc.err.Code {
+				t.Errorf("Code mismatch: got %v, want %v", decoded.Code, tc.err.Code)
+			}
+			if decoded.Message != tc.err.Message {
+				t.Errorf("Message mismatch: got %q, want %q", decoded.Message, tc.err.Message)
+			}
+			if string(decoded.Details) != string(tc.err.Details) {
+				t.Errorf("Details mismatch: got %q, want %q", decoded.Details, tc.err.Details)
+			}
+			if decoded.RequestID != tc.err.RequestID {
+				t.Errorf("RequestID mismatch: got %v, want %v", decoded.RequestID, tc.err.RequestID)
+			}
+			if !errors.Is(decoded, tc.err) {
+				t.Errorf("errors.Is(decoded, original) = false")
+			}
+		})
+	}
+}
+
+func TestErrorFrameFromPlainError(t *testing.T) {
+	header, payload, err := sockethub.ErrorFrame(fmt.Errorf("boom"), uuid.New(), uuid.Nil, 0)
+	if err != nil {
+		t.Fatalf("ErrorFrame failed: %v", err)
+	}
+
+	decoded, err := sockethub.ErrorFromFrame(header, payload)
+	if err != nil {
+		t.Fatalf("ErrorFromFrame failed: %v", err)
+	}
+	if !errors.Is(decoded, sockethub.ErrInternal) {
+		t.Errorf("plain error should map to Internal, got %v", decoded.Code)
+	}
+	if decoded.Message != "boom" {
+		t.Errorf("Message mismatch: got %q, want %q", decoded.Message, "boom")
+	}
+}
+
+func TestErrorFrameRejectsMalformed(t *testing.T) {
+	header := &protocol.SocketHeader{Flags: protocol.FlagError}
+
+	if _, err := sockethub.ErrorFromFrame(header, []byte{0x00, 0x01}); err == nil {
+		t.Error("expected error for truncated payload")
+	}
+
+	payload, _ := sockethub.ErrUnauthorized.MarshalBinary()
+	if _, err := sockethub.ErrorFromFrame(&protocol.SocketHeader{}, payload); err == nil {
+		t.Error("expected error for frame without FlagError")
+	}
+	if _, err := sockethub.ErrorFromFrame(header, append(payload, 0xFF)); err == nil {
+		t.Error("expected error for trailing bytes")
+	}
+}
+
+func TestErrorCodeRegistry(t *testing.T) {
+	if got := sockethub.ErrorCodeTooLarge.String(); got != "TooLarge" {
+		t.Errorf("ErrorCodeTooLarge.String() = %q, want %q", got, "TooLarge")
+	}
+
+	if err := sockethub.RegisterErrorCode(sockethub.ErrorCodeInternal, "Mine"); err == nil {
+		t.Error("expected error registering a reserved code")
+	}
+
+	// The registry is process-wide, so pick a code no earlier run (-count) has taken
+	code := sockethub.ErrorCodeUserBase + 1
+	for code.IsRegistered() {
+		code++
+	}
+	if err := sockethub.RegisterErrorCode(code, "QuotaExceeded"); err != nil {
+		t.Fatalf("RegisterErrorCode failed: %v", err)
+	}
+	if err := sockethub.RegisterErrorCode(code, "Duplicate"); err == nil {
+		t.Error("expected error registering a duplicate code")
+	}
+	if !code.IsRegistered() || code.String() != "QuotaExceeded" {
+		t.Errorf("registered code lookup failed: %v", code)
+	}
+}
@@ -0,0 +1,309 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/transfer"
+	"github.com/google/uuid"
+)
+
+// memoryFile is an io.WriterAt that grows as needed.
+type memoryFile struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if end := int(off) + len(p); end > len(f.data) {
+		f.data = append(f.data, make([]byte, end-len(f.data))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memoryFile) bytes() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return bytes.Clone(f.data)
+}
+
+// receiver records what a receiving Manager was given.
+type receiver struct {
+	file     memoryFile
+	mu       sync.Mutex
+	offsets  []int64
+	complete chan error
+}
+
+func newReceiver() *receiver {
+	return &receiver{complete: make(chan error, 1)}
+}
+
+func (r *receiver) config() transfer.Config {
+	return transfer.Config{
+		ChunkSize:  8 << 10,
+		Window:     4,
+		AckTimeout: 50 * time.Millisecond,
+		OnOffer: func(uuid.UUID, transfer.Offer) (io.WriterAt, error) {
+			return &r.file, nil
+		},
+		OnProgress: func(p transfer.Progress) {
+			r.mu.Lock()
+			r.offsets = append(r.offsets, p.Offset)
+			r.mu.Unlock()
+		},
+		OnComplete: func(_ uuid.UUID, _ transfer.Offer, err error) {
+			r.complete <- err
+		},
+	}
+}
+
+// pumpTransfers passes every frame read from conn to m until the connection fails.
+func pumpTransfers(conn protocol.Conn, m *transfer.Manager) {
+	go func() {
+		for {
+			header, payload, err := conn.ReadFrame()
+			if err != nil {
+				if protocol.IsDroppable(err) {
+					continue
+				}
+				return
+			}
+			m.HandleFrame(header, payload)
+		}
+	}()
+}
+
+// transferPair returns a sending and a receiving Manager over a memory pipe.
+func transferPair(t *testing.T, link protocol.MemoryLink, recv *receiver) *transfer.Manager {
+	t.Helper()
+
+	a, b := protocol.NewMemoryPipe(link)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	sender := transfer.NewManager(a, transfer.Config{ChunkSize: 8 << 10, Window: 4, AckTimeout: 50 * time.Millisecond})
+	receiving := transfer.NewManager(b, recv.config())
+	pumpTransfers(a, sender)
+	pumpTransfers(b, receiving)
+	return sender
+}
+
+func randomFile(t *testing.T, size int) ([]byte, transfer.Offer) {
+	t.Helper()
+
+	data := make([]byte, size)
+	rand.Read(data)
+	offer, err := transfer.NewOffer("data.bin", bytes.NewReader(data), int64(size))
+	if err != nil {
+		t.Fatalf("NewOffer failed: %v", err)
+	}
+	return data, offer
+}
+
+func sendContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestFileTransferRoundTrip(t *testing.T) {
+	recv := newReceiver()
+	sender := transferPair(t, protocol.MemoryLink{}, recv)
+	data, offer := randomFile(t, 100<<10+123)
+
+	if err := sender.Send(sendContext(t), uuid.Nil, offer, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := <-recv.complete; err != nil {
+		t.Fatalf("receiver reported %v", err)
+	}
+	if !bytes.Equal(recv.file.bytes(), data) {
+		t.Fatal("received file differs")
+	}
+
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	if n := len(recv.offsets); n != 13 || recv.offsets[n-1] != offer.Size {
+		t.Errorf("unexpected progress: %v", recv.offsets)
+	}
+
+	// The verdict is remembered, so offering the file again does not start it over
+	if err := sender.Send(sendContext(t), uuid.Nil, offer, bytes.NewReader(data)); err != nil {
+		t.Errorf("repeated Send failed: %v", err)
+	}
+}
+
+func TestFileTransferRejected(t *testing.T) {
+	recv := newReceiver()
+	a, b := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	defer a.Close()
+	defer b.Close()
+	cfg := recv.config()
+	cfg.OnOffer = func(uuid.UUID, transfer.Offer) (io.WriterAt, error) {
+		return nil, errors.New("disk full")
+	}
+	sender := transfer.NewManager(a, transfer.Config{})
+	pumpTransfers(a, sender)
+	pumpTransfers(b, transfer.NewManager(b, cfg))
+
+	data, offer := randomFile(t, 1024)
+	err := sender.Send(sendContext(t), uuid.Nil, offer, bytes.NewReader(data))
+	if !errors.Is(err, transfer.ErrRejected) || !bytes.Contains([]byte(err.Error()), []byte("disk full")) {
+		t.Fatalf("expected rejection with reason, got %v", err)
+	}
+}
+
+func TestFileTransferVerification(t *testing.T) {
+	recv := newReceiver()
+	sender := transferPair(t, protocol.MemoryLink{}, recv)
+	data, offer := randomFile(t, 20<<10)
+	offer.SHA256[0] ^= 0xFF
+
+	if err := sender.Send(sendContext(t), uuid.Nil, offer, bytes.NewReader(data)); !errors.Is(err, transfer.ErrTransferFailed) {
+		t.Fatalf("expected ErrTransferFailed, got %v", err)
+	}
+	if err := <-recv.complete; !errors.Is(err, transfer.ErrVerification) {
+		t.Errorf("expected ErrVerification on the receiver, got %v", err)
+	}
+}
+
+func TestFileTransferLossyLink(t *testing.T) {
+	recv := newReceiver()
+	sender := transferPair(t, protocol.MemoryLink{LossRate: 0.1, Seed: 7}, recv)
+	data, offer := randomFile(t, 200<<10)
+
+	if err := sender.Send(sendContext(t), uuid.Nil, offer, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if !bytes.Equal(recv.file.bytes(), data) {
+		t.Fatal("received file differs")
+	}
+}
+
+func TestFileTransferIgnoresOtherPeers(t *testing.T) {
+	peer, mallory := uuid.New(), uuid.New()
+	var mu sync.Mutex
+	var peers []uuid.UUID // Peers reported by progress on either side
+	onProgress := func(p transfer.Progress) {
+		mu.Lock()
+		peers = append(peers, p.Peer)
+		mu.Unlock()
+	}
+
+	a, b := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	recv := newReceiver()
+	cfg := recv.config()
+	cfg.OnProgress = onProgress
+	sender := transfer.NewManager(a, transfer.Config{ChunkSize: 8 << 10, Window: 4, AckTimeout: 50 * time.Millisecond, OnProgress: onProgress})
+	receiving := transfer.NewManager(b, cfg)
+
+	// Every frame arrives twice: first forged by mallory, then from the real peer
+	relay := func(conn protocol.Conn, m *transfer.Manager) {
+		for {
+			header, payload, err := conn.ReadFrame()
+			if err != nil {
+				return
+			}
+			for _, from := range []uuid.UUID{mallory, peer} {
+				forged := *header.Clone()
+				forged.Sender = from
+				m.HandleFrame(&forged, payload)
+			}
+		}
+	}
+	go relay(a, sender)
+	go relay(b, receiving)
+
+	data, offer := randomFile(t, 50<<10)
+	if err := sender.Send(sendContext(t), peer, offer, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := <-recv.complete; err != nil {
+		t.Fatalf("OnComplete reported %v", err)
+	}
+	if !bytes.Equal(recv.file.bytes(), data) {
+		t.Error("received file differs")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range peers {
+		if p != peer {
+			t.Fatalf("progress reported from %v, want only %v", p, peer)
+		}
+	}
+}
+
+func TestFileTransferResumesThroughHub(t *testing.T) {
+	hub := newTestHub(t, nil)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	recv := newReceiver()
+	recvConn, recvID := dialMemory(t, hub, l)
+	pumpTransfers(recvConn, transfer.NewManager(recvConn, recv.config()))
+
+	data, offer := randomFile(t, 256<<10)
+
+	// The first connection drops once a quarter of the file is acknowledged
+	first, _ := dialMemory(t, hub, l)
+	var once sync.Once
+	interrupted := transfer.NewManager(first, transfer.Config{
+		ChunkSize:  8 << 10,
+		Window:     2,
+		AckTimeout: 100 * time.Millisecond,
+		OnProgress: func(p transfer.Progress) {
+			if p.Offset >= offer.Size/4 {
+				once.Do(func() { first.Close() })
+			}
+		},
+	})
+	pumpTransfers(first, interrupted)
+	if err := interrupted.Send(sendContext(t), recvID, offer, bytes.NewReader(data)); err == nil {
+		t.Fatal("expected Send to fail when its connection closed")
+	}
+
+	second, _ := dialMemory(t, hub, l)
+	var resumedAt int64 = -1
+	resumed := transfer.NewManager(second, transfer.Config{
+		ChunkSize: 8 << 10,
+		OnProgress: func(p transfer.Progress) {
+			if resumedAt < 0 {
+				resumedAt = p.Offset
+			}
+		},
+	})
+	pumpTransfers(second, resumed)
+	if err := resumed.Send(sendContext(t), recvID, offer, bytes.NewReader(data)); err != nil {
+		t.Fatalf("resumed Send failed: %v", err)
+	}
+	if resumedAt < offer.Size/4 {
+		t.Errorf("transfer restarted at %d instead of resuming", resumedAt)
+	}
+	if !bytes.Equal(recv.file.bytes(), data) {
+		t.Fatal("received file differs")
+	}
+
+	// Every chunk was stored exactly once
+	recv.mu.Lock()
+	defer recv.mu.Unlock()
+	for i := 1; i < len(recv.offsets); i++ {
+		if recv.offsets[i] <= recv.offsets[i-1] {
+			t.Fatalf("receiver progress went backwards: %v", recv.offsets)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// flowPipe returns two flow-controlled ends of a memory pipe. The writer end reads in the
+// background so it receives the credit granted by the reader end.
+func flowPipe(t *testing.T, cfg protocol.FlowControl) (protocol.FlowControlConn, protocol.FlowControlConn) {
+	t.Helper()
+
+	a, b := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	writer, reader := protocol.NewFlowControlConn(a, cfg), protocol.NewFlowControlConn(b, cfg)
+	t.Cleanup(func() {
+		writer.Close()
+		reader.Close()
+	})
+	go func() {
+		for {
+			if _, _, err := writer.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}()
+	return writer, reader
+}
+
+// writeAsync writes a frame in the background and returns its result.
+func writeAsync(conn protocol.Conn, h *protocol.SocketHeader, payload []byte) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- conn.WriteFrame(h, payload) }()
+	return done
+}
+
+// expectBlocked asserts that a write has not completed yet.
+func expectBlocked(t *testing.T, done <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		t.Fatalf("write completed without credit: %v", err)
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+// expectWritten waits for a write to complete successfully.
+func expectWritten(t *testing.T, done <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write still blocked after credit was granted")
+	}
+}
+
+func streamFrame(stream uint32) *protocol.SocketHeader {
+	h := memoryHeader()
+	h.SetStreamID(stream)
+	return h
+}
+
+func TestWindowUpdateRoundTrip(t *testing.T) {
+	h, payload := protocol.WindowUpdateFrame(7, 4096)
+	code, body, err := protocol.ParseControl(h, payload)
+	if err != nil || code != protocol.ControlWindowUpdate {
+		t.Fatalf("ParseControl = %v, %v", code, err)
+	}
+	stream, increment, err := protocol.ParseWindowUpdate(body)
+	if err != nil || stream != 7 || increment != 4096 {
+		t.Fatalf("ParseWindowUpdate = %d, %d, %v", stream, increment, err)
+	}
+	if _, _, err := protocol.ParseWindowUpdate(body[:5]); err == nil {
+		t.Error("expected error for a truncated window update")
+	}
+}
+
+func TestFlowControlBlocksWithoutCredit(t *testing.T) {
+	writer, reader := flowPipe(t, protocol.FlowControl{ConnWindow: 1024})
+	reader.ReadFrameContext(expiredContext()) // Announce the reader's windows
+
+	payload := make([]byte, 600)
+	for range 2 {
+		expectWritten(t, writeAsync(writer, memoryHeader(), payload))
+	}
+	blocked := writeAsync(writer, memoryHeader(), payload)
+	expectBlocked(t, blocked)
+
+	if _, _, err := reader.ReadFrame(); err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	expectWritten(t, blocked)
+	for range 2 {
+		if _, got, err := reader.ReadFrame(); err != nil || len(got) != len(payload) {
+			t.Fatalf("ReadFrame = %d bytes, %v", len(got), err)
+		}
+	}
+
+	stats := writer.FlowStats()
+	if stats.Stalls == 0 || stats.Window != 1024 {
+		t.Errorf("unexpected writer stats: %+v", stats)
+	}
+	if got := reader.FlowStats(); got.RecvCredit <= 0 || got.RecvCredit > 1024 {
+		t.Errorf("unexpected reader stats: %+v", got)
+	}
+}
+
+func TestFlowControlStreamWindows(t *testing.T) {
+	writer, reader := flowPipe(t, protocol.FlowControl{StreamWindow: 1000})
+	reader.ReadFrameContext(expiredContext())
+
+	payload := make([]byte, 800)
+	for range 2 {
+		expectWritten(t, writeAsync(writer, streamFrame(1), payload))
+	}
+	blocked := writeAsync(writer, streamFrame(1), payload)
+	expectBlocked(t, blocked)
+
+	// Other streams and frames without a stream are not held back
+	expectWritten(t, writeAsync(writer, streamFrame(2), payload))
+	expectWritten(t, writeAsync(writer, memoryHeader(), payload))
+
+	stats, ok := writer.StreamFlowStats(1)
+	if !ok || stats.SendCredit >= 0 || stats.Window != 1000 {
+		t.Fatalf("unexpected stream stats: %+v, %v", stats, ok)
+	}
+
+	if _, _, err := reader.ReadFrame(); err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	expectWritten(t, blocked)
+
+	writer.ReleaseStream(2)
+	if _, ok := writer.StreamFlowStats(2); ok {
+		t.Error("released stream still has flow-control state")
+	}
+	if got := writer.FlowStats().Streams; got != 1 {
+		t.Errorf("expected 1 stream, got %d", got)
+	}
+}
+
+func TestFlowControlWaitEnds(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(protocol.FlowControlConn) error
+		check func(error) bool
+	}{
+		{
+			name: "write deadline",
+			write: func(c protocol.FlowControlConn) error {
+				c.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+				return c.WriteFrame(memoryHeader(), make([]byte, 10))
+			},
+			check: protocol.IsTimeout,
+		},
+		{
+			name: "context",
+			write: func(c protocol.FlowControlConn) error {
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+				return c.WriteFrameContext(ctx, memoryHeader(), make([]byte, 10))
+			},
+			check: func(err error) bool { return errors.Is(err, context.DeadlineExceeded) },
+		},
+		{
+			name: "close",
+			write: func(c protocol.FlowControlConn) error {
+				time.AfterFunc(20*time.Millisecond, func() { c.Close() })
+				return c.WriteFrame(memoryHeader(), make([]byte, 10))
+			},
+			check: func(err error) bool { return errors.Is(err, net.ErrClosed) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The peer never reads, so no credit is ever granted
+			writer, _ := flowPipe(t, protocol.FlowControl{})
+			if err := tt.write(writer); !tt.check(err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestFlowControlExemptsControlFrames(t *testing.T) {
+	writer, reader := flowPipe(t, protocol.FlowControl{})
+
+	// No credit has been granted, yet control and heartbeat frames go out
+	header, payload := protocol.GoingAwayFrame("bye")
+	expectWritten(t, writeAsync(writer, header, payload))
+	expectWritten(t, writeAsync(writer, &protocol.SocketHeader{MessageType: protocol.MessageTypeHeartbeat}, nil))
+
+	if h, _, err := reader.ReadFrame(); err != nil || h.MessageType != protocol.MessageTypeControl {
+		t.Fatalf("expected the going-away frame, got %v", err)
+	}
+}
+
+func TestHubFlowControl(t *testing.T) {
+	hub := newTestHub(t, nil)
+	fc := protocol.FlowControl{ConnWindow: 1024}
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{FlowControl: &fc})
+	raw, id := dialMemory(t, hub, l)
+	conn := protocol.NewFlowControlConn(raw, fc)
+	expectNothing(t, conn) // Announce the client's windows
+	c, _ := hub.Client(id)
+
+	for i := range 3 {
+		if err := c.Send(dataFrame(uint8(i)), make([]byte, 600)); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+	waitFor(t, "the hub to run out of credit", func() bool {
+		stats, ok := c.FlowStats()
+		return ok && stats.SendCredit < 0 && stats.Stalls > 0
+	})
+	for i := range 3 {
+		if h, _ := readData(t, conn); h.Router != uint8(i) {
+			t.Fatalf("frame %d: unexpected router %d", i, h.Router)
+		}
+	}
+}
+
+func TestHubFlowControlKeepsHeartbeatsMoving(t *testing.T) {
+	fc := protocol.FlowControl{ConnWindow: 1024}
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		writeTimeout, heartbeat := 50*time.Millisecond, 20*time.Millisecond
+		cfg.FlowControl, cfg.WriteTimeout, cfg.HeartbeatInterval = &fc, &writeTimeout, &heartbeat
+	})
+	conn, id := dialMemory(t, hub, l)
+	c, _ := hub.Client(id)
+
+	// The raw end grants the hub one window and no more
+	header, payload := protocol.WindowUpdateFrame(0, fc.ConnWindow)
+	if err := conn.WriteFrame(header, payload); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	for i := range 3 {
+		if err := c.Send(dataFrame(uint8(i)), make([]byte, 600)); err != nil {
+			t.Fatalf("Send %d failed: %v", i, err)
+		}
+	}
+
+	// Well past the write timeout, heartbeats still go out while the third frame waits
+	data, heartbeats := 0, 0
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	for {
+		h, _, err := conn.ReadFrameContext(ctx)
+		if err != nil {
+			break
+		}
+		switch h.MessageType {
+		case protocol.MessageTypeData:
+			data++
+		case protocol.MessageTypeHeartbeat:
+			heartbeats++
+		}
+	}
+	if data != 2 || heartbeats == 0 {
+		t.Fatalf("got %d data frames and %d heartbeats, want 2 and some", data, heartbeats)
+	}
+	if _, ok := hub.Client(id); !ok {
+		t.Fatal("client closed while waiting for credit")
+	}
+
+	header, payload = protocol.WindowUpdateFrame(0, fc.ConnWindow)
+	if err := conn.WriteFrame(header, payload); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if h, _ := readData(t, conn); h.Router != 2 {
+		t.Fatalf("unexpected router %d", h.Router)
+	}
+}
+
+func TestFlowControlRejectedOnDatagramListeners(t *testing.T) {
+	lc := sockethub_config.ListenerConfig{Network: sockethub_config.NetworkUDP, Address: "127.0.0.1:0", FlowControl: &protocol.FlowControl{}}
+	if err := lc.Validate(); err == nil {
+		t.Error("expected flow control to be rejected on UDP")
+	}
+}
+
+// expiredContext returns a context that is already done, for reads that only need to start a conn.
+func expiredContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// replayConn is a net.Conn whose reads endlessly replay one encoded frame and whose writes are discarded.
+type replayConn struct {
+	frame  []byte
+	offset int
+}
+
+func (r *replayConn) Read(p []byte) (int, error) {
+	n := copy(p, r.frame[r.offset:])
+	r.offset = (r.offset + n) % len(r.frame)
+	return n, nil
+}
+
+func (r *replayConn) Write(p []byte) (int, error)        { return len(p), nil }
+func (r *replayConn) Close() error                       { return nil }
+func (r *replayConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (r *replayConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (r *replayConn) SetDeadline(t time.Time) error      { return nil }
+func (r *replayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (r *replayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// benchFrame returns a ready-to-send header and a payload of the given size.
+func benchFrame(size int) (*protocol.SocketHeader, []byte) {
+	header := &protocol.SocketHeader{
+		ID:          uuid.New(),
+		Sender:      uuid.New(),
+		Receiver:    uuid.New(),
+		MessageType: protocol.MessageTypeData,
+		Protocol:    protocol.ProtocolTCP,
+		Timestamp:   uint64(time.Now().UnixMilli()),
+		Router:      1,
+	}
+	return header, make([]byte, size)
+}
+
+// newReplayTCPConn returns a TCP wrapper that reads the encoded frame forever.
+func newReplayTCPConn(tb testing.TB, header *protocol.SocketHeader, payload []byte) protocol.Conn {
+	tb.Helper()
+
+	frame := make([]byte, protocol.FrameSize(header, len(payload)))
+	if _, err := protocol.EncodeFrameTo(frame, header, payload); err != nil {
+		tb.Fatalf("EncodeFrameTo failed: %v", err)
+	}
+	return protocol.NewTCPConnWrapper(&replayConn{frame: frame})
+}
+
+func TestFrameCodecAllocations(t *testing.T) {
+	header, payload := benchFrame(512)
+	buf := make([]byte, protocol.HeaderMaxSize)
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		protocol.HeaderEncodeTo(buf, header)
+	}); allocs != 0 {
+		t.Errorf("HeaderEncodeTo allocations: got %v, want 0", allocs)
+	}
+
+	n, err := protocol.HeaderEncodeTo(buf, header)
+	if err != nil {
+		t.Fatalf("HeaderEncodeTo failed: %v", err)
+	}
+	var decoded protocol.SocketHeader
+	if allocs := testing.AllocsPerRun(100, func() {
+		protocol.HeaderDecodeInto(&decoded, buf[:n], protocol.ValidationOptions{})
+	}); allocs != 0 {
+		t.Errorf("HeaderDecodeInto allocations: got %v, want 0", allocs)
+	}
+
+	conn := newReplayTCPConn(t, header, payload)
+	if allocs := testing.AllocsPerRun(100, func() {
+		conn.WriteFrame(header, payload)
+	}); allocs != 0 {
+		t.Errorf("TCP WriteFrame allocations: got %v, want 0", allocs)
+	}
+
+	readBuf := make([]byte, 0, 1024)
+	if allocs := testing.AllocsPerRun(100, func() {
+		readBuf, _ = conn.ReadFrameInto(&decoded, readBuf)
+	}); allocs != 0 {
+		t.Errorf("TCP ReadFrameInto allocations: got %v, want 0", allocs)
+	}
+	if len(readBuf) != len(payload) || decoded.ID != header.ID {
+		t.Errorf("ReadFrameInto returned wrong frame: %d bytes, ID %v", len(readBuf), decoded.ID)
+	}
+}
+
+func TestHeaderEncodeToShortBuffer(t *testing.T) {
+	header, _ := benchFrame(0)
+	if _, err := protocol.HeaderEncodeTo(make([]byte, 10), header); err == nil {
+		t.Error("expected error for short buffer")
+	}
+}
+
+func BenchmarkHeaderEncode(b *testing.B) {
+	header, _ := benchFrame(0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		protocol.HeaderEncode(header)
+	}
+}
+
+func BenchmarkHeaderEncodeTo(b *testing.B) {
+	header, _ := benchFrame(0)
+	buf := make([]byte, protocol.HeaderMaxSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		protocol.HeaderEncodeTo(buf, header)
+	}
+}
+
+func BenchmarkHeaderDecode(b *testing.B) {
+	header, _ := benchFrame(0)
+	encoded, _ := protocol.HeaderEncode(header)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		protocol.HeaderDecode(encoded)
+	}
+}
+
+func BenchmarkHeaderDecodeInto(b *testing.B) {
+	header, _ := benchFrame(0)
+	encoded, _ := protocol.HeaderEncode(header)
+	var decoded protocol.SocketHeader
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		protocol.HeaderDecodeInto(&decoded, encoded, protocol.ValidationOptions{})
+	}
+}
+
+func BenchmarkTCPWriteFrame(b *testing.B) {
+	header, payload := benchFrame(1024)
+	conn := newReplayTCPConn(b, header, payload)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		conn.WriteFrame(header, payload)
+	}
+}
+
+func BenchmarkTCPReadFrame(b *testing.B) {
+	header, payload := benchFrame(1024)
+	conn := newReplayTCPConn(b, header, payload)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := conn.ReadFrame(); err != nil {
+			b.Fatalf("ReadFrame failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkTCPReadFrameInto(b *testing.B) {
+	header, payload := benchFrame(1024)
+	conn := newReplayTCPConn(b, header, payload)
+	var decoded protocol.SocketHeader
+	buf := make([]byte, 0, 2048)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = conn.ReadFrameInto(&decoded, buf); err != nil {
+			b.Fatalf("ReadFrameInto failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkTCPWriteFrameCoalesced(b *testing.B) {
+	header, payload := benchFrame(64)
+	conn := protocol.NewTCPConnWrapper(&replayConn{frame: []byte{0}}, protocol.WithWriteCoalescing(protocol.WriteCoalescing{}))
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		h := *header
+		for pb.Next() {
+			conn.WriteFrame(&h, payload)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -48,7 +49,7 @@ func TestHeaderCodec_MultipleCases(t *testing.T) {
 				Router:    1,
 				Flags:     0,
 				Length:    100,
-				Timestamp: uint64(time.Now().UnixNano()),
+				Timestamp: uint64(time.Now().UnixMilli()),
 			}
 
 			if tc.broadcast {
@@ -96,3 +97,87 @@ func TestHeaderCodec_MultipleCases(t *testing.T) {
 		})
 	}
 }
+
+func TestHeaderCodec_OptionalFieldPresence(t *testing.T) {
+	receiver := uuid.New()
+	cases := []struct {
+		name        string
+		messageType protocol.MessageType
+		protocol    protocol.ProtocolType
+		receiver    uuid.UUID
+		sequence    uint32
+		wantSize    int
+	}{
+		{"Broadcast with nil receiver", protocol.MessageTypeBroadcast, protocol.ProtocolTCP, uuid.Nil, 0, protocol.HeaderMinSize},
+		{"Direct data with receiver", protocol.MessageTypeData, protocol.ProtocolTCP, receiver, 0, protocol.HeaderMinSize + 16},
+		{"Heartbeat with receiver", protocol.MessageTypeHeartbeat, protocol.ProtocolTCP, receiver, 0, protocol.HeaderMinSize + 16},
+		{"TCP with sequence", protocol.MessageTypeData, protocol.ProtocolTCP, uuid.Nil, 9, protocol.HeaderMinSize + 4},
+		{"UDP first packet", protocol.MessageTypeData, protocol.ProtocolUDP, uuid.Nil, 0, protocol.HeaderMinSize},
+		{"All optional fields", protocol.MessageTypeBroadcast, protocol.ProtocolUDP, receiver, 3, protocol.HeaderSize},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			header := &protocol.SocketHeader{
+				ID:          uuid.New(),
+				Sender:      uuid.New(),
+				Receiver:    tc.receiver,
+				Sequence:    tc.sequence,
+				Protocol:    tc.protocol,
+				MessageType: tc.messageType,
+			}
+
+			encoded, err := protocol.HeaderEncode(header)
+			if err != nil {
+				t.Fatalf("Failed to encode header: %v", err)
+			}
+			if len(encoded) != tc.wantSize {
+				t.Errorf("Encoded size mismatch: got %d, want %d", len(encoded), tc.wantSize)
+			}
+
+			decoded, err := protocol.HeaderDecode(encoded)
+			if err != nil {
+				t.Fatalf("Failed to decode header: %v", err)
+			}
+			if decoded.Receiver != tc.receiver {
+				t.Errorf("Receiver mismatch: got %v, want %v", decoded.Receiver, tc.receiver)
+			}
+			if decoded.Sequence != tc.sequence {
+				t.Errorf("Sequence mismatch: got %v, want %v", decoded.Sequence, tc.sequence)
+			}
+		})
+	}
+}
+
+func TestHeaderCodec_RejectsUnknownLayout(t *testing.T) {
+	encoded, err := protocol.HeaderEncode(&protocol.SocketHeader{
+		ID:          uuid.New(),
+		Sender:      uuid.New(),
+		MessageType: protocol.MessageTypeData,
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode header: %v", err)
+	}
+
+	// Version and Presence are the first two control bytes, after ID + Sender + Timestamp + Length
+	const versionOffset, presenceOffset = 48, 49
+
+	badVersion := append([]byte(nil), encoded...)
+	badVersion[versionOffset] = protocol.CurrentVersion + 1
+	if _, err := protocol.HeaderDecode(badVersion); !errors.Is(err, protocol.ErrUnsupportedVersion) {
+		t.Errorf("got %v, want ErrUnsupportedVersion", err)
+	}
+
+	badPresence := append([]byte(nil), encoded...)
+	badPresence[presenceOffset] = 0x80
+	if _, err := protocol.HeaderDecode(badPresence); !errors.Is(err, protocol.ErrInvalidPresence) {
+		t.Errorf("got %v, want ErrInvalidPresence", err)
+	}
+
+	// Presence claims a Receiver that is not there
+	missingField := append([]byte(nil), encoded...)
+	missingField[presenceOffset] = byte(protocol.PresenceReceiver)
+	if _, err := protocol.HeaderDecode(missingField); !errors.Is(err, protocol.ErrHeaderSize) {
+		t.Errorf("got %v, want ErrHeaderSize", err)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// userExtension is registered once for the tests below.
+const userExtension protocol.ExtensionKey = protocol.ExtensionUserBase + 5
+
+func init() {
+	if err := protocol.RegisterExtension(userExtension, "TenantID", 4); err != nil {
+		panic(err)
+	}
+}
+
+func TestHeaderExtensionsRoundTrip(t *testing.T) {
+	header := validHeader()
+	correlation := uuid.New()
+	header.SetCorrelationID(correlation)
+	if err := header.SetContentType("application/json"); err != nil {
+		t.Fatalf("SetContentType failed: %v", err)
+	}
+	if err := header.SetTraceContext("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"); err != nil {
+		t.Fatalf("SetTraceContext failed: %v", err)
+	}
+	header.SetFragment(2, 5)
+	if err := header.SetExt(userExtension, []byte{0, 0, 0, 42}); err != nil {
+		t.Fatalf("SetExt failed: %v", err)
+	}
+
+	encoded, err := protocol.HeaderEncode(header)
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+	if len(encoded) != header.HeaderSize() {
+		t.Errorf("encoded size mismatch: got %d, want %d", len(encoded), header.HeaderSize())
+	}
+
+	decoded, err := protocol.HeaderDecode(encoded)
+	if err != nil {
+		t.Fatalf("HeaderDecode failed: %v", err)
+	}
+	if id, ok := decoded.CorrelationID(); !ok || id != correlation {
+		t.Errorf("CorrelationID mismatch: got %v (%v), want %v", id, ok, correlation)
+	}
+	if ct, ok := decoded.ContentType(); !ok || ct != "application/json" {
+		t.Errorf("ContentType mismatch: got %q (%v)", ct, ok)
+	}
+	if _, ok := decoded.TraceContext(); !ok {
+		t.Error("TraceContext missing")
+	}
+	if index, count, ok := decoded.Fragment(); !ok || index != 2 || count != 5 {
+		t.Errorf("Fragment mismatch: got %d/%d (%v)", index, count, ok)
+	}
+	if value, ok := decoded.Ext(userExtension); !ok || !bytes.Equal(value, []byte{0, 0, 0, 42}) {
+		t.Errorf("user extension mismatch: got %v (%v)", value, ok)
+	}
+	if len(decoded.Extensions()) != 5 {
+		t.Errorf("extension count mismatch: got %d, want 5", len(decoded.Extensions()))
+	}
+}
+
+func TestHeaderExtensionsCopyValues(t *testing.T) {
+	header := validHeader()
+	buf := []byte{0, 0, 0, 42}
+	header.SetExt(userExtension, buf)
+	buf[3] = 7 // The caller reuses its buffer
+	if value, _ := header.Ext(userExtension); !bytes.Equal(value, []byte{0, 0, 0, 42}) {
+		t.Errorf("extension changed with the caller's buffer: %v", value)
+	}
+}
+
+func TestHeaderCloneSurvivesDecodeReuse(t *testing.T) {
+	encode := func(tenant byte) []byte {
+		header := validHeader()
+		header.SetExt(userExtension, []byte{0, 0, 0, tenant})
+		buf := make([]byte, protocol.FrameSize(header, 0))
+		if _, err := protocol.EncodeFrameTo(buf, header, nil); err != nil {
+			t.Fatalf("EncodeFrameTo failed: %v", err)
+		}
+		return buf
+	}
+
+	var header protocol.SocketHeader
+	if _, _, err := protocol.DecodeFrameInto(&header, encode(1), protocol.ValidationOptions{}); err != nil {
+		t.Fatalf("DecodeFrameInto failed: %v", err)
+	}
+	clone := header.Clone()
+	exts := header.Extensions()
+
+	// Decoding into the same header reuses its extension buffers
+	if _, _, err := protocol.DecodeFrameInto(&header, encode(2), protocol.ValidationOptions{}); err != nil {
+		t.Fatalf("DecodeFrameInto failed: %v", err)
+	}
+	if value, _ := clone.Ext(userExtension); !bytes.Equal(value, []byte{0, 0, 0, 1}) {
+		t.Errorf("clone changed with the decoded header: %v", value)
+	}
+	if !bytes.Equal(exts[0].Value, []byte{0, 0, 0, 1}) {
+		t.Errorf("Extensions changed with the decoded header: %v", exts[0].Value)
+	}
+}
+
+func TestHeaderExtensionsUnknownKeysAreKept(t *testing.T) {
+	header := validHeader()
+	unknown := protocol.ExtensionKey(0xEE)
+	if err := header.SetExt(unknown, []byte("opaque")); err != nil {
+		t.Fatalf("SetExt failed: %v", err)
+	}
+
+	encoded, err := protocol.HeaderEncode(header)
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+	decoded, err := protocol.HeaderDecode(encoded)
+	if err != nil {
+		t.Fatalf("HeaderDecode failed: %v", err)
+	}
+	if value, ok := decoded.Ext(unknown); !ok || string(value) != "opaque" {
+		t.Errorf("unknown extension not preserved: got %q (%v)", value, ok)
+	}
+}
+
+func TestHeaderExtensionsValidation(t *testing.T) {
+	header := validHeader()
+
+	if err := header.SetExt(protocol.ExtensionCorrelationID, []byte{1, 2, 3}); !errors.Is(err, protocol.ErrInvalidExtension) {
+		t.Errorf("wrong fixed size: got %v, want ErrInvalidExtension", err)
+	}
+	if err := header.SetExt(protocol.ExtensionInvalid, nil); !errors.Is(err, protocol.ErrInvalidExtension) {
+		t.Errorf("reserved key: got %v, want ErrInvalidExtension", err)
+	}
+	if err := protocol.RegisterExtension(protocol.ExtensionContentType, "Mine", 1); err == nil {
+		t.Error("expected error registering a reserved key")
+	}
+
+	header.SetContentType("text/plain")
+	header.DelExt(protocol.ExtensionContentType)
+	if _, ok := header.ContentType(); ok {
+		t.Error("DelExt did not remove the extension")
+	}
+
+	if err := header.SetExt(0xEE, make([]byte, protocol.MaxExtensionsSize)); err != nil {
+		t.Fatalf("SetExt failed: %v", err)
+	}
+	if _, err := protocol.HeaderEncode(header); !errors.Is(err, protocol.ErrInvalidExtension) {
+		t.Errorf("oversized extension area: got %v, want ErrInvalidExtension", err)
+	}
+}
+
+func TestTCPFrameWithLargeExtensions(t *testing.T) {
+	// Headers larger than 255 bytes need the 2-byte size prefix
+	header := validHeader()
+	header.SetTraceContext(strings.Repeat("x", 600))
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		protocol.NewTCPConnWrapper(client).WriteFrame(header, []byte("big header"))
+	}()
+
+	decoded, payload, err := protocol.NewTCPConnWrapper(server).ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if trace, _ := decoded.TraceContext(); len(trace) != 600 || string(payload) != "big header" {
+		t.Errorf("frame mismatch: trace=%d bytes payload=%q", len(trace), payload)
+	}
+}
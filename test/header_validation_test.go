@@ -0,0 +1,143 @@
+package test
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// Offsets of the control bytes in an encoded header:
+// ID(16) + Sender(16) + Timestamp(8) + Length(8) + Version(1) + Presence(1)
+const (
+	flagsOffset       = 50
+	messageTypeOffset = 51
+)
+
+func validHeader() *protocol.SocketHeader {
+	return &protocol.SocketHeader{
+		ID:          uuid.New(),
+		Sender:      uuid.New(),
+		MessageType: protocol.MessageTypeData,
+		Protocol:    protocol.ProtocolTCP,
+		Timestamp:   uint64(time.Now().UnixMilli()),
+	}
+}
+
+func TestHeaderValidation(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(h *protocol.SocketHeader)
+		opts   protocol.ValidationOptions
+		want   error
+	}{
+		{"Valid header", func(h *protocol.SocketHeader) {}, protocol.ValidationOptions{}, nil},
+		{"Combined flags", func(h *protocol.SocketHeader) {
+			h.Flags = protocol.SetFlag(protocol.FlagACK, protocol.FlagEncrypted)
+		}, protocol.ValidationOptions{}, nil},
+		{"Undefined flag bit", func(h *protocol.SocketHeader) { h.Flags = 0x80 }, protocol.ValidationOptions{}, protocol.ErrInvalidFlags},
+		{"Unknown message type", func(h *protocol.SocketHeader) { h.MessageType = 0x7F }, protocol.ValidationOptions{}, protocol.ErrInvalidMessageType},
+		{"Unknown message type allowed", func(h *protocol.SocketHeader) { h.MessageType = 0x7F },
+			protocol.ValidationOptions{AllowUnknownMessageTypes: true}, nil},
+		{"Uninitialized message type", func(h *protocol.SocketHeader) { h.MessageType = protocol.MessageTypeUnknown },
+			protocol.ValidationOptions{AllowUnknownMessageTypes: true}, protocol.ErrInvalidMessageType},
+		{"Invalid protocol", func(h *protocol.SocketHeader) { h.Protocol = 9 }, protocol.ValidationOptions{}, protocol.ErrInvalidProtocol},
+		{"Zero ID", func(h *protocol.SocketHeader) { h.ID = uuid.Nil }, protocol.ValidationOptions{}, protocol.ErrZeroID},
+		{"Zero sender", func(h *protocol.SocketHeader) { h.Sender = uuid.Nil }, protocol.ValidationOptions{}, protocol.ErrZeroID},
+		{"Zero timestamp", func(h *protocol.SocketHeader) { h.Timestamp = 0 }, protocol.ValidationOptions{}, protocol.ErrInvalidTimestamp},
+		{"Nanosecond timestamp", func(h *protocol.SocketHeader) { h.Timestamp = uint64(time.Now().UnixNano()) },
+			protocol.ValidationOptions{}, protocol.ErrInvalidTimestamp},
+		{"Future timestamp within custom skew", func(h *protocol.SocketHeader) {
+			h.Timestamp = uint64(time.Now().Add(2 * time.Hour).UnixMilli())
+		}, protocol.ValidationOptions{MaxClockSkew: 3 * time.Hour}, nil},
+		{"Disabled", func(h *protocol.SocketHeader) { h.ID = uuid.Nil; h.Flags = 0xFF },
+			protocol.ValidationOptions{Disabled: true}, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := validHeader()
+			tc.mutate(h)
+
+			err := h.Validate(tc.opts)
+			if tc.want == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.want != nil && !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestHeaderDecodeRejectsInvalidEnums(t *testing.T) {
+	encoded, err := protocol.HeaderEncode(validHeader())
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+
+	badType := append([]byte(nil), encoded...)
+	badType[messageTypeOffset] = 0x7F
+	if _, err := protocol.HeaderDecode(badType); !errors.Is(err, protocol.ErrInvalidMessageType) {
+		t.Errorf("got %v, want ErrInvalidMessageType", err)
+	}
+	h, err := protocol.HeaderDecodeWithOptions(badType, protocol.ValidationOptions{AllowUnknownMessageTypes: true})
+	if err != nil || h.MessageType != 0x7F {
+		t.Errorf("forward-compatible decode failed: header=%v err=%v", h, err)
+	}
+
+	badFlags := append([]byte(nil), encoded...)
+	badFlags[flagsOffset] = 0xF0
+	if _, err := protocol.HeaderDecode(badFlags); !errors.Is(err, protocol.ErrInvalidFlags) {
+		t.Errorf("got %v, want ErrInvalidFlags", err)
+	}
+}
+
+func TestTCPRejectsProtocolMismatchAndKeepsReading(t *testing.T) {
+	// A UDP header (with Sequence) carried over a TCP stream, followed by a valid TCP frame
+	udpHeader := validHeader()
+	udpHeader.Protocol = protocol.ProtocolUDP
+	udpHeader.Sequence = 7
+	encoded, err := protocol.HeaderEncode(udpHeader)
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+	mismatched := binary.BigEndian.AppendUint16(nil, uint16(len(encoded)))
+	mismatched = append(mismatched, encoded...)
+	mismatched = append(mismatched, 0, 0, 0, 0) // empty payload checksum
+
+	valid := encodeTCPFrame(t, validHeader(), []byte("after"))
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		defer client.Close()
+		client.Write(append(mismatched, valid...))
+	}()
+
+	wrapped := protocol.NewTCPConnWrapper(server)
+	if _, _, err := wrapped.ReadFrame(); !errors.Is(err, protocol.ErrProtocolMismatch) || !protocol.IsDroppable(err) {
+		t.Fatalf("got %v, want droppable ErrProtocolMismatch", err)
+	}
+
+	_, payload, err := wrapped.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame after rejected frame failed: %v", err)
+	}
+	if string(payload) != "after" {
+		t.Errorf("payload mismatch: got %q, want %q", payload, "after")
+	}
+}
+
+func TestFlagString(t *testing.T) {
+	if got := protocol.SetFlag(protocol.FlagACK, protocol.FlagCompressed).String(); got != "ACK|Compressed" {
+		t.Errorf("got %q, want %q", got, "ACK|Compressed")
+	}
+	if got := protocol.FlagNone.String(); got != "None" {
+		t.Errorf("got %q, want %q", got, "None")
+	}
+}
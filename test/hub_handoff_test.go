@@ -0,0 +1,160 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// Environment of the child process started by TestHubHandoff
+const (
+	handoffChildEnv = "SOCKETHUB_TEST_HANDOFF_CHILD"
+	handoffUnixEnv  = "SOCKETHUB_TEST_HANDOFF_UNIX"
+)
+
+// handoffConfig returns the listeners shared by the parent and child hubs.
+func handoffConfig(unixPath string) *sockethub_config.SocketConfig {
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Listeners = []sockethub_config.ListenerConfig{
+		{Name: "tcp", Network: sockethub_config.NetworkTCP, Address: "127.0.0.1:0"},
+		{Name: "unix", Network: sockethub_config.NetworkUnix, Address: unixPath},
+	}
+	return cfg
+}
+
+// replyWith answers every frame on the router with a fixed payload.
+func replyWith(hub *sockethub.SocketHub, router uint8, reply string) {
+	hub.Handle(router, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) {
+		c.Send(dataFrame(router), []byte(reply))
+	})
+}
+
+// ask sends a frame on router 1 and returns the reply.
+func ask(t *testing.T, conn protocol.Conn) string {
+	t.Helper()
+
+	if err := conn.WriteFrame(dataFrame(1), nil); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	_, payload := readData(t, conn)
+	return string(payload)
+}
+
+// TestHandoffChildProcess is the new process in TestHubHandoff; it serves until stdin closes.
+func TestHandoffChildProcess(t *testing.T) {
+	if os.Getenv(handoffChildEnv) == "" {
+		t.Skip("only runs as the child of TestHubHandoff")
+	}
+
+	hub := newTestHub(t, handoffConfig(os.Getenv(handoffUnixEnv)))
+	replyWith(hub, 1, "child")
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	io.Copy(io.Discard, os.Stdin)
+}
+
+func TestHubHandoff(t *testing.T) {
+	unixPath := filepath.Join(socketDir(t), "handoff.sock")
+	hub := newTestHub(t, handoffConfig(unixPath))
+	replyWith(hub, 1, "parent")
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	tcpAddr, _ := hub.ListenerAddr("tcp")
+
+	nc, err := net.Dial("tcp", tcpAddr.String())
+	if err != nil {
+		t.Fatalf("TCP dial failed: %v", err)
+	}
+	old := protocol.NewTCPConnWrapper(nc)
+	defer old.Close()
+	if got := ask(t, old); got != "parent" {
+		t.Fatalf("reply = %q, want parent", got)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHandoffChildProcess$")
+	cmd.Env = append(os.Environ(), handoffChildEnv+"=1", handoffUnixEnv+"="+unixPath)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("StdinPipe failed: %v", err)
+	}
+	defer func() {
+		stdin.Close()
+		cmd.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := hub.Handoff(ctx, cmd); err != nil {
+		t.Fatalf("Handoff failed: %v", err)
+	}
+
+	// The existing client was drained by the old hub
+	expectGoingAway(t, old)
+	if _, _, err := old.ReadFrame(); err == nil {
+		t.Fatal("old connection still open after handoff")
+	}
+
+	// The same addresses are now served by the child
+	nc, err = net.Dial("tcp", tcpAddr.String())
+	if err != nil {
+		t.Fatalf("TCP dial after handoff failed: %v", err)
+	}
+	fresh := protocol.NewTCPConnWrapper(nc)
+	defer fresh.Close()
+	if got := ask(t, fresh); got != "child" {
+		t.Fatalf("TCP reply = %q, want child", got)
+	}
+
+	unix, err := protocol.DialUnix(context.Background(), unixPath)
+	if err != nil {
+		t.Fatalf("Unix dial after handoff failed: %v", err)
+	}
+	defer unix.Close()
+	if got := ask(t, unix); got != "child" {
+		t.Fatalf("Unix reply = %q, want child", got)
+	}
+}
+
+func TestHubHandoffWithoutSockets(t *testing.T) {
+	hub := newTestHub(t, nil)
+	serveMemory(t, hub, sockethub_config.ListenerConfig{})
+
+	err := hub.Handoff(context.Background(), exec.Command(os.Args[0], "-test.run=^$"))
+	if !errors.Is(err, sockethub.ErrNoHandoffSockets) {
+		t.Fatalf("Handoff: err = %v, want ErrNoHandoffSockets", err)
+	}
+	select {
+	case <-hub.Done():
+		t.Fatal("hub closed after a failed handoff")
+	default:
+	}
+}
+
+func TestHubHandoffChildNotReady(t *testing.T) {
+	hub := newTestHub(t, handoffConfig(filepath.Join(socketDir(t), "handoff.sock")))
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	// The child exits without starting a hub
+	err := hub.Handoff(context.Background(), exec.Command(os.Args[0], "-test.run=^$"))
+	if !errors.Is(err, sockethub.ErrChildNotReady) {
+		t.Fatalf("Handoff: err = %v, want ErrChildNotReady", err)
+	}
+	if _, ok := hub.ListenerAddr("tcp"); !ok {
+		t.Fatal("listener closed after a failed handoff")
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// expectGoingAway reads the next frame and checks it is the hub's going-away notice.
+func expectGoingAway(t *testing.T, conn protocol.Conn) {
+	t.Helper()
+
+	header, payload := readData(t, conn)
+	code, reason, err := protocol.ParseControl(header, payload)
+	if err != nil {
+		t.Fatalf("ParseControl failed: %v", err)
+	}
+	if code != protocol.ControlGoingAway || string(reason) != sockethub.ShutdownReason {
+		t.Fatalf("control frame = %v %q, want going away %q", code, reason, sockethub.ShutdownReason)
+	}
+}
+
+func TestControlFrameRoundTrip(t *testing.T) {
+	header, payload := protocol.GoingAwayFrame("maintenance")
+	if header.MessageType != protocol.MessageTypeControl {
+		t.Fatalf("MessageType = %v, want control", header.MessageType)
+	}
+	code, body, err := protocol.ParseControl(header, payload)
+	if err != nil || code != protocol.ControlGoingAway || string(body) != "maintenance" {
+		t.Fatalf("ParseControl = %v %q %v", code, body, err)
+	}
+
+	if _, _, err := protocol.ParseControl(dataFrame(0), payload); !errors.Is(err, protocol.ErrInvalidControl) {
+		t.Fatalf("data frame: err = %v, want ErrInvalidControl", err)
+	}
+	if _, _, err := protocol.ParseControl(header, nil); !errors.Is(err, protocol.ErrInvalidControl) {
+		t.Fatalf("empty payload: err = %v, want ErrInvalidControl", err)
+	}
+}
+
+func TestHubShutdownDrainsClients(t *testing.T) {
+	hub := newTestHub(t, nil)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	conn, _ := dialMemory(t, hub, l)
+
+	// The handler is still running when Shutdown starts; its replies must be delivered
+	started := make(chan struct{})
+	hub.Handle(1, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		for _, p := range []string{"one", "two", "three"} {
+			c.Send(dataFrame(1), []byte(p))
+		}
+	})
+	if err := conn.WriteFrame(dataFrame(1), []byte("work")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	<-started
+
+	done := make(chan error, 1)
+	go func() { done <- hub.Shutdown(context.Background()) }()
+
+	expectGoingAway(t, conn)
+	for _, want := range []string{"one", "two", "three"} {
+		if _, payload := readData(t, conn); string(payload) != want {
+			t.Fatalf("payload = %q, want %q", payload, want)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, _, err := conn.ReadFrameContext(ctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("read after shutdown: err = %v, want connection closed", err)
+	}
+	if hub.ClientCount() != 0 {
+		t.Fatalf("ClientCount = %d after shutdown", hub.ClientCount())
+	}
+	select {
+	case <-hub.Done():
+	default:
+		t.Fatal("hub not closed after Shutdown")
+	}
+}
+
+func TestHubShutdownStopsAccepting(t *testing.T) {
+	hub := newTestHub(t, nil)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+
+	if err := hub.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if _, err := l.Dial(context.Background()); err == nil {
+		t.Fatal("Dial succeeded after shutdown")
+	}
+	if err := hub.Shutdown(context.Background()); !errors.Is(err, sockethub.ErrHubClosed) {
+		t.Fatalf("second Shutdown: err = %v, want ErrHubClosed", err)
+	}
+}
+
+func TestHubShutdownForceClosesOnTimeout(t *testing.T) {
+	hub := newTestHub(t, nil)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	conn, _ := dialMemory(t, hub, l)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	hub.Handle(1, func(*sockethub.Client, *protocol.SocketHeader, []byte) {
+		close(started)
+		<-release
+	})
+	if err := conn.WriteFrame(dataFrame(1), nil); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := hub.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown: err = %v, want DeadlineExceeded", err)
+	}
+
+	expectGoingAway(t, conn)
+	rctx, rcancel := context.WithTimeout(context.Background(), time.Second)
+	defer rcancel()
+	if _, _, err := conn.ReadFrameContext(rctx); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("read after forced shutdown: err = %v, want connection closed", err)
+	}
+}
@@ -0,0 +1,350 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// newTestHub creates a hub without configured listeners, closed at the end of the test.
+func newTestHub(t *testing.T, cfg *sockethub_config.SocketConfig) *sockethub.SocketHub {
+	t.Helper()
+
+	if cfg == nil {
+		cfg = sockethub_config.DefaultConfig()
+		cfg.Listeners = nil
+	}
+	hub, err := sockethub.New(cfg, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { hub.Close() })
+	return hub
+}
+
+// serveMemory adds an in-memory listener to hub and returns it.
+func serveMemory(t *testing.T, hub *sockethub.SocketHub, lc sockethub_config.ListenerConfig) *protocol.MemoryListener {
+	t.Helper()
+
+	if lc.Name == "" {
+		lc.Name = "memory"
+	}
+	l := protocol.NewMemoryListener(lc.Name, protocol.MemoryLink{})
+	if err := hub.Serve(l, lc); err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	return l
+}
+
+// memoryHub starts a hub with no configured listeners, after configure (if not nil) has
+// adjusted its config, and serves it on a memory listener.
+func memoryHub(t *testing.T, configure func(cfg *sockethub_config.SocketConfig)) (*sockethub.SocketHub, *protocol.MemoryListener) {
+	t.Helper()
+
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Listeners = nil
+	if configure != nil {
+		configure(cfg)
+	}
+	hub := newTestHub(t, cfg)
+	return hub, serveMemory(t, hub, sockethub_config.ListenerConfig{})
+}
+
+// dialMemory connects a client and waits until the hub has registered it.
+func dialMemory(t *testing.T, hub *sockethub.SocketHub, l *protocol.MemoryListener) (protocol.Conn, uuid.UUID) {
+	t.Helper()
+
+	before := make(map[uuid.UUID]bool)
+	for _, c := range hub.Clients() {
+		before[c.ID] = true
+	}
+	conn, err := l.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var id uuid.UUID
+	waitFor(t, "client registration", func() bool {
+		for _, c := range hub.Clients() {
+			if !before[c.ID] {
+				id = c.ID
+				return true
+			}
+		}
+		return false
+	})
+	return conn, id
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readData reads the next non-heartbeat frame, failing the test after a timeout.
+func readData(t *testing.T, conn protocol.Conn) (*protocol.SocketHeader, []byte) {
+	t.Helper()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		header, payload, err := conn.ReadFrameContext(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("ReadFrameContext failed: %v", err)
+		}
+		if header.MessageType != protocol.MessageTypeHeartbeat {
+			return header, payload
+		}
+	}
+}
+
+// expectNothing asserts that no data frame arrives within a short window.
+func expectNothing(t *testing.T, conn protocol.Conn) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if header, payload, err := conn.ReadFrameContext(ctx); err == nil {
+		t.Fatalf("unexpected frame %v: %q", header.MessageType, payload)
+	}
+}
+
+func dataFrame(router uint8) *protocol.SocketHeader {
+	return &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: router}
+}
+
+func TestHubServesMultipleTransports(t *testing.T) {
+	cfg := sockethub_config.DefaultConfig()
+	cfg.Listeners = []sockethub_config.ListenerConfig{
+		{Name: "tcp", Network: sockethub_config.NetworkTCP, Address: "127.0.0.1:0"},
+		{Name: "udp", Network: sockethub_config.NetworkUDP, Address: "127.0.0.1:0"},
+		{Name: "unix", Network: sockethub_config.NetworkUnix, Address: filepath.Join(socketDir(t), "hub.sock")},
+		{Name: "ws", Network: sockethub_config.NetworkWebSocket, Address: "127.0.0.1:0", Path: "/ws"},
+	}
+	hub := newTestHub(t, cfg)
+	if err := hub.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	memory := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+
+	addr := func(name string) string {
+		a, ok := hub.ListenerAddr(name)
+		if !ok {
+			t.Fatalf("listener %s not found", name)
+		}
+		return a.String()
+	}
+
+	// One client per transport
+	clients := make(map[string]protocol.Conn)
+	tcpConn, err := net.Dial("tcp", addr("tcp"))
+	if err != nil {
+		t.Fatalf("TCP dial failed: %v", err)
+	}
+	clients["tcp"] = protocol.NewTCPConnWrapper(tcpConn)
+
+	udpPC, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("UDP listen failed: %v", err)
+	}
+	udpAddr, _ := hub.ListenerAddr("udp")
+	clients["udp"] = protocol.NewUDPConnWrapper(udpPC, udpAddr, 65535)
+	defer udpPC.Close()
+
+	if clients["unix"], err = protocol.DialUnix(context.Background(), addr("unix")); err != nil {
+		t.Fatalf("Unix dial failed: %v", err)
+	}
+	if clients["ws"], err = protocol.DialWebSocket(context.Background(), "ws://"+addr("ws")+"/ws"); err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	if clients["memory"], err = memory.Dial(context.Background()); err != nil {
+		t.Fatalf("Memory dial failed: %v", err)
+	}
+	for _, c := range clients {
+		defer c.Close()
+	}
+
+	// A datagram peer only exists once it has sent something
+	clients["udp"].WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeHeartbeat}, nil)
+	waitFor(t, "five clients", func() bool { return hub.ClientCount() == 5 })
+
+	ids := make(map[string]uuid.UUID)
+	for _, c := range hub.Clients() {
+		ids[c.Listener] = c.ID
+	}
+
+	// A broadcast from TCP reaches every other transport
+	if err := clients["tcp"].WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeBroadcast}, []byte("hello all")); err != nil {
+		t.Fatalf("broadcast failed: %v", err)
+	}
+	for name, c := range clients {
+		if name == "tcp" {
+			continue
+		}
+		header, payload := readData(t, c)
+		if string(payload) != "hello all" || header.Sender != ids["tcp"] {
+			t.Errorf("%s client got %q from %v", name, payload, header.Sender)
+		}
+	}
+	expectNothing(t, clients["tcp"])
+
+	// Direct messages cross transports in both directions
+	for _, pair := range [][2]string{{"ws", "udp"}, {"udp", "unix"}, {"unix", "memory"}, {"memory", "ws"}} {
+		from, to := pair[0], pair[1]
+		header := dataFrame(1)
+		header.Receiver = ids[to]
+		if err := clients[from].WriteFrame(header, []byte(from+"->"+to)); err != nil {
+			t.Fatalf("%s write failed: %v", from, err)
+		}
+		got, payload := readData(t, clients[to])
+		if string(payload) != from+"->"+to || got.Sender != ids[from] {
+			t.Errorf("%s received %q from %v", to, payload, got.Sender)
+		}
+	}
+}
+
+func TestHubRooms(t *testing.T) {
+	hub := newTestHub(t, nil)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+
+	alice, aliceID := dialMemory(t, hub, l)
+	bob, bobID := dialMemory(t, hub, l)
+	carol, _ := dialMemory(t, hub, l)
+
+	if err := hub.Join(aliceID, "lobby"); err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	hub.Join(bobID, "lobby")
+	if got := len(hub.RoomMembers("lobby")); got != 2 {
+		t.Fatalf("lobby has %d members, want 2", got)
+	}
+
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeBroadcast, Receiver: sockethub.RoomID("lobby")}
+	alice.WriteFrame(header, []byte("lobby only"))
+	if _, payload := readData(t, bob); string(payload) != "lobby only" {
+		t.Errorf("bob got %q", payload)
+	}
+	expectNothing(t, carol)
+	expectNothing(t, alice)
+
+	// Broadcasting to an unknown room is answered with an error frame
+	header = &protocol.SocketHeader{MessageType: protocol.MessageTypeBroadcast, Receiver: sockethub.RoomID("nowhere")}
+	carol.WriteFrame(header, nil)
+	reply, payload := readData(t, carol)
+	if e, err := sockethub.ErrorFromFrame(reply, payload); err != nil || !errors.Is(e, sockethub.ErrUnknownReceiver) {
+		t.Errorf("expected unknown receiver error, got %v, %v", e, err)
+	}
+
+	// Members leave rooms when they disconnect
+	bob.Close()
+	waitFor(t, "bob to leave", func() bool { return len(hub.RoomMembers("lobby")) == 1 })
+}
+
+func TestHubRouterHandlers(t *testing.T) {
+	hub := newTestHub(t, nil)
+	hub.Handle(7, func(c *sockethub.Client, header *protocol.SocketHeader, payload []byte) {
+		c.Send(dataFrame(7), append([]byte("echo: "), payload...))
+	})
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	conn, _ := dialMemory(t, hub, l)
+
+	conn.WriteFrame(dataFrame(7), []byte("hi"))
+	if header, payload := readData(t, conn); string(payload) != "echo: hi" || header.Sender != hub.ID() {
+		t.Errorf("unexpected reply %q from %v", payload, header.Sender)
+	}
+
+	// Direct messages to unknown clients are rejected
+	header := dataFrame(1)
+	header.Receiver = uuid.New()
+	conn.WriteFrame(header, nil)
+	reply, payload := readData(t, conn)
+	if !sockethub.IsErrorFrame(reply) {
+		t.Fatalf("expected error frame, got %v %q", reply.MessageType, payload)
+	}
+	if e, _ := sockethub.ErrorFromFrame(reply, payload); e.RequestID != header.ID {
+		t.Errorf("error not correlated to request: %v", e.RequestID)
+	}
+}
+
+func TestHubSendCopiesHeaderExtensions(t *testing.T) {
+	hub, l := memoryHub(t, nil)
+	conn, connID := dialMemory(t, hub, l)
+
+	// The first frame holds up the write loop, so the second is still queued when its header changes
+	hub.Send(connID, dataFrame(1), nil)
+	header := dataFrame(2)
+	header.SetContentType("application/json")
+	hub.Send(connID, header, nil)
+	header.SetContentType("text/plain")
+
+	readData(t, conn)
+	if got, _ := readData(t, conn); got.Router != 2 {
+		t.Fatalf("unexpected frame router %d", got.Router)
+	} else if ct, _ := got.ContentType(); ct != "application/json" {
+		t.Errorf("queued frame has content type %q, want the one set when it was sent", ct)
+	}
+}
+
+func TestHubPerListenerLimits(t *testing.T) {
+	hub := newTestHub(t, nil)
+	limited := serveMemory(t, hub, sockethub_config.ListenerConfig{Name: "limited", MaxClients: 1})
+	open := serveMemory(t, hub, sockethub_config.ListenerConfig{Name: "open"})
+
+	dialMemory(t, hub, limited)
+	rejected, err := limited.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if _, _, err := rejected.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected rejected client to be closed, got %v", err)
+	}
+
+	// Other listeners are unaffected
+	dialMemory(t, hub, open)
+	if got := hub.ClientCount(); got != 2 {
+		t.Errorf("client count: got %d, want 2", got)
+	}
+
+	if err := hub.Serve(protocol.NewMemoryListener("dup", protocol.MemoryLink{}), sockethub_config.ListenerConfig{Name: "open"}); !errors.Is(err, sockethub.ErrListenerExists) {
+		t.Errorf("expected ErrListenerExists, got %v", err)
+	}
+}
+
+func TestHubStopsOnContextCancel(t *testing.T) {
+	hub := newTestHub(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := hub.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	conn, _ := dialMemory(t, hub, l)
+
+	cancel()
+	select {
+	case <-hub.Done():
+	case <-time.After(time.Second):
+		t.Fatal("hub did not stop after cancel")
+	}
+	if _, _, err := conn.ReadFrame(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected client connection to end, got %v", err)
+	}
+	waitFor(t, "clients to be removed", func() bool { return hub.ClientCount() == 0 })
+}
@@ -0,0 +1,180 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+func memoryHeader() *protocol.SocketHeader {
+	return &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: 42}
+}
+
+func TestMemoryPipeRoundTrip(t *testing.T) {
+	client, server := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	defer client.Close()
+	defer server.Close()
+
+	if err := client.WriteFrame(memoryHeader(), []byte("hello from memory client")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	header, payload, err := server.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if string(payload) != "hello from memory client" {
+		t.Errorf("unexpected payload: %q", payload)
+	}
+	if header.Protocol != protocol.ProtocolMemory || header.Router != 42 {
+		t.Errorf("unexpected header: protocol %v, router %d", header.Protocol, header.Router)
+	}
+	if header.Sender != client.GetSender() {
+		t.Errorf("sender mismatch: got %v, want %v", header.Sender, client.GetSender())
+	}
+
+	// Closing one end drains queued frames, then reports EOF
+	client.WriteFrame(memoryHeader(), []byte("last"))
+	client.Close()
+	if _, payload, err := server.ReadFrame(); err != nil || string(payload) != "last" {
+		t.Fatalf("expected queued frame after close, got %q, %v", payload, err)
+	}
+	if _, _, err := server.ReadFrame(); !errors.Is(err, io.EOF) || !protocol.IsFatal(err) {
+		t.Errorf("expected fatal EOF, got %v", err)
+	}
+	if err := server.WriteFrame(memoryHeader(), nil); err == nil {
+		t.Error("expected error writing to a closed peer")
+	}
+}
+
+func TestMemoryPipeLatency(t *testing.T) {
+	client, server := protocol.NewMemoryPipe(protocol.MemoryLink{Latency: 30 * time.Millisecond})
+	defer client.Close()
+
+	start := time.Now()
+	client.WriteFrame(memoryHeader(), []byte("delayed"))
+	if _, _, err := server.ReadFrame(); err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("frame arrived after %v, want at least 30ms", elapsed)
+	}
+}
+
+func TestMemoryPipeBandwidth(t *testing.T) {
+	// 10 frames of ~1KB at 100KB/s take ~100ms on the wire
+	client, server := protocol.NewMemoryPipe(protocol.MemoryLink{Bandwidth: 100 * 1024})
+	defer client.Close()
+
+	start := time.Now()
+	go func() {
+		for i := 0; i < 10; i++ {
+			client.WriteFrame(memoryHeader(), make([]byte, 1024))
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if _, _, err := server.ReadFrame(); err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("10KB arrived after %v, want at least ~100ms", elapsed)
+	}
+}
+
+// receiveAll writes n numbered frames over link and returns the numbers received before the reader times out.
+func receiveAll(t *testing.T, link protocol.MemoryLink, n int) []int {
+	t.Helper()
+
+	client, server := protocol.NewMemoryPipe(link)
+	defer client.Close()
+	for i := 0; i < n; i++ {
+		if err := client.WriteFrame(memoryHeader(), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	var got []int
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, payload, err := server.ReadFrameContext(ctx)
+		cancel()
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("unexpected read error: %v", err)
+			}
+			return got
+		}
+		var i int
+		fmt.Sscan(string(payload), &i)
+		got = append(got, i)
+	}
+}
+
+func TestMemoryPipeLossIsDeterministic(t *testing.T) {
+	link := protocol.MemoryLink{LossRate: 0.3, Seed: 7}
+	first, second := receiveAll(t, link, 100), receiveAll(t, link, 100)
+
+	if len(first) == 0 || len(first) == 100 {
+		t.Fatalf("expected partial loss, received %d of 100", len(first))
+	}
+	if fmt.Sprint(first) != fmt.Sprint(second) {
+		t.Errorf("same seed produced different losses:\n%v\n%v", first, second)
+	}
+}
+
+func TestMemoryPipeReorder(t *testing.T) {
+	got := receiveAll(t, protocol.MemoryLink{ReorderRate: 0.3, ReorderGap: 5 * time.Millisecond, Seed: 3}, 50)
+	if len(got) != 50 {
+		t.Fatalf("reordering must not drop frames: received %d of 50", len(got))
+	}
+
+	inOrder := true
+	for i := 1; i < len(got); i++ {
+		if got[i] < got[i-1] {
+			inOrder = false
+		}
+	}
+	if inOrder {
+		t.Error("expected some frames out of order")
+	}
+}
+
+func TestMemoryListener(t *testing.T) {
+	listener := protocol.NewMemoryListener("hub", protocol.MemoryLink{})
+
+	accepted := make(chan protocol.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		accepted <- conn
+	}()
+
+	client, err := listener.Dial(context.Background())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	server := <-accepted
+
+	client.WriteFrame(memoryHeader(), []byte("ping"))
+	if _, payload, err := server.ReadFrame(); err != nil || string(payload) != "ping" {
+		t.Fatalf("server read: %q, %v", payload, err)
+	}
+	if client.RemoteAddr().String() != server.LocalAddr().String() {
+		t.Errorf("address mismatch: %v vs %v", client.RemoteAddr(), server.LocalAddr())
+	}
+
+	listener.Close()
+	if _, err := listener.Accept(); err == nil {
+		t.Error("expected Accept to fail after Close")
+	}
+	if _, err := listener.Dial(context.Background()); err == nil {
+		t.Error("expected Dial to fail after Close")
+	}
+}
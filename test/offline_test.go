@@ -0,0 +1,271 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/offline"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+func offlineEntry(i int, expires time.Time) offline.Entry {
+	return offline.Entry{Header: dataFrame(uint8(i)), Payload: fmt.Appendf(nil, "msg %d", i), Expires: expires}
+}
+
+func TestOfflineStores(t *testing.T) {
+	file, err := offline.NewFileStore(t.TempDir(), false)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	for name, store := range map[string]offline.Store{"memory": offline.NewMemoryStore(), "file": file} {
+		t.Run(name, func(t *testing.T) {
+			to := uuid.New()
+			now := time.Now()
+			store.Append(to, offlineEntry(0, time.Time{}))
+			store.Append(to, offlineEntry(1, now.Add(time.Minute)))
+			store.Append(to, offlineEntry(2, now.Add(-time.Second))) // Already expired
+			store.Append(to, offlineEntry(3, time.Time{}))
+
+			if count, size, err := store.Usage(to, now); err != nil || count != 3 || size != 15 {
+				t.Errorf("Usage = %d, %d, %v", count, size, err)
+			}
+			if receivers, size, err := store.Totals(now); err != nil || receivers != 1 || size != 15 {
+				t.Errorf("Totals = %d, %d, %v", receivers, size, err)
+			}
+			// Expire keeps the entries that are still valid
+			if err := store.Expire(now); err != nil {
+				t.Fatalf("Expire failed: %v", err)
+			}
+			entries, err := store.Take(to, now)
+			if err != nil || len(entries) != 3 {
+				t.Fatalf("Take = %d entries, %v", len(entries), err)
+			}
+			for i, want := range []uint8{0, 1, 3} {
+				if entries[i].Header.Router != want || string(entries[i].Payload) != fmt.Sprintf("msg %d", want) {
+					t.Errorf("entry %d = router %d %q", i, entries[i].Header.Router, entries[i].Payload)
+				}
+			}
+			if entries, _ := store.Take(to, now); len(entries) != 0 {
+				t.Errorf("Take did not remove the queue: %d entries left", len(entries))
+			}
+
+			// Entries expire while queued
+			store.Append(to, offlineEntry(4, now.Add(time.Second)))
+			if entries, _ := store.Take(to, now.Add(2*time.Second)); len(entries) != 0 {
+				t.Errorf("expired entry delivered")
+			}
+		})
+	}
+}
+
+func TestOfflineFileStoreRecovers(t *testing.T) {
+	dir := t.TempDir()
+	store, _ := offline.NewFileStore(dir, true)
+	to := uuid.New()
+	for i := range 3 {
+		store.Append(to, offlineEntry(i, time.Time{}))
+	}
+
+	// Simulate a crash in the middle of the last append
+	path := filepath.Join(dir, to.String()+".queue")
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, _ := offline.NewFileStore(dir, true)
+	if count, _, err := reopened.Usage(to, time.Now()); err != nil || count != 2 {
+		t.Fatalf("Usage after torn write = %d, %v", count, err)
+	}
+	reopened.Append(to, offlineEntry(9, time.Time{}))
+	entries, err := reopened.Take(to, time.Now())
+	if err != nil || len(entries) != 3 || entries[2].Header.Router != 9 {
+		t.Fatalf("Take after recovery = %d entries, %v", len(entries), err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("queue file not removed: %v", err)
+	}
+}
+
+// bindAndLeave binds a client to address and disconnects it, so frames for the address are
+// queued from then on.
+func bindAndLeave(t *testing.T, hub *sockethub.SocketHub, l *protocol.MemoryListener, address uuid.UUID) {
+	t.Helper()
+
+	conn, connID := dialMemory(t, hub, l)
+	if err := hub.Bind(connID, address); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	conn.Close()
+	waitFor(t, "client to disconnect", func() bool {
+		_, ok := hub.Client(address)
+		return !ok
+	})
+}
+
+func directFrame(to uuid.UUID, router uint8) *protocol.SocketHeader {
+	header := dataFrame(router)
+	header.Receiver = to
+	return header
+}
+
+func TestHubQueuesDirectMessagesForOfflineReceivers(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.OfflineQueue = &sockethub_config.OfflineQueueConfig{MaxMessages: 3}
+	})
+	sender, senderID := dialMemory(t, hub, l)
+	user := uuid.New() // Stable address of a user who is offline
+	bindAndLeave(t, hub, l, user)
+
+	for i := range 3 {
+		sender.WriteFrame(directFrame(user, uint8(i)), fmt.Appendf(nil, "msg %d", i))
+	}
+	expectNothing(t, sender)
+
+	// Frames beyond the cap are refused like before
+	overflow := directFrame(user, 9)
+	sender.WriteFrame(overflow, []byte("too many"))
+	reply, payload := readData(t, sender)
+	if e, err := sockethub.ErrorFromFrame(reply, payload); err != nil || !errors.Is(e, sockethub.ErrUnknownReceiver) || e.RequestID != overflow.ID {
+		t.Fatalf("expected ErrUnknownReceiver for the overflow, got %v, %v", e, err)
+	}
+
+	// The user connects and the application binds their address: the queue arrives in order
+	conn, connID := dialMemory(t, hub, l)
+	if err := hub.Bind(connID, user); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	for i := range 3 {
+		header, payload := readData(t, conn)
+		if header.Router != uint8(i) || string(payload) != fmt.Sprintf("msg %d", i) || header.Sender != senderID {
+			t.Fatalf("frame %d: router %d %q from %v", i, header.Router, payload, header.Sender)
+		}
+	}
+
+	// Bound clients are reached directly, and their frames carry the address as Sender
+	sender.WriteFrame(directFrame(user, 5), []byte("live"))
+	if _, payload := readData(t, conn); string(payload) != "live" {
+		t.Errorf("unexpected live frame %q", payload)
+	}
+	conn.WriteFrame(directFrame(senderID, 6), []byte("reply"))
+	if header, _ := readData(t, sender); header.Sender != user {
+		t.Errorf("reply Sender = %v, want the bound address %v", header.Sender, user)
+	}
+	if c, ok := hub.Client(user); !ok || c.ID != connID || c.Address() != user {
+		t.Errorf("Client(address) = %v, %v", c, ok)
+	}
+
+	// A reconnect takes the address over from the stale connection
+	again, againID := dialMemory(t, hub, l)
+	if err := hub.Bind(againID, user); err != nil {
+		t.Fatalf("second Bind failed: %v", err)
+	}
+	waitFor(t, "stale connection to close", func() bool {
+		_, ok := hub.Client(connID)
+		return !ok
+	})
+	sender.WriteFrame(directFrame(user, 7), []byte("after takeover"))
+	if _, payload := readData(t, again); string(payload) != "after takeover" {
+		t.Errorf("unexpected frame %q", payload)
+	}
+
+	if err := hub.Bind(againID, senderID); !errors.Is(err, sockethub.ErrAddressInUse) {
+		t.Errorf("expected ErrAddressInUse binding another client's ID, got %v", err)
+	}
+}
+
+func TestHubOfflineQueueLimits(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.OfflineQueue = &sockethub_config.OfflineQueueConfig{TTL: 50 * time.Millisecond, MaxBytes: 10}
+	})
+	sender, _ := dialMemory(t, hub, l)
+	user := uuid.New()
+	if err := hub.Send(user, dataFrame(1), nil); !errors.Is(err, sockethub.ErrUnknownReceiver) {
+		t.Errorf("expected an address that was never bound to be refused, got %v", err)
+	}
+	bindAndLeave(t, hub, l, user)
+
+	if err := hub.Send(user, dataFrame(1), []byte("0123456789")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if err := hub.Send(user, dataFrame(1), []byte("x")); !errors.Is(err, sockethub.ErrUnknownReceiver) {
+		t.Errorf("expected the byte cap to refuse the frame, got %v", err)
+	}
+
+	// Once the TTL passes the queue is empty again
+	time.Sleep(60 * time.Millisecond)
+	sender.WriteFrame(directFrame(user, 2), []byte("fresh"))
+	expectNothing(t, sender)
+
+	conn, connID := dialMemory(t, hub, l)
+	hub.Bind(connID, user)
+	if header, payload := readData(t, conn); header.Router != 2 || string(payload) != "fresh" {
+		t.Errorf("unexpected frame router %d %q", header.Router, payload)
+	}
+	expectNothing(t, conn)
+}
+
+func TestHubOfflineQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	user := uuid.New()
+
+	store, _ := offline.NewFileStore(dir, false)
+	first, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.OfflineQueue = &sockethub_config.OfflineQueueConfig{Store: store}
+	})
+	bindAndLeave(t, first, l, user)
+	if err := first.Send(user, dataFrame(0), []byte("msg 0")); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	first.Close()
+
+	// Addresses with frames in the store are still known after a restart
+	store, _ = offline.NewFileStore(dir, false)
+	second, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.OfflineQueue = &sockethub_config.OfflineQueueConfig{Store: store}
+	})
+	if err := second.Send(user, dataFrame(1), []byte("msg 1")); err != nil {
+		t.Fatalf("Send after restart failed: %v", err)
+	}
+	conn, connID := dialMemory(t, second, l)
+	if err := second.Bind(connID, user); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	for i := range 2 {
+		if header, payload := readData(t, conn); header.Router != uint8(i) || string(payload) != fmt.Sprintf("msg %d", i) {
+			t.Fatalf("frame %d: router %d %q", i, header.Router, payload)
+		}
+	}
+}
+
+func TestHubOfflineQueueStoreCaps(t *testing.T) {
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) {
+		cfg.OfflineQueue = &sockethub_config.OfflineQueueConfig{MaxReceivers: 2, MaxTotalBytes: 8}
+	})
+	users := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, user := range users {
+		bindAndLeave(t, hub, l, user)
+	}
+
+	for _, user := range users[:2] {
+		if err := hub.Send(user, dataFrame(1), []byte("abc")); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if err := hub.Send(users[2], dataFrame(1), []byte("x")); !errors.Is(err, sockethub.ErrUnknownReceiver) {
+		t.Errorf("expected the receiver cap to refuse a third receiver, got %v", err)
+	}
+	if err := hub.Send(users[0], dataFrame(1), []byte("abc")); !errors.Is(err, sockethub.ErrUnknownReceiver) {
+		t.Errorf("expected the store byte cap to refuse the frame, got %v", err)
+	}
+	if err := hub.Send(users[0], dataFrame(1), []byte("ab")); err != nil {
+		t.Errorf("Send within the caps failed: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package test
+
+import (
+	"testing"
+
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+func TestHeaderPriority(t *testing.T) {
+	tests := []struct {
+		name   string
+		header protocol.SocketHeader
+		set    *protocol.Priority
+		want   protocol.Priority
+	}{
+		{name: "data defaults to normal", header: protocol.SocketHeader{MessageType: protocol.MessageTypeData}, want: protocol.PriorityNormal},
+		{name: "heartbeat", header: protocol.SocketHeader{MessageType: protocol.MessageTypeHeartbeat}, want: protocol.PriorityControl},
+		{name: "control", header: protocol.SocketHeader{MessageType: protocol.MessageTypeControl}, want: protocol.PriorityControl},
+		{name: "bulk extension", header: protocol.SocketHeader{MessageType: protocol.MessageTypeBroadcast}, set: ptr(protocol.PriorityBulk), want: protocol.PriorityBulk},
+		{name: "data cannot claim control", header: protocol.SocketHeader{MessageType: protocol.MessageTypeData}, set: ptr(protocol.PriorityControl), want: protocol.PriorityHigh},
+		{name: "invalid extension", header: protocol.SocketHeader{MessageType: protocol.MessageTypeData}, set: ptr(protocol.Priority(9)), want: protocol.PriorityNormal},
+		{name: "acknowledgment", header: protocol.SocketHeader{MessageType: protocol.MessageTypeData, Flags: protocol.FlagACK}, set: ptr(protocol.PriorityBulk), want: protocol.PriorityControl},
+		{name: "heartbeat ignores extension", header: protocol.SocketHeader{MessageType: protocol.MessageTypeHeartbeat}, set: ptr(protocol.PriorityBulk), want: protocol.PriorityControl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := tt.header
+			if tt.set != nil {
+				h.SetPriority(*tt.set)
+			}
+			if got := h.Priority(); got != tt.want {
+				t.Fatalf("Priority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityExtensionRoundTrip(t *testing.T) {
+	h := validHeader()
+	h.SetPriority(protocol.PriorityBulk)
+
+	encoded, err := protocol.HeaderEncode(h)
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+	decoded, err := protocol.HeaderDecode(encoded)
+	if err != nil {
+		t.Fatalf("HeaderDecode failed: %v", err)
+	}
+	if got := decoded.Priority(); got != protocol.PriorityBulk {
+		t.Fatalf("decoded Priority() = %v, want Bulk", got)
+	}
+	if err := h.SetExt(protocol.ExtensionPriority, []byte{1, 2}); err == nil {
+		t.Fatal("SetExt accepted a 2-byte priority")
+	}
+}
+
+func TestClientWritesByPriority(t *testing.T) {
+	_, c, conn, gl := slowConsumer(t, sockethub_config.BackpressureConfig{})
+
+	frame := func(router uint8, p protocol.Priority) *protocol.SocketHeader {
+		h := dataFrame(router)
+		h.SetPriority(p)
+		return h
+	}
+
+	// Frame 0 holds the writer while the rest queue up in every class
+	if err := c.Send(frame(0, protocol.PriorityBulk), nil); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	<-gl.writing
+	for _, h := range []*protocol.SocketHeader{
+		frame(1, protocol.PriorityBulk),
+		frame(2, protocol.PriorityBulk),
+		frame(3, protocol.PriorityNormal),
+		frame(4, protocol.PriorityHigh),
+		frame(5, protocol.PriorityNormal),
+	} {
+		if err := c.Send(h, nil); err != nil {
+			t.Fatalf("Send %d failed: %v", h.Router, err)
+		}
+	}
+	control, payload := protocol.NewControlFrame(protocol.ControlGoingAway, []byte("bye"))
+	if err := c.Send(control, payload); err != nil {
+		t.Fatalf("Send control failed: %v", err)
+	}
+
+	// A full bulk class does not block other classes
+	if err := c.Send(frame(6, protocol.PriorityBulk), nil); err == nil {
+		t.Fatal("bulk class accepted a third frame")
+	}
+	if err := c.Send(frame(7, protocol.PriorityHigh), nil); err != nil {
+		t.Fatalf("Send high failed: %v", err)
+	}
+
+	close(gl.gate)
+	want := []any{uint8(0), protocol.MessageTypeControl, uint8(4), uint8(7), uint8(3), uint8(5), uint8(1), uint8(2)}
+	for i, w := range want {
+		h, _ := readData(t, conn)
+		switch w := w.(type) {
+		case protocol.MessageType:
+			if h.MessageType != w {
+				t.Fatalf("frame %d: type %v, want %v", i, h.MessageType, w)
+			}
+		case uint8:
+			if h.MessageType != protocol.MessageTypeData || h.Router != w {
+				t.Fatalf("frame %d: %v router %d, want data router %d", i, h.MessageType, h.Router, w)
+			}
+		}
+	}
+	expectNothing(t, conn)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
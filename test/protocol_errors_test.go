@@ -0,0 +1,141 @@
+package test
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// encodeTCPFrame builds a raw TCP frame (prefix + header + payload + checksum) for tampering.
+func encodeTCPFrame(t *testing.T, header *protocol.SocketHeader, payload []byte) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		protocol.NewTCPConnWrapper(client).WriteFrame(header, payload)
+	}()
+
+	raw, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatalf("failed to capture frame: %v", err)
+	}
+	return raw
+}
+
+// readTampered feeds raw bytes into a TCP wrapper and returns the ReadFrame error.
+func readTampered(raw []byte) error {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		defer client.Close()
+		client.Write(raw)
+	}()
+
+	_, _, err := protocol.NewTCPConnWrapper(server).ReadFrame()
+	return err
+}
+
+func TestHeaderDecodeSizeErrors(t *testing.T) {
+	if _, err := protocol.HeaderDecode(make([]byte, 10)); !errors.Is(err, protocol.ErrHeaderSize) {
+		t.Errorf("short header: got %v, want ErrHeaderSize", err)
+	}
+
+	encoded, err := protocol.HeaderEncode(&protocol.SocketHeader{
+		ID:          uuid.New(),
+		MessageType: protocol.MessageTypeData,
+		Protocol:    protocol.ProtocolTCP,
+	})
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+	if _, err := protocol.HeaderDecode(append(encoded, 0, 0, 0, 0)); !errors.Is(err, protocol.ErrHeaderSize) {
+		t.Errorf("oversized header: got %v, want ErrHeaderSize", err)
+	}
+}
+
+func TestHeaderEncodeErrors(t *testing.T) {
+	if _, err := protocol.HeaderEncode(nil); !errors.Is(err, protocol.ErrNilHeader) {
+		t.Errorf("nil header: got %v, want ErrNilHeader", err)
+	}
+	if _, err := protocol.HeaderEncode(&protocol.SocketHeader{MessageType: 200}); !errors.Is(err, protocol.ErrInvalidMessageType) {
+		t.Errorf("invalid message type: got %v, want ErrInvalidMessageType", err)
+	}
+}
+
+func TestTCPChecksumMismatchIsDroppable(t *testing.T) {
+	raw := encodeTCPFrame(t, &protocol.SocketHeader{
+		ID:          uuid.New(),
+		MessageType: protocol.MessageTypeData,
+		Protocol:    protocol.ProtocolTCP,
+	}, []byte("payload"))
+
+	// Flip one payload byte (just before the 4-byte checksum)
+	raw[len(raw)-5] ^= 0xFF
+
+	err := readTampered(raw)
+	if !errors.Is(err, protocol.ErrChecksumMismatch) {
+		t.Fatalf("got %v, want ErrChecksumMismatch", err)
+	}
+	if protocol.IsFatal(err) || !protocol.IsDroppable(err) {
+		t.Errorf("checksum mismatch should be droppable, not fatal (fatal=%v)", protocol.IsFatal(err))
+	}
+}
+
+func TestTCPPayloadLimitByDefault(t *testing.T) {
+	header := &protocol.SocketHeader{ID: uuid.New(), MessageType: protocol.MessageTypeData, Length: 1 << 40}
+	encoded, err := protocol.HeaderEncode(header)
+	if err != nil {
+		t.Fatalf("HeaderEncode failed: %v", err)
+	}
+
+	// A header announcing a huge payload is refused before anything is allocated
+	raw := append([]byte{byte(len(encoded) >> 8), byte(len(encoded))}, encoded...)
+	if err := readTampered(raw); !errors.Is(err, protocol.ErrFrameTooLarge) {
+		t.Fatalf("got %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestTCPHeaderErrorIsFatal(t *testing.T) {
+	// Prefix announces a 10-byte header, which is below the minimum
+	raw := append([]byte{0, 10}, make([]byte, 10)...)
+
+	err := readTampered(raw)
+	if !errors.Is(err, protocol.ErrHeaderSize) {
+		t.Fatalf("got %v, want ErrHeaderSize", err)
+	}
+	if !protocol.IsFatal(err) || protocol.IsDroppable(err) {
+		t.Errorf("undecodable TCP header should be fatal")
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	if !protocol.IsFatal(io.EOF) {
+		t.Error("io.EOF should be fatal")
+	}
+	if protocol.IsFatal(nil) || protocol.IsDroppable(nil) {
+		t.Error("nil should be neither fatal nor droppable")
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("UDP listen error: %v", err)
+	}
+	defer pc.Close()
+
+	wrapped := protocol.NewUDPConnWrapper(pc, pc.LocalAddr(), 64)
+	err = wrapped.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, make([]byte, 128))
+	if !errors.Is(err, protocol.ErrFrameTooLarge) {
+		t.Fatalf("got %v, want ErrFrameTooLarge", err)
+	}
+	if !protocol.IsDroppable(err) {
+		t.Error("oversized UDP frame should be droppable")
+	}
+}
@@ -0,0 +1,250 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/roomlog"
+	"github.com/google/uuid"
+)
+
+// openRoomLog opens a room log that is closed when the test ends.
+func openRoomLog(t *testing.T, cfg roomlog.Config) *roomlog.Log {
+	t.Helper()
+
+	log, err := roomlog.Open(cfg)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { log.Close() })
+	return log
+}
+
+// appendFrames logs n frames to room, one second apart starting at start, and returns their IDs.
+func appendFrames(t *testing.T, log *roomlog.Log, room uuid.UUID, start time.Time, n int) []uuid.UUID {
+	t.Helper()
+
+	ids := make([]uuid.UUID, n)
+	for i := range n {
+		header := dataFrame(uint8(i))
+		header.ID = uuid.New()
+		header.Timestamp = uint64(start.Add(time.Duration(i) * time.Second).UnixMilli())
+		if err := log.Append(room, header, fmt.Appendf(nil, "msg %d", i)); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		ids[i] = header.ID
+	}
+	return ids
+}
+
+// replayRouters returns the routers of the frames replayed from a position.
+func replayRouters(t *testing.T, log *roomlog.Log, room uuid.UUID, from roomlog.Position) []uint8 {
+	t.Helper()
+
+	var routers []uint8
+	n, err := log.Replay(room, from, func(header *protocol.SocketHeader, payload []byte) error {
+		if string(payload) != fmt.Sprintf("msg %d", header.Router) {
+			t.Errorf("frame %d has payload %q", header.Router, payload)
+		}
+		routers = append(routers, header.Router)
+		return nil
+	})
+	if err != nil || n != len(routers) {
+		t.Fatalf("Replay = %d, %v", n, err)
+	}
+	return routers
+}
+
+func TestRoomLogReplay(t *testing.T) {
+	log := openRoomLog(t, roomlog.Config{Dir: t.TempDir()})
+	room := sockethub.RoomID("lobby")
+	start := time.Now().Add(-time.Minute).Truncate(time.Millisecond)
+	ids := appendFrames(t, log, room, start, 5)
+
+	for name, tc := range map[string]struct {
+		from roomlog.Position
+		want string
+	}{
+		"everything":    {roomlog.Position{}, "[0 1 2 3 4]"},
+		"since":         {roomlog.Position{Since: start.Add(2 * time.Second)}, "[2 3 4]"},
+		"after":         {roomlog.Position{After: ids[1]}, "[2 3 4]"},
+		"after last":    {roomlog.Position{After: ids[4]}, "[]"},
+		"after unknown": {roomlog.Position{After: uuid.New()}, "[0 1 2 3 4]"},
+	} {
+		if got := fmt.Sprint(replayRouters(t, log, room, tc.from)); got != tc.want {
+			t.Errorf("%s: replayed %s, want %s", name, got, tc.want)
+		}
+	}
+
+	// Callbacks can stop early, and rooms without history replay nothing
+	n, err := log.Replay(room, roomlog.Position{}, func(header *protocol.SocketHeader, _ []byte) error {
+		if header.Router == 1 {
+			return roomlog.ErrStopReplay
+		}
+		return nil
+	})
+	if err != nil || n != 1 {
+		t.Errorf("stopped Replay = %d, %v", n, err)
+	}
+	if got := replayRouters(t, log, sockethub.RoomID("empty"), roomlog.Position{}); len(got) != 0 {
+		t.Errorf("empty room replayed %v", got)
+	}
+}
+
+func TestRoomLogRetention(t *testing.T) {
+	dir := t.TempDir()
+	room := sockethub.RoomID("lobby")
+	segments := func(room uuid.UUID) int {
+		names, _ := filepath.Glob(filepath.Join(dir, room.String(), "*.log"))
+		return len(names)
+	}
+
+	// Tiny segments hold one frame each; the byte cap keeps the newest three
+	log := openRoomLog(t, roomlog.Config{Dir: dir, SegmentSize: 1, MaxBytes: 200})
+	appendFrames(t, log, room, time.Now(), 10)
+	if got := fmt.Sprint(replayRouters(t, log, room, roomlog.Position{})); got != "[7 8 9]" || segments(room) != 3 {
+		t.Errorf("size retention kept %s in %d segments", got, segments(room))
+	}
+	log.Close()
+
+	// Segments whose newest frame is too old are dropped, except the active one
+	log = openRoomLog(t, roomlog.Config{Dir: dir, SegmentSize: 1, MaxAge: time.Hour})
+	old := sockethub.RoomID("old")
+	appendFrames(t, log, old, time.Now().Add(-2*time.Hour), 3)
+	if got := fmt.Sprint(replayRouters(t, log, old, roomlog.Position{})); got != "[2]" || segments(old) != 1 {
+		t.Errorf("age retention kept %s in %d segments", got, segments(old))
+	}
+}
+
+func TestRoomLogSweepsQuietRooms(t *testing.T) {
+	dir := t.TempDir()
+	log := openRoomLog(t, roomlog.Config{Dir: dir, MaxAge: 200 * time.Millisecond, IdleTimeout: 10 * time.Millisecond})
+
+	// A room nobody writes to loses even its newest segment once every frame has expired
+	room := sockethub.RoomID("quiet")
+	appendFrames(t, log, room, time.Now(), 2)
+	waitFor(t, "quiet room to be removed", func() bool {
+		_, err := os.Stat(filepath.Join(dir, room.String()))
+		return errors.Is(err, os.ErrNotExist)
+	})
+	if got := replayRouters(t, log, room, roomlog.Position{}); len(got) != 0 {
+		t.Errorf("expired room replayed %v", got)
+	}
+
+	// The room is usable again afterwards
+	appendFrames(t, log, room, time.Now(), 1)
+	if got := fmt.Sprint(replayRouters(t, log, room, roomlog.Position{})); got != "[0]" {
+		t.Errorf("replay after the sweep = %s", got)
+	}
+}
+
+func TestRoomLogRecoversTornTail(t *testing.T) {
+	dir := t.TempDir()
+	room := sockethub.RoomID("lobby")
+	log := openRoomLog(t, roomlog.Config{Dir: dir, Sync: true})
+	appendFrames(t, log, room, time.Now(), 3)
+	log.Close()
+	if _, err := log.Replay(room, roomlog.Position{}, nil); !errors.Is(err, roomlog.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+
+	// Simulate a crash in the middle of the last append
+	path := filepath.Join(dir, room.String(), fmt.Sprintf("%020d.log", 0))
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openRoomLog(t, roomlog.Config{Dir: dir})
+	if got := fmt.Sprint(replayRouters(t, reopened, room, roomlog.Position{})); got != "[0 1]" {
+		t.Fatalf("replay after torn write = %s", got)
+	}
+	header := dataFrame(2)
+	header.Timestamp = uint64(time.Now().UnixMilli())
+	reopened.Append(room, header, []byte("msg 2"))
+	if got := fmt.Sprint(replayRouters(t, reopened, room, roomlog.Position{})); got != "[0 1 2]" {
+		t.Errorf("replay after recovery = %s", got)
+	}
+}
+
+// readReplayEnd reads the control frame that ends a history replay.
+func readReplayEnd(t *testing.T, conn protocol.Conn) (uuid.UUID, uint32) {
+	t.Helper()
+
+	header, payload := readData(t, conn)
+	code, body, err := protocol.ParseControl(header, payload)
+	if err != nil || code != protocol.ControlReplayEnd {
+		t.Fatalf("expected ControlReplayEnd, got %v, %v", code, err)
+	}
+	room, count, err := protocol.ParseReplayEnd(body)
+	if err != nil {
+		t.Fatalf("ParseReplayEnd failed: %v", err)
+	}
+	return room, count
+}
+
+func TestHubJoinWithHistory(t *testing.T) {
+	log := openRoomLog(t, roomlog.Config{Dir: t.TempDir()})
+	hub, l := memoryHub(t, func(cfg *sockethub_config.SocketConfig) { cfg.RoomLog = log })
+
+	member, memberID := dialMemory(t, hub, l)
+	hub.Join(memberID, "lobby")
+	var ids []uuid.UUID
+	priorities := []protocol.Priority{protocol.PriorityBulk, protocol.PriorityNormal, protocol.PriorityHigh}
+	for i := range 3 {
+		header := dataFrame(uint8(i))
+		header.SetPriority(priorities[i])
+		if err := hub.BroadcastRoom("lobby", header, fmt.Appendf(nil, "msg %d", i), uuid.Nil); err != nil {
+			t.Fatalf("BroadcastRoom failed: %v", err)
+		}
+		logged, _ := readData(t, member)
+		ids = append(ids, logged.ID)
+	}
+
+	// A late joiner gets the history in order whatever its priorities, the end marker, then
+	// live frames, even ones queued before it read the history
+	late, lateID := dialMemory(t, hub, l)
+	if n, err := hub.JoinWithHistory(lateID, "lobby", roomlog.Position{}); err != nil || n != 3 {
+		t.Fatalf("JoinWithHistory = %d, %v", n, err)
+	}
+	live := &protocol.SocketHeader{MessageType: protocol.MessageTypeBroadcast, Receiver: sockethub.RoomID("lobby"), Router: 7}
+	live.SetPriority(protocol.PriorityHigh)
+	if err := hub.BroadcastRoom("lobby", live, []byte("live"), memberID); err != nil {
+		t.Fatalf("BroadcastRoom failed: %v", err)
+	}
+	for i := range 3 {
+		header, payload := readData(t, late)
+		if header.Router != uint8(i) || string(payload) != fmt.Sprintf("msg %d", i) || header.ID != ids[i] {
+			t.Fatalf("history frame %d: router %d %q id %v", i, header.Router, payload, header.ID)
+		}
+	}
+	if room, count := readReplayEnd(t, late); room != sockethub.RoomID("lobby") || count != 3 {
+		t.Errorf("ReplayEnd = %v, %d", room, count)
+	}
+	if header, payload := readData(t, late); header.Router != 7 || string(payload) != "live" {
+		t.Fatalf("unexpected live frame router %d %q", header.Router, payload)
+	}
+	expectNothing(t, late)
+
+	// A reconnecting client resumes after the last frame it saw
+	again, againID := dialMemory(t, hub, l)
+	if n, err := hub.JoinWithHistory(againID, "lobby", roomlog.Position{After: ids[2]}); err != nil || n != 1 {
+		t.Fatalf("JoinWithHistory after a frame = %d, %v", n, err)
+	}
+	if _, payload := readData(t, again); string(payload) != "live" {
+		t.Errorf("resumed with %q", payload)
+	}
+	readReplayEnd(t, again)
+
+	plain := newTestHub(t, nil)
+	if _, err := plain.JoinWithHistory(uuid.New(), "lobby", roomlog.Position{}); !errors.Is(err, sockethub.ErrNoRoomLog) {
+		t.Errorf("expected ErrNoRoomLog without a room log, got %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub"
+	"github.com/Jdcabreradev/sockethub/codec"
+	sockethub_config "github.com/Jdcabreradev/sockethub/config"
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/Jdcabreradev/sockethub/schema"
+	"github.com/Jdcabreradev/sockethub/test/chat"
+	"github.com/google/uuid"
+)
+
+func TestSchemaParse(t *testing.T) {
+	f, err := schema.Parse([]byte(`package demo
+
+// Ping checks liveness.
+// It carries no data.
+message Ping router 1 {
+}
+
+message Event router 2 { // trailing comments are ignored
+	user_id uuid
+	tags    []string max 4 optional
+}
+`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if f.Package != "demo" || len(f.Messages) != 2 {
+		t.Fatalf("unexpected file: %+v", f)
+	}
+	if ping := f.Message("Ping"); ping.Doc != "Ping checks liveness.\nIt carries no data." || len(ping.Fields) != 0 {
+		t.Errorf("unexpected Ping: %+v", ping)
+	}
+	want := schema.Field{Name: "tags", Type: schema.TypeString, List: true, Optional: true, Max: 4}
+	if event := f.Message("Event"); event.Router != 2 || event.Fields[1] != want {
+		t.Errorf("unexpected Event: %+v", event)
+	}
+	if got := schema.GoName("user_id"); got != "UserID" {
+		t.Errorf("GoName(user_id) = %q", got)
+	}
+
+	for _, src := range []string{
+		"message A router 1 {\n}",                                     // Missing package
+		"package p\nmessage a router 1 {\n}",                          // Unexported name
+		"package p\nmessage A router 256 {\n}",                        // Router out of range
+		"package p\nmessage A router 1 {\n}\nmessage B router 1 {\n}", // Duplicate router
+		"package p\nmessage A router 1 {\n\tx string\n",               // Not closed
+		"package p\nmessage A router 1 {\n\tx decimal\n}",             // Unknown type
+		"package p\nmessage A router 1 {\n\tx int max 3\n}",           // Max on a number
+		"package p\nmessage A router 1 {\n\tx int\n\tx string\n}",     // Duplicate field
+		"package p\nmessage A router 1 {\n\tx int required\n}",        // Unknown modifier
+	} {
+		if _, err := schema.Parse([]byte(src)); !errors.Is(err, schema.ErrSyntax) {
+			t.Errorf("Parse(%q) = %v, want ErrSyntax", src, err)
+		}
+	}
+}
+
+func TestSchemaGeneratedCodeIsCurrent(t *testing.T) {
+	src, err := os.ReadFile("chat/chat.schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := schema.Parse(src)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	code, err := schema.Generate(f, src, "chat.schema")
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	current, err := os.ReadFile("chat/chat_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(code, current) {
+		t.Error("chat/chat_gen.go is stale; run: go run ./cmd gen test/chat/chat.schema")
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	post := chat.Post{Room: uuid.New(), Text: "hello", Mentions: []string{"ana"}, SentAt: time.Now()}
+	for _, c := range []codec.Codec{codec.JSON, codec.MessagePack} {
+		header, payload, err := post.Encode(c)
+		if err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+		decoded, err := chat.DecodePost(header, payload)
+		if err != nil {
+			t.Fatalf("%s: DecodePost failed: %v", c.ContentType(), err)
+		}
+		if decoded.Room != post.Room || decoded.Text != post.Text || !decoded.SentAt.Equal(post.SentAt) {
+			t.Errorf("%s: decoded %+v", c.ContentType(), decoded)
+		}
+	}
+
+	// Empty lists encode as null, which is accepted for a required list
+	if header, payload, _ := (&chat.Post{Room: uuid.New(), SentAt: time.Now()}).Encode(codec.JSON); chat.Schema.Message("Post").Validate(header, payload) != nil {
+		t.Error("empty list rejected")
+	}
+
+	msg := chat.Schema.Message("Reaction")
+	jsonHeader := dataFrame(chat.ReactionRouter)
+	jsonHeader.SetContentType("application/json")
+	valid := `"post_id":"` + uuid.NewString() + `","emoji":"+1"`
+	for payload, ok := range map[string]bool{
+		`{` + valid + `,"weight":9223372036854775807}`:      true,
+		`{` + valid + `,"weight":-3,"score":1.5,"count":2}`: true,
+		`{` + valid + `,"weight":9223372036854775808}`:      false, // Overflows int
+		`{` + valid + `,"weight":1.5}`:                      false, // Not an integer
+		`{` + valid + `,"weight":1,"count":-1}`:             false, // Negative uint
+		`{` + valid + `}`:                                   false, // Missing weight
+		`{` + valid + `,"weight":1,"extra":true}`:           false, // Unknown field
+		`{` + valid + `,"weight":"1"}`:                      false, // Wrong type
+		`{"post_id":"nope","emoji":"+1","weight":1}`:        false, // Invalid UUID
+		`[1,2]`:                         false, // Not an object
+		`{` + valid + `,"weight":1} {}`: false, // Trailing data
+	} {
+		err := msg.Validate(jsonHeader, []byte(payload))
+		if ok != (err == nil) {
+			t.Errorf("Validate(%s) = %v", payload, err)
+		}
+		if err != nil && !errors.Is(err, schema.ErrMismatch) {
+			t.Errorf("Validate(%s) returned %v, want ErrMismatch", payload, err)
+		}
+	}
+
+	// Length limits apply to strings and lists
+	join := chat.Schema.Message("Join")
+	_, payload, _ := (&chat.Join{Room: uuid.New(), Nickname: strings.Repeat("x", 33)}).Encode(codec.MessagePack)
+	if err := join.Validate(msgpackHeader(), payload); err == nil {
+		t.Error("expected an over-long nickname to be rejected")
+	}
+	_, payload, _ = (&chat.Post{Room: uuid.New(), Mentions: make([]string, 9), SentAt: time.Now()}).Encode(codec.MessagePack)
+	if err := chat.Schema.Message("Post").Validate(msgpackHeader(), payload); err == nil {
+		t.Error("expected a list over its max to be rejected")
+	}
+
+	// Codecs that are not self-describing cannot be checked
+	header, payload, _ := (&chat.Join{Room: uuid.New(), Nickname: "ana"}).Encode(codec.Gob)
+	if err := join.Validate(header, payload); !errors.Is(err, schema.ErrMismatch) {
+		t.Errorf("expected gob payload to be rejected, got %v", err)
+	}
+}
+
+func msgpackHeader() *protocol.SocketHeader {
+	header := dataFrame(0)
+	header.SetContentType(codec.MessagePack.ContentType())
+	return header
+}
+
+func TestHubRejectsPayloadsNotMatchingSchema(t *testing.T) {
+	hub := newTestHub(t, nil)
+	posts := make(chan *chat.Post, 1)
+	chat.HandlePost(hub, func(c *sockethub.Client, header *protocol.SocketHeader, m *chat.Post) {
+		posts <- m
+	})
+	chat.RegisterSchemas(hub)
+	l := serveMemory(t, hub, sockethub_config.ListenerConfig{})
+	conn, _ := dialMemory(t, hub, l)
+	peer, peerID := dialMemory(t, hub, l)
+
+	// A matching payload reaches the typed handler
+	header, payload, _ := (&chat.Post{Room: uuid.New(), Text: "hi", SentAt: time.Now()}).Encode(codec.MessagePack)
+	conn.WriteFrame(header, payload)
+	select {
+	case m := <-posts:
+		if m.Text != "hi" {
+			t.Errorf("handler got %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler not called")
+	}
+
+	// A mismatching one is answered with an error frame instead
+	header = dataFrame(chat.PostRouter)
+	header.SetContentType("application/json")
+	conn.WriteFrame(header, []byte(`{"text":"no room"}`))
+	reply, body := readData(t, conn)
+	e, err := sockethub.ErrorFromFrame(reply, body)
+	if err != nil || !errors.Is(e, sockethub.ErrInvalidPayload) || e.RequestID != header.ID {
+		t.Fatalf("expected InvalidPayload for %v, got %v, %v", header.ID, e, err)
+	}
+	if !strings.Contains(string(e.Details), "Post.room is required") {
+		t.Errorf("unexpected details %q", e.Details)
+	}
+	select {
+	case m := <-posts:
+		t.Fatalf("handler called with %+v", m)
+	default:
+	}
+
+	// Routes without a handler are checked too
+	header = dataFrame(chat.JoinRouter)
+	header.Receiver = peerID
+	conn.WriteFrame(header, []byte(`{}`))
+	if reply, _ := readData(t, conn); !sockethub.IsErrorFrame(reply) {
+		t.Fatal("expected the relayed frame to be rejected")
+	}
+	expectNothing(t, peer)
+
+	_, payload, _ = (&chat.Join{Room: uuid.New(), Nickname: "ana"}).Encode(codec.JSON)
+	header.SetContentType("application/json")
+	conn.WriteFrame(header, payload)
+	if _, got := readData(t, peer); !bytes.Equal(got, payload) {
+		t.Errorf("relayed payload %q", got)
+	}
+}
@@ -0,0 +1,324 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// sessionPair returns a client and a server session over a memory pipe.
+func sessionPair(t *testing.T, cfg protocol.SessionConfig) (*protocol.Session, *protocol.Session) {
+	t.Helper()
+
+	a, b := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	client, server := protocol.NewClientSession(a, cfg), protocol.NewServerSession(b, cfg)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// rawPeer returns a server session and the raw Conn at the other end, for tests that send
+// frames a well-behaved client session would not.
+func rawPeer(t *testing.T, cfg protocol.SessionConfig) (protocol.Conn, *protocol.Session) {
+	t.Helper()
+
+	a, b := protocol.NewMemoryPipe(protocol.MemoryLink{})
+	server := protocol.NewServerSession(b, cfg)
+	t.Cleanup(func() {
+		a.Close()
+		server.Close()
+	})
+	return a, server
+}
+
+// openRawStream opens a stream from a raw Conn and sends each payload on it.
+func openRawStream(t *testing.T, conn protocol.Conn, id uint32, payloads ...[]byte) {
+	t.Helper()
+
+	header, payload := protocol.StreamControlFrame(protocol.ControlStreamOpen, id, "")
+	if err := conn.WriteFrame(header, payload); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	for _, payload := range payloads {
+		header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData}
+		header.SetStreamID(id)
+		if err := conn.WriteFrame(header, payload); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+}
+
+// openPair opens a stream on one session and accepts it on the other.
+func openPair(t *testing.T, opener, acceptor *protocol.Session) (*protocol.Stream, *protocol.Stream) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	local, err := opener.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	remote, err := acceptor.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream failed: %v", err)
+	}
+	if local.ID() != remote.ID() {
+		t.Fatalf("stream IDs differ: %d and %d", local.ID(), remote.ID())
+	}
+	return local, remote
+}
+
+// readAll reads a stream to EOF, failing the test after a timeout.
+func readAll(t *testing.T, st *protocol.Stream) []byte {
+	t.Helper()
+
+	st.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data, err := io.ReadAll(st)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	return data
+}
+
+func TestSessionStreamHalfClose(t *testing.T) {
+	client, server := sessionPair(t, protocol.SessionConfig{ChunkSize: 4})
+	local, remote := openPair(t, client, server)
+	if local.ID()%2 != 1 {
+		t.Errorf("client stream ID %d is not odd", local.ID())
+	}
+
+	if _, err := local.Write([]byte("request body")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := local.CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+	if _, err := local.Write([]byte("late")); !errors.Is(err, protocol.ErrStreamClosed) {
+		t.Errorf("expected ErrStreamClosed after CloseWrite, got %v", err)
+	}
+	if got := readAll(t, remote); string(got) != "request body" {
+		t.Fatalf("server read %q", got)
+	}
+
+	// The server can still answer on its half
+	if _, err := remote.Write([]byte("response")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	remote.Close()
+	if got := readAll(t, local); string(got) != "response" {
+		t.Fatalf("client read %q", got)
+	}
+
+	waitFor(t, "both sessions to forget the stream", func() bool {
+		return client.NumStreams() == 0 && server.NumStreams() == 0
+	})
+}
+
+func TestSessionStreamReset(t *testing.T) {
+	client, server := sessionPair(t, protocol.SessionConfig{})
+	local, remote := openPair(t, client, server)
+	other, otherRemote := openPair(t, server, client)
+	if other.ID()%2 != 0 {
+		t.Errorf("server stream ID %d is not even", other.ID())
+	}
+
+	if err := local.Reset("changed my mind"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	remote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := remote.Read(make([]byte, 1)); !errors.Is(err, protocol.ErrStreamReset) {
+		t.Fatalf("expected ErrStreamReset, got %v", err)
+	}
+	if _, err := remote.Write([]byte("x")); !errors.Is(err, protocol.ErrStreamReset) {
+		t.Errorf("expected ErrStreamReset writing, got %v", err)
+	}
+	if _, err := local.Write([]byte("x")); !errors.Is(err, protocol.ErrStreamReset) {
+		t.Errorf("expected ErrStreamReset writing locally, got %v", err)
+	}
+
+	// Other streams are unaffected
+	go other.Write([]byte("still here"))
+	buf := make([]byte, 10)
+	otherRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(otherRemote, buf); err != nil || string(buf) != "still here" {
+		t.Fatalf("other stream read %q, %v", buf, err)
+	}
+}
+
+func TestSessionFairScheduling(t *testing.T) {
+	// A slow link keeps both writes queued long enough to interleave
+	a, raw := protocol.NewMemoryPipe(protocol.MemoryLink{Bandwidth: 256 << 10})
+	client := protocol.NewClientSession(a, protocol.SessionConfig{ChunkSize: 1024})
+	defer client.Close()
+	defer raw.Close()
+
+	ctx := context.Background()
+	first, _ := client.OpenStream(ctx)
+	second, _ := client.OpenStream(ctx)
+	go first.Write(make([]byte, 32<<10))
+	time.Sleep(10 * time.Millisecond)
+	go second.Write(make([]byte, 8<<10))
+
+	// Once the second stream starts, the streams take turns until it is done
+	var order []uint32
+	for remaining := 8; remaining > 0; {
+		raw.SetReadDeadline(time.Now().Add(2 * time.Second))
+		header, _, err := raw.ReadFrame()
+		if err != nil {
+			t.Fatalf("ReadFrame failed: %v", err)
+		}
+		id, ok := header.StreamID()
+		if !ok {
+			continue
+		}
+		order = append(order, id)
+		if id == second.ID() {
+			remaining--
+		}
+	}
+	start := slices.Index(order, second.ID())
+	for i := start; i < len(order); i++ {
+		want := second.ID()
+		if (i-start)%2 == 1 {
+			want = first.ID()
+		}
+		if order[i] != want {
+			t.Fatalf("streams did not alternate: %v", order)
+		}
+	}
+}
+
+func TestSessionFlowControlPerStream(t *testing.T) {
+	client, server := sessionPair(t, protocol.SessionConfig{
+		ChunkSize:   1024,
+		FlowControl: &protocol.FlowControl{StreamWindow: 4096},
+	})
+	stalled, stalledRemote := openPair(t, client, server)
+	live, liveRemote := openPair(t, client, server)
+
+	// Nobody reads the first stream, so its writer runs out of credit
+	written := make(chan error, 1)
+	go func() {
+		_, err := stalled.Write(make([]byte, 16<<10))
+		written <- err
+	}()
+	expectBlocked(t, written)
+
+	// The second stream is not held back by the first
+	if _, err := live.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	live.CloseWrite()
+	if got := readAll(t, liveRemote); string(got) != "ping" {
+		t.Fatalf("read %q", got)
+	}
+
+	// Reading grants credit back, so the write completes
+	go func() {
+		buf := make([]byte, 16<<10)
+		stalledRemote.SetReadDeadline(time.Now().Add(2 * time.Second))
+		io.ReadFull(stalledRemote, buf)
+	}()
+	expectWritten(t, written)
+}
+
+func TestSessionRejectsDataBeyondStreamWindow(t *testing.T) {
+	conn, server := rawPeer(t, protocol.SessionConfig{FlowControl: &protocol.FlowControl{StreamWindow: 1024}})
+
+	// Nobody reads the stream, so the third frame arrives after its credit ran out
+	openRawStream(t, conn, 1, make([]byte, 600), make([]byte, 600), make([]byte, 600))
+	select {
+	case <-server.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("session survived a peer that ignored flow control")
+	}
+	if err := server.Err(); !errors.Is(err, protocol.ErrFlowControl) {
+		t.Errorf("expected ErrFlowControl, got %v", err)
+	}
+}
+
+func TestSessionResetsStreamBeyondBuffer(t *testing.T) {
+	conn, server := rawPeer(t, protocol.SessionConfig{MaxStreamBuffer: 1024})
+
+	// Without flow control, nothing stops the peer, so the unread stream is reset
+	openRawStream(t, conn, 1, make([]byte, 600), make([]byte, 600))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	for {
+		header, payload, err := conn.ReadFrameContext(ctx)
+		if err != nil {
+			t.Fatalf("no reset received: %v", err)
+		}
+		if code, body, err := protocol.ParseControl(header, payload); err == nil && code == protocol.ControlStreamReset {
+			if id, _, _ := protocol.ParseStreamControl(body); id != 1 {
+				t.Fatalf("reset stream %d, want 1", id)
+			}
+			break
+		}
+	}
+
+	st, err := server.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("AcceptStream failed: %v", err)
+	}
+	if _, err := st.Read(make([]byte, 1)); !errors.Is(err, protocol.ErrStreamReset) {
+		t.Errorf("expected ErrStreamReset, got %v", err)
+	}
+}
+
+func TestSessionStreamDeadlines(t *testing.T) {
+	client, server := sessionPair(t, protocol.SessionConfig{
+		ChunkSize:   1024,
+		FlowControl: &protocol.FlowControl{StreamWindow: 2048},
+	})
+	local, _ := openPair(t, client, server)
+
+	local.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := local.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected read deadline error, got %v", err)
+	}
+
+	local.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := local.Write(make([]byte, 8<<10))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected write deadline error, got %v", err)
+	}
+	if n < 2048 || n >= 8<<10 {
+		t.Errorf("expected a partial write beyond the window, got %d bytes", n)
+	}
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := sessionPair(t, protocol.SessionConfig{})
+	local, remote := openPair(t, client, server)
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := remote.Read(make([]byte, 1))
+		read <- err
+	}()
+	client.Close()
+
+	select {
+	case err := <-read:
+		if !errors.Is(err, protocol.ErrSessionClosed) {
+			t.Errorf("expected ErrSessionClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read not interrupted by the peer closing the session")
+	}
+	if _, err := local.Write([]byte("x")); !errors.Is(err, protocol.ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed writing, got %v", err)
+	}
+	if _, err := server.AcceptStream(context.Background()); !errors.Is(err, protocol.ErrSessionClosed) {
+		t.Errorf("expected ErrSessionClosed accepting, got %v", err)
+	}
+}
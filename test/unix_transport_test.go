@@ -0,0 +1,149 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// socketDir returns a short temporary directory (socket paths are limited to ~100 bytes).
+func socketDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "sh")
+	if err != nil {
+		t.Fatalf("MkdirTemp failed: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestUnixStreamConnection(t *testing.T) {
+	path := filepath.Join(socketDir(t), "hub.sock")
+	listener, err := protocol.ListenUnix(path, protocol.UnixSocketOptions{Mode: 0600})
+	if err != nil {
+		t.Fatalf("ListenUnix failed: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket file missing: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("socket permissions: got %o, want 600", perm)
+	}
+
+	accepted := make(chan protocol.Conn, 1)
+	go func() {
+		c, err := listener.AcceptUnix()
+		if err != nil {
+			t.Errorf("AcceptUnix failed: %v", err)
+			close(accepted)
+			return
+		}
+		accepted <- protocol.NewUnixConnWrapper(c)
+	}()
+
+	client, err := protocol.DialUnix(context.Background(), path)
+	if err != nil {
+		t.Fatalf("DialUnix failed: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	if server == nil {
+		t.FailNow()
+	}
+	defer server.Close()
+
+	if err := client.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("hello over unix")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	header, payload, err := server.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if string(payload) != "hello over unix" || header.Protocol != protocol.ProtocolUnix {
+		t.Errorf("unexpected frame: %q over %v", payload, header.Protocol)
+	}
+
+	cred, err := server.(protocol.PeerCredentialsConn).PeerCredentials()
+	if runtime.GOOS != "linux" {
+		if err == nil {
+			t.Error("expected peer credentials to be unavailable off Linux")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("PeerCredentials failed: %v", err)
+	}
+	if int(cred.PID) != os.Getpid() || int(cred.UID) != os.Getuid() {
+		t.Errorf("unexpected credentials %+v (pid %d, uid %d)", cred, os.Getpid(), os.Getuid())
+	}
+}
+
+func TestUnixListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(socketDir(t), "stale.sock")
+
+	// Leave a socket file behind without a listener
+	stale, err := protocol.ListenUnixgram(path, protocol.UnixSocketOptions{})
+	if err != nil {
+		t.Fatalf("ListenUnixgram failed: %v", err)
+	}
+	stale.Close()
+
+	if _, err := protocol.ListenUnix(path, protocol.UnixSocketOptions{}); err == nil {
+		t.Fatal("expected listen on a leftover socket file to fail without RemoveStale")
+	}
+	listener, err := protocol.ListenUnix(path, protocol.UnixSocketOptions{RemoveStale: true})
+	if err != nil {
+		t.Fatalf("ListenUnix with RemoveStale failed: %v", err)
+	}
+	defer listener.Close()
+
+	// A live listener must never be removed
+	if _, err := protocol.ListenUnix(path, protocol.UnixSocketOptions{RemoveStale: true}); err == nil {
+		t.Error("expected listen on an active socket to fail")
+	}
+}
+
+func TestUnixDatagramConnection(t *testing.T) {
+	dir := socketDir(t)
+	serverPath := filepath.Join(dir, "server.sock")
+
+	pc, err := protocol.ListenUnixgram(serverPath, protocol.UnixSocketOptions{})
+	if err != nil {
+		t.Fatalf("ListenUnixgram failed: %v", err)
+	}
+	defer pc.Close()
+	server := protocol.NewUnixgramConnWrapper(pc, nil, 4096)
+
+	client, err := protocol.DialUnixgram(serverPath, filepath.Join(dir, "client.sock"), 4096)
+	if err != nil {
+		t.Fatalf("DialUnixgram failed: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("ping")); err != nil {
+		t.Fatalf("client WriteFrame failed: %v", err)
+	}
+	header, payload, err := server.ReadFrame()
+	if err != nil {
+		t.Fatalf("server ReadFrame failed: %v", err)
+	}
+	if string(payload) != "ping" || header.Protocol != protocol.ProtocolUnix {
+		t.Errorf("unexpected frame: %q over %v", payload, header.Protocol)
+	}
+
+	// The server replies to the client's bound address
+	if err := server.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("pong")); err != nil {
+		t.Fatalf("server WriteFrame failed: %v", err)
+	}
+	if _, payload, err := client.ReadFrame(); err != nil || string(payload) != "pong" {
+		t.Errorf("client read: %q, %v", payload, err)
+	}
+}
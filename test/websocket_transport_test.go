@@ -0,0 +1,310 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// startWebSocketServer mounts a WebSocketListener on a test HTTP server and returns its ws:// URL.
+func startWebSocketServer(t *testing.T, cfg protocol.WebSocketConfig) (*protocol.WebSocketListener, string) {
+	t.Helper()
+
+	listener := protocol.NewWebSocketListener(cfg)
+	mux := http.NewServeMux()
+	mux.Handle("/ws", listener)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		listener.Close()
+		srv.Close()
+	})
+	return listener, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func TestWebSocketRoundTrip(t *testing.T) {
+	listener, url := startWebSocketServer(t, protocol.WebSocketConfig{})
+
+	accepted := make(chan protocol.Conn, 1)
+	go func() {
+		c, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+		}
+		accepted <- c
+	}()
+
+	client, err := protocol.DialWebSocket(context.Background(), url)
+	if err != nil {
+		t.Fatalf("DialWebSocket failed: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// Payloads around the 7-bit, 16-bit and 64-bit WebSocket length encodings
+	for _, size := range []int{0, 10, 200, 70000} {
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		if err := client.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, payload); err != nil {
+			t.Fatalf("client WriteFrame(%d) failed: %v", size, err)
+		}
+		header, got, err := server.ReadFrame()
+		if err != nil {
+			t.Fatalf("server ReadFrame(%d) failed: %v", size, err)
+		}
+		if header.Protocol != protocol.ProtocolWebSocket || string(got) != string(payload) {
+			t.Fatalf("frame of %d bytes corrupted over %v", size, header.Protocol)
+		}
+	}
+
+	if err := server.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("pong")); err != nil {
+		t.Fatalf("server WriteFrame failed: %v", err)
+	}
+	if _, payload, err := client.ReadFrame(); err != nil || string(payload) != "pong" {
+		t.Fatalf("client read: %q, %v", payload, err)
+	}
+
+	// A normal close reaches the peer as a fatal EOF
+	client.Close()
+	if _, _, err := server.ReadFrame(); !errors.Is(err, io.EOF) || !protocol.IsFatal(err) {
+		t.Errorf("expected fatal EOF after close, got %v", err)
+	}
+}
+
+// deadlineHijacker is a ResponseWriter whose Hijack leaves a deadline set on the connection, as
+// the http.Hijacker documentation allows.
+type deadlineHijacker struct {
+	http.ResponseWriter
+	deadline time.Duration
+}
+
+func (w deadlineHijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		c.SetDeadline(time.Now().Add(w.deadline))
+	}
+	return c, brw, err
+}
+
+func TestWebSocketOutlivesServerTimeouts(t *testing.T) {
+	listener := protocol.NewWebSocketListener(protocol.WebSocketConfig{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		listener.ServeHTTP(deadlineHijacker{rw, 50 * time.Millisecond}, r)
+	}))
+	srv.Config.ReadTimeout = 50 * time.Millisecond
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	t.Cleanup(func() {
+		listener.Close()
+		srv.Close()
+	})
+
+	accepted := make(chan protocol.Conn, 1)
+	go func() {
+		c, _ := listener.Accept()
+		accepted <- c
+	}()
+	client, err := protocol.DialWebSocket(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatalf("DialWebSocket failed: %v", err)
+	}
+	defer client.Close()
+	server := <-accepted
+	defer server.Close()
+
+	// The upgraded connection is no longer bound by deadlines the HTTP server set
+	time.Sleep(100 * time.Millisecond)
+	if err := client.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("ping")); err != nil {
+		t.Fatalf("client WriteFrame failed: %v", err)
+	}
+	if _, payload, err := server.ReadFrame(); err != nil || string(payload) != "ping" {
+		t.Fatalf("server read: %q, %v", payload, err)
+	}
+	if err := server.WriteFrame(&protocol.SocketHeader{MessageType: protocol.MessageTypeData}, []byte("pong")); err != nil {
+		t.Fatalf("server WriteFrame failed: %v", err)
+	}
+	if _, payload, err := client.ReadFrame(); err != nil || string(payload) != "pong" {
+		t.Fatalf("client read: %q, %v", payload, err)
+	}
+}
+
+func TestWebSocketHandshakeRejections(t *testing.T) {
+	_, url := startWebSocketServer(t, protocol.WebSocketConfig{})
+	httpURL := "http" + strings.TrimPrefix(url, "ws")
+
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"Plain request", nil, http.StatusUpgradeRequired},
+		{"Wrong version", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "8", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ=="}, http.StatusUpgradeRequired},
+		{"Bad key", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+		{"Foreign origin", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==", "Origin": "https://evil.example"}, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, httpURL, nil)
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.status {
+				t.Errorf("status: got %d, want %d", resp.StatusCode, tc.status)
+			}
+		})
+	}
+}
+
+// rawWebSocketClient performs the handshake by hand so tests can send arbitrary WebSocket frames.
+func rawWebSocketClient(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	host := strings.TrimSuffix(strings.TrimPrefix(url, "ws://"), "/ws")
+	c, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	io.WriteString(c, "GET /ws HTTP/1.1\r\nHost: "+host+"\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("handshake failed: %v", err)
+	}
+	// Accept value from the RFC 6455 example
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response: %s %v", resp.Status, resp.Header)
+	}
+	return c, br
+}
+
+// maskedFrame builds a masked client WebSocket frame.
+func maskedFrame(fin bool, op byte, payload []byte) []byte {
+	b0 := op
+	if fin {
+		b0 |= 0x80
+	}
+	frame := []byte{b0}
+	switch {
+	case len(payload) > 0xFFFF:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	case len(payload) > 125:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|byte(len(payload)))
+	}
+	key := [4]byte{1, 2, 3, 4}
+	frame = append(frame, key[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^key[i&3])
+	}
+	return frame
+}
+
+func TestWebSocketFragmentsAndPing(t *testing.T) {
+	listener, url := startWebSocketServer(t, protocol.WebSocketConfig{})
+	accepted := make(chan protocol.Conn, 1)
+	go func() {
+		c, _ := listener.Accept()
+		accepted <- c
+	}()
+
+	c, br := rawWebSocketClient(t, url)
+	server := <-accepted
+	defer server.Close()
+
+	// A SocketHub frame split across three WebSocket fragments with a ping in the middle
+	frame := make([]byte, 256)
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData}
+	header.ID, header.Sender = server.GetSender(), server.GetSender()
+	header.Protocol = protocol.ProtocolWebSocket
+	header.Timestamp = uint64(time.Now().UnixMilli())
+	n, err := protocol.EncodeFrameTo(frame, header, []byte("fragmented payload"))
+	if err != nil {
+		t.Fatalf("EncodeFrameTo failed: %v", err)
+	}
+	frame = frame[:n]
+
+	c.Write(maskedFrame(false, 0x2, frame[:10]))
+	c.Write(maskedFrame(true, 0x9, []byte("hi")))
+	c.Write(maskedFrame(false, 0x0, frame[10:40]))
+	c.Write(maskedFrame(true, 0x0, frame[40:]))
+
+	got, payload, err := server.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame failed: %v", err)
+	}
+	if string(payload) != "fragmented payload" || got.ID != header.ID {
+		t.Errorf("unexpected frame: %q", payload)
+	}
+
+	// The ping was answered with an unmasked pong carrying the same data
+	pong := make([]byte, 4)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(br, pong); err != nil {
+		t.Fatalf("reading pong failed: %v", err)
+	}
+	if pong[0] != 0x8A || pong[1] != 2 || string(pong[2:]) != "hi" {
+		t.Errorf("unexpected pong: %x", pong)
+	}
+}
+
+func TestWebSocketRejectsProtocolViolations(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		code  uint16
+	}{
+		{"Text message", maskedFrame(true, 0x1, []byte("text")), 1003},
+		{"Unmasked frame", []byte{0x82, 0x01, 0x00}, 1002},
+		{"Continuation without start", maskedFrame(true, 0x0, []byte{1}), 1002},
+		{"Message too big", maskedFrame(true, 0x2, make([]byte, 2048)), 1009},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			listener, url := startWebSocketServer(t, protocol.WebSocketConfig{MaxMessageSize: 1024})
+			accepted := make(chan protocol.Conn, 1)
+			go func() {
+				c, _ := listener.Accept()
+				accepted <- c
+			}()
+
+			c, br := rawWebSocketClient(t, url)
+			server := <-accepted
+			defer server.Close()
+
+			c.Write(tc.frame)
+			if _, _, err := server.ReadFrame(); !protocol.IsFatal(err) {
+				t.Fatalf("expected fatal error, got %v", err)
+			}
+
+			closeFrame := make([]byte, 4)
+			c.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := io.ReadFull(br, closeFrame); err != nil {
+				t.Fatalf("reading close frame failed: %v", err)
+			}
+			if closeFrame[0] != 0x88 || binary.BigEndian.Uint16(closeFrame[2:]) != tc.code {
+				t.Errorf("unexpected close frame %x, want code %d", closeFrame, tc.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package test
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// recordConn is a net.Conn that records written bytes and counts Write calls.
+type recordConn struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writes int
+}
+
+func (r *recordConn) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writes++
+	return r.buf.Write(p)
+}
+
+func (r *recordConn) stats() (writes int, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.writes, append([]byte(nil), r.buf.Bytes()...)
+}
+
+func (r *recordConn) Read(p []byte) (int, error)         { select {} }
+func (r *recordConn) Close() error                       { return nil }
+func (r *recordConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (r *recordConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (r *recordConn) SetDeadline(t time.Time) error      { return nil }
+func (r *recordConn) SetReadDeadline(t time.Time) error  { return nil }
+func (r *recordConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// readerConn is a net.Conn that reads from a fixed byte slice.
+type readerConn struct {
+	*bytes.Reader
+	recordConn
+}
+
+func (r *readerConn) Read(p []byte) (int, error) { return r.Reader.Read(p) }
+
+// decodeAll reads every frame from data and returns the payloads in order.
+func decodeAll(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	conn := protocol.NewTCPConnWrapper(&readerConn{Reader: bytes.NewReader(data)})
+	var payloads []string
+	for {
+		_, payload, err := conn.ReadFrame()
+		if err != nil {
+			if !protocol.IsFatal(err) {
+				t.Fatalf("unexpected frame error: %v", err)
+			}
+			return payloads
+		}
+		payloads = append(payloads, string(payload))
+	}
+}
+
+func coalescedHeader() *protocol.SocketHeader {
+	return &protocol.SocketHeader{ID: uuid.New(), Sender: uuid.New(), MessageType: protocol.MessageTypeData}
+}
+
+func TestWriteCoalescingLightLoadFlushesImmediately(t *testing.T) {
+	rec := &recordConn{}
+	conn := protocol.NewTCPConnWrapper(rec, protocol.WithWriteCoalescing(protocol.WriteCoalescing{}))
+
+	for i := 0; i < 5; i++ {
+		if err := conn.WriteFrame(coalescedHeader(), []byte("ping")); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+		if writes, _ := rec.stats(); writes != i+1 {
+			t.Fatalf("frame %d not flushed immediately: %d writes", i, writes)
+		}
+	}
+}
+
+func TestWriteCoalescingBatchesConcurrentWriters(t *testing.T) {
+	rec := &recordConn{}
+	conn := protocol.NewTCPConnWrapper(rec, protocol.WithWriteCoalescing(protocol.WriteCoalescing{
+		FlushInterval: 5 * time.Millisecond,
+	}))
+
+	const writers, perWriter = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				if err := conn.WriteFrame(coalescedHeader(), []byte("data")); err != nil {
+					t.Errorf("WriteFrame failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := conn.(protocol.Flusher).Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	writes, data := rec.stats()
+	if got := len(decodeAll(t, data)); got != writers*perWriter {
+		t.Fatalf("decoded %d frames, want %d", got, writers*perWriter)
+	}
+	if writes >= writers*perWriter {
+		t.Errorf("expected coalesced writes, got %d writes for %d frames", writes, writers*perWriter)
+	}
+	t.Logf("%d frames in %d writes", writers*perWriter, writes)
+}
+
+func TestWriteCoalescingFlushInterval(t *testing.T) {
+	rec := &recordConn{}
+	conn := protocol.NewTCPConnWrapper(rec, protocol.WithWriteCoalescing(protocol.WriteCoalescing{
+		FlushInterval: 20 * time.Millisecond,
+	}))
+
+	if err := conn.WriteFrame(coalescedHeader(), []byte("delayed")); err != nil {
+		t.Fatalf("WriteFrame failed: %v", err)
+	}
+	if writes, _ := rec.stats(); writes != 0 {
+		t.Fatalf("frame flushed before interval: %d writes", writes)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if writes, data := rec.stats(); writes == 1 {
+			if payloads := decodeAll(t, data); len(payloads) != 1 || payloads[0] != "delayed" {
+				t.Fatalf("unexpected payloads: %q", payloads)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("frame was not flushed after the interval")
+}
+
+func TestWriteCoalescingFlushSize(t *testing.T) {
+	rec := &recordConn{}
+	conn := protocol.NewTCPConnWrapper(rec, protocol.WithWriteCoalescing(protocol.WriteCoalescing{
+		FlushSize:     256,
+		FlushInterval: time.Hour,
+	}))
+
+	payload := make([]byte, 100)
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteFrame(coalescedHeader(), payload); err != nil {
+			t.Fatalf("WriteFrame failed: %v", err)
+		}
+	}
+
+	// Three ~170-byte frames: the second crosses 256 bytes and forces a flush
+	writes, data := rec.stats()
+	if writes != 1 || len(decodeAll(t, data)) != 2 {
+		t.Errorf("expected one flush of two frames, got %d writes", writes)
+	}
+
+	conn.Close()
+	if _, data := rec.stats(); len(decodeAll(t, data)) != 3 {
+		t.Error("Close did not flush the remaining frame")
+	}
+}
@@ -0,0 +1,121 @@
+// Package transfer provides the wire format of file transfer messages.
+// Every message is the payload of a data frame on the transfer router: a kind byte, the
+// transfer ID and a kind-specific body. Integers are big-endian.
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// messageKind identifies a transfer message.
+type messageKind uint8
+
+const (
+	kindOffer  messageKind = iota + 1 // Sender proposes a file: size(8) + sha256(32) + name
+	kindAccept                        // Receiver accepts: offset(8) to resume from
+	kindReject                        // Receiver declines: UTF-8 reason
+	kindChunk                         // File data: offset(8) + crc32(4) + data
+	kindAck                           // Receiver has stored every byte before offset(8)
+	kindResend                        // Receiver discarded a chunk; resend from offset(8)
+	kindDone                          // Receiver verified the file: ok(1) + UTF-8 reason
+)
+
+// errMalformed is returned for messages that cannot be decoded.
+var errMalformed = errors.New("transfer: malformed message")
+
+// message is a decoded transfer message. Only the fields of its kind are set.
+type message struct {
+	kind   messageKind
+	id     uuid.UUID
+	offset int64
+	size   int64
+	sum    [32]byte
+	crc    uint32
+	ok     bool
+	text   string // Offer name, or Reject and Done reason
+	data   []byte
+}
+
+// encode returns the wire form of m.
+func (m *message) encode() []byte {
+	buf := make([]byte, 17, 17+45+len(m.text)+len(m.data))
+	buf[0] = byte(m.kind)
+	copy(buf[1:], m.id[:])
+
+	switch m.kind {
+	case kindOffer:
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.size))
+		buf = append(buf, m.sum[:]...)
+		buf = append(buf, m.text...)
+	case kindAccept, kindAck, kindResend:
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.offset))
+	case kindReject:
+		buf = append(buf, m.text...)
+	case kindChunk:
+		buf = binary.BigEndian.AppendUint64(buf, uint64(m.offset))
+		buf = binary.BigEndian.AppendUint32(buf, m.crc)
+		buf = append(buf, m.data...)
+	case kindDone:
+		ok := byte(0)
+		if m.ok {
+			ok = 1
+		}
+		buf = append(buf, ok)
+		buf = append(buf, m.text...)
+	}
+	return buf
+}
+
+// decodeMessage parses a transfer message. Chunk data aliases payload.
+func decodeMessage(payload []byte) (*message, error) {
+	if len(payload) < 17 {
+		return nil, fmt.Errorf("%w: %d bytes", errMalformed, len(payload))
+	}
+	m := &message{kind: messageKind(payload[0])}
+	copy(m.id[:], payload[1:17])
+	body := payload[17:]
+
+	need := 0
+	switch m.kind {
+	case kindOffer:
+		need = 40
+	case kindAccept, kindAck, kindResend:
+		need = 8
+	case kindChunk:
+		need = 12
+	case kindDone:
+		need = 1
+	case kindReject:
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %d", errMalformed, m.kind)
+	}
+	if len(body) < need {
+		return nil, fmt.Errorf("%w: short body for kind %d", errMalformed, m.kind)
+	}
+
+	switch m.kind {
+	case kindOffer:
+		m.size = int64(binary.BigEndian.Uint64(body))
+		copy(m.sum[:], body[8:40])
+		m.text = string(body[40:])
+		if m.size < 0 {
+			return nil, fmt.Errorf("%w: negative size", errMalformed)
+		}
+	case kindAccept, kindAck, kindResend:
+		m.offset = int64(binary.BigEndian.Uint64(body))
+	case kindReject:
+		m.text = string(body)
+	case kindChunk:
+		m.offset = int64(binary.BigEndian.Uint64(body))
+		m.crc = binary.BigEndian.Uint32(body[8:])
+		m.data = body[12:]
+	case kindDone:
+		m.ok = body[0] == 1
+		m.text = string(body[1:])
+	}
+	return m, nil
+}
@@ -0,0 +1,471 @@
+// Package transfer provides chunked file transfer over SocketHub frames.
+// The sender offers a file, the receiver accepts it, and the file then flows in checksummed
+// chunks that the receiver acknowledges. Once every byte has arrived, the receiver checks the
+// whole file against the SHA-256 in the offer. Partial transfers stay with the receiving
+// Manager, so a sender that reconnects and offers the same file again resumes from the last
+// acknowledged offset.
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Jdcabreradev/sockethub/protocol"
+	"github.com/google/uuid"
+)
+
+// =============================================================================
+// Configuration
+// =============================================================================
+
+const (
+	DefaultRouter     uint8 = 0xF0            // Default router of transfer frames
+	DefaultChunkSize        = 64 << 10        // Default file bytes per chunk (64KB)
+	DefaultWindow           = 8               // Default chunks sent ahead of acknowledgments
+	DefaultAckTimeout       = 5 * time.Second // Default wait for the receiver before resending
+)
+
+// maxFinished is the number of finished transfers whose verdict is kept for repeated offers.
+const maxFinished = 256
+
+var (
+	// ErrRejected is returned by Send when the receiver declines the offer.
+	ErrRejected = errors.New("transfer: offer rejected")
+
+	// ErrVerification is reported to OnComplete when a received file does not match the offered SHA-256.
+	ErrVerification = errors.New("transfer: file verification failed")
+
+	// ErrTransferFailed is returned by Send when the receiver could not store or verify the file.
+	ErrTransferFailed = errors.New("transfer: receiver failed the transfer")
+
+	// ErrTransferActive is returned by Send when the offer is already being sent.
+	ErrTransferActive = errors.New("transfer: transfer already in progress")
+
+	// ErrManagerClosed is returned by Send once the Manager is closed.
+	ErrManagerClosed = errors.New("transfer: manager closed")
+)
+
+// Offer describes a file. Offering the same ID again resumes an interrupted transfer.
+type Offer struct {
+	ID     uuid.UUID
+	Name   string
+	Size   int64
+	SHA256 [32]byte
+}
+
+// NewOffer reads size bytes from r to build an offer with a new ID.
+func NewOffer(name string, r io.ReaderAt, size int64) (Offer, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(r, 0, size)); err != nil {
+		return Offer{}, fmt.Errorf("transfer: hash %s: %w", name, err)
+	}
+	offer := Offer{ID: uuid.New(), Name: name, Size: size}
+	h.Sum(offer.SHA256[:0])
+	return offer, nil
+}
+
+// Direction tells whether a transfer is being sent or received.
+type Direction uint8
+
+const (
+	Sending Direction = iota
+	Receiving
+)
+
+// String returns the direction name.
+func (d Direction) String() string {
+	if d == Receiving {
+		return "receiving"
+	}
+	return "sending"
+}
+
+// Progress reports how far a transfer has come.
+type Progress struct {
+	Offer     Offer
+	Direction Direction
+	Peer      uuid.UUID // The other client
+	Offset    int64     // Bytes acknowledged (Sending) or stored (Receiving)
+}
+
+// AcceptFunc decides on an offer from a peer: it returns where to store the file, or an
+// error whose text is sent back as the rejection reason.
+type AcceptFunc func(from uuid.UUID, offer Offer) (io.WriterAt, error)
+
+// Config configures a Manager. Callbacks run on the goroutine calling HandleFrame and
+// should return quickly.
+type Config struct {
+	Router     uint8                                        // Router of transfer frames (zero for DefaultRouter)
+	ChunkSize  int                                          // File bytes per chunk (zero for DefaultChunkSize)
+	Window     int                                          // Chunks sent ahead of acknowledgments (zero for DefaultWindow)
+	AckTimeout time.Duration                                // Wait for the receiver before resending (zero for DefaultAckTimeout)
+	OnOffer    AcceptFunc                                   // Decides on incoming offers (nil rejects them all)
+	OnProgress func(p Progress)                             // Called as chunks are stored or acknowledged
+	OnComplete func(from uuid.UUID, offer Offer, err error) // Called when a received file is verified or fails
+}
+
+// =============================================================================
+// Manager
+// =============================================================================
+
+// outgoing is the sender's state of a transfer, updated by HandleFrame.
+type outgoing struct {
+	offer    Offer
+	to       uuid.UUID // Replies from anyone else are ignored (uuid.Nil on a direct Conn: any sender)
+	mu       sync.Mutex
+	accepted bool
+	acked    int64
+	resend   int64 // Offset to rewind to (-1 for none)
+	done     bool
+	err      error
+	changed  chan struct{} // Signaled when the state changes
+}
+
+// incoming is the receiver's state of a transfer.
+type incoming struct {
+	offer    Offer
+	from     uuid.UUID // Peer that last offered the file; chunks from anyone else are ignored
+	mu       sync.Mutex
+	w        io.WriterAt
+	offset   int64     // Every byte before it is stored
+	hash     hash.Hash // SHA-256 of the bytes before offset
+	resendAt int64     // Offset of the last resend request (-1 for none)
+}
+
+// Manager sends and receives files over a Conn. The application passes every frame it reads
+// to HandleFrame; the Manager writes its own frames to the Conn. Through a hub, frames are
+// addressed to the peer's client ID.
+type Manager struct {
+	conn      protocol.Conn
+	cfg       Config
+	mu        sync.Mutex
+	sending   map[uuid.UUID]*outgoing
+	receiving map[uuid.UUID]*incoming
+	finished  map[uuid.UUID]*message // Verdicts of finished transfers, in case the sender missed them
+	order     []uuid.UUID            // Keys of finished, oldest first
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewManager returns a Manager that writes to conn.
+func NewManager(conn protocol.Conn, cfg Config) *Manager {
+	if cfg.Router == 0 {
+		cfg.Router = DefaultRouter
+	}
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = DefaultChunkSize
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.AckTimeout <= 0 {
+		cfg.AckTimeout = DefaultAckTimeout
+	}
+	return &Manager{
+		conn:      conn,
+		cfg:       cfg,
+		sending:   make(map[uuid.UUID]*outgoing),
+		receiving: make(map[uuid.UUID]*incoming),
+		finished:  make(map[uuid.UUID]*message),
+		closed:    make(chan struct{}),
+	}
+}
+
+// Close stops every Send in progress. Partial incoming transfers are discarded.
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+		m.mu.Lock()
+		clear(m.receiving)
+		m.mu.Unlock()
+	})
+	return nil
+}
+
+// write sends a transfer message to a peer.
+func (m *Manager) write(to uuid.UUID, msg *message) error {
+	header := &protocol.SocketHeader{MessageType: protocol.MessageTypeData, Router: m.cfg.Router, Receiver: to}
+	return m.conn.WriteFrame(header, msg.encode())
+}
+
+// progress reports progress to the callback, if any.
+func (m *Manager) progress(offer Offer, dir Direction, peer uuid.UUID, offset int64) {
+	if m.cfg.OnProgress != nil {
+		m.cfg.OnProgress(Progress{Offer: offer, Direction: dir, Peer: peer, Offset: offset})
+	}
+}
+
+// =============================================================================
+// Sending
+// =============================================================================
+
+// Send offers a file read from r to a peer and sends it once accepted. It returns when the
+// receiver has verified the file, rejects it, or ctx ends. After an error, calling Send with
+// the same offer (on a new connection, if the old one failed) resumes the transfer. Through
+// a hub, to is the receiver's client ID or address; on a direct Conn it may be uuid.Nil.
+func (m *Manager) Send(ctx context.Context, to uuid.UUID, offer Offer, r io.ReaderAt) error {
+	o := &outgoing{offer: offer, to: to, resend: -1, changed: make(chan struct{}, 1)}
+	m.mu.Lock()
+	if _, ok := m.sending[offer.ID]; ok {
+		m.mu.Unlock()
+		return ErrTransferActive
+	}
+	m.sending[offer.ID] = o
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.sending, offer.ID)
+		m.mu.Unlock()
+	}()
+
+	offerMsg := &message{kind: kindOffer, id: offer.ID, size: offer.Size, sum: offer.SHA256, text: offer.Name}
+	if err := m.write(to, offerMsg); err != nil {
+		return err
+	}
+
+	chunk := int64(m.cfg.ChunkSize)
+	ahead := chunk * int64(m.cfg.Window)
+	buf := make([]byte, chunk)
+	var next int64
+	started := false
+	for {
+		o.mu.Lock()
+		accepted, acked, resend, done, err := o.accepted, o.acked, o.resend, o.done, o.err
+		o.resend = -1
+		o.mu.Unlock()
+		if done {
+			return err
+		}
+
+		if accepted {
+			if !started || resend >= 0 {
+				next, started = acked, true
+			}
+			next = max(next, acked)
+			for next < offer.Size && next-acked < ahead {
+				n := min(chunk, offer.Size-next)
+				if read, err := r.ReadAt(buf[:n], next); int64(read) < n {
+					return fmt.Errorf("transfer: read %s at %d: %w", offer.Name, next, err)
+				}
+				msg := &message{kind: kindChunk, id: offer.ID, offset: next, crc: protocol.Checksum(buf[:n]), data: buf[:n]}
+				if err := m.write(to, msg); err != nil {
+					return err
+				}
+				next += n
+			}
+		}
+
+		t := time.NewTimer(m.cfg.AckTimeout)
+		select {
+		case <-o.changed:
+		case <-t.C:
+			// Nothing heard: the offer, a chunk or the verdict may have been dropped on the way
+			if !accepted || acked == offer.Size {
+				if err := m.write(to, offerMsg); err != nil {
+					return err
+				}
+			} else {
+				next = acked
+			}
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-m.closed:
+			t.Stop()
+			return ErrManagerClosed
+		}
+		t.Stop()
+	}
+}
+
+// reply applies a receiver message to the matching Send. Messages from anyone but the
+// peer the file is sent to are ignored, unless it was sent to uuid.Nil.
+func (m *Manager) reply(from uuid.UUID, msg *message) {
+	m.mu.Lock()
+	o := m.sending[msg.id]
+	m.mu.Unlock()
+	if o == nil || (o.to != uuid.Nil && o.to != from) {
+		return
+	}
+
+	o.mu.Lock()
+	report := false
+	switch msg.kind {
+	case kindAccept:
+		if !o.accepted {
+			o.accepted, o.acked, report = true, msg.offset, true
+		}
+	case kindAck:
+		if msg.offset > o.acked {
+			o.acked, report = msg.offset, true
+		}
+	case kindResend:
+		o.acked, o.resend = max(o.acked, msg.offset), msg.offset
+	case kindReject:
+		o.done, o.err = true, fmt.Errorf("%w: %s", ErrRejected, msg.text)
+	case kindDone:
+		o.done = true
+		if !msg.ok {
+			o.err = fmt.Errorf("%w: %s", ErrTransferFailed, msg.text)
+		}
+	}
+	acked := o.acked
+	o.mu.Unlock()
+
+	select {
+	case o.changed <- struct{}{}:
+	default:
+	}
+	if report {
+		m.progress(o.offer, Sending, from, acked)
+	}
+}
+
+// =============================================================================
+// Receiving
+// =============================================================================
+
+// HandleFrame processes a frame read from the Conn. It returns false, leaving the frame to
+// the caller, if it is not a transfer frame.
+func (m *Manager) HandleFrame(header *protocol.SocketHeader, payload []byte) bool {
+	if header.MessageType != protocol.MessageTypeData || header.Router != m.cfg.Router {
+		return false
+	}
+	msg, err := decodeMessage(payload)
+	if err != nil {
+		return true
+	}
+
+	switch msg.kind {
+	case kindOffer:
+		m.offer(header.Sender, msg)
+	case kindChunk:
+		m.chunk(header.Sender, msg)
+	default:
+		m.reply(header.Sender, msg)
+	}
+	return true
+}
+
+// offer answers an offer, resuming the partial transfer with the same ID if there is one.
+func (m *Manager) offer(from uuid.UUID, msg *message) {
+	offer := Offer{ID: msg.id, Name: msg.text, Size: msg.size, SHA256: msg.sum}
+	reject := func(reason string) {
+		m.write(from, &message{kind: kindReject, id: offer.ID, text: reason})
+	}
+
+	m.mu.Lock()
+	in, verdict := m.receiving[offer.ID], m.finished[offer.ID]
+	m.mu.Unlock()
+	if verdict != nil {
+		m.write(from, verdict)
+		return
+	}
+	if in != nil {
+		in.mu.Lock()
+		defer in.mu.Unlock()
+		if in.offer != offer {
+			reject("offer does not match the partial transfer")
+			return
+		}
+		in.from, in.resendAt = from, -1
+		m.write(from, &message{kind: kindAccept, id: offer.ID, offset: in.offset})
+		return
+	}
+
+	if m.cfg.OnOffer == nil {
+		reject("transfers are not accepted")
+		return
+	}
+	w, err := m.cfg.OnOffer(from, offer)
+	if err != nil {
+		reject(err.Error())
+		return
+	}
+
+	in = &incoming{offer: offer, from: from, w: w, hash: sha256.New(), resendAt: -1}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	m.mu.Lock()
+	m.receiving[offer.ID] = in
+	m.mu.Unlock()
+	m.write(from, &message{kind: kindAccept, id: offer.ID})
+	if offer.Size == 0 {
+		m.finish(in, nil)
+	}
+}
+
+// chunk stores the next chunk of a transfer. Chunks that are out of order or fail their
+// checksum are discarded, and the sender is asked once to resend from the expected offset.
+// Chunks from anyone but the peer that last offered the file are ignored.
+func (m *Manager) chunk(from uuid.UUID, msg *message) {
+	m.mu.Lock()
+	in := m.receiving[msg.id]
+	m.mu.Unlock()
+	if in == nil {
+		return // Finished or unknown
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.from != from {
+		return
+	}
+	if msg.offset < in.offset {
+		return // Duplicate after a resend
+	}
+	if msg.offset > in.offset || protocol.Checksum(msg.data) != msg.crc || in.offset+int64(len(msg.data)) > in.offer.Size {
+		if in.resendAt != in.offset {
+			in.resendAt = in.offset
+			m.write(from, &message{kind: kindResend, id: msg.id, offset: in.offset})
+		}
+		return
+	}
+
+	if _, err := in.w.WriteAt(msg.data, msg.offset); err != nil {
+		m.finish(in, fmt.Errorf("transfer: write %s: %w", in.offer.Name, err))
+		return
+	}
+	in.hash.Write(msg.data)
+	in.offset += int64(len(msg.data))
+	in.resendAt = -1
+	m.write(from, &message{kind: kindAck, id: msg.id, offset: in.offset})
+	m.progress(in.offer, Receiving, from, in.offset)
+
+	if in.offset == in.offer.Size {
+		m.finish(in, nil)
+	}
+}
+
+// finish verifies a complete transfer, or aborts it with err, and tells the sender.
+// in.mu must be held.
+func (m *Manager) finish(in *incoming, err error) {
+	if err == nil && [32]byte(in.hash.Sum(nil)) != in.offer.SHA256 {
+		err = fmt.Errorf("%w: %s does not match its SHA-256", ErrVerification, in.offer.Name)
+	}
+	done := &message{kind: kindDone, id: in.offer.ID, ok: err == nil}
+	if err != nil {
+		done.text = err.Error()
+	}
+
+	m.mu.Lock()
+	delete(m.receiving, in.offer.ID)
+	m.finished[in.offer.ID] = done
+	m.order = append(m.order, in.offer.ID)
+	if len(m.order) > maxFinished {
+		delete(m.finished, m.order[0])
+		m.order = m.order[1:]
+	}
+	m.mu.Unlock()
+
+	m.write(in.from, done)
+	if m.cfg.OnComplete != nil {
+		m.cfg.OnComplete(in.from, in.offer, err)
+	}
+}
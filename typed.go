@@ -0,0 +1,50 @@
+// Package sockethub provides typed payload helpers on top of the codec registry.
+// SendTyped encodes a value with the codec the receiving client asked for, and DecodeTyped
+// decodes a payload with the codec named by its ContentType extension.
+package sockethub
+
+import (
+	"github.com/Jdcabreradev/sockethub/codec"
+	"github.com/Jdcabreradev/sockethub/protocol"
+)
+
+// SendTyped encodes v and queues it for the client like Send. The codec is the one named by
+// the header's ContentType extension if set, else the one negotiated from the client's Accept
+// extension, else codec.Default. The header is not modified.
+func SendTyped[T any](c *Client, header *protocol.SocketHeader, v T) error {
+	header = header.Clone()
+	cd, err := codec.ForHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, ok := header.ContentType(); !ok {
+		cd = c.Codec()
+	}
+	payload, err := codec.Encode(header, cd, v)
+	if err != nil {
+		return err
+	}
+	return c.Send(header, payload)
+}
+
+// DecodeTyped decodes a payload received by a handler into a T.
+func DecodeTyped[T any](header *protocol.SocketHeader, payload []byte) (T, error) {
+	return codec.Decode[T](header, payload)
+}
+
+// Codec returns the codec negotiated from the client's Accept extension, or codec.Default
+// if the client has not announced one it shares with the hub.
+func (c *Client) Codec() codec.Codec {
+	if cd := c.codec.Load(); cd != nil {
+		return *cd
+	}
+	return codec.Default
+}
+
+// negotiate records the codec for an Accept list announced by the client. Lists without a
+// supported type leave the previous choice in place.
+func (c *Client) negotiate(accept string) {
+	if cd, ok := codec.Negotiate(accept); ok {
+		c.codec.Store(&cd)
+	}
+}